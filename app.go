@@ -1,16 +1,23 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 
+	"github.com/hadleyso/netid-activate/src/attribute"
+	"github.com/hadleyso/netid-activate/src/challenge"
 	"github.com/hadleyso/netid-activate/src/config"
 	"github.com/hadleyso/netid-activate/src/db"
+	"github.com/hadleyso/netid-activate/src/directory"
 	"github.com/hadleyso/netid-activate/src/models"
 	"github.com/hadleyso/netid-activate/src/routes"
+	"github.com/hadleyso/netid-activate/src/server"
+	"github.com/hadleyso/netid-activate/src/sponsor"
 	"github.com/spf13/viper"
 )
 
@@ -38,6 +45,36 @@ func main() {
 	viper.SetEnvPrefix("NETID")
 	viper.AutomaticEnv()
 
+	// Check login name rules
+	if err := attribute.CheckLoginConfig(); err != nil {
+		log.Fatal("Invalid login name config: " + err.Error())
+	}
+
+	// Check custom invite fields
+	if err := attribute.CheckInviteFields(); err != nil {
+		log.Fatal("Invalid invite field config: " + err.Error())
+	}
+
+	// Check invite approval
+	if err := attribute.CheckApproval(); err != nil {
+		log.Fatal("Invalid approval config: " + err.Error())
+	}
+
+	// Check activation challenges
+	if err := challenge.CheckConfig(); err != nil {
+		log.Fatal("Invalid challenge config: " + err.Error())
+	}
+
+	// Check directory backend
+	if err := directory.CheckConfig(); err != nil {
+		log.Fatal("Invalid directory config: " + err.Error())
+	}
+
+	// Check sponsored terms
+	if err := sponsor.CheckConfig(); err != nil {
+		log.Fatal("Invalid sponsor config: " + err.Error())
+	}
+
 	// Register struct
 	gob.Register(&models.UserInfo{})
 
@@ -47,11 +84,16 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Remind sponsors and disable expired accounts until shutdown
+	ctx, stopSponsor := context.WithCancel(context.Background())
+	defer stopSponsor()
+	sponsor.Start(ctx)
+
 	// Register Routes
 	routes.Main()
 
-	// Listen
-	port := viper.GetString("SERVER_PORT")
-	log.Println("Listening to localhost:" + port)
-	http.ListenAndServe(fmt.Sprintf("localhost:%v", port), routes.Router)
+	// Listen until SIGTERM
+	if err := server.Run(routes.Router); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
 }
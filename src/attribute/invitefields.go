@@ -0,0 +1,149 @@
+package attribute
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hadleyso/netid-activate/src/config"
+)
+
+// Types of INVITE_FIELDS, text when not set
+const (
+	FieldText   = "text"
+	FieldNumber = "number"
+	FieldDate   = "date"
+	FieldEmail  = "email"
+	FieldSelect = "select"
+)
+
+// Date fields are entered as YYYY-MM-DD
+const fieldDateLayout = "2006-01-02"
+
+// Invite form inputs are named with the prefix, optional group
+// checkboxes are named by group
+const fieldInputPrefix = "field_"
+
+// Field names usable as template keys
+var fieldName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// Names of the fixed invite fields and of the user fields directory
+// attribute mappings already use
+var reservedFieldNames = []string{
+	"firstname", "lastname", "email", "state", "country", "affiliation",
+	"inviter", "loginname", "cn", "gecos", "givenname", "sn", "mail", "st",
+	"manager", "pager", "sshpublickey",
+}
+
+// Fields from INVITE_FIELDS, the type defaults to text and the label to
+// the name
+func InviteFieldsFromConfig() []config.InviteField {
+	var fields []config.InviteField
+	for _, field := range config.C.InviteFields {
+		field.Type = strings.ToLower(field.Type)
+		if field.Type == "" {
+			field.Type = FieldText
+		}
+		if field.Label == "" {
+			field.Label = field.Name
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// Check INVITE_FIELDS, run at startup
+func CheckInviteFields() error {
+	seen := map[string]bool{}
+	for _, field := range InviteFieldsFromConfig() {
+		if !fieldName.MatchString(field.Name) {
+			return fmt.Errorf("INVITE_FIELDS name %q must be lowercase letters, digits and _", field.Name)
+		}
+		if slices.Contains(reservedFieldNames, field.Name) {
+			return fmt.Errorf("INVITE_FIELDS name %s is used by a built in field", field.Name)
+		}
+		if seen[field.Name] {
+			return fmt.Errorf("INVITE_FIELDS name %s is used twice", field.Name)
+		}
+		seen[field.Name] = true
+
+		switch field.Type {
+		case FieldText, FieldNumber, FieldDate, FieldEmail:
+			if len(field.Choices) > 0 {
+				return fmt.Errorf("INVITE_FIELDS %s has choices, set type select", field.Name)
+			}
+		case FieldSelect:
+			if len(field.Choices) == 0 {
+				return fmt.Errorf("INVITE_FIELDS %s is a select without choices", field.Name)
+			}
+		default:
+			return fmt.Errorf("INVITE_FIELDS %s type %q must be text, number, date, email or select", field.Name, field.Type)
+		}
+		if _, err := fieldPattern(field); err != nil {
+			return fmt.Errorf("INVITE_FIELDS %s pattern is invalid: %w", field.Name, err)
+		}
+	}
+	return nil
+}
+
+// Pattern matched against the whole value, nil if not set
+func fieldPattern(field config.InviteField) (*regexp.Regexp, error) {
+	if field.Pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile("^(?:" + field.Pattern + ")$")
+}
+
+// Values of the INVITE_FIELDS from the invite form by name
+// Returns the problems shown to the inviter when a value is not valid
+func InviteFieldValues(form url.Values) (map[string]string, []string) {
+	values := map[string]string{}
+	var problems []string
+	for _, field := range InviteFieldsFromConfig() {
+		value := strings.TrimSpace(form.Get(fieldInputPrefix + field.Name))
+		values[field.Name] = value
+		if value == "" {
+			if field.Required {
+				problems = append(problems, field.Label+" is required")
+			}
+			continue
+		}
+		if problem := checkFieldValue(field, value); problem != "" {
+			problems = append(problems, field.Label+" "+problem)
+		}
+	}
+	return values, problems
+}
+
+// Problem with a non-empty value, empty if valid
+func checkFieldValue(field config.InviteField, value string) string {
+	switch field.Type {
+	case FieldNumber:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return "must be a number"
+		}
+	case FieldDate:
+		if _, err := time.Parse(fieldDateLayout, value); err != nil {
+			return "must be a date"
+		}
+	case FieldEmail:
+		if address, err := mail.ParseAddress(value); err != nil || address.Address != value {
+			return "must be an email address"
+		}
+	case FieldSelect:
+		if !slices.Contains(field.Choices, value) {
+			return "must be one of the choices"
+		}
+	}
+
+	pattern, err := fieldPattern(field)
+	if err != nil || (pattern != nil && !pattern.MatchString(value)) {
+		return "is not in the expected format"
+	}
+	return ""
+}
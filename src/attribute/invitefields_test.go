@@ -0,0 +1,109 @@
+package attribute
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/hadleyso/netid-activate/src/config"
+)
+
+func setInviteFields(t *testing.T, fields []config.InviteField) {
+	config.C.InviteFields = fields
+	t.Cleanup(func() { config.C.InviteFields = nil })
+}
+
+func TestCheckInviteFields(t *testing.T) {
+	setInviteFields(t, []config.InviteField{
+		{Name: "employee_id", Label: "Employee ID", Required: true, Pattern: "[0-9]{6}"},
+		{Name: "department", Type: "Select", Choices: []string{"Physics", "Chemistry"}},
+		{Name: "end_date", Type: "date"},
+	})
+	if err := CheckInviteFields(); err != nil {
+		t.Errorf("expected fields to pass, got %v", err)
+	}
+
+	tests := map[string][]config.InviteField{
+		"name case":         {{Name: "EmployeeID"}},
+		"empty name":        {{Name: ""}},
+		"built in name":     {{Name: "email"}},
+		"duplicate":         {{Name: "project"}, {Name: "project"}},
+		"unknown type":      {{Name: "project", Type: "checkbox"}},
+		"select no choices": {{Name: "project", Type: "select"}},
+		"text with choices": {{Name: "project", Choices: []string{"a"}}},
+		"bad pattern":       {{Name: "project", Pattern: "[0-9"}},
+	}
+	for name, fields := range tests {
+		config.C.InviteFields = fields
+		if err := CheckInviteFields(); err == nil {
+			t.Errorf("%s: expected CheckInviteFields to fail", name)
+		}
+	}
+}
+
+func TestInviteFieldsFromConfig(t *testing.T) {
+	setInviteFields(t, []config.InviteField{{Name: "cost_center"}, {Name: "end_date", Type: "DATE", Label: "End Date"}})
+
+	expected := []config.InviteField{
+		{Name: "cost_center", Label: "cost_center", Type: FieldText},
+		{Name: "end_date", Label: "End Date", Type: FieldDate},
+	}
+	if fields := InviteFieldsFromConfig(); !reflect.DeepEqual(fields, expected) {
+		t.Errorf("expected %v, got %v", expected, fields)
+	}
+}
+
+func TestInviteFieldValues(t *testing.T) {
+	setInviteFields(t, []config.InviteField{
+		{Name: "employee_id", Label: "Employee ID", Required: true, Pattern: "[0-9]{6}"},
+		{Name: "department", Label: "Department", Type: FieldSelect, Choices: []string{"Physics", "Chemistry"}},
+		{Name: "hours", Label: "Hours", Type: FieldNumber},
+		{Name: "end_date", Label: "End Date", Type: FieldDate},
+		{Name: "sponsor", Label: "Sponsor", Type: FieldEmail},
+	})
+
+	form := url.Values{
+		"field_employee_id": {" 123456 "},
+		"field_department":  {"Physics"},
+		"field_hours":       {"12.5"},
+		"field_end_date":    {"2027-06-30"},
+	}
+	values, problems := InviteFieldValues(form)
+	if len(problems) != 0 {
+		t.Errorf("expected no problems, got %v", problems)
+	}
+	expected := map[string]string{
+		"employee_id": "123456",
+		"department":  "Physics",
+		"hours":       "12.5",
+		"end_date":    "2027-06-30",
+		"sponsor":     "",
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected %v, got %v", expected, values)
+	}
+
+	form = url.Values{
+		"field_employee_id": {"12345"},
+		"field_department":  {"Biology"},
+		"field_hours":       {"many"},
+		"field_end_date":    {"30/06/2027"},
+		"field_sponsor":     {"Jane <jane@example.com>"},
+	}
+	_, problems = InviteFieldValues(form)
+	expectedProblems := []string{
+		"Employee ID is not in the expected format",
+		"Department must be one of the choices",
+		"Hours must be a number",
+		"End Date must be a date",
+		"Sponsor must be an email address",
+	}
+	if !reflect.DeepEqual(problems, expectedProblems) {
+		t.Errorf("expected %v, got %v", expectedProblems, problems)
+	}
+
+	_, problems = InviteFieldValues(url.Values{})
+	if !reflect.DeepEqual(problems, []string{"Employee ID is required"}) {
+		t.Errorf("expected required problem, got %v", problems)
+	}
+}
@@ -1,54 +1,382 @@
 package attribute
 
 import (
+	"fmt"
+	"log"
 	"math/rand/v2"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/hadleyso/netid-activate/src/db"
+	"github.com/hadleyso/netid-activate/src/directory"
 	"github.com/hadleyso/netid-activate/src/models"
-	idm "github.com/hadleyso/netid-activate/src/redhat-idm"
+	"github.com/spf13/viper"
 )
 
+// Patterns used when LOGIN_NAME_PATTERNS is not set
+var DefaultLoginPatterns = []string{
+	"{first}{last}",
+	"{first:3}{last}",
+	"{first:2}{last}",
+	"{first}{last:2}",
+	"{f}{last}{nn}",
+}
+
+// Names never offered, extended by LOGIN_NAME_RESERVED
+var DefaultReservedNames = []string{
+	"root", "admin", "administrator", "daemon", "bin", "sys", "nobody",
+	"operator", "postmaster", "hostmaster", "webmaster", "abuse", "security",
+	"support", "noreply", "guest",
+}
+
+const (
+	DefaultLoginMinLength  = 5
+	DefaultLoginMaxLength  = 18
+	DefaultLoginCharset    = "a-z0-9"
+	DefaultLoginMinOptions = 3
+)
+
+// Rounds of numbered variants tried to reach the minimum options
+const variantRounds = 3
+
+// Pattern token, {name} or {name:length}
+var patternToken = regexp.MustCompile(`\{([a-z]+)(?::([0-9]+))?\}`)
+
+// Login name generation rules
+type LoginRules struct {
+	Patterns   []string
+	MinLength  int
+	MaxLength  int
+	Charset    string
+	Reserved   []string
+	MinOptions int
+}
+
+// Rules from LOGIN_NAME_* settings with defaults
+func LoginRulesFromConfig() LoginRules {
+	rules := LoginRules{
+		Patterns:   viper.GetStringSlice("LOGIN_NAME_PATTERNS"),
+		MinLength:  DefaultLoginMinLength,
+		MaxLength:  DefaultLoginMaxLength,
+		Charset:    viper.GetString("LOGIN_NAME_CHARSET"),
+		Reserved:   append(slices.Clone(DefaultReservedNames), viper.GetStringSlice("LOGIN_NAME_RESERVED")...),
+		MinOptions: DefaultLoginMinOptions,
+	}
+	if len(rules.Patterns) == 0 {
+		rules.Patterns = DefaultLoginPatterns
+	}
+	if rules.Charset == "" {
+		rules.Charset = DefaultLoginCharset
+	}
+	if viper.IsSet("LOGIN_NAME_MIN_LENGTH") {
+		rules.MinLength = viper.GetInt("LOGIN_NAME_MIN_LENGTH")
+	}
+	if viper.IsSet("LOGIN_NAME_MAX_LENGTH") {
+		rules.MaxLength = viper.GetInt("LOGIN_NAME_MAX_LENGTH")
+	}
+	if viper.IsSet("LOGIN_NAME_MIN_OPTIONS") {
+		rules.MinOptions = viper.GetInt("LOGIN_NAME_MIN_OPTIONS")
+	}
+	return rules
+}
+
+// Check rules are usable, run at startup
+func (r LoginRules) Validate() error {
+	if r.MinLength < 1 || r.MaxLength < r.MinLength {
+		return fmt.Errorf("login name length %d to %d is invalid", r.MinLength, r.MaxLength)
+	}
+	charset, err := r.charset()
+	if err != nil {
+		return fmt.Errorf("LOGIN_NAME_CHARSET %q is invalid: %w", r.Charset, err)
+	}
+	for _, pattern := range r.Patterns {
+		tokens := patternToken.FindAllStringSubmatch(pattern, -1)
+		if len(tokens) == 0 {
+			return fmt.Errorf("login name pattern %q has no tokens", pattern)
+		}
+		for _, token := range tokens {
+			switch token[1] {
+			case "first", "last", "f", "l", "nn":
+			default:
+				return fmt.Errorf("login name pattern %q has unknown token %s", pattern, token[0])
+			}
+		}
+		literal := patternToken.ReplaceAllString(pattern, "")
+		if strings.ContainsAny(literal, "{}") {
+			return fmt.Errorf("login name pattern %q has an unclosed token", pattern)
+		}
+		for _, c := range literal {
+			if !charset.MatchString(string(c)) {
+				return fmt.Errorf("login name pattern %q has %q outside LOGIN_NAME_CHARSET", pattern, c)
+			}
+		}
+	}
+	return nil
+}
+
+// Check login name settings from config
+func CheckLoginConfig() error {
+	return LoginRulesFromConfig().Validate()
+}
+
+func (r LoginRules) charset() (*regexp.Regexp, error) {
+	return regexp.Compile("^[" + r.Charset + "]$")
+}
+
+// Check name meets length, charset and reserved rules
+func (r LoginRules) Allowed(name string) bool {
+	return len(r.Check(name)) == 0
+}
+
+// Rules name breaks, as messages for invitees
+func (r LoginRules) Check(name string) []string {
+	var problems []string
+
+	length := utf8.RuneCountInString(name)
+	if length < r.MinLength || length > r.MaxLength {
+		problems = append(problems, fmt.Sprintf("Must be %d to %d characters", r.MinLength, r.MaxLength))
+	}
+
+	charset, err := r.charset()
+	if err != nil {
+		return append(problems, "Login names are not available, please contact support")
+	}
+	for _, c := range name {
+		if !charset.MatchString(string(c)) {
+			problems = append(problems, "May only use the characters "+r.Charset)
+			break
+		}
+	}
+
+	// Separators from patterns only between name parts
+	first, _ := utf8.DecodeRuneInString(name)
+	last, _ := utf8.DecodeLastRuneInString(name)
+	if name != "" && (!isAlphanumeric(first) || !isAlphanumeric(last)) {
+		problems = append(problems, "Must start and end with a letter or digit")
+	}
+
+	if slices.ContainsFunc(r.Reserved, func(reserved string) bool {
+		return strings.EqualFold(reserved, name)
+	}) {
+		problems = append(problems, "This login name is reserved")
+	}
+
+	return problems
+}
+
+// Login names from patterns, without checking IdM
+func (r LoginRules) Generate(invite models.Invite) []string {
+	charset, err := r.charset()
+	if err != nil {
+		return nil
+	}
+	first := namePart(invite.FirstName, charset)
+
+	// Full surname options first, then the last part of
+	// multi-part surnames
+	var options []string
+	for _, surname := range surnameVariants(invite.LastName) {
+		last := namePart(surname, charset)
+		for _, pattern := range r.Patterns {
+			name, ok := expandPattern(pattern, first, last)
+			if !ok || !r.Allowed(name) || slices.Contains(options, name) {
+				continue
+			}
+			options = append(options, name)
+		}
+	}
+	return options
+}
+
+// Numbered variants of the first usable base name, count names
+// numbered from start, also returns the next unused number
+func (r LoginRules) Variants(invite models.Invite, start int, count int) ([]string, int) {
+	charset, err := r.charset()
+	if err != nil {
+		return nil, start
+	}
+	first := namePart(invite.FirstName, charset)
+	last := namePart(invite.LastName, charset)
+
+	// Base from patterns in order, ignoring length, then name parts
+	var base string
+	for _, pattern := range r.Patterns {
+		if name, ok := expandPattern(pattern, first, last); ok {
+			base = strings.TrimRightFunc(name, unicode.IsDigit)
+			break
+		}
+	}
+	if base == "" {
+		base = first + last
+	}
+	if base == "" {
+		return nil, start
+	}
+
+	var variants []string
+	n := start
+	for ; len(variants) < count && n < start+count*10; n++ {
+		suffix := strconv.Itoa(n)
+		// Pad number to reach the minimum length
+		if pad := r.MinLength - utf8.RuneCountInString(base) - len(suffix); pad > 0 {
+			suffix = strings.Repeat("0", pad) + suffix
+		}
+		name := truncate(base, r.MaxLength-len(suffix)) + suffix
+		if r.Allowed(name) && !slices.Contains(variants, name) {
+			variants = append(variants, name)
+		}
+	}
+	return variants, n
+}
+
+// Available login names from IdM, adding numbered variants until
+// at least the minimum number of options is free
 func GetLoginOptions(invite models.Invite) ([]string, error) {
-	loginNames := LoginGenerator(invite)
+	rules := LoginRulesFromConfig()
+	loginNames := rules.Generate(invite)
 
-	readyNames, err := idm.CheckUsernamesExists(loginNames)
+	readyNames, err := directory.CheckUsernamesExists(unreserved(loginNames, invite.ID.String()))
 	if err != nil {
 		return []string{}, err
 	}
 
+	next := 1
+	for round := 0; round < variantRounds && len(readyNames) < rules.MinOptions; round++ {
+		var variants []string
+		variants, next = rules.Variants(invite, next, rules.MinOptions-len(readyNames))
+		if len(variants) == 0 {
+			break
+		}
+
+		var candidates []string
+		for _, name := range variants {
+			if !slices.Contains(readyNames, name) && !slices.Contains(loginNames, name) {
+				candidates = append(candidates, name)
+			}
+		}
+
+		free, err := directory.CheckUsernamesExists(unreserved(candidates, invite.ID.String()))
+		if err != nil {
+			return []string{}, err
+		}
+		readyNames = append(readyNames, free...)
+	}
+
 	return readyNames, nil
 }
 
-func LoginGenerator(invite models.Invite) []string {
-	firstName := strings.ToLower(invite.FirstName)
-	lastName := strings.ToLower(invite.LastName)
-	usernameNumber := strconv.Itoa(rand.IntN(100) + 1)
+// Check proposed login name against the rules, reservations by
+// invites other than inviteID, and IdM
+// Returns problems, none if the name can be used
+func CheckCustomLoginName(loginName string, inviteID string) ([]string, error) {
+	if problems := LoginRulesFromConfig().Check(loginName); len(problems) > 0 {
+		return problems, nil
+	}
 
-	options := []string{}
+	reserved, err := db.LoginNameReserved(loginName, inviteID)
+	if err != nil {
+		return nil, err
+	}
+	if reserved {
+		return []string{"This login name is already taken"}, nil
+	}
 
-	// First name + last name (never truncated)
-	options = append(options, firstName+lastName)
+	available, err := directory.LoginNameAvailable(loginName)
+	if err != nil {
+		return nil, err
+	}
+	if !available {
+		return []string{"This login name is already taken"}, nil
+	}
+	return nil, nil
+}
 
-	// First three letters + last name
-	options = append(options, firstName[:3]+lastName)
+// Names not reserved by other invites
+// Names are kept if reservations cannot be read, IdM still rejects
+// duplicates at creation
+func unreserved(loginNames []string, inviteID string) []string {
+	var names []string
+	for _, name := range loginNames {
+		reserved, err := db.LoginNameReserved(name, inviteID)
+		if err != nil {
+			log.Println("Call to LoginNameReserved() in unreserved() src/attribute/loginname.go error - " + err.Error())
+		}
+		if !reserved {
+			names = append(names, name)
+		}
+	}
+	return names
+}
 
-	// First two letters + last name
-	options = append(options, firstName[:2]+lastName)
+// Login names from configured patterns
+func LoginGenerator(invite models.Invite) []string {
+	return LoginRulesFromConfig().Generate(invite)
+}
+
+// Expand pattern tokens, false if a name part it uses is empty
+func expandPattern(pattern string, first string, last string) (string, bool) {
+	ok := true
+	name := patternToken.ReplaceAllStringFunc(pattern, func(token string) string {
+		match := patternToken.FindStringSubmatch(token)
+
+		var value string
+		switch match[1] {
+		case "first":
+			value = first
+		case "last":
+			value = last
+		case "f":
+			value = truncate(first, 1)
+		case "l":
+			value = truncate(last, 1)
+		case "nn":
+			return strconv.Itoa(rand.IntN(90) + 10)
+		default:
+			ok = false
+			return ""
+		}
 
-	// First name + last two letters
-	options = append(options, firstName+lastName[:2])
+		if value == "" {
+			ok = false
+		}
+		if match[2] != "" {
+			length, _ := strconv.Atoi(match[2])
+			value = truncate(value, length)
+		}
+		return value
+	})
+	return name, ok
+}
 
-	// First initial + last name + number
-	options = append(options, firstName[:1]+lastName+usernameNumber)
+// Transliterated name keeping only letters and digits in charset
+func namePart(name string, charset *regexp.Regexp) string {
+	var part strings.Builder
+	for _, c := range Transliterate(name) {
+		if isAlphanumeric(c) && charset.MatchString(string(c)) {
+			part.WriteRune(c)
+		}
+	}
+	return part.String()
+}
 
-	// build a new filtered slice
-	var filtered []string
-	for _, s := range options {
-		if len(s) >= 5 && len(s) <= 18 {
-			filtered = append(filtered, s)
+// First length runes of s
+func truncate(s string, length int) string {
+	if length <= 0 {
+		return ""
+	}
+	for i := range s {
+		if length == 0 {
+			return s[:i]
 		}
+		length--
 	}
+	return s
+}
 
-	return filtered
+func isAlphanumeric(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c)
 }
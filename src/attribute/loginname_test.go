@@ -1,11 +1,15 @@
 package attribute
 
 import (
+	"encoding/json"
+	"net/http"
 	"slices"
+	"strconv"
 	"strings"
 	"testing"
 
 	"github.com/hadleyso/netid-activate/src/models"
+	"github.com/spf13/viper"
 )
 
 func TestLoginGenerator(t *testing.T) {
@@ -88,3 +92,172 @@ func TestLoginGenerator_LengthFilter(t *testing.T) {
 		t.Errorf("Expected to find an option with prefix 'llonglastname', but it was not present")
 	}
 }
+
+func TestLoginGenerator_ShortNames(t *testing.T) {
+	tests := []models.Invite{
+		{FirstName: "A", LastName: "B"},
+		{FirstName: "Al", LastName: "Li"},
+		{FirstName: "J", LastName: "Wu"},
+		{FirstName: "", LastName: "Smith"},
+		{FirstName: "", LastName: ""},
+	}
+
+	for _, invite := range tests {
+		options := LoginGenerator(invite)
+		for _, opt := range options {
+			if len(opt) < DefaultLoginMinLength || len(opt) > DefaultLoginMaxLength {
+				t.Errorf("Option '%s' for %q %q is outside length limits", opt, invite.FirstName, invite.LastName)
+			}
+		}
+	}
+}
+
+func TestLoginGenerator_ConfiguredPatterns(t *testing.T) {
+	viper.Set("LOGIN_NAME_PATTERNS", []string{"{first}.{last}", "{f}{l}{last}", "{first}_{last}"})
+	viper.Set("LOGIN_NAME_CHARSET", "a-z0-9.")
+	viper.Set("LOGIN_NAME_MIN_LENGTH", 3)
+	defer func() {
+		viper.Set("LOGIN_NAME_PATTERNS", nil)
+		viper.Set("LOGIN_NAME_CHARSET", nil)
+		viper.Set("LOGIN_NAME_MIN_LENGTH", nil)
+	}()
+
+	options := LoginGenerator(models.Invite{FirstName: "Mary-Jo", LastName: "O'Neil"})
+
+	// Underscore is outside the charset
+	expected := []string{"maryjo.oneil", "mooneil"}
+	if !slices.Equal(options, expected) {
+		t.Errorf("Expected %v, got %v", expected, options)
+	}
+}
+
+func TestLoginGenerator_Reserved(t *testing.T) {
+	viper.Set("LOGIN_NAME_RESERVED", []string{"TestUser"})
+	defer viper.Set("LOGIN_NAME_RESERVED", nil)
+
+	if options := LoginGenerator(models.Invite{FirstName: "Test", LastName: "User"}); slices.Contains(options, "testuser") {
+		t.Errorf("Reserved name offered in %v", options)
+	}
+	if options := LoginGenerator(models.Invite{FirstName: "Ad", LastName: "Min"}); slices.Contains(options, "admin") {
+		t.Errorf("Default reserved name offered in %v", options)
+	}
+}
+
+func TestLoginRulesValidate(t *testing.T) {
+	base := LoginRules{Patterns: DefaultLoginPatterns, MinLength: 5, MaxLength: 18, Charset: DefaultLoginCharset}
+	if err := base.Validate(); err != nil {
+		t.Fatalf("Default rules invalid: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*LoginRules)
+	}{
+		{"unknown token", func(r *LoginRules) { r.Patterns = []string{"{middle}{last}"} }},
+		{"no tokens", func(r *LoginRules) { r.Patterns = []string{"static"} }},
+		{"unclosed token", func(r *LoginRules) { r.Patterns = []string{"{first}{last"} }},
+		{"literal outside charset", func(r *LoginRules) { r.Patterns = []string{"{first}.{last}"} }},
+		{"bad charset", func(r *LoginRules) { r.Charset = "z-a" }},
+		{"max below min", func(r *LoginRules) { r.MaxLength = 4 }},
+	}
+	for _, tt := range tests {
+		rules := base
+		tt.modify(&rules)
+		if err := rules.Validate(); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
+
+func TestLoginRulesVariants(t *testing.T) {
+	rules := LoginRules{Patterns: DefaultLoginPatterns, MinLength: 5, MaxLength: 8, Charset: DefaultLoginCharset}
+
+	variants, next := rules.Variants(models.Invite{FirstName: "Al", LastName: "Li"}, 1, 2)
+	if !slices.Equal(variants, []string{"alli1", "alli2"}) || next != 3 {
+		t.Errorf("Unexpected variants %v next %d", variants, next)
+	}
+
+	// Padded to minimum length
+	variants, _ = rules.Variants(models.Invite{FirstName: "A", LastName: "B"}, 1, 1)
+	if !slices.Equal(variants, []string{"ab001"}) {
+		t.Errorf("Unexpected variants %v", variants)
+	}
+
+	// Truncated to maximum length
+	variants, _ = rules.Variants(models.Invite{FirstName: "Longfirst", LastName: "Name"}, 9, 2)
+	if !slices.Equal(variants, []string{"longfir9", "longfi10"}) {
+		t.Errorf("Unexpected variants %v", variants)
+	}
+
+	// Nothing to build from
+	if variants, _ := rules.Variants(models.Invite{}, 1, 1); len(variants) != 0 {
+		t.Errorf("Expected no variants, got %v", variants)
+	}
+}
+
+func TestGetLoginOptions_MinimumOptions(t *testing.T) {
+	taken := map[string]bool{"alli1": true}
+	var checked []string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ipa/session/login_password", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/ipa/session/json", func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Params []json.RawMessage `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+		var options map[string]any
+		json.Unmarshal(payload.Params[1], &options)
+
+		uid, _ := options["uid"].(string)
+		checked = append(checked, uid)
+		count := 0
+		if taken[uid] {
+			count = 1
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result": {"count": ` + strconv.Itoa(count) + `}}`))
+	})
+	setupIDMTestServer(t, mux)
+
+	viper.Set("LOGIN_NAME_MIN_OPTIONS", 3)
+	defer viper.Set("LOGIN_NAME_MIN_OPTIONS", nil)
+
+	options, err := GetLoginOptions(models.Invite{FirstName: "Al", LastName: "Li"})
+	if err != nil {
+		t.Fatalf("GetLoginOptions failed: %v", err)
+	}
+	if len(options) != 3 {
+		t.Fatalf("Expected 3 options, got %v", options)
+	}
+	if slices.Contains(options, "alli1") {
+		t.Errorf("Taken name offered in %v", options)
+	}
+	if !slices.Contains(options, "alli2") {
+		t.Errorf("Expected numbered variant in %v, checked %v", options, checked)
+	}
+}
+
+func TestLoginRulesCheck(t *testing.T) {
+	rules := LoginRules{MinLength: 5, MaxLength: 10, Charset: "a-z0-9.", Reserved: []string{"admin"}}
+
+	tests := []struct {
+		name     string
+		problems int
+	}{
+		{"jane.doe", 0},
+		{"jd", 1},
+		{"jane_doe", 1},
+		{".janedoe", 1},
+		{"averyverylongname", 1},
+		{"ADMIN", 2},
+		{"", 1},
+	}
+	for _, tt := range tests {
+		if problems := rules.Check(tt.name); len(problems) != tt.problems {
+			t.Errorf("Check(%q) = %v, expected %d problems", tt.name, problems, tt.problems)
+		}
+	}
+}
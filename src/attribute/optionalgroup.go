@@ -1,9 +1,12 @@
 package attribute
 
 import (
+	"fmt"
+	"net/mail"
+
 	"github.com/hadleyso/netid-activate/src/config"
+	"github.com/hadleyso/netid-activate/src/directory"
 	"github.com/hadleyso/netid-activate/src/models"
-	idm "github.com/hadleyso/netid-activate/src/redhat-idm"
 )
 
 // Get optional groups that user can add to
@@ -37,7 +40,7 @@ func GetOptionalGroupLimited(user *models.UserInfo) ([]config.Group, error) {
 		}
 	}
 
-	err, managedGroups := idm.CheckManagedGroup(user, optionalGroups)
+	managedGroups, err := directory.ManagedGroups(user, optionalGroups)
 	if err != nil {
 		return nil, err
 	}
@@ -46,3 +49,42 @@ func GetOptionalGroupLimited(user *models.UserInfo) ([]config.Group, error) {
 
 	return filterGroup, nil
 }
+
+// Groups of OPTIONAL_GROUPS in cns that require approval, with CN set
+func ApprovalRequired(cns []string) []config.Group {
+	var groups []config.Group
+	for _, cn := range cns {
+		for _, g := range config.C.OptionalGroups[cn] {
+			if g.RequiresApproval {
+				g.CN = cn
+				groups = append(groups, g)
+				break
+			}
+		}
+	}
+	return groups
+}
+
+// Check APPROVAL_GROUP and APPROVAL_EMAIL are set when a group requires
+// approval, run at startup
+func CheckApproval() error {
+	for cn, groups := range config.C.OptionalGroups {
+		for _, g := range groups {
+			if !g.RequiresApproval {
+				continue
+			}
+			if config.C.ApprovalGroup == "" {
+				return fmt.Errorf("OPTIONAL_GROUPS %s requires approval, set APPROVAL_GROUP", cn)
+			}
+			if len(config.C.ApprovalEmail) == 0 {
+				return fmt.Errorf("OPTIONAL_GROUPS %s requires approval, set APPROVAL_EMAIL", cn)
+			}
+		}
+	}
+	for _, address := range config.C.ApprovalEmail {
+		if _, err := mail.ParseAddress(address); err != nil {
+			return fmt.Errorf("APPROVAL_EMAIL %q is not an email address", address)
+		}
+	}
+	return nil
+}
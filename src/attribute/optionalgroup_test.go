@@ -167,3 +167,45 @@ func TestGetOptionalGroupLimited(t *testing.T) {
 		})
 	}
 }
+
+func TestApprovalRequired(t *testing.T) {
+	config.C.OptionalGroups = map[string][]config.Group{
+		"prod":    {{GroupName: "Production", RequiresApproval: true}},
+		"desktop": {{GroupName: "Desktops"}},
+	}
+	t.Cleanup(func() { config.C.OptionalGroups = nil })
+
+	groups := ApprovalRequired([]string{"desktop", "prod", "unknown"})
+	expected := []config.Group{{GroupName: "Production", RequiresApproval: true, CN: "prod"}}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Errorf("expected %v, got %v", expected, groups)
+	}
+	if groups := ApprovalRequired([]string{"desktop"}); len(groups) != 0 {
+		t.Errorf("expected no groups, got %v", groups)
+	}
+}
+
+func TestCheckApproval(t *testing.T) {
+	config.C.OptionalGroups = map[string][]config.Group{"prod": {{GroupName: "Production", RequiresApproval: true}}}
+	t.Cleanup(func() {
+		config.C.OptionalGroups = nil
+		config.C.ApprovalGroup = ""
+		config.C.ApprovalEmail = nil
+	})
+
+	if err := CheckApproval(); err == nil {
+		t.Error("expected missing APPROVAL_GROUP to fail")
+	}
+	config.C.ApprovalGroup = "prod_approvers"
+	if err := CheckApproval(); err == nil {
+		t.Error("expected missing APPROVAL_EMAIL to fail")
+	}
+	config.C.ApprovalEmail = []string{"not an address"}
+	if err := CheckApproval(); err == nil {
+		t.Error("expected bad APPROVAL_EMAIL to fail")
+	}
+	config.C.ApprovalEmail = []string{"prod-approvers@example.com"}
+	if err := CheckApproval(); err != nil {
+		t.Errorf("expected approval config to pass, got %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package attribute
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Latin letters NFKD does not decompose to ASCII, lowercase
+var latinLetters = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'đ': "d", 'ð': "d",
+	'ł': "l", 'þ': "th", 'ı': "i", 'ŀ': "l", 'ħ': "h", 'ŧ': "t",
+	'ŋ': "ng", 'ĸ': "k", 'ſ': "s",
+}
+
+// Russian, Ukrainian and Belarusian Cyrillic, lowercase
+var cyrillicLetters = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e",
+	'ж': "zh", 'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m",
+	'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u",
+	'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch",
+	'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya",
+	'є': "ye", 'і': "i", 'ї': "yi", 'ґ': "g", 'ў': "u",
+}
+
+// Modern Greek, lowercase
+var greekLetters = map[rune]string{
+	'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z", 'η': "i",
+	'θ': "th", 'ι': "i", 'κ': "k", 'λ': "l", 'μ': "m", 'ν': "n", 'ξ': "x",
+	'ο': "o", 'π': "p", 'ρ': "r", 'σ': "s", 'ς': "s", 'τ': "t", 'υ': "y",
+	'φ': "f", 'χ': "ch", 'ψ': "ps", 'ω': "o",
+}
+
+// Greek digraphs transliterated as a pair
+var greekDigraphs = strings.NewReplacer("ου", "ou", "ού", "ou")
+
+// Lowercase ASCII form of name
+//
+// Applies NFKD decomposition, drops combining marks and transliterates
+// common Latin, Cyrillic and Greek letters. Other characters are kept
+// for the caller to filter.
+func Transliterate(name string) string {
+	var out strings.Builder
+	name = greekDigraphs.Replace(strings.ToLower(norm.NFC.String(name)))
+	for _, c := range name {
+
+		// Letters like й and ї are transliterated before decomposing
+		if s, ok := transliteration(c); ok {
+			out.WriteString(s)
+			continue
+		}
+
+		for _, d := range norm.NFKD.String(string(c)) {
+			if unicode.Is(unicode.Mn, d) {
+				continue
+			}
+			if s, ok := transliteration(d); ok {
+				out.WriteString(s)
+				continue
+			}
+			out.WriteRune(unicode.ToLower(d))
+		}
+	}
+	return out.String()
+}
+
+func transliteration(c rune) (string, bool) {
+	for _, letters := range []map[rune]string{latinLetters, cyrillicLetters, greekLetters} {
+		if s, ok := letters[c]; ok {
+			return s, true
+		}
+	}
+	return "", false
+}
+
+// Surname forms to build login names from, the full surname
+// then its last part for multi-part surnames
+func surnameVariants(lastName string) []string {
+	variants := []string{lastName}
+
+	parts := strings.FieldsFunc(lastName, func(c rune) bool {
+		return unicode.IsSpace(c) || c == '-' || c == '‐'
+	})
+	if len(parts) > 1 {
+		variants = append(variants, parts[len(parts)-1])
+	}
+	return variants
+}
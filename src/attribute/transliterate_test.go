@@ -0,0 +1,113 @@
+package attribute
+
+import (
+	"regexp"
+	"slices"
+	"testing"
+
+	"github.com/hadleyso/netid-activate/src/models"
+)
+
+func TestTransliterate(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"José", "jose"},
+		{"Müller-Øvergård", "muller-overgard"},
+		{"Zoë", "zoe"},
+		{"D'Angelo", "d'angelo"},
+		{"François", "francois"},
+		{"Łukasz", "lukasz"},
+		{"Straße", "strasse"},
+		{"Ærøskøbing", "aeroskobing"},
+		{"Þórunn", "thorunn"},
+		{"Đorđević", "dordevic"},
+		{"Nguyễn", "nguyen"},
+		{"Dvořák", "dvorak"},
+		{"Şahin", "sahin"},
+		{"Yıldız", "yildiz"},
+		{"Łódź", "lodz"},
+		{"Сергей", "sergey"},
+		{"Щукин", "shchukin"},
+		{"Юлия", "yuliya"},
+		{"Олексій", "oleksiy"},
+		{"Їжак", "yizhak"},
+		{"Αλέξανδρος", "alexandros"},
+		{"Γιώργος", "giorgos"},
+		{"Ψαράς", "psaras"},
+		{"Παπαδόπουλος", "papadopoulos"},
+		{"ﬁnn", "finn"},
+		{"Ｊｏｈｎ", "john"},
+	}
+
+	for _, tt := range tests {
+		if got := Transliterate(tt.name); got != tt.expected {
+			t.Errorf("Transliterate(%q) = %q, expected %q", tt.name, got, tt.expected)
+		}
+	}
+}
+
+func TestSurnameVariants(t *testing.T) {
+	tests := []struct {
+		surname  string
+		expected []string
+	}{
+		{"Smith", []string{"Smith"}},
+		{"D'Angelo", []string{"D'Angelo"}},
+		{"Müller-Øvergård", []string{"Müller-Øvergård", "Øvergård"}},
+		{"van der Berg", []string{"van der Berg", "Berg"}},
+		{"García Márquez", []string{"García Márquez", "Márquez"}},
+	}
+
+	for _, tt := range tests {
+		if got := surnameVariants(tt.surname); !slices.Equal(got, tt.expected) {
+			t.Errorf("surnameVariants(%q) = %v, expected %v", tt.surname, got, tt.expected)
+		}
+	}
+}
+
+func TestLoginGenerator_InternationalNames(t *testing.T) {
+	valid := regexp.MustCompile(`^[a-z0-9]+$`)
+
+	tests := []struct {
+		first    string
+		last     string
+		expected []string
+	}{
+		{"José", "Müller-Øvergård", []string{"josemullerovergard", "josovergard", "joseovergard"}},
+		{"Zoë", "D'Angelo", []string{"zoedangelo", "zodangelo", "zoeda"}},
+		{"Søren", "Kierkegaard", []string{"sorenkierkegaard", "sorkierkegaard"}},
+		{"Сергей", "Петров", []string{"sergeypetrov", "serpetrov"}},
+		{"Γιώργος", "Παπαδόπουλος", []string{"giopapadopoulos", "gipapadopoulos"}},
+		{"Ngọc Anh", "Nguyễn", []string{"ngocanhnguyen", "ngonguyen"}},
+		{"Mary-Jo", "van der Berg", []string{"maryjovanderberg", "maryjoberg", "marberg"}},
+		{"Åsa", "Ødegård", []string{"asaodegard"}},
+		{"Łukasz", "Wójcik", []string{"lukaszwojcik"}},
+		{"Ægir", "Þórsson", []string{"aegirthorsson"}},
+	}
+
+	for _, tt := range tests {
+		options := LoginGenerator(models.Invite{FirstName: tt.first, LastName: tt.last})
+		if len(options) == 0 {
+			t.Errorf("No options for %s %s", tt.first, tt.last)
+		}
+		for _, opt := range options {
+			if !valid.MatchString(opt) {
+				t.Errorf("Option %q for %s %s has invalid characters", opt, tt.first, tt.last)
+			}
+		}
+		for _, expected := range tt.expected {
+			if !slices.Contains(options, expected) {
+				t.Errorf("Expected %q for %s %s in %v", expected, tt.first, tt.last, options)
+			}
+		}
+	}
+}
+
+func TestLoginGenerator_UntransliteratedNames(t *testing.T) {
+	// Scripts without a mapping give no options instead of invalid ones
+	if options := LoginGenerator(models.Invite{FirstName: "太郎", LastName: "山田"}); len(options) != 0 {
+		t.Errorf("Expected no options, got %v", options)
+	}
+}
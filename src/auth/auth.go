@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"html/template"
 	"net/http"
+	"slices"
 
 	"github.com/gorilla/sessions"
 	"github.com/spf13/viper"
@@ -13,17 +14,56 @@ import (
 
 	"github.com/hadleyso/netid-activate/src/models"
 	"github.com/hadleyso/netid-activate/src/scenes"
+	"github.com/hadleyso/netid-activate/src/sessionstore"
 )
 
 var (
-	CallbackPath                             = "/auth/callback"
-	SessionCookieStore *sessions.CookieStore = nil
+	CallbackPath                      = "/auth/callback"
+	SessionCookieStore sessions.Store = nil
 )
 
+// Session store selected by SESSION_STORE
+//
+// "db" and "memory" keep session data server side with only an opaque ID
+// in the cookie, anything else stores session data in the cookie
+func NewSessionStore() sessions.Store {
+	key := []byte(viper.GetString("SESSION_KEY"))
+	switch viper.GetString("SESSION_STORE") {
+	case "db":
+		store := sessionstore.NewDBStore(key)
+		store.Options = SessionOptions(store.Options.MaxAge)
+		return store
+	case "memory":
+		store := sessionstore.NewMemoryStore(key)
+		store.Options = SessionOptions(store.Options.MaxAge)
+		return store
+	default:
+		store := sessions.NewCookieStore(key)
+		store.Options = SessionOptions(store.Options.MaxAge)
+		return store
+	}
+}
+
+// Remove all server side sessions for username
+// Returns false if the session store cannot revoke sessions
+func RevokeUserSessions(username string) (int64, bool, error) {
+	if SessionCookieStore == nil {
+		SessionCookieStore = NewSessionStore()
+	}
+
+	store, ok := SessionCookieStore.(*sessionstore.Store)
+	if !ok {
+		return 0, false, nil
+	}
+
+	count, err := store.RevokeSubject(username)
+	return count, true, err
+}
+
 // Generate HTTP error code and render login page to redirect
 func UnauthorizedLogin(w http.ResponseWriter, r *http.Request) {
 	tmpl := template.Must(template.ParseFS(scenes.TemplateFS, "scenes/login.html"))
-	http.SetCookie(w, &http.Cookie{Name: "FLASH_PATH", Value: r.RequestURI, Path: "/", MaxAge: 300})
+	http.SetCookie(w, NewCookie("FLASH_PATH", r.RequestURI, 300))
 	w.WriteHeader(http.StatusUnauthorized)
 	tmpl.Execute(w, nil)
 }
@@ -31,7 +71,7 @@ func UnauthorizedLogin(w http.ResponseWriter, r *http.Request) {
 // Sets cookie with user data after pulling from OIDC
 func MarshallUserInfo(w http.ResponseWriter, r *http.Request, tokens *oidc.Tokens[*oidc.IDTokenClaims], state string, rp rp.RelyingParty, info *oidc.UserInfo) {
 	if SessionCookieStore == nil {
-		SessionCookieStore = sessions.NewCookieStore([]byte(viper.GetString("SESSION_KEY")))
+		SessionCookieStore = NewSessionStore()
 	}
 	data, err := json.Marshal(info)
 	if err != nil {
@@ -52,30 +92,25 @@ func MarshallUserInfo(w http.ResponseWriter, r *http.Request, tokens *oidc.Token
 	}
 
 	session_IDCLAIM_IDENTITY, _ := SessionCookieStore.Get(r, "IDCLAIM_IDENTITY")
-	session_IDCLAIM_IDENTITY.Options = &sessions.Options{
-		Path:     "/",
-		MaxAge:   session_age,
-		HttpOnly: true,
-	}
+	session_IDCLAIM_IDENTITY.Options = SessionOptions(session_age)
 
 	session_IDCLAIM_IDENTITY.Values["IDP"] = &user
+	session_IDCLAIM_IDENTITY.Values[sessionstore.SubjectKey] = user.PreferredUsername
 	session_IDCLAIM_IDENTITY.Save(r, w)
 
 	session_IDCLAIM_AUTH, _ := SessionCookieStore.Get(r, "IDCLAIM_AUTH")
-	session_IDCLAIM_AUTH.Options = &sessions.Options{
-		Path:     "/",
-		MaxAge:   session_age,
-		HttpOnly: true,
-	}
+	session_IDCLAIM_AUTH.Options = SessionOptions(session_age)
 	session_IDCLAIM_AUTH.Values["AUTHENTICATED"] = true
+	session_IDCLAIM_AUTH.Values[sessionstore.SubjectKey] = user.PreferredUsername
 	session_IDCLAIM_AUTH.Save(r, w)
 
 	FLASH_PATH, errCookie := r.Cookie("FLASH_PATH")
 	if errCookie != nil {
-		http.SetCookie(w, &http.Cookie{Name: "FLASH_PATH", Value: "", Path: "/", MaxAge: 0})
+		http.SetCookie(w, NewCookie("FLASH_PATH", "", -1))
 		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
 	}
-	http.SetCookie(w, &http.Cookie{Name: "FLASH_PATH", Value: "", Path: "/", MaxAge: 0})
+	http.SetCookie(w, NewCookie("FLASH_PATH", "", -1))
 	http.Redirect(w, r, FLASH_PATH.Value, http.StatusSeeOther)
 
 }
@@ -83,7 +118,7 @@ func MarshallUserInfo(w http.ResponseWriter, r *http.Request, tokens *oidc.Token
 // Returns user data from existing session
 func GetUser(w http.ResponseWriter, r *http.Request) (*models.UserInfo, error) {
 	if SessionCookieStore == nil {
-		SessionCookieStore = sessions.NewCookieStore([]byte(viper.GetString("SESSION_KEY")))
+		SessionCookieStore = NewSessionStore()
 	}
 
 	// User data
@@ -100,7 +135,7 @@ func GetUser(w http.ResponseWriter, r *http.Request) (*models.UserInfo, error) {
 // Check if request has valid user session
 func ValidateSession(w http.ResponseWriter, r *http.Request) bool {
 	if SessionCookieStore == nil {
-		SessionCookieStore = sessions.NewCookieStore([]byte(viper.GetString("SESSION_KEY")))
+		SessionCookieStore = NewSessionStore()
 	}
 
 	session_IDCLAIM_AUTH, _ := SessionCookieStore.Get(r, "IDCLAIM_AUTH")
@@ -117,7 +152,7 @@ func MiddleValidateSession(next http.Handler) http.Handler {
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if SessionCookieStore == nil {
-			SessionCookieStore = sessions.NewCookieStore([]byte(viper.GetString("SESSION_KEY")))
+			SessionCookieStore = NewSessionStore()
 		}
 
 		session_HQ_AUTH, _ := SessionCookieStore.Get(r, "IDCLAIM_AUTH")
@@ -130,3 +165,50 @@ func MiddleValidateSession(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// Delete identity and auth sessions from the session store
+func ClearSession(w http.ResponseWriter, r *http.Request) {
+	if SessionCookieStore == nil {
+		SessionCookieStore = NewSessionStore()
+	}
+
+	for _, name := range []string{"IDCLAIM_IDENTITY", "IDCLAIM_AUTH"} {
+		session, _ := SessionCookieStore.Get(r, name)
+		session.Options = SessionOptions(-1)
+		session.Save(r, w)
+	}
+}
+
+// Check if session user is in ADMIN_GROUP
+// Must run after MiddleValidateSession
+func MiddleAdmin(next http.Handler) http.Handler {
+	return middleGroup("ADMIN_GROUP", next)
+}
+
+// Check if session user is in APPROVAL_GROUP
+// Must run after MiddleValidateSession
+func MiddleApprover(next http.Handler) http.Handler {
+	return middleGroup("APPROVAL_GROUP", next)
+}
+
+// Forbid users not in the group set by key, nobody if unset
+func middleGroup(key string, next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		group := viper.GetString(key)
+
+		user, err := GetUser(w, r)
+		if err != nil {
+			return
+		}
+
+		if group == "" || !slices.Contains(user.Groups, group) {
+			tmpl := template.Must(template.ParseFS(scenes.TemplateFS, "scenes/403.html", "scenes/base.html"))
+			w.WriteHeader(http.StatusForbidden)
+			tmpl.ExecuteTemplate(w, "base", models.NewPageBase(""))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
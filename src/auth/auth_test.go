@@ -11,6 +11,7 @@ import (
 	"github.com/spf13/viper"
 
 	"github.com/hadleyso/netid-activate/src/models"
+	"github.com/hadleyso/netid-activate/src/sessionstore"
 )
 
 // helper: reset global store and set session key
@@ -182,3 +183,98 @@ func TestMiddleValidateSession_Behavior(t *testing.T) {
 		t.Fatalf("expected next to set status %d, got %d", http.StatusOK, rr2.Result().StatusCode)
 	}
 }
+
+func TestMiddleAdmin(t *testing.T) {
+	setupStore(t)
+	viper.Set("ADMIN_GROUP", "admins")
+	defer viper.Set("ADMIN_GROUP", "")
+
+	called := false
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := MiddleAdmin(next)
+
+	// not in admin group
+	req, rr := requestWithSession(t, "IDCLAIM_IDENTITY", map[interface{}]interface{}{
+		"IDP": &models.UserInfo{PreferredUsername: "jdoe", Groups: []string{"staff"}},
+	})
+	handler.ServeHTTP(rr, req)
+	if called {
+		t.Fatalf("expected next not to be called for non admin")
+	}
+	if rr.Result().StatusCode != http.StatusForbidden {
+		t.Fatalf("expected status %d got %d", http.StatusForbidden, rr.Result().StatusCode)
+	}
+
+	// in admin group
+	req2, rr2 := requestWithSession(t, "IDCLAIM_IDENTITY", map[interface{}]interface{}{
+		"IDP": &models.UserInfo{PreferredUsername: "jdoe", Groups: []string{"admins"}},
+	})
+	handler.ServeHTTP(rr2, req2)
+	if !called {
+		t.Fatalf("expected next to be called for admin")
+	}
+}
+
+func TestMiddleApprover(t *testing.T) {
+	setupStore(t)
+	viper.Set("ADMIN_GROUP", "admins")
+	defer viper.Set("ADMIN_GROUP", "")
+
+	called := false
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+	handler := MiddleApprover(next)
+
+	// APPROVAL_GROUP unset, admins are not approvers
+	req, rr := requestWithSession(t, "IDCLAIM_IDENTITY", map[interface{}]interface{}{
+		"IDP": &models.UserInfo{PreferredUsername: "jdoe", Groups: []string{"admins"}},
+	})
+	handler.ServeHTTP(rr, req)
+	if called || rr.Result().StatusCode != http.StatusForbidden {
+		t.Fatalf("expected forbidden without APPROVAL_GROUP, got %d", rr.Result().StatusCode)
+	}
+
+	viper.Set("APPROVAL_GROUP", "prod_approvers")
+	defer viper.Set("APPROVAL_GROUP", "")
+	req2, rr2 := requestWithSession(t, "IDCLAIM_IDENTITY", map[interface{}]interface{}{
+		"IDP": &models.UserInfo{PreferredUsername: "jdoe", Groups: []string{"prod_approvers"}},
+	})
+	handler.ServeHTTP(rr2, req2)
+	if !called {
+		t.Fatalf("expected next to be called for approver")
+	}
+}
+
+func TestRevokeUserSessions(t *testing.T) {
+	setupStore(t)
+
+	// cookie store cannot revoke
+	_, supported, err := RevokeUserSessions("jdoe")
+	if err != nil || supported {
+		t.Fatalf("expected unsupported without error, got supported=%v err=%v", supported, err)
+	}
+
+	// memory store
+	viper.Set("SESSION_STORE", "memory")
+	defer viper.Set("SESSION_STORE", "")
+	SessionCookieStore = NewSessionStore()
+
+	req, _ := requestWithSession(t, "IDCLAIM_AUTH", map[interface{}]interface{}{
+		"AUTHENTICATED":         true,
+		sessionstore.SubjectKey: "jdoe",
+	})
+
+	count, supported, err := RevokeUserSessions("jdoe")
+	if err != nil || !supported || count != 1 {
+		t.Fatalf("expected one revoked session, got count=%d supported=%v err=%v", count, supported, err)
+	}
+
+	rr := httptest.NewRecorder()
+	if ValidateSession(rr, req) {
+		t.Fatalf("expected revoked session to be invalid")
+	}
+}
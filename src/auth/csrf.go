@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"log"
+	"net/http"
+	"net/url"
+	"text/template"
+
+	"github.com/gorilla/csrf"
+	"github.com/gorilla/mux"
+	"github.com/spf13/viper"
+
+	"github.com/hadleyso/netid-activate/src/models"
+	"github.com/hadleyso/netid-activate/src/proxy"
+	"github.com/hadleyso/netid-activate/src/scenes"
+)
+
+// Form field holding the CSRF token
+const CSRFFieldName = "csrf_token"
+
+// URL users reach the app at, SERVER_HOSTNAME with OIDC_SERVER_PORT
+func PublicURL() string {
+	serverURL := viper.GetString("SERVER_HOSTNAME")
+	if viper.GetString("OIDC_SERVER_PORT") != "" {
+		serverURL = serverURL + ":" + viper.GetString("OIDC_SERVER_PORT")
+	}
+	return serverURL
+}
+
+// CSRF protection for all state changing requests
+//
+// Token is kept in the IDCLAIM_CSRF cookie and must be posted back in the
+// csrf_token form field. The OIDC callback is exempt.
+func NewCSRFMiddleware() mux.MiddlewareFunc {
+	publicURL, _ := url.Parse(PublicURL())
+	plaintext := !IsHTTPS()
+
+	var trustedOrigins []string
+	if publicURL != nil && publicURL.Host != "" {
+		trustedOrigins = append(trustedOrigins, publicURL.Host)
+	}
+
+	// Separate key from the session key
+	key := sha256.Sum256([]byte("csrf:" + viper.GetString("SESSION_KEY")))
+
+	protect := csrf.Protect(key[:],
+		csrf.CookieName("IDCLAIM_CSRF"),
+		csrf.FieldName(CSRFFieldName),
+		csrf.Path("/"),
+		csrf.HttpOnly(true),
+		csrf.Secure(SecureCookies()),
+		csrf.SameSite(csrf.SameSiteLaxMode),
+		csrf.TrustedOrigins(trustedOrigins),
+		csrf.ErrorHandler(http.HandlerFunc(CSRFFailure)),
+	)
+
+	return func(next http.Handler) http.Handler {
+		protected := protect(next)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if plaintext && proxy.Scheme(r) != "https" {
+				r = csrf.PlaintextHTTPRequest(r)
+			}
+			if r.URL.Path == CallbackPath {
+				r = csrf.UnsafeSkipCheck(r)
+			}
+			protected.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Render 403 page for requests failing CSRF validation
+func CSRFFailure(w http.ResponseWriter, r *http.Request) {
+	log.Printf("CSRFFailure() %s %s from %s: %v\n", r.Method, r.URL.Path, proxy.ClientIP(r), csrf.FailureReason(r))
+
+	tmpl := template.Must(template.ParseFS(scenes.TemplateFS, "scenes/400.html", "scenes/base.html"))
+	w.WriteHeader(http.StatusForbidden)
+	tmpl.ExecuteTemplate(w, "base",
+		struct {
+			Tile    string
+			Message string
+			models.PageBase
+		}{
+			Message:  "Your form could not be verified, it may have expired. Please go back, reload the page and try again.",
+			Tile:     "Request Rejected",
+			PageBase: models.NewPageBase(""),
+		},
+	)
+}
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/sessions"
+	"github.com/hadleyso/netid-activate/src/challenge"
+	"github.com/spf13/viper"
+)
+
+// Default Content-Security-Policy, inline script and style are used by scenes
+const DefaultContentSecurityPolicy = "default-src 'self'; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline'; img-src 'self' https: data:; font-src 'self'; object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'"
+
+// Default policy allowing scripts and frames from extra origins, used by
+// third party challenge providers
+func defaultContentSecurityPolicy(origins []string) string {
+	if len(origins) == 0 {
+		return DefaultContentSecurityPolicy
+	}
+	sources := strings.Join(origins, " ")
+	csp := strings.Replace(DefaultContentSecurityPolicy, "script-src 'self' 'unsafe-inline'", "script-src 'self' 'unsafe-inline' "+sources, 1)
+	return csp + "; frame-src " + sources + "; connect-src 'self' " + sources
+}
+
+// Check if users reach the app over HTTPS based on SERVER_HOSTNAME
+func IsHTTPS() bool {
+	publicURL, err := url.Parse(PublicURL())
+	if err != nil {
+		return false
+	}
+	return publicURL.Scheme == "https"
+}
+
+// Check if cookies should have the Secure attribute
+// SECURE_COOKIES overrides the SERVER_HOSTNAME scheme check
+func SecureCookies() bool {
+	if viper.IsSet("SECURE_COOKIES") {
+		return viper.GetBool("SECURE_COOKIES")
+	}
+	return IsHTTPS()
+}
+
+// Session options with the app cookie attributes
+//
+// Every cookie set by the app should use this or NewCookie
+func SessionOptions(maxAge int) *sessions.Options {
+	return &sessions.Options{
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   SecureCookies(),
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// Cookie with the app cookie attributes
+// maxAge < 0 deletes the cookie
+func NewCookie(name string, value string, maxAge int) *http.Cookie {
+	return sessions.NewCookie(name, value, SessionOptions(maxAge))
+}
+
+// Set security response headers
+func MiddleSecurityHeaders(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		csp := viper.GetString("CONTENT_SECURITY_POLICY")
+		if csp == "" {
+			csp = defaultContentSecurityPolicy(challenge.ExternalOrigins())
+		}
+
+		headers := w.Header()
+		headers.Set("Content-Security-Policy", csp)
+		headers.Set("X-Frame-Options", "DENY")
+		headers.Set("X-Content-Type-Options", "nosniff")
+		headers.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		if SecureCookies() {
+			headers.Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Require a verified client certificate when TLS_CLIENT_CA_PATH is set
+func MiddleClientCert(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if viper.GetString("TLS_CLIENT_CA_PATH") != "" {
+			if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+				http.Error(w, "client certificate required", http.StatusForbidden)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func resetSecurityConfig() {
+	viper.Set("SERVER_HOSTNAME", "")
+	viper.Set("OIDC_SERVER_PORT", "")
+	viper.Set("CONTENT_SECURITY_POLICY", "")
+	viper.Set("SECURE_COOKIES", nil)
+	viper.Set("CHALLENGE_PROVIDER", "")
+}
+
+func TestSecureCookies(t *testing.T) {
+	defer resetSecurityConfig()
+
+	cases := []struct {
+		name     string
+		hostname string
+		override any
+		expected bool
+	}{
+		{name: "https hostname", hostname: "https://idclaim.example.com", expected: true},
+		{name: "http hostname", hostname: "http://localhost", expected: false},
+		{name: "override off", hostname: "https://idclaim.example.com", override: false, expected: false},
+		{name: "override on", hostname: "http://localhost", override: true, expected: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			resetSecurityConfig()
+			viper.Set("SERVER_HOSTNAME", tc.hostname)
+			if tc.override != nil {
+				viper.Set("SECURE_COOKIES", tc.override)
+			}
+
+			if got := SecureCookies(); got != tc.expected {
+				t.Fatalf("expected SecureCookies() %v got %v", tc.expected, got)
+			}
+
+			opts := SessionOptions(60)
+			if opts.Secure != tc.expected || !opts.HttpOnly || opts.SameSite != http.SameSiteLaxMode {
+				t.Fatalf("unexpected session options %+v", opts)
+			}
+
+			cookie := NewCookie("TEST", "value", 60)
+			if cookie.Secure != tc.expected || !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+				t.Fatalf("unexpected cookie %+v", cookie)
+			}
+		})
+	}
+}
+
+func TestMiddleSecurityHeaders(t *testing.T) {
+	defer resetSecurityConfig()
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := MiddleSecurityHeaders(next)
+
+	// plain http, no HSTS
+	resetSecurityConfig()
+	viper.Set("SERVER_HOSTNAME", "http://localhost")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	headers := rr.Result().Header
+	if headers.Get("Content-Security-Policy") != DefaultContentSecurityPolicy {
+		t.Fatalf("expected default CSP, got %q", headers.Get("Content-Security-Policy"))
+	}
+	if headers.Get("X-Frame-Options") != "DENY" {
+		t.Fatalf("expected X-Frame-Options DENY, got %q", headers.Get("X-Frame-Options"))
+	}
+	if headers.Get("Referrer-Policy") == "" || headers.Get("X-Content-Type-Options") != "nosniff" {
+		t.Fatalf("expected Referrer-Policy and X-Content-Type-Options headers")
+	}
+	if headers.Get("Strict-Transport-Security") != "" {
+		t.Fatalf("expected no HSTS over http")
+	}
+
+	// https with custom CSP
+	viper.Set("SERVER_HOSTNAME", "https://idclaim.example.com")
+	viper.Set("CONTENT_SECURITY_POLICY", "default-src 'none'")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	headers = rr.Result().Header
+	if headers.Get("Strict-Transport-Security") == "" {
+		t.Fatalf("expected HSTS over https")
+	}
+	if headers.Get("Content-Security-Policy") != "default-src 'none'" {
+		t.Fatalf("expected custom CSP, got %q", headers.Get("Content-Security-Policy"))
+	}
+}
+
+func TestMiddleSecurityHeadersChallengeOrigins(t *testing.T) {
+	defer resetSecurityConfig()
+	resetSecurityConfig()
+	viper.Set("CHALLENGE_PROVIDER", "turnstile")
+
+	handler := MiddleSecurityHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	csp := rr.Result().Header.Get("Content-Security-Policy")
+	if !strings.Contains(csp, "script-src 'self' 'unsafe-inline' https://challenges.cloudflare.com") {
+		t.Fatalf("expected turnstile script source, got %q", csp)
+	}
+	if !strings.Contains(csp, "frame-src https://challenges.cloudflare.com") {
+		t.Fatalf("expected turnstile frame source, got %q", csp)
+	}
+}
+
+func TestMiddleClientCert(t *testing.T) {
+	defer viper.Set("TLS_CLIENT_CA_PATH", "")
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := MiddleClientCert(next)
+
+	// No client CA, open
+	viper.Set("TLS_CLIENT_CA_PATH", "")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/status", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d got %d", http.StatusOK, rr.Code)
+	}
+
+	// Client CA, no certificate
+	viper.Set("TLS_CLIENT_CA_PATH", "/abs/path/client-ca.crt")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/status", nil))
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected status %d got %d", http.StatusForbidden, rr.Code)
+	}
+}
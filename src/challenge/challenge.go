@@ -0,0 +1,111 @@
+package challenge
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/hadleyso/netid-activate/src/ratelimit"
+	"github.com/spf13/viper"
+)
+
+// Default activation attempts per client IP or email before a challenge
+// is required
+const DefaultThreshold = "3/1h"
+
+// Data for rendering a challenge on a form
+type Widget struct {
+	Provider   string
+	SiteKey    string
+	Token      string
+	Difficulty int
+}
+
+// Challenge provider
+type Verifier interface {
+	// Challenge to render on the form for request
+	Widget(r *http.Request) (Widget, error)
+	// Check the challenge response posted with request
+	Verify(r *http.Request) (bool, error)
+}
+
+// Check CHALLENGE_* settings, run at startup so a mistyped provider
+// does not turn challenges off
+func CheckConfig() error {
+	provider := viper.GetString("CHALLENGE_PROVIDER")
+	switch provider {
+	case "", "pow":
+	case "hcaptcha", "turnstile":
+		if viper.GetString("CHALLENGE_SITE_KEY") == "" || viper.GetString("CHALLENGE_SECRET") == "" {
+			return fmt.Errorf("CHALLENGE_PROVIDER %s needs CHALLENGE_SITE_KEY and CHALLENGE_SECRET", provider)
+		}
+	default:
+		return fmt.Errorf("CHALLENGE_PROVIDER %q must be pow, hcaptcha, turnstile or empty", provider)
+	}
+
+	if viper.IsSet("CHALLENGE_THRESHOLD") {
+		if _, err := ratelimit.ParseLimit(viper.GetString("CHALLENGE_THRESHOLD")); err != nil {
+			return fmt.Errorf("CHALLENGE_THRESHOLD: %w", err)
+		}
+	}
+	return nil
+}
+
+// Verifier chosen by CHALLENGE_PROVIDER
+// Returns nil if challenges are disabled
+func New() Verifier {
+	switch viper.GetString("CHALLENGE_PROVIDER") {
+	case "":
+		return nil
+	case "pow":
+		return NewProofOfWork(viper.GetString("SESSION_KEY"), viper.GetInt("CHALLENGE_POW_DIFFICULTY"), ratelimit.New())
+	case "hcaptcha":
+		return NewHCaptcha(viper.GetString("CHALLENGE_SITE_KEY"), viper.GetString("CHALLENGE_SECRET"))
+	case "turnstile":
+		return NewTurnstile(viper.GetString("CHALLENGE_SITE_KEY"), viper.GetString("CHALLENGE_SECRET"))
+	default:
+		// Rejected by CheckConfig at startup
+		log.Println("New() unknown CHALLENGE_PROVIDER " + viper.GetString("CHALLENGE_PROVIDER") + ", challenges disabled")
+		return nil
+	}
+}
+
+// Attempts allowed before a challenge is required, CHALLENGE_THRESHOLD
+// A disabled threshold (0) challenges every attempt, as does an invalid
+// one CheckConfig would have rejected
+func Threshold() ratelimit.Limit {
+	value := DefaultThreshold
+	if viper.IsSet("CHALLENGE_THRESHOLD") {
+		value = viper.GetString("CHALLENGE_THRESHOLD")
+	}
+	limit, err := ratelimit.ParseLimit(value)
+	if err != nil {
+		log.Println("Error in Threshold(): " + err.Error())
+		return ratelimit.Limit{}
+	}
+	return limit
+}
+
+// Third party origins the configured provider loads scripts and frames from
+func ExternalOrigins() []string {
+	switch viper.GetString("CHALLENGE_PROVIDER") {
+	case "hcaptcha":
+		return []string{"https://hcaptcha.com", "https://*.hcaptcha.com"}
+	case "turnstile":
+		return []string{"https://challenges.cloudflare.com"}
+	}
+	return nil
+}
+
+// Verifier for tests, accepts a fixed challengeResponse form value
+type Stub struct {
+	Response string
+}
+
+func (s Stub) Widget(r *http.Request) (Widget, error) {
+	return Widget{Provider: "stub"}, nil
+}
+
+func (s Stub) Verify(r *http.Request) (bool, error) {
+	return s.Response != "" && r.PostFormValue("challengeResponse") == s.Response, nil
+}
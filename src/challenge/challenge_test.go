@@ -0,0 +1,222 @@
+package challenge
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hadleyso/netid-activate/src/ratelimit"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func newFormRequest(form url.Values) *http.Request {
+	req := httptest.NewRequest("POST", "/activate", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+func solve(token string, difficulty int) string {
+	for i := 0; ; i++ {
+		nonce := strconv.Itoa(i)
+		if Solved(token, nonce, difficulty) {
+			return nonce
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	defer viper.Set("CHALLENGE_PROVIDER", nil)
+
+	viper.Set("CHALLENGE_PROVIDER", "")
+	assert.Nil(t, New())
+
+	viper.Set("CHALLENGE_PROVIDER", "unknown")
+	assert.Nil(t, New())
+
+	viper.Set("CHALLENGE_PROVIDER", "pow")
+	assert.IsType(t, &ProofOfWork{}, New())
+
+	viper.Set("CHALLENGE_PROVIDER", "hcaptcha")
+	assert.Equal(t, "h-captcha-response", New().(*SiteVerifier).ResponseField)
+	assert.Contains(t, ExternalOrigins(), "https://hcaptcha.com")
+
+	viper.Set("CHALLENGE_PROVIDER", "turnstile")
+	assert.Equal(t, "cf-turnstile-response", New().(*SiteVerifier).ResponseField)
+	assert.Equal(t, []string{"https://challenges.cloudflare.com"}, ExternalOrigins())
+}
+
+func TestThreshold(t *testing.T) {
+	defer viper.Set("CHALLENGE_THRESHOLD", nil)
+
+	viper.Set("CHALLENGE_THRESHOLD", nil)
+	assert.Equal(t, ratelimit.Limit{Burst: 3, Period: time.Hour}, Threshold())
+
+	viper.Set("CHALLENGE_THRESHOLD", "0")
+	assert.False(t, Threshold().Enabled())
+
+	// Fails closed
+	viper.Set("CHALLENGE_THRESHOLD", "bad")
+	assert.False(t, Threshold().Enabled())
+}
+
+func TestCheckConfig(t *testing.T) {
+	defer func() {
+		viper.Set("CHALLENGE_PROVIDER", nil)
+		viper.Set("CHALLENGE_SITE_KEY", nil)
+		viper.Set("CHALLENGE_SECRET", nil)
+		viper.Set("CHALLENGE_THRESHOLD", nil)
+	}()
+
+	viper.Set("CHALLENGE_PROVIDER", "")
+	assert.NoError(t, CheckConfig())
+
+	viper.Set("CHALLENGE_PROVIDER", "pow")
+	assert.NoError(t, CheckConfig())
+
+	viper.Set("CHALLENGE_PROVIDER", "turnsile")
+	assert.Error(t, CheckConfig())
+
+	// Site providers need both keys
+	viper.Set("CHALLENGE_PROVIDER", "turnstile")
+	viper.Set("CHALLENGE_SITE_KEY", "site")
+	assert.Error(t, CheckConfig())
+	viper.Set("CHALLENGE_SECRET", "secret")
+	assert.NoError(t, CheckConfig())
+	viper.Set("CHALLENGE_PROVIDER", "hcaptcha")
+	viper.Set("CHALLENGE_SITE_KEY", "")
+	assert.Error(t, CheckConfig())
+
+	viper.Set("CHALLENGE_PROVIDER", "pow")
+	viper.Set("CHALLENGE_THRESHOLD", "bad")
+	assert.Error(t, CheckConfig())
+	viper.Set("CHALLENGE_THRESHOLD", "0")
+	assert.NoError(t, CheckConfig())
+}
+
+func TestStub(t *testing.T) {
+	stub := Stub{Response: "pass"}
+
+	widget, err := stub.Widget(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "stub", widget.Provider)
+
+	ok, _ := stub.Verify(newFormRequest(url.Values{"challengeResponse": {"pass"}}))
+	assert.True(t, ok)
+	ok, _ = stub.Verify(newFormRequest(url.Values{"challengeResponse": {"fail"}}))
+	assert.False(t, ok)
+	ok, _ = Stub{}.Verify(newFormRequest(url.Values{}))
+	assert.False(t, ok)
+}
+
+func TestProofOfWork(t *testing.T) {
+	pow := NewProofOfWork("secret", 8, ratelimit.NewMemoryLimiter())
+
+	widget, err := pow.Widget(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "pow", widget.Provider)
+	assert.Equal(t, 8, widget.Difficulty)
+
+	nonce := solve(widget.Token, 8)
+	form := url.Values{"powToken": {widget.Token}, "powNonce": {nonce}}
+
+	ok, err := pow.Verify(newFormRequest(form))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// Replay rejected
+	ok, err = pow.Verify(newFormRequest(form))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestProofOfWorkRejects(t *testing.T) {
+	pow := NewProofOfWork("secret", 8, ratelimit.NewMemoryLimiter())
+
+	// Missing fields
+	ok, _ := pow.Verify(newFormRequest(url.Values{}))
+	assert.False(t, ok)
+
+	// Signed with another key
+	other := NewProofOfWork("other", 8, ratelimit.NewMemoryLimiter())
+	widget, _ := other.Widget(nil)
+	ok, _ = pow.Verify(newFormRequest(url.Values{"powToken": {widget.Token}, "powNonce": {solve(widget.Token, 8)}}))
+	assert.False(t, ok)
+
+	// Expired
+	token, _ := pow.issue(time.Now().Add(-time.Hour))
+	ok, _ = pow.Verify(newFormRequest(url.Values{"powToken": {token}, "powNonce": {solve(token, 8)}}))
+	assert.False(t, ok)
+
+	// Unsolved
+	token, _ = pow.issue(time.Now())
+	nonce := 0
+	for Solved(token, strconv.Itoa(nonce), 8) {
+		nonce++
+	}
+	ok, _ = pow.Verify(newFormRequest(url.Values{"powToken": {token}, "powNonce": {strconv.Itoa(nonce)}}))
+	assert.False(t, ok)
+
+	// Malformed
+	ok, _ = pow.Verify(newFormRequest(url.Values{"powToken": {"abc.def"}, "powNonce": {"1"}}))
+	assert.False(t, ok)
+}
+
+func TestSiteVerifier(t *testing.T) {
+	var gotForm url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		gotForm = r.PostForm
+		w.Header().Set("Content-Type", "application/json")
+		if r.PostForm.Get("response") == "good" {
+			w.Write([]byte(`{"success": true}`))
+			return
+		}
+		w.Write([]byte(`{"success": false, "error-codes": ["invalid-input-response"]}`))
+	}))
+	defer server.Close()
+
+	verifier := NewTurnstile("site-key", "secret-key")
+	verifier.VerifyURL = server.URL
+
+	widget, err := verifier.Widget(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, Widget{Provider: "turnstile", SiteKey: "site-key"}, widget)
+
+	req := newFormRequest(url.Values{"cf-turnstile-response": {"good"}})
+	req.RemoteAddr = "198.51.100.4:1234"
+	ok, err := verifier.Verify(req)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "secret-key", gotForm.Get("secret"))
+	assert.Equal(t, "198.51.100.4", gotForm.Get("remoteip"))
+
+	ok, err = verifier.Verify(newFormRequest(url.Values{"cf-turnstile-response": {"bad"}}))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// No response does not call the provider
+	gotForm = nil
+	ok, err = verifier.Verify(newFormRequest(url.Values{}))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Nil(t, gotForm)
+}
+
+func TestSiteVerifierError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	verifier := NewHCaptcha("site-key", "secret-key")
+	verifier.VerifyURL = server.URL
+
+	ok, err := verifier.Verify(newFormRequest(url.Values{"h-captcha-response": {"good"}}))
+	assert.Error(t, err)
+	assert.False(t, ok)
+}
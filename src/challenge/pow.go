@@ -0,0 +1,130 @@
+package challenge
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hadleyso/netid-activate/src/ratelimit"
+)
+
+// Default leading zero bits required, around 65k hashes
+const DefaultDifficulty = 16
+
+// How long an issued proof of work token can be solved and used
+const powTTL = 10 * time.Minute
+
+// Self hosted proof of work
+//
+// The token is a signed timestamp and random value. The browser searches
+// for a nonce where sha256(token:nonce) starts with Difficulty zero bits.
+// Each solved token is accepted once.
+type ProofOfWork struct {
+	key        []byte
+	Difficulty int
+	used       *ratelimit.Limiter
+}
+
+func NewProofOfWork(secret string, difficulty int, used *ratelimit.Limiter) *ProofOfWork {
+	if difficulty <= 0 {
+		difficulty = DefaultDifficulty
+	}
+	key := sha256.Sum256([]byte("challenge:" + secret))
+	return &ProofOfWork{key: key[:], Difficulty: difficulty, used: used}
+}
+
+func (p *ProofOfWork) Widget(r *http.Request) (Widget, error) {
+	token, err := p.issue(time.Now())
+	if err != nil {
+		return Widget{}, err
+	}
+	return Widget{Provider: "pow", Token: token, Difficulty: p.Difficulty}, nil
+}
+
+func (p *ProofOfWork) Verify(r *http.Request) (bool, error) {
+	token := r.PostFormValue("powToken")
+	nonce := r.PostFormValue("powNonce")
+	if token == "" || nonce == "" || len(nonce) > 32 {
+		return false, nil
+	}
+
+	if !p.valid(token, time.Now()) || !Solved(token, nonce, p.Difficulty) {
+		return false, nil
+	}
+
+	// Accept each token once
+	ok, _, err := p.used.Allow("pow:"+token, ratelimit.Limit{Burst: 1, Period: powTTL})
+	if err != nil {
+		return false, err
+	}
+	return ok, nil
+}
+
+// New signed token
+func (p *ProofOfWork) issue(now time.Time) (string, error) {
+	payload := make([]byte, 24)
+	binary.BigEndian.PutUint64(payload, uint64(now.Unix()))
+	if _, err := rand.Read(payload[8:]); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(p.sign(payload)), nil
+}
+
+// Check signature and age of token
+func (p *ProofOfWork) valid(token string, now time.Time) bool {
+	payload, sig, err := p.decode(token)
+	if err != nil {
+		return false
+	}
+	if !hmac.Equal(sig, p.sign(payload)) {
+		return false
+	}
+
+	issued := time.Unix(int64(binary.BigEndian.Uint64(payload)), 0)
+	return now.Sub(issued) < powTTL && !issued.After(now.Add(time.Minute))
+}
+
+func (p *ProofOfWork) decode(token string) ([]byte, []byte, error) {
+	encPayload, encSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, nil, errors.New("malformed token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encPayload)
+	if err != nil || len(payload) != 24 {
+		return nil, nil, errors.New("malformed token payload")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encSig)
+	if err != nil {
+		return nil, nil, err
+	}
+	return payload, sig, nil
+}
+
+func (p *ProofOfWork) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// Check sha256(token:nonce) starts with difficulty zero bits
+func Solved(token string, nonce string, difficulty int) bool {
+	sum := sha256.Sum256([]byte(token + ":" + nonce))
+
+	zeros := 0
+	for _, b := range sum {
+		if b != 0 {
+			zeros += bits.LeadingZeros8(b)
+			break
+		}
+		zeros += 8
+	}
+	return zeros >= difficulty
+}
@@ -0,0 +1,87 @@
+package challenge
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/hadleyso/netid-activate/src/proxy"
+)
+
+const hCaptchaVerifyURL = "https://api.hcaptcha.com/siteverify"
+
+const turnstileVerifyURL = "https://challenges.cloudflare.com/turnstile/v0/siteverify"
+
+// hCaptcha or Turnstile style provider, the browser widget posts a response
+// token which is checked against the provider siteverify endpoint
+type SiteVerifier struct {
+	Provider      string
+	SiteKey       string
+	Secret        string
+	VerifyURL     string
+	ResponseField string
+	Client        *http.Client
+}
+
+func NewHCaptcha(siteKey string, secret string) *SiteVerifier {
+	return &SiteVerifier{
+		Provider:      "hcaptcha",
+		SiteKey:       siteKey,
+		Secret:        secret,
+		VerifyURL:     hCaptchaVerifyURL,
+		ResponseField: "h-captcha-response",
+		Client:        &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func NewTurnstile(siteKey string, secret string) *SiteVerifier {
+	return &SiteVerifier{
+		Provider:      "turnstile",
+		SiteKey:       siteKey,
+		Secret:        secret,
+		VerifyURL:     turnstileVerifyURL,
+		ResponseField: "cf-turnstile-response",
+		Client:        &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *SiteVerifier) Widget(r *http.Request) (Widget, error) {
+	return Widget{Provider: s.Provider, SiteKey: s.SiteKey}, nil
+}
+
+func (s *SiteVerifier) Verify(r *http.Request) (bool, error) {
+	response := r.PostFormValue(s.ResponseField)
+	if response == "" {
+		return false, nil
+	}
+
+	form := url.Values{}
+	form.Set("secret", s.Secret)
+	form.Set("response", response)
+	form.Set("remoteip", proxy.ClientIP(r))
+	if s.SiteKey != "" {
+		form.Set("sitekey", s.SiteKey)
+	}
+
+	resp, err := s.Client.PostForm(s.VerifyURL, form)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("%s siteverify returned %d", s.Provider, resp.StatusCode)
+	}
+
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, err
+	}
+
+	return result.Success, nil
+}
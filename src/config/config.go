@@ -1,40 +1,123 @@
 package config
 
 type Config struct {
-	SessionKey          string              `mapstructure:"SESSION_KEY" yaml:"SESSION_KEY"`
-	ServerPort          int                 `mapstructure:"SERVER_PORT" yaml:"SERVER_PORT"`
-	ServerHostname      string              `mapstructure:"SERVER_HOSTNAME" yaml:"SERVER_HOSTNAME"`
-	OIDCServerPort      int                 `mapstructure:"OIDC_SERVER_PORT" yaml:"OIDC_SERVER_PORT"`
-	OIDCWellKnown       string              `mapstructure:"OIDC_WELL_KNOWN" yaml:"OIDC_WELL_KNOWN"`
-	ClientID            string              `mapstructure:"CLIENT_ID" yaml:"CLIENT_ID"`
-	ClientSecret        string              `mapstructure:"CLIENT_SECRET" yaml:"CLIENT_SECRET"`
-	Scopes              string              `mapstructure:"SCOPES" yaml:"SCOPES"`
-	DBPath              string              `mapstructure:"DB_PATH" yaml:"DB_PATH"`
-	LogoURL             string              `mapstructure:"LOGO_URL" yaml:"LOGO_URL"`
-	FaviconURL          string              `mapstructure:"FAVICON_URL" yaml:"FAVICON_URL"`
-	SiteName            string              `mapstructure:"SITE_NAME" yaml:"SITE_NAME"`
-	TenantName          string              `mapstructure:"TENANT_NAME" yaml:"TENANT_NAME"`
-	Affiliation         []map[string]string `mapstructure:"AFFILIATION" yaml:"AFFILIATION"`
-	LoginRedirect       string              `mapstructure:"LOGIN_REDIRECT" yaml:"LOGIN_REDIRECT"`
-	LinkServiceProvider string              `mapstructure:"LINK_SERVICE_PROVIDER" yaml:"LINK_SERVICE_PROVIDER"`
-	LinkPrivacyPolicy   string              `mapstructure:"LINK_PRIVACY_POLICY" yaml:"LINK_PRIVACY_POLICY"`
-	EmailFrom           string              `mapstructure:"EMAIL_FROM" yaml:"EMAIL_FROM"`
-	AWSRegion           string              `mapstructure:"AWS_REGION" yaml:"AWS_REGION"`
-	AWSAccessKeyID      string              `mapstructure:"AWS_ACCESS_KEY_ID" yaml:"AWS_ACCESS_KEY_ID"`
-	AWSSecretAccessKey  string              `mapstructure:"AWS_SECRET_ACCESS_KEY" yaml:"AWS_SECRET_ACCESS_KEY"`
-	CACertPath          string              `mapstructure:"CACERT_PATH" yaml:"CACERT_PATH"`
-	IDMHost             string              `mapstructure:"IDM_HOST" yaml:"IDM_HOST"`
-	IDMUsername         string              `mapstructure:"IDM_USERNAME" yaml:"IDM_USERNAME"`
-	IDMPassword         string              `mapstructure:"IDM_PASSWORD" yaml:"IDM_PASSWORD"`
-	IDMAddGroup         string              `mapstructure:"IDM_ADD_GROUP" yaml:"IDM_ADD_GROUP"`
-	OptionalGroups      map[string][]Group  `mapstructure:"OPTIONAL_GROUPS" yaml:"OPTIONAL_GROUPS"`
+	SessionKey            string              `mapstructure:"SESSION_KEY" yaml:"SESSION_KEY"`
+	SessionStore          string              `mapstructure:"SESSION_STORE" yaml:"SESSION_STORE"`
+	AdminGroup            string              `mapstructure:"ADMIN_GROUP" yaml:"ADMIN_GROUP"`
+	ApprovalGroup         string              `mapstructure:"APPROVAL_GROUP" yaml:"APPROVAL_GROUP"`
+	ApprovalEmail         []string            `mapstructure:"APPROVAL_EMAIL" yaml:"APPROVAL_EMAIL"`
+	ServerPort            int                 `mapstructure:"SERVER_PORT" yaml:"SERVER_PORT"`
+	ListenAddr            string              `mapstructure:"LISTEN_ADDR" yaml:"LISTEN_ADDR"`
+	TLSCertPath           string              `mapstructure:"TLS_CERT_PATH" yaml:"TLS_CERT_PATH"`
+	TLSKeyPath            string              `mapstructure:"TLS_KEY_PATH" yaml:"TLS_KEY_PATH"`
+	TLSClientCAPath       string              `mapstructure:"TLS_CLIENT_CA_PATH" yaml:"TLS_CLIENT_CA_PATH"`
+	ShutdownTimeout       string              `mapstructure:"SHUTDOWN_TIMEOUT" yaml:"SHUTDOWN_TIMEOUT"`
+	TrustedProxies        []string            `mapstructure:"TRUSTED_PROXIES" yaml:"TRUSTED_PROXIES"`
+	RateLimitStore        string              `mapstructure:"RATE_LIMIT_STORE" yaml:"RATE_LIMIT_STORE"`
+	RateLimitIP           string              `mapstructure:"RATE_LIMIT_IP" yaml:"RATE_LIMIT_IP"`
+	RateLimitEmail        string              `mapstructure:"RATE_LIMIT_EMAIL" yaml:"RATE_LIMIT_EMAIL"`
+	RateLimitNameCheck    string              `mapstructure:"RATE_LIMIT_NAMECHECK" yaml:"RATE_LIMIT_NAMECHECK"`
+	ChallengeProvider     string              `mapstructure:"CHALLENGE_PROVIDER" yaml:"CHALLENGE_PROVIDER"`
+	ChallengeSiteKey      string              `mapstructure:"CHALLENGE_SITE_KEY" yaml:"CHALLENGE_SITE_KEY"`
+	ChallengeSecret       string              `mapstructure:"CHALLENGE_SECRET" yaml:"CHALLENGE_SECRET"`
+	ChallengeThreshold    string              `mapstructure:"CHALLENGE_THRESHOLD" yaml:"CHALLENGE_THRESHOLD"`
+	ChallengeDifficulty   int                 `mapstructure:"CHALLENGE_POW_DIFFICULTY" yaml:"CHALLENGE_POW_DIFFICULTY"`
+	ServerHostname        string              `mapstructure:"SERVER_HOSTNAME" yaml:"SERVER_HOSTNAME"`
+	SecureCookies         bool                `mapstructure:"SECURE_COOKIES" yaml:"SECURE_COOKIES"`
+	ContentSecurityPolicy string              `mapstructure:"CONTENT_SECURITY_POLICY" yaml:"CONTENT_SECURITY_POLICY"`
+	LoginNamePatterns     []string            `mapstructure:"LOGIN_NAME_PATTERNS" yaml:"LOGIN_NAME_PATTERNS"`
+	LoginNameMinLength    int                 `mapstructure:"LOGIN_NAME_MIN_LENGTH" yaml:"LOGIN_NAME_MIN_LENGTH"`
+	LoginNameMaxLength    int                 `mapstructure:"LOGIN_NAME_MAX_LENGTH" yaml:"LOGIN_NAME_MAX_LENGTH"`
+	LoginNameCharset      string              `mapstructure:"LOGIN_NAME_CHARSET" yaml:"LOGIN_NAME_CHARSET"`
+	LoginNameReserved     []string            `mapstructure:"LOGIN_NAME_RESERVED" yaml:"LOGIN_NAME_RESERVED"`
+	LoginNameMinOptions   int                 `mapstructure:"LOGIN_NAME_MIN_OPTIONS" yaml:"LOGIN_NAME_MIN_OPTIONS"`
+	LoginNameHoldMinutes  int                 `mapstructure:"LOGIN_NAME_HOLD_MINUTES" yaml:"LOGIN_NAME_HOLD_MINUTES"`
+	LoginNameCustom       bool                `mapstructure:"LOGIN_NAME_CUSTOM" yaml:"LOGIN_NAME_CUSTOM"`
+	RequiredLoginGroup    string              `mapstructure:"REQUIRED_LOGIN_GROUP" yaml:"REQUIRED_LOGIN_GROUP"`
+	OIDCServerPort        int                 `mapstructure:"OIDC_SERVER_PORT" yaml:"OIDC_SERVER_PORT"`
+	OIDCWellKnown         string              `mapstructure:"OIDC_WELL_KNOWN" yaml:"OIDC_WELL_KNOWN"`
+	ClientID              string              `mapstructure:"CLIENT_ID" yaml:"CLIENT_ID"`
+	ClientSecret          string              `mapstructure:"CLIENT_SECRET" yaml:"CLIENT_SECRET"`
+	Scopes                string              `mapstructure:"SCOPES" yaml:"SCOPES"`
+	DBPath                string              `mapstructure:"DB_PATH" yaml:"DB_PATH"`
+	LogoURL               string              `mapstructure:"LOGO_URL" yaml:"LOGO_URL"`
+	FaviconURL            string              `mapstructure:"FAVICON_URL" yaml:"FAVICON_URL"`
+	SiteName              string              `mapstructure:"SITE_NAME" yaml:"SITE_NAME"`
+	TenantName            string              `mapstructure:"TENANT_NAME" yaml:"TENANT_NAME"`
+	Affiliation           []map[string]string `mapstructure:"AFFILIATION" yaml:"AFFILIATION"`
+	LoginRedirect         string              `mapstructure:"LOGIN_REDIRECT" yaml:"LOGIN_REDIRECT"`
+	LinkServiceProvider   string              `mapstructure:"LINK_SERVICE_PROVIDER" yaml:"LINK_SERVICE_PROVIDER"`
+	LinkPrivacyPolicy     string              `mapstructure:"LINK_PRIVACY_POLICY" yaml:"LINK_PRIVACY_POLICY"`
+	EmailFrom             string              `mapstructure:"EMAIL_FROM" yaml:"EMAIL_FROM"`
+	AWSRegion             string              `mapstructure:"AWS_REGION" yaml:"AWS_REGION"`
+	AWSAccessKeyID        string              `mapstructure:"AWS_ACCESS_KEY_ID" yaml:"AWS_ACCESS_KEY_ID"`
+	AWSSecretAccessKey    string              `mapstructure:"AWS_SECRET_ACCESS_KEY" yaml:"AWS_SECRET_ACCESS_KEY"`
+	CACertPath            string              `mapstructure:"CACERT_PATH" yaml:"CACERT_PATH"`
+	IDMHost               string              `mapstructure:"IDM_HOST" yaml:"IDM_HOST"`
+	IDMSRVDomain          string              `mapstructure:"IDM_SRV_DOMAIN" yaml:"IDM_SRV_DOMAIN"`
+	IDMUsername           string              `mapstructure:"IDM_USERNAME" yaml:"IDM_USERNAME"`
+	IDMPassword           string              `mapstructure:"IDM_PASSWORD" yaml:"IDM_PASSWORD"`
+	IDMAuth               string              `mapstructure:"IDM_AUTH" yaml:"IDM_AUTH"`
+	IDMKeytab             string              `mapstructure:"IDM_KEYTAB" yaml:"IDM_KEYTAB"`
+	IDMPrincipal          string              `mapstructure:"IDM_PRINCIPAL" yaml:"IDM_PRINCIPAL"`
+	IDMKrb5Conf           string              `mapstructure:"IDM_KRB5_CONF" yaml:"IDM_KRB5_CONF"`
+	IDMCertPath           string              `mapstructure:"IDM_CERT_PATH" yaml:"IDM_CERT_PATH"`
+	IDMKeyPath            string              `mapstructure:"IDM_KEY_PATH" yaml:"IDM_KEY_PATH"`
+	IDMAddGroup           string              `mapstructure:"IDM_ADD_GROUP" yaml:"IDM_ADD_GROUP"`
+	IDMAttributes         map[string]string   `mapstructure:"IDM_ATTRIBUTES" yaml:"IDM_ATTRIBUTES"`
+	DirectoryBackend      string              `mapstructure:"DIRECTORY_BACKEND" yaml:"DIRECTORY_BACKEND"`
+	LDAPURL               string              `mapstructure:"LDAP_URL" yaml:"LDAP_URL"`
+	LDAPStartTLS          bool                `mapstructure:"LDAP_START_TLS" yaml:"LDAP_START_TLS"`
+	LDAPBindDN            string              `mapstructure:"LDAP_BIND_DN" yaml:"LDAP_BIND_DN"`
+	LDAPBindPassword      string              `mapstructure:"LDAP_BIND_PASSWORD" yaml:"LDAP_BIND_PASSWORD"`
+	LDAPUserBaseDN        string              `mapstructure:"LDAP_USER_BASE_DN" yaml:"LDAP_USER_BASE_DN"`
+	LDAPGroupBaseDN       string              `mapstructure:"LDAP_GROUP_BASE_DN" yaml:"LDAP_GROUP_BASE_DN"`
+	LDAPLoginAttribute    string              `mapstructure:"LDAP_LOGIN_ATTRIBUTE" yaml:"LDAP_LOGIN_ATTRIBUTE"`
+	LDAPObjectClasses     []string            `mapstructure:"LDAP_OBJECT_CLASSES" yaml:"LDAP_OBJECT_CLASSES"`
+	LDAPAttributes        map[string]string   `mapstructure:"LDAP_ATTRIBUTES" yaml:"LDAP_ATTRIBUTES"`
+	LDAPGroupMember       string              `mapstructure:"LDAP_GROUP_MEMBER" yaml:"LDAP_GROUP_MEMBER"`
+	LDAPAddGroup          string              `mapstructure:"LDAP_ADD_GROUP" yaml:"LDAP_ADD_GROUP"`
+	KeycloakURL           string              `mapstructure:"KEYCLOAK_URL" yaml:"KEYCLOAK_URL"`
+	KeycloakRealm         string              `mapstructure:"KEYCLOAK_REALM" yaml:"KEYCLOAK_REALM"`
+	KeycloakClientID      string              `mapstructure:"KEYCLOAK_CLIENT_ID" yaml:"KEYCLOAK_CLIENT_ID"`
+	KeycloakClientSecret  string              `mapstructure:"KEYCLOAK_CLIENT_SECRET" yaml:"KEYCLOAK_CLIENT_SECRET"`
+	KeycloakAttributes    map[string]string   `mapstructure:"KEYCLOAK_ATTRIBUTES" yaml:"KEYCLOAK_ATTRIBUTES"`
+	KeycloakActions       []string            `mapstructure:"KEYCLOAK_REQUIRED_ACTIONS" yaml:"KEYCLOAK_REQUIRED_ACTIONS"`
+	KeycloakAddGroup      string              `mapstructure:"KEYCLOAK_ADD_GROUP" yaml:"KEYCLOAK_ADD_GROUP"`
+	SCIMURL               string              `mapstructure:"SCIM_URL" yaml:"SCIM_URL"`
+	SCIMToken             string              `mapstructure:"SCIM_TOKEN" yaml:"SCIM_TOKEN"`
+	SCIMAddGroup          string              `mapstructure:"SCIM_ADD_GROUP" yaml:"SCIM_ADD_GROUP"`
+	PasswordMode          string              `mapstructure:"PASSWORD_MODE" yaml:"PASSWORD_MODE"`
+	TempPasswordLength    int                 `mapstructure:"TEMP_PASSWORD_LENGTH" yaml:"TEMP_PASSWORD_LENGTH"`
+	TempPasswordClasses   int                 `mapstructure:"TEMP_PASSWORD_CLASSES" yaml:"TEMP_PASSWORD_CLASSES"`
+	OTPEnroll             bool                `mapstructure:"OTP_ENROLL" yaml:"OTP_ENROLL"`
+	SSHKeyAffiliations    []string            `mapstructure:"SSH_KEY_AFFILIATIONS" yaml:"SSH_KEY_AFFILIATIONS"`
+	SSHKeyTypes           []string            `mapstructure:"SSH_KEY_TYPES" yaml:"SSH_KEY_TYPES"`
+	SSHKeyMinRSABits      int                 `mapstructure:"SSH_KEY_MIN_RSA_BITS" yaml:"SSH_KEY_MIN_RSA_BITS"`
+	SponsorTermDays       int                 `mapstructure:"SPONSOR_TERM_DAYS" yaml:"SPONSOR_TERM_DAYS"`
+	SponsorAffiliations   []string            `mapstructure:"SPONSOR_AFFILIATIONS" yaml:"SPONSOR_AFFILIATIONS"`
+	SponsorReminderDays   []int               `mapstructure:"SPONSOR_REMINDER_DAYS" yaml:"SPONSOR_REMINDER_DAYS"`
+	SponsorCheckInterval  string              `mapstructure:"SPONSOR_CHECK_INTERVAL" yaml:"SPONSOR_CHECK_INTERVAL"`
+	AdminEmail            string              `mapstructure:"ADMIN_EMAIL" yaml:"ADMIN_EMAIL"`
+	OptionalGroups        map[string][]Group  `mapstructure:"OPTIONAL_GROUPS" yaml:"OPTIONAL_GROUPS"`
+	InviteFields          []InviteField       `mapstructure:"INVITE_FIELDS" yaml:"INVITE_FIELDS"`
 }
 
 type Group struct {
-	RequiredGroup string `mapstructure:"group_required" yaml:"group_required"`
-	GroupName     string `mapstructure:"group_name" yaml:"group_name"`
-	MemberManager bool   `mapstructure:"memberManager" yaml:"memberManager"`
-	CN            string
+	RequiredGroup    string `mapstructure:"group_required" yaml:"group_required"`
+	GroupName        string `mapstructure:"group_name" yaml:"group_name"`
+	MemberManager    bool   `mapstructure:"memberManager" yaml:"memberManager"`
+	RequiresApproval bool   `mapstructure:"requires_approval" yaml:"requires_approval"`
+	CN               string
+}
+
+type InviteField struct {
+	Name     string   `mapstructure:"name" yaml:"name"`
+	Label    string   `mapstructure:"label" yaml:"label"`
+	Type     string   `mapstructure:"type" yaml:"type"`
+	Required bool     `mapstructure:"required" yaml:"required"`
+	Pattern  string   `mapstructure:"pattern" yaml:"pattern"`
+	Choices  []string `mapstructure:"choices" yaml:"choices"`
 }
 
 var C Config
@@ -12,6 +12,7 @@ import (
 
 // Get invite by email if exists
 // otherwise return nil
+// Invites awaiting approval are not valid for activation
 func EmailValid(email string) (bool, error) {
 
 	// Check if email formatted correctly
@@ -23,7 +24,7 @@ func EmailValid(email string) (bool, error) {
 	db := DbConnect()
 
 	var userInvite models.Invite
-	result := db.Where("email = ?", email).First(&userInvite)
+	result := db.Where("email = ? AND COALESCE(approval_status, '') <> ?", email, models.ApprovalPending).First(&userInvite)
 
 	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 		return false, nil
@@ -38,6 +39,20 @@ func EmailValid(email string) (bool, error) {
 
 }
 
+// Check if email has an invite, including invites awaiting approval
+func EmailInvited(email string) (bool, error) {
+	db := DbConnect()
+
+	var count int64
+	result := db.Model(&models.Invite{}).Where("email = ?", email).Count(&count)
+	if result.Error != nil {
+		log.Println("Error in EmailInvited(): " + result.Error.Error())
+		return false, result.Error
+	}
+
+	return count > 0, nil
+}
+
 // Write OTP code to invite
 func SaveOTP(email string, otpCode *big.Int) error {
 	db := DbConnect()
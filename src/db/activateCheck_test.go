@@ -48,6 +48,20 @@ func TestEmailValid(t *testing.T) {
 	valid, err = EmailValid("test@example.com")
 	assert.NoError(t, err)
 	assert.True(t, valid)
+
+	// Awaiting approval
+	db.Create(&models.Invite{Email: "pending@example.com", ApprovalStatus: models.ApprovalPending})
+	valid, err = EmailValid("pending@example.com")
+	assert.NoError(t, err)
+	assert.False(t, valid)
+
+	invited, err := EmailInvited("pending@example.com")
+	assert.NoError(t, err)
+	assert.True(t, invited)
+
+	invited, err = EmailInvited("other@example.com")
+	assert.NoError(t, err)
+	assert.False(t, invited)
 }
 
 func TestSaveOTP(t *testing.T) {
@@ -10,8 +10,18 @@ import (
 	"gorm.io/gorm"
 )
 
+// Invite is not awaiting approval
+var ErrNotPending = errors.New("invite is not awaiting approval")
+
+// Approver is the inviter of the invite
+var ErrSelfApproval = errors.New("inviter cannot approve their own invite")
+
 // Add user to invited table
-func HandleInvite(firstName string, lastName string, email string, state string, country string, affiliation string, inviter string, optionalGroups []string) (bool, error) {
+// A required login name is reserved for the invite, ErrLoginNameReserved
+// if another invite holds it
+// approvalStatus is models.ApprovalPending when a selected group
+// requires approval
+func HandleInvite(firstName string, lastName string, email string, state string, country string, affiliation string, inviter string, optionalGroups []string, requiredLogin string, fields map[string]string, inviterEmail string, approvalStatus string) (bool, error) {
 
 	// Check if email formatted correctly
 	_, err := mail.ParseAddress(email)
@@ -25,12 +35,28 @@ func HandleInvite(firstName string, lastName string, email string, state string,
 	if err != nil {
 		return false, errors.New("Error marshalling OptionalGroups")
 	}
-	userInvite := models.Invite{FirstName: firstName, LastName: lastName, Email: email, State: state, Country: country, Affiliation: affiliation, Inviter: inviter, OptionalGroups: optionalGroupsJson}
-	result := db.Create(&userInvite)
+	if fields == nil {
+		fields = map[string]string{}
+	}
+	fieldsJson, err := json.Marshal(fields)
+	if err != nil {
+		return false, errors.New("Error marshalling CustomFields")
+	}
+	userInvite := models.Invite{FirstName: firstName, LastName: lastName, Email: email, State: state, Country: country, Affiliation: affiliation, Inviter: inviter, OptionalGroups: optionalGroupsJson, RequiredLogin: requiredLogin, CustomFields: fieldsJson, InviterEmail: inviterEmail, ApprovalStatus: approvalStatus}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&userInvite).Error; err != nil {
+			return err
+		}
+		if requiredLogin == "" {
+			return nil
+		}
+		return reserveLoginName(tx, requiredLogin, userInvite.ID.String(), nil)
+	})
 
-	if result.Error != nil {
-		log.Println("Error in HandleInvite(): " + result.Error.Error())
-		return false, result.Error
+	if err != nil {
+		log.Println("Error in HandleInvite(): " + err.Error())
+		return false, err
 	}
 
 	return true, nil
@@ -40,6 +66,10 @@ func HandleInvite(firstName string, lastName string, email string, state string,
 // Delete invite by email
 func DeleteInviteEmail(email string) {
 	db := DbConnect()
+
+	// Release login names held by the invite
+	db.Where("invite_id IN (?)", db.Model(&models.Invite{}).Select("id").Where("Email = ?", email)).Delete(&models.LoginReservation{})
+
 	db.Where("Email = ?", email).Delete(&models.Invite{})
 }
 
@@ -63,3 +93,115 @@ func GetUserSent(uid string) ([]models.Invite, error) {
 	return invites, nil
 
 }
+
+// Invites awaiting approval, oldest first
+func GetPendingApprovals() ([]models.Invite, error) {
+	db := DbConnect()
+
+	var invites []models.Invite
+	result := db.Where("approval_status = ?", models.ApprovalPending).Order("created_at").Find(&invites)
+	if result.Error != nil {
+		log.Println("Error in GetPendingApprovals(): " + result.Error.Error())
+		return invites, result.Error
+	}
+
+	return invites, nil
+}
+
+// Mark a pending invite approved by approver
+// ErrNotPending if the invite is not awaiting approval, such as when
+// another approver handled it first
+// ErrSelfApproval if approver is the inviter
+func ApproveInvite(inviteID string, approver string) (models.Invite, error) {
+	db := DbConnect()
+
+	result := db.Model(&models.Invite{}).
+		Where("id = ? AND approval_status = ? AND inviter <> ?", inviteID, models.ApprovalPending, approver).
+		Updates(map[string]any{"approval_status": models.ApprovalApproved, "approved_by": approver})
+	if result.Error != nil {
+		log.Println("Error in ApproveInvite(): " + result.Error.Error())
+		return models.Invite{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		pending, err := InviteDetails(inviteID)
+		if err == nil && pending.AwaitingApproval() && pending.Inviter == approver {
+			return models.Invite{}, ErrSelfApproval
+		}
+		return models.Invite{}, ErrNotPending
+	}
+
+	return InviteDetails(inviteID)
+}
+
+// Return an invite approved by approver to awaiting approval, when the
+// invitation email could not be sent
+func UnapproveInvite(inviteID string, approver string) error {
+	db := DbConnect()
+
+	result := db.Model(&models.Invite{}).
+		Where("id = ? AND approval_status = ? AND approved_by = ?", inviteID, models.ApprovalApproved, approver).
+		Updates(map[string]any{"approval_status": models.ApprovalPending, "approved_by": ""})
+	if result.Error != nil {
+		log.Println("Error in UnapproveInvite(): " + result.Error.Error())
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotPending
+	}
+
+	return nil
+}
+
+// Delete a pending invite rejected by an approver and release its
+// login names
+// ErrNotPending if the invite is not awaiting approval
+func RejectInvite(inviteID string) (models.Invite, error) {
+	db := DbConnect()
+
+	var userInvite models.Invite
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND approval_status = ?", inviteID, models.ApprovalPending).First(&userInvite)
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return ErrNotPending
+		}
+		if result.Error != nil {
+			return result.Error
+		}
+		if err := tx.Where("invite_id = ?", inviteID).Delete(&models.LoginReservation{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&userInvite).Error
+	})
+	if err != nil && !errors.Is(err, ErrNotPending) {
+		log.Println("Error in RejectInvite(): " + err.Error())
+	}
+
+	return userInvite, err
+}
+
+// Bring back an invite deleted by RejectInvite and its required login
+// name, when the rejection email could not be sent
+func RestoreRejectedInvite(invite models.Invite) error {
+	db := DbConnect()
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Model(&models.Invite{}).
+			Where("id = ? AND deleted_at IS NOT NULL", invite.ID).
+			Update("deleted_at", nil)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotPending
+		}
+		if invite.RequiredLogin == "" {
+			return nil
+		}
+		return reserveLoginName(tx, invite.RequiredLogin, invite.ID.String(), nil)
+	})
+	if err != nil {
+		log.Println("Error in RestoreRejectedInvite(): " + err.Error())
+	}
+
+	return err
+}
@@ -2,6 +2,7 @@ package db
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"testing"
 
@@ -16,7 +17,7 @@ func setupTestDBForInvite(t *testing.T) *gorm.DB {
 	viper.Set("DB_PATH", dbPath)
 
 	db := DbConnect()
-	err := db.AutoMigrate(&models.Invite{})
+	err := db.AutoMigrate(&models.Invite{}, &models.LoginReservation{})
 	if err != nil {
 		t.Fatalf("Failed to migrate database: %v", err)
 	}
@@ -34,13 +35,13 @@ func TestHandleInvite(t *testing.T) {
 	db := setupTestDBForInvite(t)
 
 	// Invalid email
-	success, err := HandleInvite("John", "Doe", "invalid-email", "CA", "USA", "Student", "inviter1", []string{"group1"})
+	success, err := HandleInvite("John", "Doe", "invalid-email", "CA", "USA", "Student", "inviter1", []string{"group1"}, "", nil, "", "")
 	assert.NoError(t, err)
 	assert.False(t, success)
 
 	// Valid invite
 	optionalGroups := []string{"group1", "group2"}
-	success, err = HandleInvite("Jane", "Doe", "jane.doe@example.com", "NY", "USA", "Faculty", "inviter2", optionalGroups)
+	success, err = HandleInvite("Jane", "Doe", "jane.doe@example.com", "NY", "USA", "Faculty", "inviter2", optionalGroups, "", nil, "", "")
 	assert.NoError(t, err)
 	assert.True(t, success)
 
@@ -60,6 +61,75 @@ func TestHandleInvite(t *testing.T) {
 	assert.Equal(t, optionalGroups, retrievedGroups)
 }
 
+func TestHandleInviteFields(t *testing.T) {
+	db := setupTestDBForInvite(t)
+
+	fields := map[string]string{"employee_id": "123456", "end_date": "2027-06-30"}
+	success, err := HandleInvite("Jane", "Doe", "jane@example.com", "NY", "USA", "Faculty", "inviter1", []string{}, "", fields, "", "")
+	assert.NoError(t, err)
+	assert.True(t, success)
+
+	var invite models.Invite
+	assert.NoError(t, db.Where("Email = ?", "jane@example.com").First(&invite).Error)
+	assert.Equal(t, fields, invite.FieldValues())
+
+	// Invites from before INVITE_FIELDS have no values
+	assert.Equal(t, map[string]string{}, models.Invite{}.FieldValues())
+}
+
+func TestHandleInviteRequiredLogin(t *testing.T) {
+	db := setupTestDBForInvite(t)
+
+	success, err := HandleInvite("Jane", "Doe", "jane@example.com", "NY", "USA", "Faculty", "inviter1", []string{}, "jdoe", nil, "", "")
+	assert.NoError(t, err)
+	assert.True(t, success)
+
+	var invite models.Invite
+	db.Where("Email = ?", "jane@example.com").First(&invite)
+	assert.Equal(t, "jdoe", invite.RequiredLogin)
+
+	reserved, err := LoginNameReserved("jdoe", "another-invite")
+	assert.NoError(t, err)
+	assert.True(t, reserved)
+	reserved, err = LoginNameReserved("jdoe", invite.ID.String())
+	assert.NoError(t, err)
+	assert.False(t, reserved)
+
+	// Second invite cannot claim the same name
+	success, err = HandleInvite("John", "Doe", "john@example.com", "NY", "USA", "Faculty", "inviter2", []string{}, "jdoe", nil, "", "")
+	assert.ErrorIs(t, err, ErrLoginNameReserved)
+	assert.False(t, success)
+	isInvited, _ := EmailValid("john@example.com")
+	assert.False(t, isInvited)
+
+	// Deleting the invite releases the name
+	DeleteInviteEmail("jane@example.com")
+	success, err = HandleInvite("John", "Doe", "john@example.com", "NY", "USA", "Faculty", "inviter2", []string{}, "jdoe", nil, "", "")
+	assert.NoError(t, err)
+	assert.True(t, success)
+}
+
+func TestHandleInviteRequiredLoginConcurrent(t *testing.T) {
+	setupTestDBForInvite(t)
+
+	const invites = 8
+	results := make(chan error, invites)
+	for i := range invites {
+		go func() {
+			_, err := HandleInvite("User", "Doe", fmt.Sprintf("user%d@example.com", i), "NY", "USA", "Faculty", "inviter1", []string{}, "sameuser", nil, "", "")
+			results <- err
+		}()
+	}
+
+	succeeded := 0
+	for range invites {
+		if err := <-results; err == nil {
+			succeeded++
+		}
+	}
+	assert.Equal(t, 1, succeeded)
+}
+
 func TestDeleteInviteEmail(t *testing.T) {
 	db := setupTestDBForInvite(t)
 	invite := models.Invite{Email: "test@example.com"}
@@ -93,3 +163,87 @@ func TestGetUserSent(t *testing.T) {
 	assert.Contains(t, emails, "test1@example.com")
 	assert.Contains(t, emails, "test2@example.com")
 }
+
+func TestApproveInvite(t *testing.T) {
+	setupTestDBForInvite(t)
+
+	success, err := HandleInvite("Jane", "Doe", "jane@example.com", "NY", "USA", "Faculty", "inviter1", []string{"prod"}, "", nil, "inviter1@example.com", models.ApprovalPending)
+	assert.NoError(t, err)
+	assert.True(t, success)
+	success, err = HandleInvite("John", "Doe", "john@example.com", "NY", "USA", "Faculty", "inviter1", []string{}, "", nil, "inviter1@example.com", "")
+	assert.NoError(t, err)
+	assert.True(t, success)
+
+	pending, err := GetPendingApprovals()
+	assert.NoError(t, err)
+	assert.Len(t, pending, 1)
+	assert.Equal(t, "jane@example.com", pending[0].Email)
+	assert.Equal(t, "inviter1@example.com", pending[0].InviterEmail)
+	assert.True(t, pending[0].AwaitingApproval())
+
+	// Inviter cannot sign off their own invite
+	_, err = ApproveInvite(pending[0].ID.String(), "inviter1")
+	assert.ErrorIs(t, err, ErrSelfApproval)
+
+	invite, err := ApproveInvite(pending[0].ID.String(), "approver1")
+	assert.NoError(t, err)
+	assert.Equal(t, models.ApprovalApproved, invite.ApprovalStatus)
+	assert.Equal(t, "approver1", invite.ApprovedBy)
+	assert.False(t, invite.AwaitingApproval())
+
+	// Already handled by another approver
+	_, err = ApproveInvite(pending[0].ID.String(), "approver2")
+	assert.ErrorIs(t, err, ErrNotPending)
+
+	pending, err = GetPendingApprovals()
+	assert.NoError(t, err)
+	assert.Empty(t, pending)
+
+	// Only the approver's approval is undone
+	assert.ErrorIs(t, UnapproveInvite(invite.ID.String(), "approver2"), ErrNotPending)
+	assert.NoError(t, UnapproveInvite(invite.ID.String(), "approver1"))
+	pending, err = GetPendingApprovals()
+	assert.NoError(t, err)
+	if assert.Len(t, pending, 1) {
+		assert.Empty(t, pending[0].ApprovedBy)
+	}
+}
+
+func TestRejectInvite(t *testing.T) {
+	db := setupTestDBForInvite(t)
+
+	success, err := HandleInvite("Jane", "Doe", "jane@example.com", "NY", "USA", "Faculty", "inviter1", []string{"prod"}, "jdoe", nil, "inviter1@example.com", models.ApprovalPending)
+	assert.NoError(t, err)
+	assert.True(t, success)
+	pending, _ := InviteDetailsEmail("jane@example.com")
+
+	invite, err := RejectInvite(pending.ID.String())
+	assert.NoError(t, err)
+	assert.Equal(t, "inviter1@example.com", invite.InviterEmail)
+
+	// Invite and required login name are gone
+	_, err = InviteDetailsEmail("jane@example.com")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	var count int64
+	db.Model(&models.LoginReservation{}).Where("login_name = ?", "jdoe").Count(&count)
+	assert.Zero(t, count)
+
+	_, err = RejectInvite(pending.ID.String())
+	assert.ErrorIs(t, err, ErrNotPending)
+
+	// Restored with its login name
+	assert.NoError(t, RestoreRejectedInvite(invite))
+	restored, err := InviteDetailsEmail("jane@example.com")
+	assert.NoError(t, err)
+	assert.True(t, restored.AwaitingApproval())
+	db.Model(&models.LoginReservation{}).Where("login_name = ? AND invite_id = ?", "jdoe", invite.ID.String()).Count(&count)
+	assert.Equal(t, int64(1), count)
+	assert.ErrorIs(t, RestoreRejectedInvite(invite), ErrNotPending)
+
+	// Approved invites cannot be rejected
+	HandleInvite("John", "Doe", "john@example.com", "NY", "USA", "Faculty", "inviter1", []string{"prod"}, "", nil, "", models.ApprovalPending)
+	approved, _ := InviteDetailsEmail("john@example.com")
+	ApproveInvite(approved.ID.String(), "approver1")
+	_, err = RejectInvite(approved.ID.String())
+	assert.ErrorIs(t, err, ErrNotPending)
+}
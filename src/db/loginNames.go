@@ -6,6 +6,7 @@ import (
 	"slices"
 
 	"github.com/hadleyso/netid-activate/src/models"
+	"gorm.io/gorm"
 )
 
 // Sets login name options
@@ -29,7 +30,24 @@ func SetLoginNames(logins []string, inviteID string) error {
 
 }
 
-// Check if login name is in saved login names
+// Sets invitee proposed login name after it has been validated
+func SetCustomLoginName(inviteID string, loginName string) error {
+	db := DbConnect()
+
+	result := db.Model(&models.Invite{}).Where("ID = ?", inviteID).Update("custom_login", loginName)
+	if result.Error != nil {
+		log.Println("Error in SetCustomLoginName(): " + result.Error.Error())
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Check if login name is in saved login names or is the
+// validated custom login name, or is the required login name if set
 func CheckLoginNames(inviteID string, loginName string) (bool, error) {
 	db := DbConnect()
 
@@ -41,6 +59,11 @@ func CheckLoginNames(inviteID string, loginName string) (bool, error) {
 		return false, result.Error
 	}
 
+	// Inviter set name is the only option
+	if userInvite.RequiredLogin != "" {
+		return loginName == userInvite.RequiredLogin, nil
+	}
+
 	// Unmarshall
 	var names []string
 	if err := json.Unmarshal(userInvite.LoginNames, &names); err != nil {
@@ -50,6 +73,9 @@ func CheckLoginNames(inviteID string, loginName string) (bool, error) {
 	if slices.Contains(names, loginName) {
 		return true, nil
 	}
+	if userInvite.CustomLogin != "" && userInvite.CustomLogin == loginName {
+		return true, nil
+	}
 
 	return false, nil
 }
@@ -16,7 +16,7 @@ func setupTestDBForLoginNames(t *testing.T) *gorm.DB {
 	viper.Set("DB_PATH", dbPath)
 
 	db := DbConnect()
-	err := db.AutoMigrate(&models.Invite{})
+	err := db.AutoMigrate(&models.Invite{}, &models.LoginReservation{})
 	if err != nil {
 		t.Fatalf("Failed to migrate database: %v", err)
 	}
@@ -70,3 +70,48 @@ func TestCheckLoginNames(t *testing.T) {
 	assert.Error(t, err)
 	assert.False(t, exists)
 }
+
+func TestSetCustomLoginName(t *testing.T) {
+	db := setupTestDBForLoginNames(t)
+	loginNamesJSON, _ := json.Marshal([]string{"jdoe", "johndoe"})
+	invite := models.Invite{Email: "test@example.com", LoginNames: loginNamesJSON}
+	db.Create(&invite)
+
+	// Not accepted before it is recorded
+	exists, err := CheckLoginNames(invite.ID.String(), "jd.custom")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	err = SetCustomLoginName(invite.ID.String(), "jd.custom")
+	assert.NoError(t, err)
+
+	exists, err = CheckLoginNames(invite.ID.String(), "jd.custom")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	// Generated names still accepted
+	exists, err = CheckLoginNames(invite.ID.String(), "jdoe")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	// Empty name never matches
+	exists, err = CheckLoginNames(invite.ID.String(), "")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	err = SetCustomLoginName("00000000-0000-0000-0000-000000000000", "jd.custom")
+	assert.Error(t, err)
+}
+
+func TestCheckLoginNamesRequired(t *testing.T) {
+	db := setupTestDBForLoginNames(t)
+	loginNamesJSON, _ := json.Marshal([]string{"jdoe", "johndoe"})
+	invite := models.Invite{Email: "test@example.com", LoginNames: loginNamesJSON, CustomLogin: "custom", RequiredLogin: "e12345"}
+	db.Create(&invite)
+
+	for name, expected := range map[string]bool{"e12345": true, "jdoe": false, "custom": false} {
+		exists, err := CheckLoginNames(invite.ID.String(), name)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, exists, name)
+	}
+}
@@ -0,0 +1,45 @@
+package db
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/hadleyso/netid-activate/src/models"
+	"gorm.io/gorm"
+)
+
+// Load rate bucket by key, apply update and save in one transaction
+// A new bucket only has Key set
+func UpdateRateBucket(key string, update func(bucket *models.RateBucket)) error {
+	db := DbConnect()
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var bucket models.RateBucket
+		result := tx.Where("key = ?", key).First(&bucket)
+		if result.Error != nil && !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return result.Error
+		}
+
+		// New bucket
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			bucket = models.RateBucket{Key: key}
+			update(&bucket)
+			return tx.Create(&bucket).Error
+		}
+
+		update(&bucket)
+		return tx.Save(&bucket).Error
+	})
+	if err != nil {
+		log.Println("Error in UpdateRateBucket(): " + err.Error())
+	}
+
+	return err
+}
+
+// Remove buckets not refilled since before
+func DeleteStaleRateBuckets(before time.Time) error {
+	db := DbConnect()
+	return db.Unscoped().Where("last_refill < ?", before).Delete(&models.RateBucket{}).Error
+}
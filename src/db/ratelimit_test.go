@@ -0,0 +1,80 @@
+package db
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hadleyso/netid-activate/src/models"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupTestDBForRateLimit(t *testing.T) {
+	dbPath := "test_ratelimit.db"
+	viper.Set("DB_PATH", dbPath)
+
+	db := DbConnect()
+	err := db.AutoMigrate(&models.RateBucket{})
+	if err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	dbInstance, _ := db.DB()
+	dbInstance.Close()
+
+	t.Cleanup(func() {
+		os.Remove(dbPath)
+	})
+}
+
+func TestUpdateRateBucket(t *testing.T) {
+	setupTestDBForRateLimit(t)
+
+	now := time.Now()
+
+	// New bucket starts empty with key set
+	err := UpdateRateBucket("ip:otp:192.0.2.1", func(bucket *models.RateBucket) {
+		assert.Equal(t, "ip:otp:192.0.2.1", bucket.Key)
+		assert.True(t, bucket.LastRefill.IsZero())
+		bucket.Tokens = 4
+		bucket.LastRefill = now
+	})
+	assert.NoError(t, err)
+
+	// Existing bucket is loaded
+	err = UpdateRateBucket("ip:otp:192.0.2.1", func(bucket *models.RateBucket) {
+		assert.Equal(t, float64(4), bucket.Tokens)
+		assert.WithinDuration(t, now, bucket.LastRefill, time.Second)
+		bucket.Tokens = 3
+	})
+	assert.NoError(t, err)
+
+	db := DbConnect()
+	var count int64
+	db.Model(&models.RateBucket{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+	dbInstance, _ := db.DB()
+	dbInstance.Close()
+}
+
+func TestDeleteStaleRateBuckets(t *testing.T) {
+	setupTestDBForRateLimit(t)
+
+	UpdateRateBucket("old", func(bucket *models.RateBucket) {
+		bucket.LastRefill = time.Now().Add(-48 * time.Hour)
+	})
+	UpdateRateBucket("new", func(bucket *models.RateBucket) {
+		bucket.LastRefill = time.Now()
+	})
+
+	err := DeleteStaleRateBuckets(time.Now().Add(-24 * time.Hour))
+	assert.NoError(t, err)
+
+	db := DbConnect()
+	var keys []string
+	db.Model(&models.RateBucket{}).Pluck("key", &keys)
+	assert.Equal(t, []string{"new"}, keys)
+	dbInstance, _ := db.DB()
+	dbInstance.Close()
+}
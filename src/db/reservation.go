@@ -0,0 +1,124 @@
+package db
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/hadleyso/netid-activate/src/models"
+	"gorm.io/gorm"
+)
+
+var ErrLoginNameReserved = errors.New("login name is reserved by another invite")
+
+// Reserve login name for invite within tx, nil expiresAt holds the
+// name until the invite is deleted
+// Returns ErrLoginNameReserved if another invite holds it
+func reserveLoginName(tx *gorm.DB, loginName string, inviteID string, expiresAt *time.Time) error {
+	var existing models.LoginReservation
+	result := tx.Where("login_name = ?", loginName).Limit(1).Find(&existing)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		// Extend an expiring hold, a required name keeps no expiry
+		if existing.InviteID == inviteID {
+			if existing.ExpiresAt == nil {
+				return nil
+			}
+			return tx.Model(&existing).Update("expires_at", expiresAt).Error
+		}
+		if existing.ExpiresAt == nil || existing.ExpiresAt.After(time.Now()) {
+			return ErrLoginNameReserved
+		}
+
+		// Expired hold by another invite, only removed if still expired
+		if err := tx.Where("login_name = ? AND expires_at <= ?", loginName, time.Now()).Delete(&models.LoginReservation{}).Error; err != nil {
+			return err
+		}
+	}
+
+	// Primary key rejects a concurrent claim
+	if err := tx.Create(&models.LoginReservation{LoginName: loginName, InviteID: inviteID, ExpiresAt: expiresAt}).Error; err != nil {
+		var count int64
+		if tx.Model(&models.LoginReservation{}).Where("login_name = ?", loginName).Count(&count); count > 0 {
+			return ErrLoginNameReserved
+		}
+		return err
+	}
+	return nil
+}
+
+// Hold login names offered to invite for ttl, replacing names
+// previously offered to it
+// Returns the names held, names reserved by other invites are dropped
+func ReserveLoginNames(loginNames []string, inviteID string, ttl time.Duration) ([]string, error) {
+	db := DbConnect()
+
+	if err := DeleteExpiredReservations(); err != nil {
+		log.Println("Error in ReserveLoginNames(): " + err.Error())
+		return nil, err
+	}
+
+	// Release earlier offers, required names have no expiry
+	result := db.Where("invite_id = ? AND expires_at IS NOT NULL", inviteID).Delete(&models.LoginReservation{})
+	if result.Error != nil {
+		log.Println("Error in ReserveLoginNames(): " + result.Error.Error())
+		return nil, result.Error
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	held := []string{}
+	for _, loginName := range loginNames {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			return reserveLoginName(tx, loginName, inviteID, &expiresAt)
+		})
+		if errors.Is(err, ErrLoginNameReserved) {
+			continue
+		}
+		if err != nil {
+			log.Println("Error in ReserveLoginNames(): " + err.Error())
+			return nil, err
+		}
+		held = append(held, loginName)
+	}
+
+	return held, nil
+}
+
+// Hold login name chosen by invite for ttl before creating the user
+// Returns ErrLoginNameReserved if another invite holds it
+func HoldLoginName(loginName string, inviteID string, ttl time.Duration) error {
+	db := DbConnect()
+
+	expiresAt := time.Now().Add(ttl)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return reserveLoginName(tx, loginName, inviteID, &expiresAt)
+	})
+	if err != nil && !errors.Is(err, ErrLoginNameReserved) {
+		log.Println("Error in HoldLoginName(): " + err.Error())
+	}
+
+	return err
+}
+
+// Check if login name is reserved by an invite other than inviteID
+// Expired holds are ignored
+func LoginNameReserved(loginName string, inviteID string) (bool, error) {
+	db := DbConnect()
+
+	var count int64
+	result := db.Model(&models.LoginReservation{}).Where("login_name = ? AND invite_id <> ? AND (expires_at IS NULL OR expires_at > ?)", loginName, inviteID, time.Now()).Count(&count)
+	if result.Error != nil {
+		log.Println("Error in LoginNameReserved(): " + result.Error.Error())
+		return false, result.Error
+	}
+
+	return count > 0, nil
+}
+
+// Remove login name holds past expiry
+func DeleteExpiredReservations() error {
+	db := DbConnect()
+	return db.Where("expires_at <= ?", time.Now()).Delete(&models.LoginReservation{}).Error
+}
@@ -0,0 +1,126 @@
+package db
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hadleyso/netid-activate/src/models"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func setupTestDBForReservation(t *testing.T) *gorm.DB {
+	dbPath := "test_reservation.db"
+	viper.Set("DB_PATH", dbPath)
+
+	db := DbConnect()
+	err := db.AutoMigrate(&models.Invite{}, &models.LoginReservation{})
+	if err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	t.Cleanup(func() {
+		dbInstance, _ := db.DB()
+		dbInstance.Close()
+		os.Remove(dbPath)
+	})
+
+	return db
+}
+
+func TestReserveLoginNames(t *testing.T) {
+	setupTestDBForReservation(t)
+
+	held, err := ReserveLoginNames([]string{"jdoe", "johndoe"}, "invite-1", time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"jdoe", "johndoe"}, held)
+
+	// Another invite is not offered held names
+	held, err = ReserveLoginNames([]string{"jdoe", "jodoe"}, "invite-2", time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"jodoe"}, held)
+
+	reserved, err := LoginNameReserved("jdoe", "invite-2")
+	assert.NoError(t, err)
+	assert.True(t, reserved)
+
+	// New offers release names no longer offered
+	held, err = ReserveLoginNames([]string{"johndoe"}, "invite-1", time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"johndoe"}, held)
+
+	reserved, err = LoginNameReserved("jdoe", "invite-2")
+	assert.NoError(t, err)
+	assert.False(t, reserved)
+}
+
+func TestReserveLoginNamesExpired(t *testing.T) {
+	db := setupTestDBForReservation(t)
+
+	expired := time.Now().Add(-time.Minute)
+	db.Create(&models.LoginReservation{LoginName: "jdoe", InviteID: "invite-1", ExpiresAt: &expired})
+
+	reserved, err := LoginNameReserved("jdoe", "invite-2")
+	assert.NoError(t, err)
+	assert.False(t, reserved)
+
+	held, err := ReserveLoginNames([]string{"jdoe"}, "invite-2", time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"jdoe"}, held)
+
+	var reservation models.LoginReservation
+	db.Where("login_name = ?", "jdoe").First(&reservation)
+	assert.Equal(t, "invite-2", reservation.InviteID)
+}
+
+func TestReserveLoginNamesKeepsRequired(t *testing.T) {
+	db := setupTestDBForReservation(t)
+	db.Create(&models.LoginReservation{LoginName: "jdoe", InviteID: "invite-1"})
+
+	held, err := ReserveLoginNames([]string{"jdoe"}, "invite-2", time.Hour)
+	assert.NoError(t, err)
+	assert.Empty(t, held)
+
+	// Offers to the holding invite keep the required name
+	_, err = ReserveLoginNames([]string{}, "invite-1", time.Hour)
+	assert.NoError(t, err)
+	err = HoldLoginName("jdoe", "invite-1", time.Hour)
+	assert.NoError(t, err)
+
+	var reservation models.LoginReservation
+	db.Where("login_name = ?", "jdoe").First(&reservation)
+	assert.Nil(t, reservation.ExpiresAt)
+}
+
+func TestHoldLoginName(t *testing.T) {
+	setupTestDBForReservation(t)
+
+	err := HoldLoginName("jdoe", "invite-1", time.Hour)
+	assert.NoError(t, err)
+
+	err = HoldLoginName("jdoe", "invite-2", time.Hour)
+	assert.ErrorIs(t, err, ErrLoginNameReserved)
+
+	// Holding again extends the same invite's hold
+	err = HoldLoginName("jdoe", "invite-1", time.Hour)
+	assert.NoError(t, err)
+}
+
+func TestDeleteExpiredReservations(t *testing.T) {
+	db := setupTestDBForReservation(t)
+
+	expired := time.Now().Add(-time.Minute)
+	active := time.Now().Add(time.Hour)
+	db.Create(&models.LoginReservation{LoginName: "old", InviteID: "invite-1", ExpiresAt: &expired})
+	db.Create(&models.LoginReservation{LoginName: "new", InviteID: "invite-1", ExpiresAt: &active})
+	db.Create(&models.LoginReservation{LoginName: "required", InviteID: "invite-2"})
+
+	err := DeleteExpiredReservations()
+	assert.NoError(t, err)
+
+	var count int64
+	db.Model(&models.LoginReservation{}).Count(&count)
+	assert.Equal(t, int64(2), count)
+}
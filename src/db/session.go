@@ -0,0 +1,80 @@
+package db
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/hadleyso/netid-activate/src/models"
+	"gorm.io/gorm"
+)
+
+// Get unexpired session by token
+// returns gorm.ErrRecordNotFound if missing or expired
+func GetSession(token string) (models.Session, error) {
+	db := DbConnect()
+
+	var session models.Session
+	result := db.Where("token = ? AND expires_at > ?", token, time.Now()).First(&session)
+	if result.Error != nil && !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		log.Println("Error in GetSession(): " + result.Error.Error())
+	}
+
+	return session, result.Error
+}
+
+// Create or update session by token
+func SaveSession(token string, name string, subject string, data []byte, expiresAt time.Time) error {
+	db := DbConnect()
+
+	var session models.Session
+	result := db.Where("token = ?", token).First(&session)
+
+	// New session
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		session = models.Session{Token: token, Name: name, Subject: subject, Data: data, ExpiresAt: expiresAt}
+		if err := db.Create(&session).Error; err != nil {
+			log.Println("Error in SaveSession(): " + err.Error())
+			return err
+		}
+		return nil
+	}
+
+	// DB error
+	if result.Error != nil {
+		log.Println("Error in SaveSession(): " + result.Error.Error())
+		return result.Error
+	}
+
+	session.Name = name
+	session.Subject = subject
+	session.Data = data
+	session.ExpiresAt = expiresAt
+	return db.Save(&session).Error
+}
+
+// Delete session by token
+func DeleteSession(token string) error {
+	db := DbConnect()
+	return db.Unscoped().Where("token = ?", token).Delete(&models.Session{}).Error
+}
+
+// Delete all sessions belonging to subject
+// Returns number of sessions removed
+func RevokeSubjectSessions(subject string) (int64, error) {
+	db := DbConnect()
+
+	result := db.Unscoped().Where("subject = ?", subject).Delete(&models.Session{})
+	if result.Error != nil {
+		log.Println("Error in RevokeSubjectSessions(): " + result.Error.Error())
+		return 0, result.Error
+	}
+
+	return result.RowsAffected, nil
+}
+
+// Remove sessions past expiry
+func DeleteExpiredSessions() error {
+	db := DbConnect()
+	return db.Unscoped().Where("expires_at <= ?", time.Now()).Delete(&models.Session{}).Error
+}
@@ -0,0 +1,107 @@
+package db
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hadleyso/netid-activate/src/models"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func setupTestDBForSession(t *testing.T) *gorm.DB {
+	dbPath := "test_session.db"
+	viper.Set("DB_PATH", dbPath)
+	db := DbConnect()
+
+	err := db.AutoMigrate(&models.Session{})
+	if err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	t.Cleanup(func() {
+		dbInstance, _ := db.DB()
+		dbInstance.Close()
+		os.Remove(dbPath)
+	})
+
+	return db
+}
+
+func TestSaveAndGetSession(t *testing.T) {
+	setupTestDBForSession(t)
+
+	// Missing
+	_, err := GetSession("token1")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	// Create
+	err = SaveSession("token1", "IDCLAIM_AUTH", "jdoe", []byte("data1"), time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+
+	session, err := GetSession("token1")
+	assert.NoError(t, err)
+	assert.Equal(t, "jdoe", session.Subject)
+	assert.Equal(t, []byte("data1"), session.Data)
+
+	// Update
+	err = SaveSession("token1", "IDCLAIM_AUTH", "jdoe", []byte("data2"), time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+
+	session, err = GetSession("token1")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data2"), session.Data)
+
+	// Expired
+	err = SaveSession("token2", "IDCLAIM_AUTH", "jdoe", []byte("data"), time.Now().Add(-time.Minute))
+	assert.NoError(t, err)
+
+	_, err = GetSession("token2")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
+
+func TestDeleteSession(t *testing.T) {
+	db := setupTestDBForSession(t)
+
+	SaveSession("token1", "IDCLAIM_AUTH", "jdoe", []byte("data"), time.Now().Add(time.Hour))
+
+	err := DeleteSession("token1")
+	assert.NoError(t, err)
+
+	var count int64
+	db.Unscoped().Model(&models.Session{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestRevokeSubjectSessions(t *testing.T) {
+	setupTestDBForSession(t)
+
+	SaveSession("token1", "IDCLAIM_AUTH", "jdoe", []byte("data"), time.Now().Add(time.Hour))
+	SaveSession("token2", "IDCLAIM_IDENTITY", "jdoe", []byte("data"), time.Now().Add(time.Hour))
+	SaveSession("token3", "IDCLAIM_AUTH", "asmith", []byte("data"), time.Now().Add(time.Hour))
+
+	count, err := RevokeSubjectSessions("jdoe")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+
+	_, err = GetSession("token1")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	_, err = GetSession("token3")
+	assert.NoError(t, err)
+}
+
+func TestDeleteExpiredSessions(t *testing.T) {
+	db := setupTestDBForSession(t)
+
+	SaveSession("token1", "IDCLAIM_AUTH", "jdoe", []byte("data"), time.Now().Add(-time.Hour))
+	SaveSession("token2", "IDCLAIM_AUTH", "jdoe", []byte("data"), time.Now().Add(time.Hour))
+
+	err := DeleteExpiredSessions()
+	assert.NoError(t, err)
+
+	var count int64
+	db.Unscoped().Model(&models.Session{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+}
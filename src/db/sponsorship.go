@@ -0,0 +1,114 @@
+package db
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/hadleyso/netid-activate/src/models"
+)
+
+// Sponsorship was renewed, declined or expired since it was read
+var ErrSponsorshipChanged = errors.New("sponsorship changed since it was read")
+
+// Record the term of a new account
+func CreateSponsorship(sponsorship *models.Sponsorship) error {
+	db := DbConnect()
+
+	sponsorship.Status = models.SponsorActive
+	if err := db.Create(sponsorship).Error; err != nil {
+		log.Println("Error in CreateSponsorship(): " + err.Error())
+		return err
+	}
+	return nil
+}
+
+// Get sponsorship by ID
+func GetSponsorship(id string) (models.Sponsorship, error) {
+	db := DbConnect()
+
+	var sponsorship models.Sponsorship
+	result := db.Where("id = ?", id).First(&sponsorship)
+
+	return sponsorship, result.Error
+}
+
+// Active sponsorships expiring before until, soonest first
+func ActiveSponsorships(until time.Time) ([]models.Sponsorship, error) {
+	db := DbConnect()
+
+	var sponsorships []models.Sponsorship
+	result := db.Where("status = ? AND expires_at < ?", models.SponsorActive, until).Order("expires_at").Find(&sponsorships)
+	if result.Error != nil {
+		log.Println("Error in ActiveSponsorships(): " + result.Error.Error())
+		return sponsorships, result.Error
+	}
+
+	return sponsorships, nil
+}
+
+// Record reminder number sent at now
+// ErrSponsorshipChanged if another instance sent it or the sponsorship
+// changed, the reminder must not be sent
+func ClaimReminder(sponsorship models.Sponsorship, sent int, now time.Time) error {
+	return updateSponsorship(sponsorship, map[string]any{"reminders_sent": sent, "last_reminder": now})
+}
+
+// Undo a ClaimReminder of reminder number sent whose email failed, so
+// the next check sends it again
+// ErrSponsorshipChanged if the sponsorship changed since the claim
+func UnclaimReminder(sponsorship models.Sponsorship, sent int) error {
+	claimed := sponsorship
+	claimed.RemindersSent = sent
+	return updateSponsorship(claimed, map[string]any{"reminders_sent": sponsorship.RemindersSent, "last_reminder": sponsorship.LastReminder})
+}
+
+// Extend an active sponsorship to expiresAt and reset its reminders
+func RenewSponsorship(sponsorship models.Sponsorship, expiresAt time.Time) error {
+	return updateSponsorship(sponsorship, map[string]any{
+		"expires_at":     expiresAt,
+		"reminders_sent": 0,
+		"renewals":       sponsorship.Renewals + 1,
+	})
+}
+
+// Close an active sponsorship with status, after the account is disabled
+func CloseSponsorship(sponsorship models.Sponsorship, status string, now time.Time) error {
+	return updateSponsorship(sponsorship, map[string]any{"status": status, "closed_at": now})
+}
+
+// Update an active sponsorship unless it changed since it was read
+// Every change to an active sponsorship changes renewals or
+// reminders_sent, so they identify the version read
+func updateSponsorship(sponsorship models.Sponsorship, values map[string]any) error {
+	db := DbConnect()
+
+	result := db.Model(&models.Sponsorship{}).
+		Where("id = ? AND status = ? AND renewals = ? AND reminders_sent = ?", sponsorship.ID, models.SponsorActive, sponsorship.Renewals, sponsorship.RemindersSent).
+		Updates(values)
+	if result.Error != nil {
+		log.Println("Error in updateSponsorship(): " + result.Error.Error())
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSponsorshipChanged
+	}
+
+	return nil
+}
+
+// Reopen a sponsorship closed with status whose account could not be
+// disabled
+func ReopenSponsorship(sponsorship models.Sponsorship, status string) error {
+	db := DbConnect()
+
+	result := db.Model(&models.Sponsorship{}).
+		Where("id = ? AND status = ?", sponsorship.ID, status).
+		Updates(map[string]any{"status": models.SponsorActive, "closed_at": nil})
+	if result.Error != nil {
+		log.Println("Error in ReopenSponsorship(): " + result.Error.Error())
+		return result.Error
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hadleyso/netid-activate/src/models"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func setupTestDBForSponsorship(t *testing.T) *gorm.DB {
+	dbPath := "test_sponsorship.db"
+	viper.Set("DB_PATH", dbPath)
+
+	db := DbConnect()
+	err := db.AutoMigrate(&models.Sponsorship{})
+	if err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	t.Cleanup(func() {
+		dbInstance, _ := db.DB()
+		dbInstance.Close()
+		os.Remove(dbPath)
+	})
+
+	return db
+}
+
+func TestActiveSponsorships(t *testing.T) {
+	setupTestDBForSponsorship(t)
+	now := time.Now()
+
+	soon := models.Sponsorship{LoginName: "soon", ExpiresAt: now.Add(24 * time.Hour)}
+	later := models.Sponsorship{LoginName: "later", ExpiresAt: now.Add(90 * 24 * time.Hour)}
+	assert.NoError(t, CreateSponsorship(&later))
+	assert.NoError(t, CreateSponsorship(&soon))
+	assert.Equal(t, models.SponsorActive, soon.Status)
+
+	sponsorships, err := ActiveSponsorships(now.Add(30 * 24 * time.Hour))
+	assert.NoError(t, err)
+	assert.Len(t, sponsorships, 1)
+	assert.Equal(t, "soon", sponsorships[0].LoginName)
+
+	// Closed sponsorships are not returned
+	assert.NoError(t, CloseSponsorship(sponsorships[0], models.SponsorDeclined, now))
+	sponsorships, err = ActiveSponsorships(now.Add(365 * 24 * time.Hour))
+	assert.NoError(t, err)
+	assert.Len(t, sponsorships, 1)
+	assert.Equal(t, "later", sponsorships[0].LoginName)
+
+	closed, err := GetSponsorship(soon.ID.String())
+	assert.NoError(t, err)
+	assert.Equal(t, models.SponsorDeclined, closed.Status)
+	assert.NotNil(t, closed.ClosedAt)
+}
+
+func TestSponsorshipChanged(t *testing.T) {
+	setupTestDBForSponsorship(t)
+	now := time.Now()
+
+	sponsorship := models.Sponsorship{LoginName: "jdoe", ExpiresAt: now.Add(7 * 24 * time.Hour)}
+	assert.NoError(t, CreateSponsorship(&sponsorship))
+
+	// Reminder is claimed once
+	assert.NoError(t, ClaimReminder(sponsorship, 1, now))
+	assert.ErrorIs(t, ClaimReminder(sponsorship, 1, now), ErrSponsorshipChanged)
+
+	// Stale read cannot renew or close
+	assert.ErrorIs(t, RenewSponsorship(sponsorship, now.Add(365*24*time.Hour)), ErrSponsorshipChanged)
+
+	current, _ := GetSponsorship(sponsorship.ID.String())
+	assert.Equal(t, 1, current.RemindersSent)
+	assert.NoError(t, RenewSponsorship(current, current.ExpiresAt.Add(365*24*time.Hour)))
+
+	renewed, _ := GetSponsorship(sponsorship.ID.String())
+	assert.Equal(t, 0, renewed.RemindersSent)
+	assert.Equal(t, 1, renewed.Renewals)
+	assert.WithinDuration(t, now.Add(372*24*time.Hour), renewed.ExpiresAt, time.Second)
+	assert.ErrorIs(t, CloseSponsorship(current, models.SponsorExpired, now), ErrSponsorshipChanged)
+	assert.NoError(t, CloseSponsorship(renewed, models.SponsorExpired, now))
+}
@@ -0,0 +1,330 @@
+package directory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/hadleyso/netid-activate/src/config"
+	"github.com/hadleyso/netid-activate/src/keycloak"
+	ldapdir "github.com/hadleyso/netid-activate/src/ldap-directory"
+	"github.com/hadleyso/netid-activate/src/models"
+	idm "github.com/hadleyso/netid-activate/src/redhat-idm"
+	"github.com/hadleyso/netid-activate/src/scim"
+	"github.com/spf13/viper"
+)
+
+// Directory already has a user with the login name
+var ErrLoginNameExists = errors.New("login name already exists in the directory")
+
+// Directory rejected a password for policy reasons
+var ErrPasswordPolicy = errors.New("password rejected by the directory password policy")
+
+// Directory has no standard way to disable a user
+var ErrDisableNotSupported = errors.New("the directory backend cannot disable users")
+
+// Account operations activation needs from a user directory
+type Backend interface {
+	// Returns the login names that don't exist
+	CheckUsernamesExists(loginNames []string) ([]string, error)
+	LoginNameAvailable(loginName string) (bool, error)
+	CheckEmailExists(email string) (bool, error)
+	// Policy for the password of the user of invite
+	PasswordPolicy(invite models.Invite) (idm.PasswordPolicy, error)
+	// Optional groups with memberManager set that user may invite to
+	ManagedGroups(user *models.UserInfo, groups map[string][]config.Group) ([]config.Group, error)
+	// Empty password generates a temporary password which is returned
+	MakeUser(invite models.Invite, loginName string, password string, sshKeys []string) (string, error)
+	// Users already disabled or deleted are not an error
+	DisableUser(loginName string) error
+}
+
+// Account creations in progress, waited on during shutdown
+// idle is closed when count drops to zero, so waiting needs no goroutine
+// that could outlive a timed out WaitInFlight
+var inFlight struct {
+	sync.Mutex
+	count int
+	idle  chan struct{}
+}
+
+// Count an account creation as started
+func beginInFlight() {
+	inFlight.Lock()
+	defer inFlight.Unlock()
+
+	if inFlight.count == 0 {
+		inFlight.idle = make(chan struct{})
+	}
+	inFlight.count++
+}
+
+// Count an account creation as finished
+func endInFlight() {
+	inFlight.Lock()
+	defer inFlight.Unlock()
+
+	inFlight.count--
+	if inFlight.count == 0 {
+		close(inFlight.idle)
+	}
+}
+
+// Backend from DIRECTORY_BACKEND, IdM when not set
+func Current() Backend {
+	switch viper.GetString("DIRECTORY_BACKEND") {
+	case "ldap":
+		return ldapBackend{}
+	case "keycloak":
+		return keycloakBackend{}
+	case "scim":
+		return scimBackend{}
+	default:
+		return idmBackend{}
+	}
+}
+
+// Check DIRECTORY_BACKEND and its settings, run at startup
+func CheckConfig() error {
+	backend := viper.GetString("DIRECTORY_BACKEND")
+	switch backend {
+	case "", "idm":
+		return idm.CheckConfig()
+	case "ldap", "keycloak", "scim":
+	default:
+		return fmt.Errorf("DIRECTORY_BACKEND %q must be idm, ldap, keycloak or scim", backend)
+	}
+
+	// Tokens are added with the IdM otptoken_add
+	if viper.GetBool("OTP_ENROLL") {
+		return fmt.Errorf("OTP_ENROLL is only supported with the idm DIRECTORY_BACKEND, not %s", backend)
+	}
+
+	switch backend {
+	case "ldap":
+		return ldapdir.CheckConfig()
+	case "keycloak":
+		return keycloak.CheckConfig()
+	default:
+		return scim.CheckConfig()
+	}
+}
+
+// Checks if login names exist in the directory
+// Returns names that don't exist
+func CheckUsernamesExists(loginNames []string) ([]string, error) {
+	return Current().CheckUsernamesExists(loginNames)
+}
+
+// Checks if login name is unused in the directory
+func LoginNameAvailable(loginName string) (bool, error) {
+	return Current().LoginNameAvailable(loginName)
+}
+
+// Checks if a user with email exists in the directory
+func CheckEmailExists(email string) (bool, error) {
+	return Current().CheckEmailExists(email)
+}
+
+// Password policy the password of the user of invite must meet, empty
+// if the directory has none to read
+func GetPasswordPolicy(invite models.Invite) (idm.PasswordPolicy, error) {
+	return Current().PasswordPolicy(invite)
+}
+
+// Optional groups with memberManager set that user may invite to
+func ManagedGroups(user *models.UserInfo, groups map[string][]config.Group) ([]config.Group, error) {
+	return Current().ManagedGroups(user, groups)
+}
+
+// Create user in the directory and add to groups
+// Empty password generates a temporary password which is returned
+// SSH public keys are optional and must already be validated
+func HandleMakeUser(invite models.Invite, loginName string, password string, sshKeys []string) (string, error) {
+	beginInFlight()
+	defer endInFlight()
+
+	return Current().MakeUser(invite, loginName, password, sshKeys)
+}
+
+// Disable user so it can no longer log in
+func DisableUser(loginName string) error {
+	return Current().DisableUser(loginName)
+}
+
+// Wait for in progress account creations to finish
+func WaitInFlight(ctx context.Context) error {
+	inFlight.Lock()
+	if inFlight.count == 0 {
+		inFlight.Unlock()
+		return nil
+	}
+	idle := inFlight.idle
+	inFlight.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Wrap backend err with the matching directory error
+func classify(err error, exists error, policy error) error {
+	switch {
+	case errors.Is(err, exists):
+		return fmt.Errorf("%w: %w", ErrLoginNameExists, err)
+	case errors.Is(err, policy):
+		return fmt.Errorf("%w: %w", ErrPasswordPolicy, err)
+	}
+	return err
+}
+
+// FreeIPA and Red Hat IdM through the JSON-RPC API
+type idmBackend struct{}
+
+func (idmBackend) CheckUsernamesExists(loginNames []string) ([]string, error) {
+	return idm.CheckUsernamesExists(loginNames)
+}
+
+func (idmBackend) LoginNameAvailable(loginName string) (bool, error) {
+	return idm.LoginNameAvailable(loginName)
+}
+
+func (idmBackend) CheckEmailExists(email string) (bool, error) {
+	return idm.CheckEmailExists(email)
+}
+
+// Group policies of the groups joined apply, not only the global one
+func (idmBackend) PasswordPolicy(invite models.Invite) (idm.PasswordPolicy, error) {
+	return idm.GetInvitePasswordPolicy(invite)
+}
+
+func (idmBackend) ManagedGroups(user *models.UserInfo, groups map[string][]config.Group) ([]config.Group, error) {
+	err, managed := idm.CheckManagedGroup(user, groups)
+	return managed, err
+}
+
+func (idmBackend) MakeUser(invite models.Invite, loginName string, password string, sshKeys []string) (string, error) {
+	passwd, err := idm.HandleMakeUser(invite, loginName, password, sshKeys)
+	return passwd, classify(err, idm.ErrLoginNameExists, idm.ErrPasswordPolicy)
+}
+
+func (idmBackend) DisableUser(loginName string) error {
+	return idm.DisableUser(loginName)
+}
+
+// OpenLDAP, 389-DS and other LDAPv3 directories
+type ldapBackend struct{}
+
+func (ldapBackend) CheckUsernamesExists(loginNames []string) ([]string, error) {
+	return ldapdir.CheckUsernamesExists(loginNames)
+}
+
+func (ldapBackend) LoginNameAvailable(loginName string) (bool, error) {
+	return ldapdir.LoginNameAvailable(loginName)
+}
+
+func (ldapBackend) CheckEmailExists(email string) (bool, error) {
+	return ldapdir.CheckEmailExists(email)
+}
+
+// Password policies are enforced by the server on add
+func (ldapBackend) PasswordPolicy(invite models.Invite) (idm.PasswordPolicy, error) {
+	return idm.PasswordPolicy{}, nil
+}
+
+// LDAP has no member managers, memberManager groups are not offered
+func (ldapBackend) ManagedGroups(user *models.UserInfo, groups map[string][]config.Group) ([]config.Group, error) {
+	return nil, nil
+}
+
+func (ldapBackend) MakeUser(invite models.Invite, loginName string, password string, sshKeys []string) (string, error) {
+	m, err := ldapdir.NewMaker()
+	if err != nil {
+		return "", err
+	}
+	passwd, err := makeUser(m, ldapdir.ErrPasswordPolicy, invite, loginName, password, sshKeys)
+	return passwd, classify(err, ldapdir.ErrLoginNameExists, ldapdir.ErrPasswordPolicy)
+}
+
+// Disabling is server specific, such as nsAccountLock or pwdAccountLockedTime
+func (ldapBackend) DisableUser(loginName string) error {
+	return ErrDisableNotSupported
+}
+
+// Keycloak through the Admin REST API
+type keycloakBackend struct{}
+
+func (keycloakBackend) CheckUsernamesExists(loginNames []string) ([]string, error) {
+	return keycloak.CheckUsernamesExists(loginNames)
+}
+
+func (keycloakBackend) LoginNameAvailable(loginName string) (bool, error) {
+	return keycloak.LoginNameAvailable(loginName)
+}
+
+func (keycloakBackend) CheckEmailExists(email string) (bool, error) {
+	return keycloak.CheckEmailExists(email)
+}
+
+func (keycloakBackend) PasswordPolicy(invite models.Invite) (idm.PasswordPolicy, error) {
+	return keycloak.PasswordPolicy()
+}
+
+func (keycloakBackend) ManagedGroups(user *models.UserInfo, groups map[string][]config.Group) ([]config.Group, error) {
+	return keycloak.CheckManagedGroup(user, groups)
+}
+
+func (keycloakBackend) MakeUser(invite models.Invite, loginName string, password string, sshKeys []string) (string, error) {
+	m, err := keycloak.NewMaker()
+	if err != nil {
+		return "", err
+	}
+	passwd, err := makeUser(m, keycloak.ErrPasswordPolicy, invite, loginName, password, sshKeys)
+	return passwd, classify(err, keycloak.ErrLoginNameExists, keycloak.ErrPasswordPolicy)
+}
+
+func (keycloakBackend) DisableUser(loginName string) error {
+	return keycloak.DisableUser(loginName)
+}
+
+// SCIM 2.0 service providers
+type scimBackend struct{}
+
+func (scimBackend) CheckUsernamesExists(loginNames []string) ([]string, error) {
+	return scim.CheckUsernamesExists(loginNames)
+}
+
+func (scimBackend) LoginNameAvailable(loginName string) (bool, error) {
+	return scim.LoginNameAvailable(loginName)
+}
+
+func (scimBackend) CheckEmailExists(email string) (bool, error) {
+	return scim.CheckEmailExists(email)
+}
+
+// SCIM has no password policy discovery, the provider enforces it on create
+func (scimBackend) PasswordPolicy(invite models.Invite) (idm.PasswordPolicy, error) {
+	return idm.PasswordPolicy{}, nil
+}
+
+// SCIM has no member managers, memberManager groups are not offered
+func (scimBackend) ManagedGroups(user *models.UserInfo, groups map[string][]config.Group) ([]config.Group, error) {
+	return nil, nil
+}
+
+func (scimBackend) MakeUser(invite models.Invite, loginName string, password string, sshKeys []string) (string, error) {
+	m, err := scim.NewMaker()
+	if err != nil {
+		return "", err
+	}
+	passwd, err := makeUser(m, scim.ErrPasswordPolicy, invite, loginName, password, sshKeys)
+	return passwd, classify(err, scim.ErrLoginNameExists, scim.ErrPasswordPolicy)
+}
+
+func (scimBackend) DisableUser(loginName string) error {
+	return scim.DisableUser(loginName)
+}
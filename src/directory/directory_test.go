@@ -0,0 +1,129 @@
+package directory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hadleyso/netid-activate/src/config"
+	ldapdir "github.com/hadleyso/netid-activate/src/ldap-directory"
+	"github.com/hadleyso/netid-activate/src/models"
+	idm "github.com/hadleyso/netid-activate/src/redhat-idm"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCurrent(t *testing.T) {
+	defer viper.Set("DIRECTORY_BACKEND", nil)
+
+	viper.Set("DIRECTORY_BACKEND", nil)
+	assert.IsType(t, idmBackend{}, Current())
+
+	viper.Set("DIRECTORY_BACKEND", "ldap")
+	assert.IsType(t, ldapBackend{}, Current())
+
+	viper.Set("DIRECTORY_BACKEND", "keycloak")
+	assert.IsType(t, keycloakBackend{}, Current())
+
+	viper.Set("DIRECTORY_BACKEND", "scim")
+	assert.IsType(t, scimBackend{}, Current())
+}
+
+func TestCheckConfig(t *testing.T) {
+	defer viper.Set("DIRECTORY_BACKEND", nil)
+
+	viper.Set("DIRECTORY_BACKEND", "idm")
+	assert.NoError(t, CheckConfig())
+
+	viper.Set("DIRECTORY_BACKEND", "ad")
+	assert.Error(t, CheckConfig())
+
+	// LDAP settings are checked
+	viper.Set("DIRECTORY_BACKEND", "ldap")
+	viper.Set("LDAP_URL", "")
+	assert.Error(t, CheckConfig())
+
+	// Keycloak settings are checked
+	viper.Set("DIRECTORY_BACKEND", "keycloak")
+	viper.Set("KEYCLOAK_CLIENT_SECRET", "")
+	assert.Error(t, CheckConfig())
+
+	// SCIM settings are checked
+	viper.Set("DIRECTORY_BACKEND", "scim")
+	viper.Set("SCIM_URL", "https://scim.example.org/v2")
+	viper.Set("SCIM_TOKEN", "")
+	assert.Error(t, CheckConfig())
+	viper.Set("SCIM_TOKEN", "token")
+	assert.NoError(t, CheckConfig())
+
+	// OTP enrollment needs IdM
+	viper.Set("OTP_ENROLL", true)
+	assert.Error(t, CheckConfig())
+	viper.Set("DIRECTORY_BACKEND", "idm")
+	assert.NoError(t, CheckConfig())
+	viper.Set("OTP_ENROLL", nil)
+	viper.Set("SCIM_URL", nil)
+	viper.Set("SCIM_TOKEN", nil)
+}
+
+func TestClassify(t *testing.T) {
+	err := classify(fmt.Errorf("%w: entry exists", ldapdir.ErrLoginNameExists), ldapdir.ErrLoginNameExists, ldapdir.ErrPasswordPolicy)
+	assert.ErrorIs(t, err, ErrLoginNameExists)
+	assert.ErrorIs(t, err, ldapdir.ErrLoginNameExists)
+
+	err = classify(fmt.Errorf("%w: too short", idm.ErrPasswordPolicy), idm.ErrLoginNameExists, idm.ErrPasswordPolicy)
+	assert.ErrorIs(t, err, ErrPasswordPolicy)
+	assert.NotErrorIs(t, err, ErrLoginNameExists)
+
+	other := errors.New("connection refused")
+	assert.Equal(t, other, classify(other, idm.ErrLoginNameExists, idm.ErrPasswordPolicy))
+	assert.NoError(t, classify(nil, idm.ErrLoginNameExists, idm.ErrPasswordPolicy))
+}
+
+func TestLDAPPasswordPolicy(t *testing.T) {
+	policy, err := ldapBackend{}.PasswordPolicy(models.Invite{})
+	assert.NoError(t, err)
+	assert.Equal(t, idm.PasswordPolicy{}, policy)
+}
+
+func TestLDAPManagedGroups(t *testing.T) {
+	managed, err := ldapBackend{}.ManagedGroups(&models.UserInfo{PreferredUsername: "employee"}, map[string][]config.Group{
+		"managed-group": {{GroupName: "Managed Group", MemberManager: true}},
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, managed)
+}
+
+func TestLDAPDisableUser(t *testing.T) {
+	assert.ErrorIs(t, ldapBackend{}.DisableUser("jdoe"), ErrDisableNotSupported)
+}
+
+func TestWaitInFlight(t *testing.T) {
+	// Nothing running
+	assert.NoError(t, WaitInFlight(context.Background()))
+
+	beginInFlight()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, WaitInFlight(ctx), context.DeadlineExceeded)
+
+	// Creations can start again after a timed out wait
+	beginInFlight()
+	endInFlight()
+	endInFlight()
+	assert.NoError(t, WaitInFlight(context.Background()))
+
+	// Waiter is released when the last creation finishes
+	beginInFlight()
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		endInFlight()
+	}()
+	assert.NoError(t, WaitInFlight(context.Background()))
+
+	beginInFlight()
+	endInFlight()
+	assert.NoError(t, WaitInFlight(context.Background()))
+}
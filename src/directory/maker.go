@@ -0,0 +1,68 @@
+package directory
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+
+	"github.com/hadleyso/netid-activate/src/models"
+	idm "github.com/hadleyso/netid-activate/src/redhat-idm"
+)
+
+// Times the user is created with a new password after a policy rejection
+const tempPasswordAttempts = 3
+
+// Account creation steps of the LDAP, Keycloak and SCIM backends
+type userMaker interface {
+	// Groups every user joins
+	DefaultGroups() []string
+	// Returns the ID AddUserGroups takes
+	MakeUser(loginName string, invite models.Invite, password string, temporary bool, sshKeys []string) (string, error)
+	// Groups are tried in turn, returns the last error
+	AddUserGroups(userID string, groups []string) error
+	Close()
+}
+
+// Create user with m and add to groups
+// Empty password generates a temporary password which is returned, drawn
+// again while policyErr rejects it
+func makeUser(m userMaker, policyErr error, invite models.Invite, loginName string, password string, sshKeys []string) (string, error) {
+	defer m.Close()
+
+	// Groups to add to
+	var groups []string
+	if err := json.Unmarshal(invite.OptionalGroups, &groups); err != nil {
+		log.Println("MakeUser() unable to json.Unmarshal() " + err.Error())
+		return "", err
+	}
+	groups = append(groups, m.DefaultGroups()...)
+
+	// Create user, drawing a new temporary password if rejected
+	var userID, tempPassword string
+	var err error
+	if password != "" {
+		userID, err = m.MakeUser(loginName, invite, password, false, sshKeys)
+	} else {
+		for attempt := 1; attempt <= tempPasswordAttempts; attempt++ {
+			tempPassword, err = idm.TempPassword()
+			if err != nil {
+				break
+			}
+			userID, err = m.MakeUser(loginName, invite, tempPassword, true, sshKeys)
+			if !errors.Is(err, policyErr) {
+				break
+			}
+			log.Printf("MakeUser() password rejected, attempt %d of %d\n", attempt, tempPasswordAttempts)
+		}
+	}
+	if err != nil {
+		log.Println("MakeUser() unable to makeUser() " + err.Error())
+		return "", err
+	}
+
+	// The account exists, a missing group is fixed by an admin
+	if err := m.AddUserGroups(userID, groups); err != nil {
+		log.Println("MakeUser() unable to addUserGroups() " + err.Error())
+	}
+	return tempPassword, nil
+}
@@ -0,0 +1,116 @@
+package directory
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hadleyso/netid-activate/src/models"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/datatypes"
+)
+
+var errFakePolicy = errors.New("fake password policy")
+
+// Records the calls makeUser makes
+type fakeMaker struct {
+	defaultGroups []string
+	makeErr       error
+	groupErr      error
+
+	passwords []string
+	temporary []bool
+	userID    string
+	groups    []string
+	closed    bool
+}
+
+func (f *fakeMaker) DefaultGroups() []string {
+	return f.defaultGroups
+}
+
+func (f *fakeMaker) MakeUser(loginName string, invite models.Invite, password string, temporary bool, sshKeys []string) (string, error) {
+	f.passwords = append(f.passwords, password)
+	f.temporary = append(f.temporary, temporary)
+	if f.makeErr != nil {
+		return "", f.makeErr
+	}
+	return "id-" + loginName, nil
+}
+
+func (f *fakeMaker) AddUserGroups(userID string, groups []string) error {
+	f.userID = userID
+	f.groups = groups
+	return f.groupErr
+}
+
+func (f *fakeMaker) Close() {
+	f.closed = true
+}
+
+func makerInvite(groups string) models.Invite {
+	return models.Invite{Email: "jane@example.com", OptionalGroups: datatypes.JSON(groups)}
+}
+
+func TestMakeUser(t *testing.T) {
+	f := &fakeMaker{defaultGroups: []string{"vpn"}}
+
+	password, err := makeUser(f, errFakePolicy, makerInvite(`["staff"]`), "janedoe", "Correct-Horse-9", nil)
+	assert.NoError(t, err)
+	assert.Empty(t, password)
+	assert.Equal(t, []string{"Correct-Horse-9"}, f.passwords)
+	assert.Equal(t, []bool{false}, f.temporary)
+	assert.Equal(t, "id-janedoe", f.userID)
+	assert.Equal(t, []string{"staff", "vpn"}, f.groups)
+	assert.True(t, f.closed)
+}
+
+func TestMakeUserTemporaryPassword(t *testing.T) {
+	f := &fakeMaker{}
+
+	password, err := makeUser(f, errFakePolicy, makerInvite(`[]`), "janedoe", "", nil)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, password)
+	assert.Equal(t, []string{password}, f.passwords)
+	assert.Equal(t, []bool{true}, f.temporary)
+}
+
+func TestMakeUserPasswordPolicy(t *testing.T) {
+	f := &fakeMaker{makeErr: errFakePolicy}
+
+	// Chosen passwords are not retried
+	_, err := makeUser(f, errFakePolicy, makerInvite(`[]`), "janedoe", "password", nil)
+	assert.ErrorIs(t, err, errFakePolicy)
+	assert.Len(t, f.passwords, 1)
+
+	// Temporary passwords are drawn again
+	f.passwords = nil
+	_, err = makeUser(f, errFakePolicy, makerInvite(`[]`), "janedoe", "", nil)
+	assert.ErrorIs(t, err, errFakePolicy)
+	assert.Len(t, f.passwords, tempPasswordAttempts)
+	assert.NotEqual(t, f.passwords[0], f.passwords[1])
+	assert.Nil(t, f.groups)
+
+	// Other errors end the attempts
+	f = &fakeMaker{makeErr: errors.New("connection reset")}
+	_, err = makeUser(f, errFakePolicy, makerInvite(`[]`), "janedoe", "", nil)
+	assert.Error(t, err)
+	assert.Len(t, f.passwords, 1)
+}
+
+func TestMakeUserMissingGroup(t *testing.T) {
+	f := &fakeMaker{groupErr: errors.New("group nosuchgroup not found")}
+
+	// The account is kept when a group is missing
+	_, err := makeUser(f, errFakePolicy, makerInvite(`["nosuchgroup"]`), "janedoe", "Correct-Horse-9", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"nosuchgroup"}, f.groups)
+}
+
+func TestMakeUserBadGroups(t *testing.T) {
+	f := &fakeMaker{}
+
+	_, err := makeUser(f, errFakePolicy, makerInvite(`{`), "janedoe", "Correct-Horse-9", nil)
+	assert.Error(t, err)
+	assert.Empty(t, f.passwords)
+	assert.True(t, f.closed)
+}
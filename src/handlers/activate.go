@@ -2,23 +2,30 @@ package handlers
 
 import (
 	"encoding/gob"
+	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
 	"github.com/hadleyso/netid-activate/src/attribute"
+	"github.com/hadleyso/netid-activate/src/auth"
 	"github.com/hadleyso/netid-activate/src/db"
+	"github.com/hadleyso/netid-activate/src/directory"
 	"github.com/hadleyso/netid-activate/src/mailer"
 	"github.com/hadleyso/netid-activate/src/models"
 	idm "github.com/hadleyso/netid-activate/src/redhat-idm"
 	"github.com/hadleyso/netid-activate/src/scenes"
+	"github.com/hadleyso/netid-activate/src/sponsor"
+	"github.com/hadleyso/netid-activate/src/sshkey"
 	"github.com/spf13/viper"
 )
 
-var SessionCookieStore *sessions.CookieStore = nil
+var SessionCookieStore sessions.Store = nil
 
 func ActivateEmailGet(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -44,6 +51,17 @@ func ActivateEmailPost(w http.ResponseWriter, r *http.Request) {
 	// Email has value
 	if activateEmail == "" {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	// Throttle invite enumeration
+	if !allowRate(w, r, "activate", activateEmail) {
+		return
+	}
+
+	// Challenge scripted sends
+	if !passChallenge(w, r, activateEmail) {
+		return
 	}
 
 	// Check if invite exists
@@ -83,7 +101,7 @@ func ActivateEmailPost(w http.ResponseWriter, r *http.Request) {
 		}{
 			ActivateEmail: activateEmail,
 			EmailNotReset: emailNotResent,
-			PageBase:      models.NewPageBase(""),
+			PageBase:      models.NewPageBase("").WithCSRF(r),
 		},
 	)
 
@@ -96,6 +114,11 @@ func ActivateOTPPost(w http.ResponseWriter, r *http.Request) {
 	activateEmail := r.Form.Get("activateEmail")
 	activateOTP := r.Form.Get("activateOTP")
 
+	// Throttle OTP guessing
+	if !allowRate(w, r, "otp", activateEmail) {
+		return
+	}
+
 	inviteID, isValid, err := db.EmailOTPValid(activateEmail, activateOTP)
 	// OTP Error
 	if err != nil {
@@ -129,25 +152,26 @@ func ActivateOTPPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Generate login names and save
-	usernameOptions, err := attribute.GetLoginOptions(invite)
-	if err != nil {
-		log.Println("Call to InviteDetails() in ActivateOTPPost() src/handlers/activate.go error")
-		http.Redirect(w, r, "/500", http.StatusSeeOther)
-		return
+	// Generate, hold and save login names, an inviter set name is
+	// the only option
+	usernameOptions := []string{invite.RequiredLogin}
+	if invite.RequiredLogin == "" {
+		usernameOptions, err = offerLoginNames(invite)
+		if err != nil {
+			log.Println("Call to offerLoginNames() in ActivateOTPPost() src/handlers/activate.go error - " + err.Error())
+			http.Redirect(w, r, "/500", http.StatusSeeOther)
+			return
+		}
+	} else {
+		db.SetLoginNames(usernameOptions, inviteID)
 	}
-	db.SetLoginNames(usernameOptions, inviteID)
 
 	// Set auth cookie
 	if SessionCookieStore == nil {
-		SessionCookieStore = sessions.NewCookieStore([]byte(viper.GetString("SESSION_KEY")))
+		SessionCookieStore = auth.NewSessionStore()
 	}
 	session_IDCLAIM_ACTIVATION, _ := SessionCookieStore.Get(r, "IDCLAIM_ACTIVATION")
-	session_IDCLAIM_ACTIVATION.Options = &sessions.Options{
-		Path:     "/",
-		MaxAge:   28800,
-		HttpOnly: true,
-	}
+	session_IDCLAIM_ACTIVATION.Options = auth.SessionOptions(28800)
 
 	session_IDCLAIM_ACTIVATION.Values["activateEmail"] = activateEmail
 	session_IDCLAIM_ACTIVATION.Values["activateOTP"] = activateOTP
@@ -159,21 +183,70 @@ func ActivateOTPPost(w http.ResponseWriter, r *http.Request) {
 	db.ClaimOTP(activateEmail, activateOTP)
 
 	// Return username selection form
+	renderLoginNameSelect(w, r, invite, usernameOptions, loginNameForm{})
+
+}
+
+// Check if invitees choose their own password, PASSWORD_MODE
+func choosePassword() bool {
+	return viper.GetString("PASSWORD_MODE") != "temporary"
+}
+
+// Values entered on the login name form, shown again on errors
+type loginNameForm struct {
+	LoginName         string
+	LoginNameErrors   []string
+	CustomLoginName   string
+	CustomLoginErrors []string
+	PasswordErrors    []string
+	SSHKeys           string
+	SSHKeyErrors      []string
+}
+
+// Show login name selection form, with password fields when
+// invitees choose their own password and SSH keys when enabled
+// for the invite affiliation
+func renderLoginNameSelect(w http.ResponseWriter, r *http.Request, invite models.Invite, loginNames []string, form loginNameForm) {
+	var policy idm.PasswordPolicy
+	if choosePassword() {
+		var err error
+		policy, err = directory.GetPasswordPolicy(invite)
+		if err != nil {
+			// IdM still enforces the policy on user_add
+			log.Println("Call to GetPasswordPolicy() in renderLoginNameSelect() src/handlers/activate.go error - " + err.Error())
+		}
+	}
+
 	tmpl := template.Must(template.ParseFS(scenes.TemplateFS, "scenes/activate-username.html", "scenes/base.html"))
 	tmpl.ExecuteTemplate(w, "base",
 		struct {
 			models.PageBase
-			LoginNames    []string
-			InviteID      string
-			PrivacyPolicy string
+			loginNameForm
+			LoginNames     []string
+			InviteID       string
+			PrivacyPolicy  string
+			ChoosePassword bool
+			Policy         idm.PasswordPolicy
+			SSHKeysEnabled bool
+			SSHKeyTypes    []string
+			CustomLogin    bool
+			CustomChoice   string
+			Required       bool
 		}{
-			LoginNames:    usernameOptions,
-			InviteID:      inviteID,
-			PrivacyPolicy: viper.GetString("LINK_PRIVACY_POLICY"),
-			PageBase:      models.NewPageBase(""),
+			loginNameForm:  form,
+			LoginNames:     loginNames,
+			InviteID:       invite.ID.String(),
+			PrivacyPolicy:  viper.GetString("LINK_PRIVACY_POLICY"),
+			ChoosePassword: choosePassword(),
+			Policy:         policy,
+			SSHKeysEnabled: sshkey.EnabledFor(invite.Affiliation),
+			SSHKeyTypes:    sshkey.AllowedTypes(),
+			CustomLogin:    customLoginEnabled() && invite.RequiredLogin == "",
+			CustomChoice:   customLoginChoice,
+			Required:       invite.RequiredLogin != "",
+			PageBase:       models.NewPageBase("").WithCSRF(r),
 		},
 	)
-
 }
 
 // Validate cookie
@@ -192,9 +265,14 @@ func CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Throttle account creation attempts
+	if !allowRate(w, r, "create", invite.Email) {
+		return
+	}
+
 	// Get cookie
 	if SessionCookieStore == nil {
-		SessionCookieStore = sessions.NewCookieStore([]byte(viper.GetString("SESSION_KEY")))
+		SessionCookieStore = auth.NewSessionStore()
 	}
 	session_IDCLAIM_ACTIVATION, _ := SessionCookieStore.Get(r, "IDCLAIM_ACTIVATION")
 
@@ -214,6 +292,32 @@ func CreateUser(w http.ResponseWriter, r *http.Request) {
 		cookieCheckOk = false
 	}
 
+	// Validate and record a proposed login name so it passes the
+	// login name check below
+	form := loginNameForm{LoginName: loginName}
+	if cookieCheckOk && loginName == customLoginChoice && customLoginEnabled() && invite.RequiredLogin == "" {
+		loginName = customLoginName(r)
+		form.CustomLoginName = loginName
+
+		problems, err := attribute.CheckCustomLoginName(loginName, invite.ID.String())
+		if err != nil {
+			log.Println("Call to CheckCustomLoginName() in CreateUser() src/handlers/activate.go error - " + err.Error())
+			http.Redirect(w, r, "/500", http.StatusSeeOther)
+			return
+		}
+		if len(problems) > 0 {
+			form.CustomLoginErrors = problems
+			renderLoginNameSelect(w, r, invite, inviteLoginNames(invite), form)
+			return
+		}
+
+		if err := db.SetCustomLoginName(inviteID, loginName); err != nil {
+			log.Println("Call to SetCustomLoginName() in CreateUser() src/handlers/activate.go error - " + err.Error())
+			http.Redirect(w, r, "/500", http.StatusSeeOther)
+			return
+		}
+	}
+
 	// Check login name
 	nameValid, err := db.CheckLoginNames(inviteID, loginName)
 	if err != nil {
@@ -242,8 +346,35 @@ func CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Check chosen password
+	password := ""
+	if choosePassword() {
+		password = r.Form.Get("password")
+
+		if password != r.Form.Get("passwordConfirm") {
+			form.PasswordErrors = append(form.PasswordErrors, "Passwords do not match")
+		}
+		policy, err := directory.GetPasswordPolicy(invite)
+		if err != nil {
+			log.Println("Call to GetPasswordPolicy() in CreateUser() src/handlers/activate.go error - " + err.Error())
+		}
+		form.PasswordErrors = append(form.PasswordErrors, policy.Validate(password, loginName, invite.FirstName, invite.LastName)...)
+	}
+
+	// Check SSH public keys
+	var sshKeys []string
+	if sshkey.EnabledFor(invite.Affiliation) {
+		form.SSHKeys = r.Form.Get("sshKeys")
+		sshKeys, form.SSHKeyErrors = sshkey.Parse(form.SSHKeys)
+	}
+
+	if len(form.PasswordErrors) > 0 || len(form.SSHKeyErrors) > 0 {
+		renderLoginNameSelect(w, r, invite, inviteLoginNames(invite), form)
+		return
+	}
+
 	// Check user doesn't exist (email)
-	emailExists, err := idm.CheckEmailExists(invite.Email)
+	emailExists, err := directory.CheckEmailExists(invite.Email)
 	if err != nil {
 		log.Println("Call to CheckEmailExists() in CreateUser() src/handlers/activate.go error - " + err.Error())
 		http.Redirect(w, r, "/500", http.StatusSeeOther)
@@ -253,7 +384,7 @@ func CreateUser(w http.ResponseWriter, r *http.Request) {
 	// Check user doesn't exist (username)
 	var usernameUsed bool
 	if emailExists == false {
-		readyNames, err := idm.CheckUsernamesExists([]string{loginName})
+		readyNames, err := directory.CheckUsernamesExists([]string{loginName})
 		if err != nil {
 			log.Println("Call to CheckUsernamesExists() in CreateUser() src/handlers/activate.go error - " + err.Error())
 			http.Redirect(w, r, "/500", http.StatusSeeOther)
@@ -262,7 +393,13 @@ func CreateUser(w http.ResponseWriter, r *http.Request) {
 		usernameUsed = len(readyNames) == 0
 	}
 
-	if emailExists || usernameUsed {
+	// Name claimed since it was offered
+	if usernameUsed {
+		renderLoginNameTaken(w, r, invite, form)
+		return
+	}
+
+	if emailExists {
 		tmpl := template.Must(template.ParseFS(scenes.TemplateFS, "scenes/400.html", "scenes/base.html"))
 		tmpl.ExecuteTemplate(w, "base",
 			struct {
@@ -278,23 +415,49 @@ func CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Hold chosen name so another invitee cannot claim it
+	err = db.HoldLoginName(loginName, inviteID, loginNameHold())
+	if errors.Is(err, db.ErrLoginNameReserved) {
+		renderLoginNameTaken(w, r, invite, form)
+		return
+	}
+	if err != nil {
+		log.Println("Call to HoldLoginName() in CreateUser() src/handlers/activate.go error - " + err.Error())
+		http.Redirect(w, r, "/500", http.StatusSeeOther)
+		return
+	}
+
 	// Call maker
-	passwd, err := idm.HandleMakeUser(invite, loginName)
+	passwd, err := directory.HandleMakeUser(invite, loginName, password, sshKeys)
+	if errors.Is(err, directory.ErrLoginNameExists) {
+		log.Println("Call to HandleMakeUser() in CreateUser() src/handlers/activate.go login name taken - " + err.Error())
+		renderLoginNameTaken(w, r, invite, form)
+		return
+	}
+	if errors.Is(err, directory.ErrPasswordPolicy) && password != "" {
+		log.Println("Call to HandleMakeUser() in CreateUser() src/handlers/activate.go password rejected - " + err.Error())
+		form.PasswordErrors = []string{"Password was rejected by the password policy, please choose another"}
+		renderLoginNameSelect(w, r, invite, inviteLoginNames(invite), form)
+		return
+	}
 	if err != nil {
 		log.Println("Call to HandleMakeUser() in CreateUser() src/handlers/activate.go error - " + err.Error())
 		http.Redirect(w, r, "/500", http.StatusSeeOther)
 		return
 	}
 
+	// Start the sponsored term before the invite is gone
+	sponsor.Record(invite, loginName, time.Now())
+
 	// Delete invite
 	db.DeleteInviteEmail(invite.Email)
 
 	// Set flash
 	session_IDCLAIM_SUCCESS, _ := SessionCookieStore.Get(r, "IDCLAIM_SUCCESS")
-	session_IDCLAIM_SUCCESS.Options = &sessions.Options{
-		Path:     "/",
-		MaxAge:   300,
-		HttpOnly: true,
+	session_IDCLAIM_SUCCESS.Options = auth.SessionOptions(300)
+	if otpEnrollEnabled() {
+		// Allow time to set up an authenticator first
+		session_IDCLAIM_SUCCESS.Options = auth.SessionOptions(1800)
 	}
 	session_IDCLAIM_SUCCESS.AddFlash(SuccessData{
 		FirstName: invite.FirstName,
@@ -303,11 +466,28 @@ func CreateUser(w http.ResponseWriter, r *http.Request) {
 	}, inviteID)
 	session_IDCLAIM_SUCCESS.Save(r, w)
 
+	// Offer OTP enrollment for the new account
+	if otpEnrollEnabled() {
+		session_IDCLAIM_ACTIVATION.Values["enrollLogin"] = loginName
+		session_IDCLAIM_ACTIVATION.Save(r, w)
+		http.Redirect(w, r, "/otp-enroll/"+inviteID, http.StatusSeeOther)
+		return
+	}
+
 	// Redirect to success
 	http.Redirect(w, r, "/success/"+inviteID, http.StatusSeeOther)
 
 }
 
+// Login name options saved on invite
+func inviteLoginNames(invite models.Invite) []string {
+	var names []string
+	if err := json.Unmarshal(invite.LoginNames, &names); err != nil {
+		log.Println("inviteLoginNames() src/handlers/activate.go unable to json.Unmarshal() " + err.Error())
+	}
+	return names
+}
+
 // Show success page
 func CreateSuccess(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -315,7 +495,7 @@ func CreateSuccess(w http.ResponseWriter, r *http.Request) {
 
 	// Get cookie
 	if SessionCookieStore == nil {
-		SessionCookieStore = sessions.NewCookieStore([]byte(viper.GetString("SESSION_KEY")))
+		SessionCookieStore = auth.NewSessionStore()
 	}
 
 	// Get flashes
@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -8,14 +10,17 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
 	"github.com/hadleyso/netid-activate/src/db"
 	"github.com/hadleyso/netid-activate/src/models"
+	"github.com/hadleyso/netid-activate/src/ratelimit"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
 	"gorm.io/datatypes"
 	"gorm.io/gorm"
 )
@@ -27,7 +32,7 @@ func setupTestDBForActivateHandlers(t *testing.T) (*gorm.DB, models.Invite) {
 	viper.Set("DEV", "true")
 
 	database := db.DbConnect()
-	err := database.AutoMigrate(&models.Invite{}, &models.OTP{}, &models.EmailRate{})
+	err := database.AutoMigrate(&models.Invite{}, &models.OTP{}, &models.EmailRate{}, &models.RateBucket{}, &models.LoginReservation{})
 	assert.NoError(t, err)
 
 	groups := []string{"employees", "hpc_org_008bbc9505b0429cb20d531182a9cf7e"}
@@ -127,11 +132,106 @@ func TestActivateOTPPost(t *testing.T) {
 	assert.Equal(t, int64(0), count)
 }
 
-func newRequestWithActivationSession(t *testing.T, invite models.Invite, loginName string) *http.Request {
+func TestActivateOTPPostRequiredLogin(t *testing.T) {
+	database, invite := setupTestDBForActivateHandlers(t)
+	invite.RequiredLogin = "e12345"
+	database.Save(&invite)
+	database.Create(&models.OTP{Code: 123456, InviteID: invite.ID.String()})
+
+	viper.Set("LOGIN_NAME_CUSTOM", true)
+	t.Cleanup(func() { viper.Set("LOGIN_NAME_CUSTOM", nil) })
+
+	setupIDMPasswordServer(t, "")
+
+	form := url.Values{}
+	form.Add("activateEmail", invite.Email)
+	form.Add("activateOTP", "123456")
+	req := httptest.NewRequest("POST", "/otp", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(ActivateOTPPost).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Your login name has been set by your inviter")
+	assert.Contains(t, rr.Body.String(), `value="e12345"`)
+	assert.NotContains(t, rr.Body.String(), `id="loginname-custom"`)
+
+	// Only the required name is accepted
+	valid, _ := db.CheckLoginNames(invite.ID.String(), "testuser")
+	assert.False(t, valid)
+	valid, _ = db.CheckLoginNames(invite.ID.String(), "e12345")
+	assert.True(t, valid)
+}
+
+func TestActivateOTPPostRateLimited(t *testing.T) {
+	_, invite := setupTestDBForActivateHandlers(t)
+	viper.Set("RATE_LIMIT_EMAIL", "2/1h")
+	RateLimiter = ratelimit.NewMemoryLimiter()
+	t.Cleanup(func() {
+		viper.Set("RATE_LIMIT_EMAIL", nil)
+		RateLimiter = nil
+	})
+
+	post := func(remoteAddr string) *httptest.ResponseRecorder {
+		form := url.Values{}
+		form.Add("activateEmail", invite.Email)
+		form.Add("activateOTP", "000000")
+		req := httptest.NewRequest("POST", "/otp", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = remoteAddr
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(ActivateOTPPost).ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Wrong guesses from different IPs count against the email
+	assert.Equal(t, http.StatusOK, post("192.0.2.1:1234").Code)
+	assert.Equal(t, http.StatusOK, post("192.0.2.2:1234").Code)
+
+	rr := post("192.0.2.3:1234")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+	assert.Contains(t, rr.Body.String(), "Too many attempts")
+}
+
+func TestActivateEmailPostRateLimitedByIP(t *testing.T) {
+	setupTestDBForActivateHandlers(t)
+	viper.Set("RATE_LIMIT_IP", "1/1h")
+	t.Cleanup(func() {
+		viper.Set("RATE_LIMIT_IP", nil)
+		RateLimiter = nil
+	})
+
+	// Default DB limiter
+	RateLimiter = nil
+
+	post := func(email string) int {
+		form := url.Values{}
+		form.Add("activateEmail", email)
+		req := httptest.NewRequest("POST", "/activate", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(ActivateEmailPost).ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	assert.Equal(t, http.StatusOK, post("nobody@example.com"))
+	assert.Equal(t, http.StatusTooManyRequests, post("someone@example.com"))
+}
+
+func newRequestWithActivationSession(t *testing.T, invite models.Invite, loginName string, password string, passwordConfirm string) *http.Request {
 	form := url.Values{}
 	form.Add("inviteID", invite.ID.String())
 	form.Add("loginname", loginName)
+	form.Add("password", password)
+	form.Add("passwordConfirm", passwordConfirm)
+	return newActivationSessionRequest(t, invite, form)
+}
 
+func newActivationSessionRequest(t *testing.T, invite models.Invite, form url.Values) *http.Request {
 	req := httptest.NewRequest("POST", "/login-name-select", strings.NewReader(form.Encode()))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
@@ -161,27 +261,319 @@ func TestCreateUser(t *testing.T) {
 	invite.LoginNames = loginNamesJSON
 	database.Save(&invite)
 
+	calls := setupIDMPasswordServer(t, "")
+
+	req := newRequestWithActivationSession(t, invite, "testuser", "Correct-Horse-9", "Correct-Horse-9")
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(CreateUser)
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/success/"+invite.ID.String(), rr.Header().Get("Location"))
+	assert.Equal(t, "Correct-Horse-9", calls.userPassword)
+
+	var count int64
+	database.Model(&models.Invite{}).Where("id = ?", invite.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
+
+type idmPasswordCalls struct {
+	userAdd      int
+	userUID      string
+	userPassword string
+	userSSHKeys  []any
+}
+
+// IdM stub with a 10 character, 3 class password policy
+// userAddError is returned from user_add if set
+func setupIDMPasswordServer(t *testing.T, userAddError string) *idmPasswordCalls {
+	calls := &idmPasswordCalls{}
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/ipa/session/login_password", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	})
 	mux.HandleFunc("/ipa/session/json", func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Method string `json:"method"`
+			Params []any  `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+
 		w.Header().Set("Content-Type", "application/json")
-		w.Write([]byte(`{"result": {"count": 0}}`))
+		switch payload.Method {
+		case "pwpolicy_show":
+			// The hpc-admins group has a stricter policy
+			if args, _ := payload.Params[0].([]any); len(args) > 0 && args[0] == "hpc-admins" {
+				w.Write([]byte(`{"result": {"result": {"cn": ["hpc-admins"], "krbpwdminlength": ["16"]}, "value": "hpc-admins"}}`))
+				return
+			}
+			if args, _ := payload.Params[0].([]any); len(args) > 0 {
+				w.Write([]byte(`{"error": {"code": 4001, "message": "password policy not found", "name": "NotFound"}}`))
+				return
+			}
+			w.Write([]byte(`{"result": {"result": {"cn": ["global_policy"], "krbpwdminlength": ["10"], "krbpwdmindiffchars": ["3"], "krbpwdhistorylength": ["4"], "ipapwdusercheck": ["TRUE"]}, "value": "global_policy"}}`))
+		case "user_add":
+			calls.userAdd++
+			if uids, ok := payload.Params[0].([]any); ok && len(uids) > 0 {
+				calls.userUID, _ = uids[0].(string)
+			}
+			if options, ok := payload.Params[1].(map[string]any); ok {
+				calls.userPassword, _ = options["userpassword"].(string)
+				calls.userSSHKeys, _ = options["ipasshpubkey"].([]any)
+			}
+			if userAddError != "" {
+				w.Write([]byte(`{"error": {"code": 4203, "message": "` + userAddError + `", "name": "ValidationError"}}`))
+				return
+			}
+			w.Write([]byte(`{"result": {"result": {}}}`))
+		default:
+			w.Write([]byte(`{"result": {"count": 0}}`))
+		}
 	})
 	setupIDMTestServer(t, mux)
 
-	req := newRequestWithActivationSession(t, invite, "testuser")
+	return calls
+}
+
+func TestCreateUserPasswordPolicy(t *testing.T) {
+	database, invite := setupTestDBForActivateHandlers(t)
+	loginNamesJSON, _ := json.Marshal([]string{"testuser", "tuser"})
+	invite.LoginNames = loginNamesJSON
+	database.Save(&invite)
+
+	calls := setupIDMPasswordServer(t, "")
+
+	tests := []struct {
+		password string
+		confirm  string
+		message  string
+	}{
+		{"short", "short", "Must be at least 10 characters"},
+		{"alllowercaseletters", "alllowercaseletters", "Must use at least 3 of"},
+		{"Testuser-2024", "Testuser-2024", "Must not contain your name or login name"},
+		{"Correct-Horse-9", "Correct-Horse-8", "Passwords do not match"},
+		{"", "", "Password is required"},
+	}
+	for _, tt := range tests {
+		req := newRequestWithActivationSession(t, invite, "testuser", tt.password, tt.confirm)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(CreateUser).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code, tt.password)
+		assert.Contains(t, rr.Body.String(), tt.message, tt.password)
+		// Form shown again with the selected name and policy
+		assert.Contains(t, rr.Body.String(), `value="testuser" checked`)
+		assert.Contains(t, rr.Body.String(), `data-min-length="10"`)
+		assert.Contains(t, rr.Body.String(), "cannot reuse your last 4 passwords")
+	}
+
+	assert.Equal(t, 0, calls.userAdd)
+}
+
+func TestCreateUserGroupPasswordPolicy(t *testing.T) {
+	database, invite := setupTestDBForActivateHandlers(t)
+	loginNamesJSON, _ := json.Marshal([]string{"testuser", "tuser"})
+	invite.LoginNames = loginNamesJSON
+	invite.OptionalGroups = datatypes.JSON(`["employees", "hpc-admins"]`)
+	database.Save(&invite)
+
+	calls := setupIDMPasswordServer(t, "")
+
+	// Meets the global policy, not the policy of a group joined
+	req := newRequestWithActivationSession(t, invite, "testuser", "Correct-Horse-9", "Correct-Horse-9")
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(CreateUser)
-	handler.ServeHTTP(rr, req)
+	http.HandlerFunc(CreateUser).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Must be at least 16 characters")
+	assert.Contains(t, rr.Body.String(), `data-min-length="16"`)
+	assert.Equal(t, 0, calls.userAdd)
+
+	req = newRequestWithActivationSession(t, invite, "testuser", "Correct-Horse-Battery-9", "Correct-Horse-Battery-9")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(CreateUser).ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusSeeOther, rr.Code)
-	assert.Equal(t, "/success/"+invite.ID.String(), rr.Header().Get("Location"))
+	assert.Equal(t, 1, calls.userAdd)
+}
+
+func TestCreateUserPasswordRejectedByIdM(t *testing.T) {
+	database, invite := setupTestDBForActivateHandlers(t)
+	loginNamesJSON, _ := json.Marshal([]string{"testuser", "tuser"})
+	invite.LoginNames = loginNamesJSON
+	database.Save(&invite)
+
+	setupIDMPasswordServer(t, "Constraint violation: Password is too simple (dictionary word)")
+
+	req := newRequestWithActivationSession(t, invite, "testuser", "Correct-Horse-9", "Correct-Horse-9")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(CreateUser).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Password was rejected by the password policy")
 
 	var count int64
 	database.Model(&models.Invite{}).Where("id = ?", invite.ID).Count(&count)
-	assert.Equal(t, int64(0), count)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestCreateUserLoginNameTakenInIdM(t *testing.T) {
+	database, invite := setupTestDBForActivateHandlers(t)
+	loginNamesJSON, _ := json.Marshal([]string{"testuser", "tuser"})
+	invite.LoginNames = loginNamesJSON
+	database.Save(&invite)
+
+	calls := setupIDMPasswordServer(t, "user with name testuser already exists")
+
+	req := newRequestWithActivationSession(t, invite, "testuser", "Correct-Horse-9", "Correct-Horse-9")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(CreateUser).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, 1, calls.userAdd)
+	assert.Contains(t, rr.Body.String(), "This login name was just taken, please choose another")
+
+	// Fresh options are saved and held for the invite
+	updated, _ := db.InviteDetails(invite.ID.String())
+	assert.NotContains(t, inviteLoginNames(updated), "tuser")
+	assert.NotEmpty(t, inviteLoginNames(updated))
+
+	var held int64
+	database.Model(&models.LoginReservation{}).Where("invite_id = ?", invite.ID.String()).Count(&held)
+	assert.Equal(t, int64(len(inviteLoginNames(updated))), held)
+}
+
+func TestCreateUserLoginNameHeldByOtherInvite(t *testing.T) {
+	database, invite := setupTestDBForActivateHandlers(t)
+	loginNamesJSON, _ := json.Marshal([]string{"testuser", "tuser"})
+	invite.LoginNames = loginNamesJSON
+	database.Save(&invite)
+
+	calls := setupIDMPasswordServer(t, "")
+
+	// Offered to another invitee after this one
+	err := db.HoldLoginName("testuser", "other-invite", time.Hour)
+	assert.NoError(t, err)
+
+	req := newRequestWithActivationSession(t, invite, "testuser", "Correct-Horse-9", "Correct-Horse-9")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(CreateUser).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, 0, calls.userAdd)
+	assert.Contains(t, rr.Body.String(), "This login name was just taken, please choose another")
+	assert.NotContains(t, rr.Body.String(), `value="testuser"`)
+}
+
+func TestActivateOTPPostHoldsLoginNames(t *testing.T) {
+	database, invite := setupTestDBForActivateHandlers(t)
+	otp := models.OTP{Code: 123456, InviteID: invite.ID.String()}
+	database.Create(&otp)
+
+	setupIDMPasswordServer(t, "")
+
+	// Held for another invitee, not offered
+	err := db.HoldLoginName("testuser", "other-invite", time.Hour)
+	assert.NoError(t, err)
+
+	form := url.Values{}
+	form.Add("activateEmail", invite.Email)
+	form.Add("activateOTP", "123456")
+	req := httptest.NewRequest("POST", "/otp", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(ActivateOTPPost).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotContains(t, rr.Body.String(), `value="testuser"`)
+
+	updated, _ := db.InviteDetails(invite.ID.String())
+	offered := inviteLoginNames(updated)
+	assert.NotEmpty(t, offered)
+	for _, name := range offered {
+		reserved, err := db.LoginNameReserved(name, "other-invite")
+		assert.NoError(t, err)
+		assert.True(t, reserved, name)
+	}
+}
+
+func TestCreateUserTemporaryPassword(t *testing.T) {
+	database, invite := setupTestDBForActivateHandlers(t)
+	loginNamesJSON, _ := json.Marshal([]string{"testuser", "tuser"})
+	invite.LoginNames = loginNamesJSON
+	database.Save(&invite)
+
+	viper.Set("PASSWORD_MODE", "temporary")
+	t.Cleanup(func() { viper.Set("PASSWORD_MODE", nil) })
+
+	calls := setupIDMPasswordServer(t, "")
+
+	req := newRequestWithActivationSession(t, invite, "testuser", "", "")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(CreateUser).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, 1, calls.userAdd)
+	assert.NotEmpty(t, calls.userPassword)
+}
+
+func TestCreateUserSSHKeys(t *testing.T) {
+	database, invite := setupTestDBForActivateHandlers(t)
+	loginNamesJSON, _ := json.Marshal([]string{"testuser", "tuser"})
+	invite.LoginNames = loginNamesJSON
+	database.Save(&invite)
+
+	viper.Set("SSH_KEY_AFFILIATIONS", []string{invite.Affiliation})
+	t.Cleanup(func() { viper.Set("SSH_KEY_AFFILIATIONS", nil) })
+
+	calls := setupIDMPasswordServer(t, "")
+
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+	sshPub, _ := ssh.NewPublicKey(pub)
+	key := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub)))
+
+	post := func(keys string) *httptest.ResponseRecorder {
+		form := url.Values{}
+		form.Add("inviteID", invite.ID.String())
+		form.Add("loginname", "testuser")
+		form.Add("password", "Correct-Horse-9")
+		form.Add("passwordConfirm", "Correct-Horse-9")
+		form.Add("sshKeys", keys)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(CreateUser).ServeHTTP(rr, newActivationSessionRequest(t, invite, form))
+		return rr
+	}
+
+	// Invalid key shows the form again with the input
+	rr := post("ssh-dss <script>")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Line 1 is not a valid SSH public key")
+	assert.Contains(t, rr.Body.String(), "ssh-dss &lt;script&gt;</textarea>")
+	assert.Equal(t, 0, calls.userAdd)
+
+	rr = post(key + " test@laptop\n")
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, []any{key + " test@laptop"}, calls.userSSHKeys)
+}
+
+func TestCreateUserSSHKeysDisabled(t *testing.T) {
+	database, invite := setupTestDBForActivateHandlers(t)
+	loginNamesJSON, _ := json.Marshal([]string{"testuser", "tuser"})
+	invite.LoginNames = loginNamesJSON
+	database.Save(&invite)
+
+	calls := setupIDMPasswordServer(t, "")
+
+	// Keys are ignored for affiliations without the step
+	req := newRequestWithActivationSession(t, invite, "testuser", "short", "short")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(CreateUser).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotContains(t, rr.Body.String(), `name="sshKeys"`)
+	assert.Nil(t, calls.userSSHKeys)
 }
 
 func TestCreateSuccess(t *testing.T) {
@@ -216,3 +608,29 @@ func TestCreateSuccess(t *testing.T) {
 	assert.Contains(t, rr.Body.String(), "testuser")
 	assert.Contains(t, rr.Body.String(), "ABC-DEF-GHI")
 }
+
+func TestCreateSuccessChosenPassword(t *testing.T) {
+	setupTestDBForActivateHandlers(t)
+
+	inviteID := uuid.New().String()
+	req := httptest.NewRequest("GET", "/success/"+inviteID, nil)
+	req = mux.SetURLVars(req, map[string]string{"inviteID": inviteID})
+
+	if SessionCookieStore == nil {
+		SessionCookieStore = sessions.NewCookieStore([]byte(viper.GetString("SESSION_KEY")))
+	}
+	session, _ := SessionCookieStore.Get(req, "IDCLAIM_SUCCESS")
+	session.AddFlash(SuccessData{FirstName: "Test", LoginName: "testuser"}, inviteID)
+	rr := httptest.NewRecorder()
+	assert.NoError(t, session.Save(req, rr))
+	for _, cookie := range rr.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(CreateSuccess).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "with the password you chose")
+	assert.NotContains(t, rr.Body.String(), "temporary password")
+}
@@ -1,8 +1,10 @@
 package handlers
 
 import (
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"text/template"
 
 	"github.com/hadleyso/netid-activate/src/auth"
@@ -33,7 +35,7 @@ func GetSent(w http.ResponseWriter, r *http.Request) {
 			models.PageBase
 		}{
 			Invites:  invites,
-			PageBase: models.NewPageBase(""),
+			PageBase: models.NewPageBase("").WithCSRF(r),
 		},
 	)
 }
@@ -64,3 +66,47 @@ func DeleteInvite(w http.ResponseWriter, r *http.Request) {
 	db.DeleteInviteEmail(email)
 	http.Redirect(w, r, "/invite/sent", http.StatusSeeOther)
 }
+
+func AdminSessions(w http.ResponseWriter, r *http.Request) {
+	tmpl := template.Must(template.ParseFS(scenes.TemplateFS, "scenes/admin-sessions.html", "scenes/base.html"))
+	tmpl.ExecuteTemplate(w, "base",
+		struct {
+			Message string
+			models.PageBase
+		}{
+			PageBase: models.NewPageBase("").WithCSRF(r),
+		},
+	)
+}
+
+// Force logout of all sessions for a user
+func AdminRevokeSessions(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	username := strings.TrimSpace(r.Form.Get("username"))
+
+	message := "Please enter a login name"
+	if username != "" {
+		count, supported, err := auth.RevokeUserSessions(username)
+		if err != nil {
+			log.Println("RevokeUserSessions() error in handler AdminRevokeSessions()")
+			http.Redirect(w, r, "/500", http.StatusSeeOther)
+			return
+		}
+
+		message = fmt.Sprintf("Removed %d session(s) for %s", count, username)
+		if !supported {
+			message = "The configured session store does not support forced logout"
+		}
+	}
+
+	tmpl := template.Must(template.ParseFS(scenes.TemplateFS, "scenes/admin-sessions.html", "scenes/base.html"))
+	tmpl.ExecuteTemplate(w, "base",
+		struct {
+			Message string
+			models.PageBase
+		}{
+			Message:  message,
+			PageBase: models.NewPageBase("").WithCSRF(r),
+		},
+	)
+}
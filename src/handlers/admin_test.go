@@ -23,7 +23,7 @@ func setupTestDBForAdminHandlers(t *testing.T) *gorm.DB {
 	viper.Set("SESSION_KEY", "a-very-secret-key-for-admin")
 
 	database := db.DbConnect()
-	err := database.AutoMigrate(&models.Invite{}, &models.OTP{}, &models.EmailRate{})
+	err := database.AutoMigrate(&models.Invite{}, &models.OTP{}, &models.EmailRate{}, &models.LoginReservation{})
 	if err != nil {
 		t.Fatalf("Failed to migrate database: %v", err)
 	}
@@ -134,3 +134,19 @@ func TestDeleteInvite_NotOwner(t *testing.T) {
 	database.Model(&models.Invite{}).Where("email = ?", "another@example.com").Count(&count)
 	assert.Equal(t, int64(1), count)
 }
+
+func TestAdminRevokeSessions(t *testing.T) {
+	setupTestDBForAdminHandlers(t)
+
+	form := url.Values{}
+	form.Add("username", "jdoe")
+
+	req := newRequestWithSession(t, "POST", "/admin/sessions", form.Encode(), "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(AdminRevokeSessions)
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "does not support forced logout")
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/hadleyso/netid-activate/src/attribute"
+	"github.com/hadleyso/netid-activate/src/auth"
+	"github.com/hadleyso/netid-activate/src/config"
+	"github.com/hadleyso/netid-activate/src/db"
+	"github.com/hadleyso/netid-activate/src/mailer"
+	"github.com/hadleyso/netid-activate/src/models"
+	"github.com/hadleyso/netid-activate/src/scenes"
+)
+
+// Pending invite and the selected groups that require approval
+type approvalRow struct {
+	Invite models.Invite
+	Groups []config.Group
+}
+
+// Emails sent on approval and rejection, replaced in tests
+var (
+	sendInvite    = mailer.HandleSendInvite
+	sendRejection = mailer.HandleSendRejection
+)
+
+// List invites awaiting approval
+func ApprovalList(w http.ResponseWriter, r *http.Request) {
+	renderApprovals(w, r, "")
+}
+
+// Approve an invite and email the invitee
+func ApprovalApprove(w http.ResponseWriter, r *http.Request) {
+	user, errUser := auth.GetUser(w, r)
+	if errUser != nil {
+		http.Redirect(w, r, "/500?error=GetUser+error", http.StatusSeeOther)
+		return
+	}
+
+	r.ParseForm()
+	inviteID := r.Form.Get("inviteID")
+
+	invite, err := db.ApproveInvite(inviteID, user.PreferredUsername)
+	if errors.Is(err, db.ErrSelfApproval) {
+		renderApprovals(w, r, "You cannot approve your own invite, another approver must approve it")
+		return
+	}
+	if errors.Is(err, db.ErrNotPending) {
+		renderApprovals(w, r, "The invite was already approved, rejected or deleted")
+		return
+	}
+	if err != nil {
+		http.Redirect(w, r, "/500?error=DB+ApproveInvite+error", http.StatusSeeOther)
+		return
+	}
+	log.Printf("ApprovalApprove() %s approved invite of %s by %s\n", user.PreferredUsername, invite.Email, invite.Inviter)
+
+	// Back to pending so it can be approved again, the invitee was not emailed
+	if err := sendInvite(invite.Email); err != nil {
+		log.Println("ApprovalApprove() unable to HandleSendInvite() " + err.Error())
+		if err := db.UnapproveInvite(inviteID, user.PreferredUsername); err != nil {
+			http.Redirect(w, r, "/500?error=mail+HandleSendInvite+error", http.StatusSeeOther)
+			return
+		}
+		renderApprovals(w, r, "The email to "+invite.Email+" could not be sent, the invite is still awaiting approval. Please try again later")
+		return
+	}
+
+	renderApprovals(w, r, "Approved, an email has been sent to "+invite.FirstName+"'s "+invite.Email+" inbox.")
+}
+
+// Reject and delete an invite, the inviter is emailed the reason
+func ApprovalReject(w http.ResponseWriter, r *http.Request) {
+	user, errUser := auth.GetUser(w, r)
+	if errUser != nil {
+		http.Redirect(w, r, "/500?error=GetUser+error", http.StatusSeeOther)
+		return
+	}
+
+	r.ParseForm()
+	inviteID := r.Form.Get("inviteID")
+	reason := strings.TrimSpace(r.Form.Get("reason"))
+
+	if reason == "" {
+		renderApprovals(w, r, "Please enter a reason for the rejection")
+		return
+	}
+
+	invite, err := db.RejectInvite(inviteID)
+	if errors.Is(err, db.ErrNotPending) {
+		renderApprovals(w, r, "The invite was already approved, rejected or deleted")
+		return
+	}
+	if err != nil {
+		http.Redirect(w, r, "/500?error=DB+RejectInvite+error", http.StatusSeeOther)
+		return
+	}
+	log.Printf("ApprovalReject() %s rejected invite of %s by %s\n", user.PreferredUsername, invite.Email, invite.Inviter)
+
+	// Restored so the inviter is not left without a reason
+	if err := sendRejection(invite, attribute.ApprovalRequired(inviteGroups(invite)), reason); err != nil {
+		log.Println("ApprovalReject() unable to HandleSendRejection() " + err.Error())
+		if err := db.RestoreRejectedInvite(invite); err != nil {
+			http.Redirect(w, r, "/500?error=mail+HandleSendRejection+error", http.StatusSeeOther)
+			return
+		}
+		renderApprovals(w, r, "The email to "+invite.Inviter+" could not be sent, the invite is still awaiting approval. Please try again later")
+		return
+	}
+
+	renderApprovals(w, r, "Rejected, "+invite.Inviter+" has been notified.")
+}
+
+// Optional groups selected for the invite
+func inviteGroups(invite models.Invite) []string {
+	var groups []string
+	if err := json.Unmarshal(invite.OptionalGroups, &groups); err != nil {
+		return nil
+	}
+	return groups
+}
+
+// Show invites awaiting approval with message
+func renderApprovals(w http.ResponseWriter, r *http.Request, message string) {
+	invites, err := db.GetPendingApprovals()
+	if err != nil {
+		log.Println("GetPendingApprovals() error in renderApprovals()")
+		http.Redirect(w, r, "/500", http.StatusSeeOther)
+		return
+	}
+
+	var rows []approvalRow
+	for _, invite := range invites {
+		rows = append(rows, approvalRow{Invite: invite, Groups: attribute.ApprovalRequired(inviteGroups(invite))})
+	}
+
+	tmpl := template.Must(template.ParseFS(scenes.TemplateFS, "scenes/approve.html", "scenes/base.html"))
+	tmpl.ExecuteTemplate(w, "base",
+		struct {
+			Approvals []approvalRow
+			Message   string
+			models.PageBase
+		}{
+			Approvals: rows,
+			Message:   message,
+			PageBase:  models.NewPageBase("").WithCSRF(r),
+		},
+	)
+}
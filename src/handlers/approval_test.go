@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/hadleyso/netid-activate/src/config"
+	"github.com/hadleyso/netid-activate/src/mailer"
+	"github.com/hadleyso/netid-activate/src/models"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// Pending invite to the prod group, which requires approval
+func createPendingInvite(t *testing.T, database *gorm.DB, email string) models.Invite {
+	config.C.OptionalGroups = map[string][]config.Group{
+		"prod": {{GroupName: "Production", RequiresApproval: true}},
+	}
+	t.Cleanup(func() { config.C.OptionalGroups = nil })
+
+	groups, _ := json.Marshal([]string{"prod"})
+	invite := models.Invite{Inviter: "inviter1", InviterEmail: "inviter1@example.com", Email: email, FirstName: "Jane", LastName: "Doe", OptionalGroups: groups, ApprovalStatus: models.ApprovalPending}
+	database.Create(&invite)
+	return invite
+}
+
+func TestApprovalList(t *testing.T) {
+	database := setupTestDBForAdminHandlers(t)
+	createPendingInvite(t, database, "pending@example.com")
+	database.Create(&models.Invite{Inviter: "inviter1", Email: "sent@example.com"})
+
+	req := newRequestWithSession(t, "GET", "/approve/", "", "")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(ApprovalList).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "pending@example.com")
+	assert.Contains(t, rr.Body.String(), "Production")
+	assert.NotContains(t, rr.Body.String(), "sent@example.com")
+}
+
+func TestApprovalApprove(t *testing.T) {
+	database := setupTestDBForAdminHandlers(t)
+	viper.Set("DEV", "true")
+	invite := createPendingInvite(t, database, "pending@example.com")
+
+	form := url.Values{}
+	form.Add("inviteID", invite.ID.String())
+
+	req := newRequestWithSession(t, "POST", "/approve/approve", form.Encode(), "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(ApprovalApprove).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Approved, an email has been sent")
+
+	var approved models.Invite
+	database.Where("email = ?", "pending@example.com").First(&approved)
+	assert.Equal(t, models.ApprovalApproved, approved.ApprovalStatus)
+	assert.Equal(t, "testuser", approved.ApprovedBy)
+
+	// Second approver
+	req = newRequestWithSession(t, "POST", "/approve/approve", form.Encode(), "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(ApprovalApprove).ServeHTTP(rr, req)
+	assert.Contains(t, rr.Body.String(), "already approved, rejected or deleted")
+}
+
+func TestApprovalApprove_OwnInvite(t *testing.T) {
+	database := setupTestDBForAdminHandlers(t)
+	viper.Set("DEV", "true")
+	invite := createPendingInvite(t, database, "pending@example.com")
+	database.Model(&invite).Update("inviter", "testuser")
+
+	form := url.Values{}
+	form.Add("inviteID", invite.ID.String())
+
+	req := newRequestWithSession(t, "POST", "/approve/approve", form.Encode(), "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(ApprovalApprove).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "You cannot approve your own invite")
+
+	var pending models.Invite
+	database.Where("email = ?", "pending@example.com").First(&pending)
+	assert.Equal(t, models.ApprovalPending, pending.ApprovalStatus)
+	assert.Empty(t, pending.ApprovedBy)
+}
+
+func TestApprovalApprove_MailFails(t *testing.T) {
+	database := setupTestDBForAdminHandlers(t)
+	invite := createPendingInvite(t, database, "pending@example.com")
+	sendInvite = func(email string) error { return errors.New("SES unavailable") }
+	t.Cleanup(func() { sendInvite = mailer.HandleSendInvite })
+
+	form := url.Values{}
+	form.Add("inviteID", invite.ID.String())
+
+	req := newRequestWithSession(t, "POST", "/approve/approve", form.Encode(), "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(ApprovalApprove).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "could not be sent, the invite is still awaiting approval")
+
+	// Can be approved again
+	var pending models.Invite
+	database.Where("email = ?", "pending@example.com").First(&pending)
+	assert.Equal(t, models.ApprovalPending, pending.ApprovalStatus)
+	assert.Empty(t, pending.ApprovedBy)
+}
+
+func TestApprovalReject_MailFails(t *testing.T) {
+	database := setupTestDBForAdminHandlers(t)
+	invite := createPendingInvite(t, database, "pending@example.com")
+	sendRejection = func(models.Invite, []config.Group, string) error { return errors.New("SES unavailable") }
+	t.Cleanup(func() { sendRejection = mailer.HandleSendRejection })
+
+	form := url.Values{}
+	form.Add("inviteID", invite.ID.String())
+	form.Add("reason", "No production access for students")
+
+	req := newRequestWithSession(t, "POST", "/approve/reject", form.Encode(), "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(ApprovalReject).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "could not be sent, the invite is still awaiting approval")
+
+	var pending models.Invite
+	assert.NoError(t, database.Where("email = ?", "pending@example.com").First(&pending).Error)
+	assert.Equal(t, models.ApprovalPending, pending.ApprovalStatus)
+}
+
+func TestApprovalReject(t *testing.T) {
+	database := setupTestDBForAdminHandlers(t)
+	viper.Set("DEV", "true")
+	invite := createPendingInvite(t, database, "pending@example.com")
+
+	// Reason is required
+	form := url.Values{}
+	form.Add("inviteID", invite.ID.String())
+	req := newRequestWithSession(t, "POST", "/approve/reject", form.Encode(), "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(ApprovalReject).ServeHTTP(rr, req)
+	assert.Contains(t, rr.Body.String(), "Please enter a reason")
+
+	form.Add("reason", "No production access for students")
+	req = newRequestWithSession(t, "POST", "/approve/reject", form.Encode(), "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(ApprovalReject).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Rejected, inviter1 has been notified")
+
+	var count int64
+	database.Model(&models.Invite{}).Where("email = ?", "pending@example.com").Count(&count)
+	assert.Equal(t, int64(0), count)
+}
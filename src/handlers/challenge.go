@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/hadleyso/netid-activate/src/challenge"
+	"github.com/hadleyso/netid-activate/src/models"
+	"github.com/hadleyso/netid-activate/src/proxy"
+	"github.com/hadleyso/netid-activate/src/ratelimit"
+	"github.com/hadleyso/netid-activate/src/scenes"
+)
+
+var ChallengeVerifier challenge.Verifier = nil
+
+// Landing form data
+type landingData struct {
+	models.PageBase
+	ActivateEmail   string
+	Challenge       *challenge.Widget
+	ChallengeFailed bool
+}
+
+// Require a challenge once the client IP or email passes CHALLENGE_THRESHOLD
+// Renders the landing form with the challenge and returns false if the
+// request did not pass
+func passChallenge(w http.ResponseWriter, r *http.Request, email string) bool {
+	if ChallengeVerifier == nil {
+		ChallengeVerifier = challenge.New()
+	}
+	if ChallengeVerifier == nil {
+		return true
+	}
+	if RateLimiter == nil {
+		RateLimiter = ratelimit.New()
+	}
+
+	// Suspicious once either bucket is empty
+	threshold := challenge.Threshold()
+	suspicious := !threshold.Enabled()
+	for _, key := range []string{"challenge:ip:" + proxy.ClientIP(r), "challenge:email:" + strings.ToLower(email)} {
+		ok, _, err := RateLimiter.Allow(key, threshold)
+		if err != nil {
+			log.Println("Call to Allow() in passChallenge() src/handlers/challenge.go error - " + err.Error())
+			continue
+		}
+		if !ok {
+			suspicious = true
+		}
+	}
+	if !suspicious {
+		return true
+	}
+
+	passed, err := ChallengeVerifier.Verify(r)
+	if err != nil {
+		log.Println("Call to Verify() in passChallenge() src/handlers/challenge.go error - " + err.Error())
+	}
+	if passed {
+		return true
+	}
+
+	widget, err := ChallengeVerifier.Widget(r)
+	if err != nil {
+		log.Println("Call to Widget() in passChallenge() src/handlers/challenge.go error - " + err.Error())
+		http.Redirect(w, r, "/500", http.StatusSeeOther)
+		return false
+	}
+
+	// Only flag failure if the user attempted the challenge
+	attempted := false
+	for _, field := range []string{"challengeResponse", "powNonce", "h-captcha-response", "cf-turnstile-response"} {
+		if r.PostFormValue(field) != "" {
+			attempted = true
+		}
+	}
+
+	tmpl := template.Must(template.ParseFS(scenes.TemplateFS, "scenes/landing.html", "scenes/base.html"))
+	tmpl.ExecuteTemplate(w, "base", landingData{
+		PageBase:        models.NewPageBase("").WithCSRF(r),
+		ActivateEmail:   email,
+		Challenge:       &widget,
+		ChallengeFailed: attempted,
+	})
+	return false
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/hadleyso/netid-activate/src/challenge"
+	"github.com/hadleyso/netid-activate/src/ratelimit"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func postActivateEmail(email string, challengeResponse string) *httptest.ResponseRecorder {
+	form := url.Values{}
+	form.Add("activateEmail", email)
+	if challengeResponse != "" {
+		form.Add("challengeResponse", challengeResponse)
+	}
+	req := httptest.NewRequest("POST", "/activate", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(ActivateEmailPost).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestActivateEmailPostChallenge(t *testing.T) {
+	_, invite := setupTestDBForActivateHandlers(t)
+	viper.Set("CHALLENGE_THRESHOLD", "1/1h")
+	ChallengeVerifier = challenge.Stub{Response: "pass"}
+	RateLimiter = ratelimit.NewMemoryLimiter()
+	t.Cleanup(func() {
+		viper.Set("CHALLENGE_THRESHOLD", nil)
+		ChallengeVerifier = nil
+		RateLimiter = nil
+	})
+
+	// Below threshold, no challenge
+	rr := postActivateEmail(invite.Email, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "One Time Code")
+
+	// Past threshold, challenge shown with email kept
+	rr = postActivateEmail(invite.Email, "")
+	assert.Contains(t, rr.Body.String(), "Please complete the verification")
+	assert.Contains(t, rr.Body.String(), `name="challengeResponse"`)
+	assert.Contains(t, rr.Body.String(), `value="`+invite.Email+`"`)
+	assert.NotContains(t, rr.Body.String(), "One Time Code")
+
+	// Wrong response
+	rr = postActivateEmail(invite.Email, "fail")
+	assert.Contains(t, rr.Body.String(), "Verification failed")
+
+	// Passed challenge
+	rr = postActivateEmail(invite.Email, "pass")
+	assert.Contains(t, rr.Body.String(), "One Time Code")
+}
+
+func TestActivateEmailPostChallengeAlways(t *testing.T) {
+	setupTestDBForActivateHandlers(t)
+	viper.Set("CHALLENGE_THRESHOLD", "0")
+	ChallengeVerifier = challenge.Stub{Response: "pass"}
+	RateLimiter = ratelimit.NewMemoryLimiter()
+	t.Cleanup(func() {
+		viper.Set("CHALLENGE_THRESHOLD", nil)
+		ChallengeVerifier = nil
+		RateLimiter = nil
+	})
+
+	rr := postActivateEmail("<script>@example.com", "")
+	assert.Contains(t, rr.Body.String(), "Please complete the verification")
+	assert.NotContains(t, rr.Body.String(), "<script>@example.com")
+}
+
+func TestActivateEmailPostChallengeDisabled(t *testing.T) {
+	_, invite := setupTestDBForActivateHandlers(t)
+	viper.Set("CHALLENGE_PROVIDER", "")
+	viper.Set("CHALLENGE_THRESHOLD", "0")
+	ChallengeVerifier = nil
+	t.Cleanup(func() {
+		viper.Set("CHALLENGE_THRESHOLD", nil)
+	})
+
+	rr := postActivateEmail(invite.Email, "")
+	assert.Contains(t, rr.Body.String(), "One Time Code")
+}
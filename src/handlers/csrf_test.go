@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/hadleyso/netid-activate/src/auth"
+	"github.com/hadleyso/netid-activate/src/models"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// Router with CSRF protection in front of the form handlers
+func newCSRFTestRouter() *mux.Router {
+	router := mux.NewRouter()
+	router.Use(auth.NewCSRFMiddleware())
+	router.HandleFunc("/", Landing).Methods("GET")
+	router.HandleFunc("/activate", ActivateEmailPost).Methods("POST")
+	router.HandleFunc("/otp", ActivateOTPPost).Methods("POST")
+	router.HandleFunc("/login-name-select", CreateUser).Methods("POST")
+	router.HandleFunc("/invite/", InviteSubmit).Methods("POST")
+	router.HandleFunc("/invite/sent/delete", DeleteInvite).Methods("POST")
+	router.HandleFunc("/admin/sessions", AdminRevokeSessions).Methods("POST")
+	return router
+}
+
+var csrfFieldRegex = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+// Load landing page and return rendered CSRF token and cookie
+func getCSRFToken(t *testing.T, router *mux.Router) (string, *http.Cookie) {
+	t.Helper()
+
+	req := httptest.NewRequest("GET", "/", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	match := csrfFieldRegex.FindStringSubmatch(rr.Body.String())
+	if match == nil {
+		t.Fatalf("CSRF token not rendered in landing form")
+	}
+
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == "IDCLAIM_CSRF" {
+			assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+			return match[1], cookie
+		}
+	}
+	t.Fatalf("IDCLAIM_CSRF cookie not set")
+	return "", nil
+}
+
+func TestCSRF_RejectsMissingToken(t *testing.T) {
+	setupTestDBForActivateHandlers(t)
+	router := newCSRFTestRouter()
+
+	for _, path := range []string{"/activate", "/otp", "/login-name-select", "/invite/", "/invite/sent/delete", "/admin/sessions"} {
+		t.Run(path, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("activateEmail", "test@example.com")
+			req := newRequestWithSession(t, "POST", path, form.Encode(), "application/x-www-form-urlencoded")
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, http.StatusForbidden, rr.Code)
+			assert.Contains(t, rr.Body.String(), "Request Rejected")
+		})
+	}
+}
+
+func TestCSRF_RejectsWrongToken(t *testing.T) {
+	setupTestDBForActivateHandlers(t)
+	router := newCSRFTestRouter()
+	_, cookie := getCSRFToken(t, router)
+
+	form := url.Values{}
+	form.Add("activateEmail", "test@example.com")
+	form.Add(auth.CSRFFieldName, "not-the-token")
+	req := httptest.NewRequest("POST", "/activate", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(cookie)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+}
+
+func TestCSRF_RejectsCrossOrigin(t *testing.T) {
+	setupTestDBForActivateHandlers(t)
+	router := newCSRFTestRouter()
+	token, cookie := getCSRFToken(t, router)
+
+	form := url.Values{}
+	form.Add("activateEmail", "test@example.com")
+	form.Add(auth.CSRFFieldName, token)
+	req := httptest.NewRequest("POST", "/activate", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://evil.example.net")
+	req.AddCookie(cookie)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+}
+
+func TestCSRF_AcceptsValidToken_Activate(t *testing.T) {
+	setupTestDBForActivateHandlers(t)
+	router := newCSRFTestRouter()
+	token, cookie := getCSRFToken(t, router)
+
+	form := url.Values{}
+	form.Add("activateEmail", "test@example.com")
+	form.Add(auth.CSRFFieldName, token)
+	req := httptest.NewRequest("POST", "/activate", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(cookie)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "One Time Code")
+
+	// OTP form carries a token as well
+	assert.Regexp(t, csrfFieldRegex, rr.Body.String())
+}
+
+func TestCSRF_AcceptsValidToken_DeleteInvite(t *testing.T) {
+	database := setupTestDBForAdminHandlers(t)
+	database.Create(&models.Invite{Inviter: "testuser", Email: "delete@example.com"})
+	router := newCSRFTestRouter()
+	token, cookie := getCSRFToken(t, router)
+
+	form := url.Values{}
+	form.Add("email", "delete@example.com")
+	form.Add(auth.CSRFFieldName, token)
+	req := newRequestWithSession(t, "POST", "/invite/sent/delete", form.Encode(), "application/x-www-form-urlencoded")
+	req.AddCookie(cookie)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/invite/sent", rr.Header().Get("Location"))
+}
+
+func TestCSRF_TokenRenderedInSentInvites(t *testing.T) {
+	database := setupTestDBForAdminHandlers(t)
+	database.Create(&models.Invite{Inviter: "testuser", Email: "invite1@example.com"})
+
+	router := mux.NewRouter()
+	router.Use(auth.NewCSRFMiddleware())
+	router.HandleFunc("/invite/sent", GetSent).Methods("GET")
+
+	req := newRequestWithSession(t, "GET", "/invite/sent", "", "")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Regexp(t, csrfFieldRegex, rr.Body.String())
+}
+
+func TestCSRF_SessionCookieSameSite(t *testing.T) {
+	database, invite := setupTestDBForActivateHandlers(t)
+	viper.Set("SESSION_KEY", "a-very-secret-key-for-activate")
+	database.Create(&models.OTP{Code: 222333, InviteID: invite.ID.String()})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ipa/session/login_password", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/ipa/session/json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result": {"count": 0}}`))
+	})
+	setupIDMTestServer(t, mux)
+
+	form := url.Values{}
+	form.Add("activateEmail", invite.Email)
+	form.Add("activateOTP", "222333")
+	req := httptest.NewRequest("POST", "/otp", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(ActivateOTPPost).ServeHTTP(rr, req)
+
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == "IDCLAIM_ACTIVATION" {
+			assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+			return
+		}
+	}
+	t.Fatalf("IDCLAIM_ACTIVATION cookie not set")
+}
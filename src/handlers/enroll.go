@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+	"github.com/hadleyso/netid-activate/src/auth"
+	"github.com/hadleyso/netid-activate/src/models"
+	idm "github.com/hadleyso/netid-activate/src/redhat-idm"
+	"github.com/hadleyso/netid-activate/src/scenes"
+	"github.com/hadleyso/netid-activate/src/totp"
+	qrcode "github.com/skip2/go-qrcode"
+	"github.com/spf13/viper"
+)
+
+// Check if new accounts are offered OTP enrollment, OTP_ENROLL
+func otpEnrollEnabled() bool {
+	return viper.GetBool("OTP_ENROLL")
+}
+
+// Activation session for an account waiting on OTP enrollment
+// Returns false if the session is not enrolling inviteID
+func enrollSession(r *http.Request, inviteID string) (*sessions.Session, string, bool) {
+	if SessionCookieStore == nil {
+		SessionCookieStore = auth.NewSessionStore()
+	}
+	session, _ := SessionCookieStore.Get(r, "IDCLAIM_ACTIVATION")
+
+	loginName, _ := session.Values["enrollLogin"].(string)
+	if loginName == "" || session.Values["inviteID"] != inviteID {
+		return session, "", false
+	}
+	return session, loginName, true
+}
+
+// Show QR code for a new TOTP secret
+func OTPEnrollGet(w http.ResponseWriter, r *http.Request) {
+	inviteID := mux.Vars(r)["inviteID"]
+
+	session, loginName, ok := enrollSession(r, inviteID)
+	if !ok || !otpEnrollEnabled() {
+		http.Redirect(w, r, "/success/"+inviteID, http.StatusSeeOther)
+		return
+	}
+
+	// Keep the secret across reloads so a scanned code stays valid
+	encoded, _ := session.Values["enrollSecret"].(string)
+	if encoded == "" {
+		secret, err := totp.NewSecret()
+		if err != nil {
+			log.Println("Call to NewSecret() in OTPEnrollGet() src/handlers/enroll.go error - " + err.Error())
+			http.Redirect(w, r, "/500", http.StatusSeeOther)
+			return
+		}
+		encoded = totp.EncodeSecret(secret)
+		session.Values["enrollSecret"] = encoded
+		if err := session.Save(r, w); err != nil {
+			log.Println("Call to Save() in OTPEnrollGet() src/handlers/enroll.go error - " + err.Error())
+			http.Redirect(w, r, "/500", http.StatusSeeOther)
+			return
+		}
+	}
+
+	renderOTPEnroll(w, r, inviteID, loginName, encoded, "")
+}
+
+// Verify one code then add the token in IdM
+func OTPEnrollPost(w http.ResponseWriter, r *http.Request) {
+	inviteID := mux.Vars(r)["inviteID"]
+	r.ParseForm()
+
+	session, loginName, ok := enrollSession(r, inviteID)
+	encoded, _ := session.Values["enrollSecret"].(string)
+	if !ok || encoded == "" || !otpEnrollEnabled() {
+		http.Redirect(w, r, "/success/"+inviteID, http.StatusSeeOther)
+		return
+	}
+
+	// Throttle code guessing
+	if !allowRate(w, r, "totp", loginName) {
+		return
+	}
+
+	secret, err := totp.DecodeSecret(encoded)
+	if err != nil {
+		log.Println("Call to DecodeSecret() in OTPEnrollPost() src/handlers/enroll.go error - " + err.Error())
+		http.Redirect(w, r, "/500", http.StatusSeeOther)
+		return
+	}
+
+	if !totp.Validate(secret, r.Form.Get("otpCode"), time.Now()) {
+		renderOTPEnroll(w, r, inviteID, loginName, encoded, "That code did not match, check the time on your device and try the current code.")
+		return
+	}
+
+	if err := idm.EnrollTOTP(loginName, secret, "Enrolled during activation"); err != nil {
+		log.Println("Call to EnrollTOTP() in OTPEnrollPost() src/handlers/enroll.go error - " + err.Error())
+		http.Redirect(w, r, "/500", http.StatusSeeOther)
+		return
+	}
+
+	// Enrollment done
+	delete(session.Values, "enrollLogin")
+	delete(session.Values, "enrollSecret")
+	session.Save(r, w)
+
+	http.Redirect(w, r, "/success/"+inviteID, http.StatusSeeOther)
+}
+
+func renderOTPEnroll(w http.ResponseWriter, r *http.Request, inviteID string, loginName string, encoded string, message string) {
+	secret, err := totp.DecodeSecret(encoded)
+	if err != nil {
+		log.Println("Call to DecodeSecret() in renderOTPEnroll() src/handlers/enroll.go error - " + err.Error())
+		http.Redirect(w, r, "/500", http.StatusSeeOther)
+		return
+	}
+
+	issuer := viper.GetString("TENANT_NAME")
+	if issuer == "" {
+		issuer = viper.GetString("SITE_NAME")
+	}
+	uri := totp.URI(secret, issuer, loginName)
+
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		log.Println("Call to qrcode.Encode() in renderOTPEnroll() src/handlers/enroll.go error - " + err.Error())
+		http.Redirect(w, r, "/500", http.StatusSeeOther)
+		return
+	}
+
+	// Group manual entry key for readability
+	var grouped bytes.Buffer
+	for i, c := range encoded {
+		if i > 0 && i%4 == 0 {
+			grouped.WriteByte(' ')
+		}
+		grouped.WriteRune(c)
+	}
+
+	tmpl := template.Must(template.ParseFS(scenes.TemplateFS, "scenes/otp-enroll.html", "scenes/base.html"))
+	tmpl.ExecuteTemplate(w, "base",
+		struct {
+			InviteID  string
+			LoginName string
+			QRCode    string
+			Secret    string
+			Message   string
+			models.PageBase
+		}{
+			InviteID:  inviteID,
+			LoginName: loginName,
+			QRCode:    base64.StdEncoding.EncodeToString(png),
+			Secret:    grouped.String(),
+			Message:   message,
+			PageBase:  models.NewPageBase("").WithCSRF(r),
+		},
+	)
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+	"github.com/hadleyso/netid-activate/src/ratelimit"
+	"github.com/hadleyso/netid-activate/src/totp"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// Request carrying an IDCLAIM_ACTIVATION session with values
+func newRequestWithEnrollSession(t *testing.T, method string, inviteID string, form url.Values, values map[any]any) *http.Request {
+	req := httptest.NewRequest(method, "/otp-enroll/"+inviteID, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = mux.SetURLVars(req, map[string]string{"inviteID": inviteID})
+
+	if SessionCookieStore == nil {
+		SessionCookieStore = sessions.NewCookieStore([]byte(viper.GetString("SESSION_KEY")))
+	}
+	session, _ := SessionCookieStore.Get(req, "IDCLAIM_ACTIVATION")
+	for k, v := range values {
+		session.Values[k] = v
+	}
+	rr := httptest.NewRecorder()
+	assert.NoError(t, session.Save(req, rr))
+	for _, cookie := range rr.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	return req
+}
+
+func setupOTPEnroll(t *testing.T) *[]string {
+	viper.Set("SESSION_KEY", "a-very-secret-key-for-enroll")
+	viper.Set("OTP_ENROLL", true)
+	RateLimiter = ratelimit.NewMemoryLimiter()
+	t.Cleanup(func() {
+		viper.Set("OTP_ENROLL", nil)
+		RateLimiter = nil
+	})
+
+	var methods []string
+	idmMux := http.NewServeMux()
+	idmMux.HandleFunc("/ipa/session/login_password", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	idmMux.HandleFunc("/ipa/session/json", func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Method string `json:"method"`
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+		methods = append(methods, payload.Method)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result": {"result": {}}}`))
+	})
+	setupIDMTestServer(t, idmMux)
+
+	return &methods
+}
+
+func TestCreateUserRedirectsToOTPEnroll(t *testing.T) {
+	database, invite := setupTestDBForActivateHandlers(t)
+	loginNamesJSON, _ := json.Marshal([]string{"testuser", "tuser"})
+	invite.LoginNames = loginNamesJSON
+	database.Save(&invite)
+	setupOTPEnroll(t)
+	setupIDMPasswordServer(t, "")
+
+	req := newRequestWithActivationSession(t, invite, "testuser", "Correct-Horse-9", "Correct-Horse-9")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(CreateUser).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/otp-enroll/"+invite.ID.String(), rr.Header().Get("Location"))
+
+	// Session now names the account to enroll
+	check := httptest.NewRequest("GET", "/", nil)
+	for _, cookie := range rr.Result().Cookies() {
+		check.AddCookie(cookie)
+	}
+	_, loginName, ok := enrollSession(check, invite.ID.String())
+	assert.True(t, ok)
+	assert.Equal(t, "testuser", loginName)
+}
+
+func TestOTPEnrollWithoutSession(t *testing.T) {
+	setupOTPEnroll(t)
+
+	req := newRequestWithEnrollSession(t, "GET", "invite-1", url.Values{}, nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(OTPEnrollGet).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/success/invite-1", rr.Header().Get("Location"))
+
+	// Session for another invite
+	req = newRequestWithEnrollSession(t, "POST", "invite-1", url.Values{"otpCode": {"123456"}}, map[any]any{
+		"inviteID":     "invite-2",
+		"enrollLogin":  "jdoe",
+		"enrollSecret": totp.EncodeSecret([]byte("12345678901234567890")),
+	})
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(OTPEnrollPost).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/success/invite-1", rr.Header().Get("Location"))
+}
+
+func TestOTPEnrollGet(t *testing.T) {
+	setupOTPEnroll(t)
+
+	req := newRequestWithEnrollSession(t, "GET", "invite-1", url.Values{}, map[any]any{
+		"inviteID":    "invite-1",
+		"enrollLogin": "jdoe",
+	})
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(OTPEnrollGet).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `src="data:image/png;base64,`)
+	assert.Contains(t, rr.Body.String(), "jdoe")
+
+	// Secret saved to the session
+	check := httptest.NewRequest("GET", "/", nil)
+	for _, cookie := range rr.Result().Cookies() {
+		check.AddCookie(cookie)
+	}
+	session, _, _ := enrollSession(check, "invite-1")
+	assert.NotEmpty(t, session.Values["enrollSecret"])
+}
+
+func TestOTPEnrollPost(t *testing.T) {
+	methods := setupOTPEnroll(t)
+	secret := []byte("12345678901234567890")
+	values := map[any]any{
+		"inviteID":     "invite-1",
+		"enrollLogin":  "jdoe",
+		"enrollSecret": totp.EncodeSecret(secret),
+	}
+
+	// Wrong code
+	req := newRequestWithEnrollSession(t, "POST", "invite-1", url.Values{"otpCode": {"000000"}}, values)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(OTPEnrollPost).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "That code did not match")
+	assert.Empty(t, *methods)
+
+	// Current code
+	code := totp.Code(secret, time.Now())
+	req = newRequestWithEnrollSession(t, "POST", "invite-1", url.Values{"otpCode": {code}}, values)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(OTPEnrollPost).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/success/invite-1", rr.Header().Get("Location"))
+	assert.Equal(t, []string{"otptoken_add", "user_mod"}, *methods)
+}
@@ -1,19 +1,22 @@
 package handlers
 
 import (
+	"errors"
 	"log"
 	"net/http"
 	"net/mail"
+	"slices"
 	"strings"
 	"text/template"
 
 	"github.com/hadleyso/netid-activate/src/attribute"
 	"github.com/hadleyso/netid-activate/src/auth"
+	"github.com/hadleyso/netid-activate/src/config"
 	"github.com/hadleyso/netid-activate/src/countries"
 	"github.com/hadleyso/netid-activate/src/db"
+	"github.com/hadleyso/netid-activate/src/directory"
 	"github.com/hadleyso/netid-activate/src/mailer"
 	"github.com/hadleyso/netid-activate/src/models"
-	idm "github.com/hadleyso/netid-activate/src/redhat-idm"
 	"github.com/hadleyso/netid-activate/src/scenes"
 	"github.com/spf13/viper"
 )
@@ -72,12 +75,16 @@ func InviteLandingPage(w http.ResponseWriter, r *http.Request) {
 			Affiliation   map[string]string
 			OptionalGroup map[string]string
 			Countries     []countries.Country
+			RequiredLogin bool
+			InviteFields  []config.InviteField
 			models.PageBase
 		}{
 			Affiliation:   affiliationMap,
 			OptionalGroup: optionalGroup,
 			Countries:     countries.Countries,
-			PageBase:      models.NewPageBase(""),
+			RequiredLogin: canRequireLogin(user),
+			InviteFields:  attribute.InviteFieldsFromConfig(),
+			PageBase:      models.NewPageBase("").WithCSRF(r),
 		},
 	)
 
@@ -91,6 +98,7 @@ func InviteSubmit(w http.ResponseWriter, r *http.Request) {
 	state := strings.TrimSpace(r.Form.Get("state"))
 	country := strings.TrimSpace(r.Form.Get("country"))
 	affiliation := strings.TrimSpace(r.Form.Get("affiliation"))
+	requiredLogin := strings.ToLower(strings.TrimSpace(r.Form.Get("requiredLogin")))
 
 	// Check if email formatted correctly
 	_, err := mail.ParseAddress(email)
@@ -112,8 +120,15 @@ func InviteSubmit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if already invited
-	isInvited, _ := db.EmailValid(email)
+	// Check custom fields
+	fields, problems := attribute.InviteFieldValues(r.Form)
+	if len(problems) > 0 {
+		renderInviteError(w, "Please correct the form: "+strings.Join(problems, ", "))
+		return
+	}
+
+	// Check if already invited, including awaiting approval
+	isInvited, _ := db.EmailInvited(email)
 	if isInvited {
 		tmpl := template.Must(template.ParseFS(scenes.TemplateFS, "scenes/400.html", "scenes/base.html"))
 		tmpl.ExecuteTemplate(w, "base",
@@ -152,8 +167,15 @@ func InviteSubmit(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Groups that hold the invite for approval
+	approvalGroups := attribute.ApprovalRequired(optionalGroups)
+	approvalStatus := ""
+	if len(approvalGroups) > 0 {
+		approvalStatus = models.ApprovalPending
+	}
+
 	// Check if email in directory
-	emailExists, err := idm.CheckEmailExists(email)
+	emailExists, err := directory.CheckEmailExists(email)
 	if err != nil {
 		http.Redirect(w, r, "/500?error=error+in+CheckEmailExists", http.StatusSeeOther)
 		return
@@ -175,24 +197,63 @@ func InviteSubmit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Check required login name is allowed and unused
+	if requiredLogin != "" {
+		if !canRequireLogin(user) {
+			renderInviteError(w, "You are not allowed to set a login name")
+			return
+		}
+
+		problems, err := attribute.CheckCustomLoginName(requiredLogin, "")
+		if err != nil {
+			log.Println("Call to CheckCustomLoginName() in InviteSubmit() src/handlers/invite.go error - " + err.Error())
+			http.Redirect(w, r, "/500?error=error+in+CheckCustomLoginName", http.StatusSeeOther)
+			return
+		}
+		if len(problems) > 0 {
+			renderInviteError(w, "The login name cannot be used: "+strings.Join(problems, ", "))
+			return
+		}
+	}
+
 	// Get inviter info
 	inviter := user.PreferredUsername
 
 	// Add to DB
-	dbSuccess, err := db.HandleInvite(firstName, lastName, email, state, country, affiliation, inviter, optionalGroups)
+	dbSuccess, err := db.HandleInvite(firstName, lastName, email, state, country, affiliation, inviter, optionalGroups, requiredLogin, fields, user.Email, approvalStatus)
+	if errors.Is(err, db.ErrLoginNameReserved) {
+		renderInviteError(w, "The login name cannot be used: This login name is already taken")
+		return
+	}
 	if dbSuccess == false {
 		http.Redirect(w, r, "/500?error=DB+HandleInvite+error", http.StatusSeeOther)
 		return
 	}
 
-	// Send email
-	errMail := mailer.HandleSendInvite(email)
-	if errMail != nil {
-		http.Redirect(w, r, "/500?error=mail+HandleSendInvite+error", http.StatusSeeOther)
-		return
+	// Ask approvers, the invitee is emailed once approved
+	if approvalStatus == models.ApprovalPending {
+		invite, err := db.InviteDetailsEmail(email)
+		if err != nil {
+			http.Redirect(w, r, "/500?error=DB+InviteDetailsEmail+error", http.StatusSeeOther)
+			return
+		}
+		if err := mailer.HandleSendApprovalRequest(invite, approvalGroups); err != nil {
+			http.Redirect(w, r, "/500?error=mail+HandleSendApprovalRequest+error", http.StatusSeeOther)
+			return
+		}
+	} else {
+		// Send email
+		errMail := mailer.HandleSendInvite(email)
+		if errMail != nil {
+			http.Redirect(w, r, "/500?error=mail+HandleSendInvite+error", http.StatusSeeOther)
+			return
+		}
 	}
 
 	successMessage := "Success, " + "an email has been sent to " + firstName + "'s " + email + " inbox."
+	if approvalStatus == models.ApprovalPending {
+		successMessage = "Success, the invite is awaiting approval. An email will be sent to " + firstName + "'s " + email + " inbox once it is approved."
+	}
 
 	tmpl := template.Must(template.ParseFS(scenes.TemplateFS, "scenes/400.html", "scenes/base.html"))
 	tmpl.ExecuteTemplate(w, "base",
@@ -207,3 +268,25 @@ func InviteSubmit(w http.ResponseWriter, r *http.Request) {
 		},
 	)
 }
+
+// Check if user may set a required login name, REQUIRED_LOGIN_GROUP
+func canRequireLogin(user *models.UserInfo) bool {
+	group := viper.GetString("REQUIRED_LOGIN_GROUP")
+	return group != "" && slices.Contains(user.Groups, group)
+}
+
+// Show invite form error
+func renderInviteError(w http.ResponseWriter, message string) {
+	tmpl := template.Must(template.ParseFS(scenes.TemplateFS, "scenes/400.html", "scenes/base.html"))
+	tmpl.ExecuteTemplate(w, "base",
+		struct {
+			Tile    string
+			Message string
+			models.PageBase
+		}{
+			Message:  message,
+			Tile:     "Invite Form",
+			PageBase: models.NewPageBase(""),
+		},
+	)
+}
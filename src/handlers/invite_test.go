@@ -1,10 +1,12 @@
 package handlers
 
 import (
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/hadleyso/netid-activate/src/config"
@@ -21,7 +23,7 @@ func setupTestDBForInviteHandlers(t *testing.T) *gorm.DB {
 	viper.Set("SESSION_KEY", "a-very-secret-key-for-invite")
 
 	database := db.DbConnect()
-	err := database.AutoMigrate(&models.Invite{}, &models.OTP{}, &models.EmailRate{})
+	err := database.AutoMigrate(&models.Invite{}, &models.OTP{}, &models.EmailRate{}, &models.LoginReservation{})
 	if err != nil {
 		t.Fatalf("Failed to migrate database: %v", err)
 	}
@@ -186,3 +188,208 @@ func TestInviteSubmit_InvalidForm(t *testing.T) {
 	assert.Equal(t, http.StatusOK, rr.Code)
 	assert.Contains(t, rr.Body.String(), "Please complete the form fully")
 }
+
+func TestInviteSubmit_RequiredLogin(t *testing.T) {
+	database := setupTestDBForInviteHandlers(t)
+	viper.Set("DEV", "true")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ipa/session/login_password", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/ipa/session/json", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(string(body), `"uid":"takenuser"`) {
+			w.Write([]byte(`{"result": {"count": 1}}`))
+			return
+		}
+		w.Write([]byte(`{"result": {"count": 0}}`))
+	})
+	setupIDMTestServer(t, mux)
+
+	config.C.OptionalGroups = map[string][]config.Group{}
+	defer func() { config.C.OptionalGroups = nil }()
+
+	submit := func(email string, requiredLogin string) string {
+		form := url.Values{}
+		form.Add("firstName", "Test")
+		form.Add("lastName", "User")
+		form.Add("email", email)
+		form.Add("state", "CA")
+		form.Add("country", "USA")
+		form.Add("affiliation", "student")
+		form.Add("requiredLogin", requiredLogin)
+
+		req := newRequestWithSession(t, "POST", "/invite/", form.Encode(), "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(InviteSubmit).ServeHTTP(rr, req)
+		return rr.Body.String()
+	}
+
+	// Inviter not in the privileged group
+	assert.Contains(t, submit("one@example.com", "e12345"), "You are not allowed to set a login name")
+
+	viper.Set("REQUIRED_LOGIN_GROUP", "some-group")
+	defer viper.Set("REQUIRED_LOGIN_GROUP", nil)
+
+	assert.Contains(t, submit("one@example.com", "takenuser"), "This login name is already taken")
+	assert.Contains(t, submit("one@example.com", "admin"), "This login name is reserved")
+
+	assert.Contains(t, submit("one@example.com", " E12345 "), "Success, an email has been sent")
+	var invite models.Invite
+	database.Where("email = ?", "one@example.com").First(&invite)
+	assert.Equal(t, "e12345", invite.RequiredLogin)
+
+	// Another invite cannot claim the same name
+	assert.Contains(t, submit("two@example.com", "e12345"), "This login name is already taken")
+	isInvited, _ := db.EmailValid("two@example.com")
+	assert.False(t, isInvited)
+}
+
+func TestInviteSubmit_InviteFields(t *testing.T) {
+	database := setupTestDBForInviteHandlers(t)
+	viper.Set("DEV", "true")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ipa/session/login_password", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/ipa/session/json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result": {"count": 0}}`))
+	})
+	setupIDMTestServer(t, mux)
+
+	config.C.OptionalGroups = map[string][]config.Group{}
+	config.C.InviteFields = []config.InviteField{
+		{Name: "employee_id", Label: "Employee ID", Required: true, Pattern: "[0-9]{6}"},
+		{Name: "department", Label: "Department", Type: "select", Choices: []string{"Physics", "Chemistry"}},
+	}
+	defer func() {
+		config.C.OptionalGroups = nil
+		config.C.InviteFields = nil
+	}()
+
+	submit := func(email string, employeeID string) string {
+		form := url.Values{}
+		form.Add("firstName", "Test")
+		form.Add("lastName", "User")
+		form.Add("email", email)
+		form.Add("state", "CA")
+		form.Add("country", "USA")
+		form.Add("affiliation", "student")
+		form.Add("field_employee_id", employeeID)
+		form.Add("field_department", "Physics")
+
+		req := newRequestWithSession(t, "POST", "/invite/", form.Encode(), "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(InviteSubmit).ServeHTTP(rr, req)
+		return rr.Body.String()
+	}
+
+	assert.Contains(t, submit("one@example.com", ""), "Employee ID is required")
+	assert.Contains(t, submit("one@example.com", "12ab56"), "Employee ID is not in the expected format")
+	var count int64
+	database.Model(&models.Invite{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	assert.Contains(t, submit("one@example.com", "123456"), "Success, an email has been sent")
+	var invite models.Invite
+	assert.NoError(t, database.Where("email = ?", "one@example.com").First(&invite).Error)
+	assert.Equal(t, map[string]string{"employee_id": "123456", "department": "Physics"}, invite.FieldValues())
+}
+
+func TestInviteLandingPage_InviteFields(t *testing.T) {
+	viper.Set("AFFILIATION", []any{map[string]any{"student": "Student"}})
+	config.C.InviteFields = []config.InviteField{
+		{Name: "employee_id", Label: "Employee ID", Required: true, Pattern: "[0-9]{6}"},
+		{Name: "department", Label: "Department", Type: "select", Choices: []string{"Physics", "Chemistry"}},
+	}
+	defer func() { config.C.InviteFields = nil }()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ipa/session/login_password", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/ipa/session/json", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"result": {"count": 0, "results": []}}`))
+	})
+	setupIDMTestServer(t, mux)
+
+	req := newRequestWithSession(t, "GET", "/invite/", "", "")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(InviteLandingPage).ServeHTTP(rr, req)
+
+	body := rr.Body.String()
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, body, `<input type="text" class="form-control" id="field_employee_id" name="field_employee_id" pattern="[0-9]{6}" required>`)
+	assert.Contains(t, body, `<select class="form-select" id="field_department" name="field_department" >`)
+	assert.Contains(t, body, `<option value="Chemistry">Chemistry</option>`)
+	assert.Contains(t, body, `Department <small class="text-muted">(optional)</small>`)
+}
+
+func TestInviteSubmit_RequiresApproval(t *testing.T) {
+	database := setupTestDBForInviteHandlers(t)
+	viper.Set("DEV", "true")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ipa/session/login_password", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/ipa/session/json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result": {"count": 0}}`))
+	})
+	setupIDMTestServer(t, mux)
+
+	config.C.OptionalGroups = map[string][]config.Group{
+		"prod":    {{RequiredGroup: "some-group", GroupName: "Production", RequiresApproval: true}},
+		"desktop": {{RequiredGroup: "some-group", GroupName: "Desktops"}},
+	}
+	config.C.ApprovalEmail = []string{"prod-approvers@example.com"}
+	defer func() {
+		config.C.OptionalGroups = nil
+		config.C.ApprovalEmail = nil
+	}()
+
+	form := url.Values{}
+	form.Add("firstName", "Test")
+	form.Add("lastName", "User")
+	form.Add("email", "approve@example.com")
+	form.Add("state", "CA")
+	form.Add("country", "USA")
+	form.Add("affiliation", "student")
+	form.Add("prod", "yes")
+
+	req := newRequestWithSession(t, "POST", "/invite/", form.Encode(), "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(InviteSubmit).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "the invite is awaiting approval")
+
+	var invite models.Invite
+	result := database.Where("email = ?", "approve@example.com").First(&invite)
+	assert.NoError(t, result.Error)
+	assert.True(t, invite.AwaitingApproval())
+	assert.Equal(t, "test@example.com", invite.InviterEmail)
+
+	// Cannot activate or be invited again while awaiting approval
+	valid, _ := db.EmailValid("approve@example.com")
+	assert.False(t, valid)
+
+	req = newRequestWithSession(t, "POST", "/invite/", form.Encode(), "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(InviteSubmit).ServeHTTP(rr, req)
+	assert.Contains(t, rr.Body.String(), "User already invited")
+
+	// Groups not requiring approval send the invite
+	form.Set("email", "desktop@example.com")
+	form.Del("prod")
+	form.Add("desktop", "yes")
+	req = newRequestWithSession(t, "POST", "/invite/", form.Encode(), "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(InviteSubmit).ServeHTTP(rr, req)
+	assert.Contains(t, rr.Body.String(), "Success, an email has been sent")
+}
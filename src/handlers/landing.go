@@ -10,5 +10,5 @@ import (
 
 func Landing(w http.ResponseWriter, r *http.Request) {
 	tmpl := template.Must(template.ParseFS(scenes.TemplateFS, "scenes/landing.html", "scenes/base.html"))
-	tmpl.ExecuteTemplate(w, "base", models.NewPageBase(""))
+	tmpl.ExecuteTemplate(w, "base", landingData{PageBase: models.NewPageBase("").WithCSRF(r)})
 }
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/hadleyso/netid-activate/src/attribute"
+	"github.com/hadleyso/netid-activate/src/auth"
+	"github.com/hadleyso/netid-activate/src/db"
+	"github.com/hadleyso/netid-activate/src/models"
+	"github.com/hadleyso/netid-activate/src/ratelimit"
+	"github.com/hadleyso/netid-activate/src/scenes"
+	"github.com/spf13/viper"
+)
+
+// Login name form value when the invitee proposes their own name,
+// never a valid login name
+const customLoginChoice = "*custom"
+
+// Time offered login names are held when LOGIN_NAME_HOLD_MINUTES is not set
+const defaultLoginNameHold = 30 * time.Minute
+
+// Check if invitees may propose their own login name, LOGIN_NAME_CUSTOM
+func customLoginEnabled() bool {
+	return viper.GetBool("LOGIN_NAME_CUSTOM")
+}
+
+// Time login names are held for an invitee, LOGIN_NAME_HOLD_MINUTES
+func loginNameHold() time.Duration {
+	if viper.IsSet("LOGIN_NAME_HOLD_MINUTES") {
+		return time.Duration(viper.GetInt("LOGIN_NAME_HOLD_MINUTES")) * time.Minute
+	}
+	return defaultLoginNameHold
+}
+
+// Available login names for invite, held so other invitees are
+// not offered the same names, and saved as the invite options
+func offerLoginNames(invite models.Invite) ([]string, error) {
+	loginNames, err := attribute.GetLoginOptions(invite)
+	if err != nil {
+		return nil, err
+	}
+
+	loginNames, err = db.ReserveLoginNames(loginNames, invite.ID.String(), loginNameHold())
+	if err != nil {
+		return nil, err
+	}
+
+	return loginNames, db.SetLoginNames(loginNames, invite.ID.String())
+}
+
+// Chosen login name was claimed by someone else, show the form
+// again with fresh options
+func renderLoginNameTaken(w http.ResponseWriter, r *http.Request, invite models.Invite, form loginNameForm) {
+
+	// Inviter set name cannot be replaced
+	if invite.RequiredLogin != "" {
+		tmpl := template.Must(template.ParseFS(scenes.TemplateFS, "scenes/400.html", "scenes/base.html"))
+		tmpl.ExecuteTemplate(w, "base",
+			struct {
+				Tile    string
+				Message string
+				models.PageBase
+			}{
+				Message:  "The login name set by your inviter is no longer available, please contact your inviter",
+				Tile:     "Activation",
+				PageBase: models.NewPageBase(""),
+			},
+		)
+		return
+	}
+
+	loginNames, err := offerLoginNames(invite)
+	if err != nil {
+		log.Println("Call to offerLoginNames() in renderLoginNameTaken() src/handlers/loginname.go error - " + err.Error())
+		http.Redirect(w, r, "/500", http.StatusSeeOther)
+		return
+	}
+
+	message := "This login name was just taken, please choose another"
+	if form.LoginName == customLoginChoice {
+		form.CustomLoginErrors = []string{message}
+	} else {
+		form.LoginName = ""
+		form.LoginNameErrors = []string{message}
+	}
+	renderLoginNameSelect(w, r, invite, loginNames, form)
+}
+
+// Result of a login name availability check
+type loginNameCheck struct {
+	LoginName string   `json:"loginName"`
+	Available bool     `json:"available"`
+	Problems  []string `json:"problems"`
+}
+
+// Check if request has an activation session for invite
+func activationSessionValid(r *http.Request, invite models.Invite) bool {
+	if SessionCookieStore == nil {
+		SessionCookieStore = auth.NewSessionStore()
+	}
+	session, _ := SessionCookieStore.Get(r, "IDCLAIM_ACTIVATION")
+
+	return session.Values["activating"] == true &&
+		session.Values["activateEmail"] == invite.Email &&
+		session.Values["inviteID"] == invite.ID.String()
+}
+
+// Normalize proposed login name as typed
+func customLoginName(r *http.Request) string {
+	return strings.ToLower(strings.TrimSpace(r.Form.Get("customLoginName")))
+}
+
+// Check proposed login name against the naming rules and IdM
+// Returns JSON for the live check on the login name form
+func LoginNameCheck(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	inviteID := r.Form.Get("inviteID")
+
+	if !customLoginEnabled() {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	invite, err := db.InviteDetails(inviteID)
+	if err != nil || !activationSessionValid(r, invite) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	// Inviter set the login name
+	if invite.RequiredLogin != "" {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	loginName := customLoginName(r)
+
+	// Throttle name enumeration, looser than the other endpoints as the
+	// form checks while the invitee types
+	limit := ratelimit.NameCheckLimit()
+	if ok, wait := takeRate(r, "namecheck", invite.Email, limit, limit); !ok {
+		setRetryAfter(w, wait.Seconds())
+		writeLoginNameCheck(w, http.StatusTooManyRequests, loginNameCheck{
+			LoginName: loginName,
+			Problems:  []string{"Too many checks, the name will be checked when you submit"},
+		})
+		return
+	}
+
+	problems, err := attribute.CheckCustomLoginName(loginName, invite.ID.String())
+	if err != nil {
+		log.Println("Call to CheckCustomLoginName() in LoginNameCheck() src/handlers/loginname.go error - " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if problems == nil {
+		problems = []string{}
+	}
+
+	writeLoginNameCheck(w, http.StatusOK, loginNameCheck{
+		LoginName: loginName,
+		Available: len(problems) == 0,
+		Problems:  problems,
+	})
+}
+
+func writeLoginNameCheck(w http.ResponseWriter, status int, check loginNameCheck) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(check)
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/hadleyso/netid-activate/src/models"
+	"github.com/hadleyso/netid-activate/src/ratelimit"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// IdM stub where taken names exist as active or staged users
+func setupIDMLoginNameServer(t *testing.T, taken map[string]string) *idmPasswordCalls {
+	calls := &idmPasswordCalls{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ipa/session/login_password", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/ipa/session/json", func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Method string `json:"method"`
+			Params []any  `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+
+		w.Header().Set("Content-Type", "application/json")
+		switch payload.Method {
+		case "user_find", "stageuser_find":
+			options, _ := payload.Params[1].(map[string]any)
+			uid, _ := options["uid"].(string)
+			if uid != "" && taken[uid] == payload.Method {
+				w.Write([]byte(`{"result": {"count": 1}}`))
+				return
+			}
+			w.Write([]byte(`{"result": {"count": 0}}`))
+		case "user_add":
+			calls.userAdd++
+			if uids, ok := payload.Params[0].([]any); ok && len(uids) > 0 {
+				calls.userUID, _ = uids[0].(string)
+			}
+			w.Write([]byte(`{"result": {"result": {}}}`))
+		default:
+			w.Write([]byte(`{"result": {"count": 0}}`))
+		}
+	})
+	setupIDMTestServer(t, mux)
+
+	return calls
+}
+
+func postLoginNameCheck(t *testing.T, invite models.Invite, name string, withSession bool) *httptest.ResponseRecorder {
+	form := url.Values{}
+	form.Add("inviteID", invite.ID.String())
+	form.Add("customLoginName", name)
+
+	var req *http.Request
+	if withSession {
+		req = newActivationSessionRequest(t, invite, form)
+	} else {
+		req = httptest.NewRequest("POST", "/login-name-check", nil)
+		req.PostForm = form
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(LoginNameCheck).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestLoginNameCheck(t *testing.T) {
+	_, invite := setupTestDBForActivateHandlers(t)
+	setupIDMLoginNameServer(t, map[string]string{"takenuser": "user_find", "stageduser": "stageuser_find"})
+
+	// Disabled by default
+	rr := postLoginNameCheck(t, invite, "freeuser", true)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	viper.Set("LOGIN_NAME_CUSTOM", true)
+	t.Cleanup(func() { viper.Set("LOGIN_NAME_CUSTOM", nil) })
+
+	// Requires the activation session
+	rr = postLoginNameCheck(t, invite, "freeuser", false)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	tests := []struct {
+		name      string
+		available bool
+		problem   string
+	}{
+		{" FreeUser ", true, ""},
+		{"takenuser", false, "already taken"},
+		{"stageduser", false, "already taken"},
+		{"ab", false, "Must be 5 to 18 characters"},
+		{"bad name!", false, "May only use the characters"},
+		{"admin", false, "reserved"},
+	}
+	for _, tt := range tests {
+		rr := postLoginNameCheck(t, invite, tt.name, true)
+		assert.Equal(t, http.StatusOK, rr.Code, tt.name)
+		assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+		var result loginNameCheck
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+		assert.Equal(t, tt.available, result.Available, tt.name)
+		if tt.problem != "" {
+			assert.Contains(t, result.Problems[0], tt.problem, tt.name)
+		} else {
+			assert.Equal(t, "freeuser", result.LoginName)
+			assert.Empty(t, result.Problems)
+		}
+	}
+}
+
+func TestLoginNameCheckRateLimited(t *testing.T) {
+	_, invite := setupTestDBForActivateHandlers(t)
+	setupIDMLoginNameServer(t, map[string]string{})
+	viper.Set("LOGIN_NAME_CUSTOM", true)
+	viper.Set("RATE_LIMIT_EMAIL", "1/1h")
+	viper.Set("RATE_LIMIT_NAMECHECK", "3/1h")
+	RateLimiter = ratelimit.NewMemoryLimiter()
+	t.Cleanup(func() {
+		viper.Set("LOGIN_NAME_CUSTOM", nil)
+		viper.Set("RATE_LIMIT_EMAIL", nil)
+		viper.Set("RATE_LIMIT_NAMECHECK", nil)
+		RateLimiter = nil
+	})
+
+	// Not held to the stricter limit of the other endpoints
+	for i := 0; i < 3; i++ {
+		rr := postLoginNameCheck(t, invite, "freeuser", true)
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+
+	rr := postLoginNameCheck(t, invite, "freeuser", true)
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+
+	var result loginNameCheck
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+	assert.Equal(t, "freeuser", result.LoginName)
+	assert.False(t, result.Available)
+	assert.Contains(t, result.Problems[0], "Too many checks")
+}
+
+func TestCreateUserCustomLoginName(t *testing.T) {
+	database, invite := setupTestDBForActivateHandlers(t)
+	loginNamesJSON, _ := json.Marshal([]string{"testuser", "tuser"})
+	invite.LoginNames = loginNamesJSON
+	database.Save(&invite)
+
+	viper.Set("PASSWORD_MODE", "temporary")
+	viper.Set("LOGIN_NAME_CUSTOM", true)
+	t.Cleanup(func() {
+		viper.Set("PASSWORD_MODE", nil)
+		viper.Set("LOGIN_NAME_CUSTOM", nil)
+	})
+
+	calls := setupIDMLoginNameServer(t, map[string]string{"takenuser": "user_find"})
+
+	post := func(loginName string, custom string) *httptest.ResponseRecorder {
+		form := url.Values{}
+		form.Add("inviteID", invite.ID.String())
+		form.Add("loginname", loginName)
+		form.Add("customLoginName", custom)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(CreateUser).ServeHTTP(rr, newActivationSessionRequest(t, invite, form))
+		return rr
+	}
+
+	// Unvalidated name posted directly is still rejected
+	rr := post("mycustom", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "security flag")
+
+	// Taken name shows the form again with the proposal
+	rr = post(customLoginChoice, "takenuser")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "This login name is already taken")
+	assert.Contains(t, rr.Body.String(), `value="takenuser"`)
+	assert.Contains(t, rr.Body.String(), `value="*custom" checked`)
+	assert.Equal(t, 0, calls.userAdd)
+
+	rr = post(customLoginChoice, "MyCustom")
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, 1, calls.userAdd)
+	assert.Equal(t, "mycustom", calls.userUID)
+}
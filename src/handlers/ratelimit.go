@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/hadleyso/netid-activate/src/models"
+	"github.com/hadleyso/netid-activate/src/proxy"
+	"github.com/hadleyso/netid-activate/src/ratelimit"
+	"github.com/hadleyso/netid-activate/src/scenes"
+)
+
+var RateLimiter *ratelimit.Limiter = nil
+
+// Take a token for action from the client IP bucket, and the email bucket
+// if email is set. Renders 429 page and returns false when exceeded
+func allowRate(w http.ResponseWriter, r *http.Request, action string, email string) bool {
+	ok, wait := takeRate(r, action, email, ratelimit.IPLimit(), ratelimit.EmailLimit())
+	if !ok {
+		rateLimited(w, wait.Seconds())
+	}
+	return ok
+}
+
+// Take a token for action from the client IP bucket, and the email bucket
+// if email is set. Returns false and the time until the next token when
+// exceeded
+//
+// Storage errors are logged and allowed so a DB problem does not lock
+// out every invitee
+func takeRate(r *http.Request, action string, email string, ipLimit ratelimit.Limit, emailLimit ratelimit.Limit) (bool, time.Duration) {
+	if RateLimiter == nil {
+		RateLimiter = ratelimit.New()
+	}
+
+	keys := []string{"ip:" + action + ":" + proxy.ClientIP(r)}
+	limits := []ratelimit.Limit{ipLimit}
+	if email != "" {
+		keys = append(keys, "email:"+action+":"+strings.ToLower(email))
+		limits = append(limits, emailLimit)
+	}
+
+	for i, key := range keys {
+		ok, wait, err := RateLimiter.Allow(key, limits[i])
+		if err != nil {
+			log.Println("Call to Allow() in takeRate() src/handlers/ratelimit.go error - " + err.Error())
+			continue
+		}
+		if !ok {
+			log.Println("takeRate() src/handlers/ratelimit.go rate limited " + key)
+			return false, wait
+		}
+	}
+
+	return true, 0
+}
+
+// Set Retry-After, in whole seconds
+func setRetryAfter(w http.ResponseWriter, retryAfter float64) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter))))
+}
+
+// Show 429 page
+func rateLimited(w http.ResponseWriter, retryAfter float64) {
+	setRetryAfter(w, retryAfter)
+	w.WriteHeader(http.StatusTooManyRequests)
+
+	tmpl := template.Must(template.ParseFS(scenes.TemplateFS, "scenes/400.html", "scenes/base.html"))
+	tmpl.ExecuteTemplate(w, "base",
+		struct {
+			Tile    string
+			Message string
+			models.PageBase
+		}{
+			Message:  "Too many attempts, please wait a while and try again.",
+			Tile:     "Slow Down",
+			PageBase: models.NewPageBase(""),
+		},
+	)
+}
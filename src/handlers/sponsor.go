@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hadleyso/netid-activate/src/db"
+	"github.com/hadleyso/netid-activate/src/models"
+	"github.com/hadleyso/netid-activate/src/scenes"
+	"github.com/hadleyso/netid-activate/src/sponsor"
+)
+
+// Show the sponsored account with options to renew or decline
+func SponsorGet(w http.ResponseWriter, r *http.Request) {
+	sponsorship, ok := sponsorshipFromLink(w, r)
+	if !ok {
+		return
+	}
+
+	renderSponsor(w, r, sponsorship, "")
+}
+
+// Renew or decline the sponsored account
+func SponsorPost(w http.ResponseWriter, r *http.Request) {
+	sponsorship, ok := sponsorshipFromLink(w, r)
+	if !ok {
+		return
+	}
+	if sponsorship.Status != models.SponsorActive {
+		renderSponsor(w, r, sponsorship, "")
+		return
+	}
+
+	r.ParseForm()
+	switch r.Form.Get("action") {
+	case "renew":
+		expiresAt, err := sponsor.Renew(sponsorship)
+		if errors.Is(err, db.ErrSponsorshipChanged) {
+			renderSponsorError(w, "The account was changed since this link was sent, please use the latest email")
+			return
+		}
+		if err != nil {
+			log.Println("Call to Renew() in SponsorPost() src/handlers/sponsor.go error - " + err.Error())
+			http.Redirect(w, r, "/500?error=sponsor+Renew+error", http.StatusSeeOther)
+			return
+		}
+		sponsorship.ExpiresAt = expiresAt
+		renderSponsor(w, r, sponsorship, "Renewed, "+sponsorship.LoginName+" is active until "+expiresAt.Format("Jan 2, 2006")+".")
+
+	case "decline":
+		err := sponsor.Close(sponsorship, models.SponsorDeclined, time.Now())
+		if errors.Is(err, db.ErrSponsorshipChanged) {
+			renderSponsorError(w, "The account was changed since this link was sent, please use the latest email")
+			return
+		}
+		if err != nil {
+			log.Println("Call to Close() in SponsorPost() src/handlers/sponsor.go error - " + err.Error())
+			http.Redirect(w, r, "/500?error=sponsor+Close+error", http.StatusSeeOther)
+			return
+		}
+		sponsorship.Status = models.SponsorDeclined
+		renderSponsor(w, r, sponsorship, "Declined, "+sponsorship.LoginName+" has been disabled.")
+
+	default:
+		renderSponsorError(w, "Please choose to renew or decline the account")
+	}
+}
+
+// Sponsorship for the id and signature in the URL
+// Renders the error page when the link is not valid
+func sponsorshipFromLink(w http.ResponseWriter, r *http.Request) (models.Sponsorship, bool) {
+	vars := mux.Vars(r)
+
+	sponsorship, err := db.GetSponsorship(vars["id"])
+	if err != nil || !sponsor.Valid(sponsorship, vars["signature"]) {
+		renderSponsorError(w, "This link is not valid or has expired, please use the latest email")
+		return models.Sponsorship{}, false
+	}
+	return sponsorship, true
+}
+
+func renderSponsorError(w http.ResponseWriter, message string) {
+	tmpl := template.Must(template.ParseFS(scenes.TemplateFS, "scenes/400.html", "scenes/base.html"))
+	tmpl.ExecuteTemplate(w, "base",
+		struct {
+			Tile    string
+			Message string
+			models.PageBase
+		}{
+			Message:  message,
+			Tile:     "Account Sponsorship",
+			PageBase: models.NewPageBase(""),
+		},
+	)
+}
+
+// Show sponsorship with message
+func renderSponsor(w http.ResponseWriter, r *http.Request, sponsorship models.Sponsorship, message string) {
+	tmpl := template.Must(template.ParseFS(scenes.TemplateFS, "scenes/sponsor.html", "scenes/base.html"))
+	tmpl.ExecuteTemplate(w, "base",
+		struct {
+			Sponsorship models.Sponsorship
+			Active      bool
+			Message     string
+			models.PageBase
+		}{
+			Sponsorship: sponsorship,
+			Active:      sponsorship.Status == models.SponsorActive,
+			Message:     message,
+			PageBase:    models.NewPageBase("").WithCSRF(r),
+		},
+	)
+}
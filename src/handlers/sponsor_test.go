@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hadleyso/netid-activate/src/db"
+	"github.com/hadleyso/netid-activate/src/models"
+	"github.com/hadleyso/netid-activate/src/sponsor"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func createSponsorship(t *testing.T) models.Sponsorship {
+	database := setupTestDBForAdminHandlers(t)
+	if err := database.AutoMigrate(&models.Sponsorship{}); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+	viper.Set("SPONSOR_TERM_DAYS", 90)
+	t.Cleanup(func() { viper.Set("SPONSOR_TERM_DAYS", nil) })
+
+	sponsorship := models.Sponsorship{LoginName: "guest1", FirstName: "Jane", Sponsor: "sponsor1", ExpiresAt: time.Now().Add(24 * time.Hour)}
+	assert.NoError(t, db.CreateSponsorship(&sponsorship))
+	return sponsorship
+}
+
+func sponsorRequest(t *testing.T, method string, sponsorship models.Sponsorship, signature string, action string) *http.Request {
+	form := url.Values{}
+	form.Add("action", action)
+
+	req := newRequestWithSession(t, method, "/sponsor/"+sponsorship.ID.String()+"/"+signature, form.Encode(), "application/x-www-form-urlencoded")
+	return mux.SetURLVars(req, map[string]string{"id": sponsorship.ID.String(), "signature": signature})
+}
+
+func TestSponsorGet(t *testing.T) {
+	sponsorship := createSponsorship(t)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(SponsorGet).ServeHTTP(rr, sponsorRequest(t, "GET", sponsorship, sponsor.Sign(sponsorship), ""))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "guest1")
+	assert.Contains(t, rr.Body.String(), "Renew")
+
+	// Bad signature
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(SponsorGet).ServeHTTP(rr, sponsorRequest(t, "GET", sponsorship, "bad", ""))
+	assert.Contains(t, rr.Body.String(), "not valid or has expired")
+	assert.NotContains(t, rr.Body.String(), "guest1")
+}
+
+func TestSponsorPost_Renew(t *testing.T) {
+	sponsorship := createSponsorship(t)
+	signature := sponsor.Sign(sponsorship)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(SponsorPost).ServeHTTP(rr, sponsorRequest(t, "POST", sponsorship, signature, "renew"))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Renewed, guest1 is active until")
+
+	stored, _ := db.GetSponsorship(sponsorship.ID.String())
+	assert.Equal(t, 1, stored.Renewals)
+	assert.WithinDuration(t, sponsorship.ExpiresAt.Add(90*24*time.Hour), stored.ExpiresAt, time.Second)
+
+	// Link was for the previous term
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(SponsorPost).ServeHTTP(rr, sponsorRequest(t, "POST", sponsorship, signature, "renew"))
+	assert.Contains(t, rr.Body.String(), "not valid or has expired")
+}
+
+func TestSponsorPost_Decline(t *testing.T) {
+	sponsorship := createSponsorship(t)
+
+	var disabled []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/scim+json")
+		if r.Method == "PATCH" {
+			disabled = append(disabled, strings.TrimPrefix(r.URL.Path, "/Users/"))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Write([]byte(`{"totalResults":1,"Resources":[{"id":"scim-guest1"}]}`))
+	}))
+	defer server.Close()
+	viper.Set("DIRECTORY_BACKEND", "scim")
+	viper.Set("SCIM_URL", server.URL)
+	viper.Set("SCIM_TOKEN", "token")
+	defer func() {
+		viper.Set("DIRECTORY_BACKEND", nil)
+		viper.Set("SCIM_URL", nil)
+		viper.Set("SCIM_TOKEN", nil)
+	}()
+
+	signature := sponsor.Sign(sponsorship)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(SponsorPost).ServeHTTP(rr, sponsorRequest(t, "POST", sponsorship, signature, "decline"))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Declined, guest1 has been disabled")
+	assert.Equal(t, []string{"scim-guest1"}, disabled)
+
+	stored, _ := db.GetSponsorship(sponsorship.ID.String())
+	assert.Equal(t, models.SponsorDeclined, stored.Status)
+
+	// Link still shows the closed sponsorship
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(SponsorPost).ServeHTTP(rr, sponsorRequest(t, "POST", sponsorship, signature, "renew"))
+	assert.Contains(t, rr.Body.String(), "no longer sponsored")
+	assert.Len(t, disabled, 1)
+}
+
+func TestSponsorPost_UnknownAction(t *testing.T) {
+	sponsorship := createSponsorship(t)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(SponsorPost).ServeHTTP(rr, sponsorRequest(t, "POST", sponsorship, sponsor.Sign(sponsorship), "delete"))
+	assert.Contains(t, rr.Body.String(), "Please choose to renew or decline")
+}
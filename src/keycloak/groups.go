@@ -0,0 +1,110 @@
+package keycloak
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/hadleyso/netid-activate/src/config"
+	"github.com/hadleyso/netid-activate/src/models"
+)
+
+// Retrieve optional groups the user has permissions for based on the
+// membermanager_user and membermanager_group attributes of the
+// Keycloak group
+func CheckManagedGroup(user *models.UserInfo, groups map[string][]config.Group) ([]config.Group, error) {
+	var filterGroup []config.Group
+
+	var managed []string
+	for cn, group := range groups {
+		for _, g := range group {
+			if g.MemberManager {
+				managed = append(managed, cn)
+				break
+			}
+		}
+	}
+	if len(managed) == 0 {
+		return filterGroup, nil
+	}
+	slices.Sort(managed)
+
+	c, err := connect(SettingsFromConfig())
+	if err != nil {
+		log.Println("CheckManagedGroup() unable to connect() " + err.Error())
+		return nil, err
+	}
+
+	// Groups of the inviter, fetched when a group has manager groups
+	var userGroups []string
+	userGroupsFetched := false
+
+	for _, cn := range managed {
+		g, err := c.groupByPath(cn)
+		if isStatus(err, http.StatusNotFound) {
+			log.Println("CheckManagedGroup() group not found in Keycloak " + cn)
+			continue
+		}
+		if err != nil {
+			log.Println("CheckManagedGroup() unable to groupByPath() " + err.Error())
+			return nil, err
+		}
+
+		optional := groups[cn][0]
+		optional.CN = cn
+
+		// Direct member manager user
+		if slices.Contains(g.Attributes[managerUserAttribute], user.PreferredUsername) {
+			log.Printf("CheckManagedGroup() user %s direct manager %s\n", user.PreferredUsername, optional.CN)
+			filterGroup = append(filterGroup, optional)
+			continue
+		}
+
+		managerGroups := g.Attributes[managerGroupAttribute]
+		if len(managerGroups) == 0 {
+			continue
+		}
+
+		if !userGroupsFetched {
+			userGroups, err = c.userGroups(user.PreferredUsername)
+			if err != nil {
+				log.Println("CheckManagedGroup() unable to userGroups() " + err.Error())
+				return nil, err
+			}
+			userGroupsFetched = true
+		}
+
+		// Check if user is in a group that can manage
+		for _, managerGroup := range managerGroups {
+			if slices.Contains(userGroups, managerGroup) || slices.Contains(userGroups, "/"+strings.TrimPrefix(managerGroup, "/")) {
+				log.Printf("CheckManagedGroup() user %s group manager %s\n", user.PreferredUsername, optional.CN)
+				filterGroup = append(filterGroup, optional)
+				break
+			}
+		}
+	}
+
+	return filterGroup, nil
+}
+
+// Names and paths of the groups a user is a direct member of
+func (c *client) userGroups(username string) ([]string, error) {
+	found, err := c.findUser("username", username)
+	if err != nil || found == nil {
+		return nil, err
+	}
+
+	var memberOf []group
+	query := url.Values{"briefRepresentation": {"true"}}
+	if _, err := c.do("GET", "/users/"+url.PathEscape(found.ID)+"/groups", query, nil, &memberOf); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, g := range memberOf {
+		names = append(names, g.Name, g.Path)
+	}
+	return names, nil
+}
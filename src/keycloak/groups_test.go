@@ -0,0 +1,61 @@
+package keycloak
+
+import (
+	"testing"
+
+	"github.com/hadleyso/netid-activate/src/config"
+	"github.com/hadleyso/netid-activate/src/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckManagedGroup(t *testing.T) {
+	k := setupKeycloakTestServer(t)
+	k.putUser("1", "employee", "employee@example.com")
+	admins := k.putGroup("/admins", nil)
+	k.addMember("1", admins.ID)
+	k.putGroup("/direct", map[string][]string{managerUserAttribute: {"employee"}})
+	k.putGroup("/delegated", map[string][]string{managerGroupAttribute: {"admins"}})
+	k.putGroup("/other", map[string][]string{managerGroupAttribute: {"/helpdesk"}})
+
+	groups := map[string][]config.Group{
+		"direct":    {{GroupName: "Direct", MemberManager: true}},
+		"delegated": {{GroupName: "Delegated", MemberManager: true}},
+		"other":     {{GroupName: "Other", MemberManager: true}},
+		"missing":   {{GroupName: "Missing", MemberManager: true}},
+		"unmanaged": {{GroupName: "Unmanaged", RequiredGroup: "admins"}},
+	}
+
+	managed, err := CheckManagedGroup(&models.UserInfo{PreferredUsername: "employee"}, groups)
+	assert.NoError(t, err)
+	assert.Equal(t, []config.Group{
+		{GroupName: "Delegated", MemberManager: true, CN: "delegated"},
+		{GroupName: "Direct", MemberManager: true, CN: "direct"},
+	}, managed)
+
+	// Not in Keycloak
+	managed, err = CheckManagedGroup(&models.UserInfo{PreferredUsername: "nobody"}, groups)
+	assert.NoError(t, err)
+	assert.Empty(t, managed)
+}
+
+func TestCheckManagedGroupNoneManaged(t *testing.T) {
+	k := setupKeycloakTestServer(t)
+	k.server.Close()
+
+	// Keycloak is not called
+	managed, err := CheckManagedGroup(&models.UserInfo{PreferredUsername: "employee"}, map[string][]config.Group{
+		"unmanaged": {{GroupName: "Unmanaged"}},
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, managed)
+}
+
+func TestCheckManagedGroupUnreachable(t *testing.T) {
+	k := setupKeycloakTestServer(t)
+	k.server.Close()
+
+	_, err := CheckManagedGroup(&models.UserInfo{PreferredUsername: "employee"}, map[string][]config.Group{
+		"direct": {{GroupName: "Direct", MemberManager: true}},
+	})
+	assert.Error(t, err)
+}
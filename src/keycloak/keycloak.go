@@ -0,0 +1,280 @@
+package keycloak
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hadleyso/netid-activate/src/config"
+	idm "github.com/hadleyso/netid-activate/src/redhat-idm"
+	"github.com/spf13/viper"
+)
+
+// Realm already has a user with the login name
+var ErrLoginNameExists = errors.New("login name already exists in Keycloak")
+
+// Realm password policy rejected a password
+var ErrPasswordPolicy = errors.New("password rejected by Keycloak password policy")
+
+// Attributes for user fields when not set in KEYCLOAK_ATTRIBUTES
+// Names, email and login name are Keycloak user fields. Other fields are
+// only stored when mapped, the realm user profile must declare the
+// attribute or allow unmanaged attributes.
+var DefaultAttributes = map[string]string{
+	"gecos":        "",
+	"st":           "",
+	"manager":      "",
+	"pager":        "",
+	"affiliation":  "",
+	"sshpublickey": "",
+}
+
+// Keycloak group attributes listing who may add members, the same
+// names IdM uses for member managers
+const (
+	managerUserAttribute  = "membermanager_user"
+	managerGroupAttribute = "membermanager_group"
+)
+
+// Keycloak Admin API settings
+type Settings struct {
+	URL             string
+	Realm           string
+	ClientID        string
+	ClientSecret    string
+	Attributes      map[string]string
+	RequiredActions []string
+	AddGroups       []string
+}
+
+// Settings from KEYCLOAK_* config, the URL and realm default to the
+// realm OIDC_WELL_KNOWN points at
+func SettingsFromConfig() Settings {
+	settings := Settings{
+		URL:             strings.TrimRight(viper.GetString("KEYCLOAK_URL"), "/"),
+		Realm:           viper.GetString("KEYCLOAK_REALM"),
+		ClientID:        viper.GetString("KEYCLOAK_CLIENT_ID"),
+		ClientSecret:    viper.GetString("KEYCLOAK_CLIENT_SECRET"),
+		Attributes:      map[string]string{},
+		RequiredActions: viper.GetStringSlice("KEYCLOAK_REQUIRED_ACTIONS"),
+	}
+	if settings.URL == "" || settings.Realm == "" {
+		baseURL, realm := realmFromWellKnown(viper.GetString("OIDC_WELL_KNOWN"))
+		if settings.URL == "" {
+			settings.URL = baseURL
+		}
+		if settings.Realm == "" {
+			settings.Realm = realm
+		}
+	}
+	for field, attribute := range DefaultAttributes {
+		settings.Attributes[field] = attribute
+	}
+	for field, attribute := range viper.GetStringMapString("KEYCLOAK_ATTRIBUTES") {
+		settings.Attributes[strings.ToLower(field)] = attribute
+	}
+	for _, group := range strings.Split(viper.GetString("KEYCLOAK_ADD_GROUP"), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			settings.AddGroups = append(settings.AddGroups, group)
+		}
+	}
+	return settings
+}
+
+// Split https://sso.example.com/realms/staff/.well-known/openid-configuration
+// into the server URL and realm, empty if not a Keycloak realm URL
+func realmFromWellKnown(wellKnown string) (string, string) {
+	u, err := url.Parse(wellKnown)
+	if err != nil || u.Host == "" {
+		return "", ""
+	}
+	before, after, found := strings.Cut(u.Path, "/realms/")
+	if !found {
+		return "", ""
+	}
+	realm, _, _ := strings.Cut(after, "/")
+	if realm == "" {
+		return "", ""
+	}
+	u.Path = before
+	u.RawQuery = ""
+	return strings.TrimRight(u.String(), "/"), realm
+}
+
+// Check settings are usable, run at startup
+func (s Settings) Validate() error {
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("KEYCLOAK_URL %q must be an http:// or https:// URL, or OIDC_WELL_KNOWN a Keycloak realm", s.URL)
+	}
+	if s.Realm == "" {
+		return errors.New("KEYCLOAK_REALM must be set, or OIDC_WELL_KNOWN a Keycloak realm")
+	}
+	if s.ClientID == "" || s.ClientSecret == "" {
+		return errors.New("KEYCLOAK_CLIENT_ID and KEYCLOAK_CLIENT_SECRET must be set")
+	}
+	for field := range s.Attributes {
+		isInviteField := slices.ContainsFunc(config.C.InviteFields, func(f config.InviteField) bool { return f.Name == field })
+		if _, ok := DefaultAttributes[field]; !ok && !isInviteField {
+			return fmt.Errorf("KEYCLOAK_ATTRIBUTES has unknown field %s", field)
+		}
+	}
+	for _, action := range s.RequiredActions {
+		if strings.TrimSpace(action) == "" {
+			return errors.New("KEYCLOAK_REQUIRED_ACTIONS has an empty action")
+		}
+	}
+	return nil
+}
+
+// Check Keycloak settings from config
+func CheckConfig() error {
+	return SettingsFromConfig().Validate()
+}
+
+// Error response from the Admin API
+type apiError struct {
+	Status  int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("Keycloak returned %d: %s", e.Status, e.Message)
+}
+
+// Admin API client authenticated as the service account
+type client struct {
+	settings Settings
+	http     *http.Client
+	token    string
+}
+
+func newHTTPClient() (*http.Client, error) {
+	rootCAs, err := idm.RootCAs()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{RootCAs: rootCAs}
+
+	return &http.Client{
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		Timeout:   30 * time.Second,
+	}, nil
+}
+
+// Get a service account token with the client credentials grant
+func connect(s Settings) (*client, error) {
+	httpClient, err := newHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {s.ClientID},
+		"client_secret": {s.ClientSecret},
+	}
+	tokenURL := s.URL + "/realms/" + url.PathEscape(s.Realm) + "/protocol/openid-connect/token"
+	resp, err := httpClient.PostForm(tokenURL, form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, err
+	}
+	if token.AccessToken == "" {
+		return nil, errors.New("Keycloak token response has no access_token")
+	}
+
+	return &client{settings: s, http: httpClient, token: token.AccessToken}, nil
+}
+
+// Call Admin API path below the realm, decoding the JSON response into
+// out when set. Errors for responses other than 2xx are *apiError.
+func (c *client) do(method string, path string, query url.Values, body any, out any) (*http.Response, error) {
+	u := c.settings.URL + "/admin/realms/" + url.PathEscape(c.settings.Realm) + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp, responseError(resp)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp, err
+		}
+	}
+	return resp, nil
+}
+
+// Keycloak reports errors as errorMessage, error_description or error
+func responseError(resp *http.Response) error {
+	var body struct {
+		ErrorMessage     string `json:"errorMessage"`
+		ErrorDescription string `json:"error_description"`
+		Error            string `json:"error"`
+	}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	_ = json.Unmarshal(b, &body)
+
+	message := body.ErrorMessage
+	if message == "" {
+		message = body.ErrorDescription
+	}
+	if message == "" {
+		message = body.Error
+	}
+	if message == "" {
+		message = http.StatusText(resp.StatusCode)
+	}
+	return &apiError{Status: resp.StatusCode, Message: message}
+}
+
+// Check for an Admin API error with status
+func isStatus(err error, status int) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && apiErr.Status == status
+}
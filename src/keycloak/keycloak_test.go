@@ -0,0 +1,91 @@
+package keycloak
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSettingsFromConfigDefaults(t *testing.T) {
+	k := setupKeycloakTestServer(t)
+
+	settings := SettingsFromConfig()
+	assert.Equal(t, k.server.URL, settings.URL)
+	assert.Equal(t, testRealm, settings.Realm)
+	assert.Empty(t, settings.Attributes["st"])
+	assert.Empty(t, settings.RequiredActions)
+	assert.NoError(t, settings.Validate())
+}
+
+func TestSettingsFromConfigOverrides(t *testing.T) {
+	setupKeycloakTestServer(t)
+	viper.Set("KEYCLOAK_ATTRIBUTES", map[string]string{"Affiliation": "affiliation", "sshpublickey": "ssh_public_key"})
+	viper.Set("KEYCLOAK_REQUIRED_ACTIONS", []string{"CONFIGURE_TOTP"})
+	viper.Set("KEYCLOAK_ADD_GROUP", "staff, ,/vpn/users")
+
+	settings := SettingsFromConfig()
+	assert.Equal(t, "affiliation", settings.Attributes["affiliation"])
+	assert.Equal(t, "ssh_public_key", settings.Attributes["sshpublickey"])
+	assert.Equal(t, []string{"CONFIGURE_TOTP"}, settings.RequiredActions)
+	assert.Equal(t, []string{"staff", "/vpn/users"}, settings.AddGroups)
+	assert.NoError(t, settings.Validate())
+}
+
+func TestSettingsFromWellKnown(t *testing.T) {
+	setupKeycloakTestServer(t)
+	viper.Set("KEYCLOAK_URL", nil)
+	viper.Set("KEYCLOAK_REALM", nil)
+	viper.Set("OIDC_WELL_KNOWN", "https://sso.example.org/auth/realms/staff/.well-known/openid-configuration")
+
+	settings := SettingsFromConfig()
+	assert.Equal(t, "https://sso.example.org/auth", settings.URL)
+	assert.Equal(t, "staff", settings.Realm)
+
+	// KEYCLOAK_REALM wins
+	viper.Set("KEYCLOAK_REALM", "guests")
+	assert.Equal(t, "guests", SettingsFromConfig().Realm)
+}
+
+func TestRealmFromWellKnown(t *testing.T) {
+	baseURL, realm := realmFromWellKnown("https://sso.example.org/realms/staff/.well-known/openid-configuration")
+	assert.Equal(t, "https://sso.example.org", baseURL)
+	assert.Equal(t, "staff", realm)
+
+	baseURL, realm = realmFromWellKnown("https://login.example.org/.well-known/openid-configuration")
+	assert.Empty(t, baseURL)
+	assert.Empty(t, realm)
+
+	_, realm = realmFromWellKnown("")
+	assert.Empty(t, realm)
+}
+
+func TestSettingsValidate(t *testing.T) {
+	setupKeycloakTestServer(t)
+
+	tests := []struct {
+		name   string
+		change func(s *Settings)
+	}{
+		{"ldap URL", func(s *Settings) { s.URL = "ldap://sso.example.org" }},
+		{"missing realm", func(s *Settings) { s.Realm = "" }},
+		{"missing client secret", func(s *Settings) { s.ClientSecret = "" }},
+		{"unknown field", func(s *Settings) { s.Attributes["title"] = "title" }},
+		{"empty required action", func(s *Settings) { s.RequiredActions = []string{" "} }},
+	}
+	for _, tt := range tests {
+		settings := SettingsFromConfig()
+		tt.change(&settings)
+		assert.Error(t, settings.Validate(), tt.name)
+	}
+}
+
+func TestConnectBadCredentials(t *testing.T) {
+	setupKeycloakTestServer(t)
+	viper.Set("KEYCLOAK_CLIENT_SECRET", "wrong")
+
+	_, err := connect(SettingsFromConfig())
+	assert.True(t, isStatus(err, http.StatusUnauthorized))
+	assert.ErrorContains(t, err, "Invalid client credentials")
+}
@@ -0,0 +1,176 @@
+package keycloak
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/hadleyso/netid-activate/src/countries"
+	"github.com/hadleyso/netid-activate/src/models"
+	"github.com/spf13/viper"
+)
+
+// Keycloak GroupRepresentation fields used here
+type group struct {
+	ID         string              `json:"id"`
+	Name       string              `json:"name"`
+	Path       string              `json:"path"`
+	Attributes map[string][]string `json:"attributes"`
+}
+
+// Creates users in the realm for directory.HandleMakeUser
+type Maker struct {
+	c *client
+}
+
+func NewMaker() (*Maker, error) {
+	c, err := connect(SettingsFromConfig())
+	if err != nil {
+		log.Println("NewMaker() unable to connect() " + err.Error())
+		return nil, err
+	}
+	return &Maker{c: c}, nil
+}
+
+// Groups from KEYCLOAK_ADD_GROUP every user joins
+func (m *Maker) DefaultGroups() []string {
+	return m.c.settings.AddGroups
+}
+
+// Create user, returns the Keycloak user ID
+// A temporary password must be changed on first login
+func (m *Maker) MakeUser(loginName string, invite models.Invite, password string, temporary bool, sshKeys []string) (string, error) {
+	return m.c.makeUser(loginName, invite, credential{Type: "password", Value: password, Temporary: temporary}, sshKeys)
+}
+
+func (m *Maker) AddUserGroups(userID string, groups []string) error {
+	return m.c.addUserGroups(userID, groups)
+}
+
+// Nothing to release, the service account token expires on its own
+func (m *Maker) Close() {}
+
+// Create user with the mapped attributes and required actions
+// Returns the Keycloak user ID
+func (c *client) makeUser(loginName string, invite models.Invite, password credential, sshKeys []string) (string, error) {
+	countryName, err := countries.GetNameFromAlpha3(invite.Country)
+	if err != nil {
+		return "", err
+	}
+	alpha2, err := countries.GetAlpha2FromAlpha3(invite.Country)
+	if err != nil {
+		return "", err
+	}
+
+	gecos := invite.FirstName + " " + invite.LastName
+	if viper.GetString("IDM_GECOS") == "true" {
+		gecos = gecos + " (" + invite.Country + " " + invite.Affiliation + ")"
+	}
+
+	values := map[string][]string{
+		"gecos":        {gecos},
+		"st":           {invite.State + ", " + countryName},
+		"manager":      {invite.Inviter},
+		"pager":        {alpha2},
+		"affiliation":  {invite.Affiliation},
+		"sshpublickey": sshKeys,
+	}
+	if invite.Inviter == "" {
+		delete(values, "manager")
+	}
+	// INVITE_FIELDS are stored when mapped
+	for field, value := range invite.FieldValues() {
+		if value != "" {
+			values[field] = []string{value}
+		}
+	}
+	if len(sshKeys) > 0 && c.settings.Attributes["sshpublickey"] == "" {
+		log.Println("makeUser() SSH keys not stored, KEYCLOAK_ATTRIBUTES has no sshpublickey attribute")
+	}
+
+	attributes := map[string][]string{}
+	for field, value := range values {
+		if attribute := c.settings.Attributes[field]; attribute != "" && len(value) > 0 {
+			attributes[attribute] = value
+		}
+	}
+
+	// The invitee proved the email with the one time code
+	newUser := user{
+		Username:        loginName,
+		Email:           invite.Email,
+		FirstName:       invite.FirstName,
+		LastName:        invite.LastName,
+		Enabled:         true,
+		EmailVerified:   true,
+		Attributes:      attributes,
+		RequiredActions: c.settings.RequiredActions,
+		Credentials:     []credential{password},
+	}
+
+	resp, err := c.do("POST", "/users", nil, newUser, nil)
+	if isStatus(err, http.StatusConflict) && !strings.Contains(strings.ToLower(err.Error()), "email") {
+		return "", fmt.Errorf("%w: %v", ErrLoginNameExists, err)
+	}
+	if isPasswordPolicyError(err) {
+		return "", fmt.Errorf("%w: %v", ErrPasswordPolicy, err)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Location is .../users/<id>
+	var userID string
+	if location, err := url.Parse(resp.Header.Get("Location")); err == nil {
+		userID = path.Base(location.Path)
+	}
+	switch userID {
+	case "", ".", "/", "users":
+		return "", fmt.Errorf("Keycloak user created without a usable Location %q", resp.Header.Get("Location"))
+	}
+	return userID, nil
+}
+
+// Add user to groups by name or path
+// Groups are tried in turn, returns the last error
+func (c *client) addUserGroups(userID string, groups []string) error {
+	var lastErr error
+	for _, name := range groups {
+		if name == "" {
+			continue
+		}
+		g, err := c.groupByPath(name)
+		if err == nil {
+			_, err = c.do("PUT", "/users/"+url.PathEscape(userID)+"/groups/"+url.PathEscape(g.ID), nil, nil, nil)
+		}
+		if err != nil {
+			log.Println("addUserGroups() unable to add to " + name + " " + err.Error())
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// Find group by path such as /staff/vpn, a name without a leading
+// slash is a top level group
+func (c *client) groupByPath(name string) (group, error) {
+	segments := strings.Split(strings.TrimPrefix(name, "/"), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	var g group
+	_, err := c.do("GET", "/group-by-path/"+strings.Join(segments, "/"), nil, nil, &g)
+	return g, err
+}
+
+// Check create error for password policy rejection
+func isPasswordPolicyError(err error) bool {
+	if !isStatus(err, http.StatusBadRequest) {
+		return false
+	}
+	return strings.Contains(strings.ToLower(err.Error()), "password")
+}
@@ -0,0 +1,144 @@
+package keycloak
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/hadleyso/netid-activate/src/models"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func testInvite() models.Invite {
+	return models.Invite{
+		FirstName:   "Jane",
+		LastName:    "Doe",
+		Email:       "jane@example.com",
+		Country:     "USA",
+		Affiliation: "Staff",
+		State:       "GA",
+		Inviter:     "admin",
+	}
+}
+
+func newTestMaker(t *testing.T) *Maker {
+	m, err := NewMaker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(m.Close)
+	return m
+}
+
+func TestMakeUser(t *testing.T) {
+	k := setupKeycloakTestServer(t)
+	k.putGroup("/staff", nil)
+	k.putGroup("/vpn", nil)
+	k.putGroup("/vpn/users", nil)
+	viper.Set("KEYCLOAK_ADD_GROUP", "/vpn/users")
+	viper.Set("KEYCLOAK_REQUIRED_ACTIONS", []string{"CONFIGURE_TOTP"})
+	m := newTestMaker(t)
+	assert.Equal(t, []string{"/vpn/users"}, m.DefaultGroups())
+
+	userID, err := m.MakeUser("janedoe", testInvite(), "Correct-Horse-9", false, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, m.AddUserGroups(userID, []string{"staff", "/vpn/users"}))
+
+	u := k.user("janedoe")
+	if assert.NotNil(t, u) {
+		assert.Equal(t, u.ID, userID)
+		assert.Equal(t, "jane@example.com", u.Email)
+		assert.Equal(t, "Jane", u.FirstName)
+		assert.Equal(t, "Doe", u.LastName)
+		assert.True(t, u.Enabled)
+		assert.True(t, u.EmailVerified)
+		assert.Empty(t, u.Attributes)
+		assert.Equal(t, []string{"CONFIGURE_TOTP"}, u.RequiredActions)
+		assert.Equal(t, []credential{{Type: "password", Value: "Correct-Horse-9"}}, u.Credentials)
+		assert.ElementsMatch(t, []string{"/staff", "/vpn/users"}, k.memberOf(u.ID))
+	}
+}
+
+func TestMakeUserAttributeMapping(t *testing.T) {
+	k := setupKeycloakTestServer(t)
+	viper.Set("KEYCLOAK_ATTRIBUTES", map[string]string{
+		"gecos":        "gecos",
+		"st":           "state",
+		"manager":      "sponsor",
+		"pager":        "country",
+		"affiliation":  "affiliation",
+		"sshpublickey": "ssh_public_key",
+	})
+	viper.Set("IDM_GECOS", "true")
+
+	keys := []string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIEXAMPLE jane@laptop"}
+	_, err := newTestMaker(t).MakeUser("janedoe", testInvite(), "Correct-Horse-9", false, keys)
+	assert.NoError(t, err)
+
+	u := k.user("janedoe")
+	if assert.NotNil(t, u) {
+		assert.Equal(t, map[string][]string{
+			"gecos":          {"Jane Doe (USA Staff)"},
+			"state":          {"GA, United States of America"},
+			"sponsor":        {"admin"},
+			"country":        {"US"},
+			"affiliation":    {"Staff"},
+			"ssh_public_key": keys,
+		}, u.Attributes)
+	}
+}
+
+func TestMakeUserTemporaryPassword(t *testing.T) {
+	k := setupKeycloakTestServer(t)
+
+	_, err := newTestMaker(t).MakeUser("janedoe", testInvite(), "Temp-Horse-9", true, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []credential{{Type: "password", Value: "Temp-Horse-9", Temporary: true}}, k.user("janedoe").Credentials)
+}
+
+func TestMakeUserExists(t *testing.T) {
+	k := setupKeycloakTestServer(t)
+	k.putUser("1", "janedoe", "other@example.com")
+	m := newTestMaker(t)
+
+	_, err := m.MakeUser("janedoe", testInvite(), "Correct-Horse-9", false, nil)
+	assert.True(t, errors.Is(err, ErrLoginNameExists))
+
+	// Email conflicts are not a taken login name
+	_, err = m.MakeUser("jdoe", testInvite(), "Correct-Horse-9", false, nil)
+	assert.NoError(t, err)
+	_, err = m.MakeUser("jane.doe", testInvite(), "Correct-Horse-9", false, nil)
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrLoginNameExists))
+}
+
+func TestMakeUserPasswordPolicy(t *testing.T) {
+	k := setupKeycloakTestServer(t)
+	k.createStatus = http.StatusBadRequest
+	k.createMessage = "Password policy not met"
+
+	_, err := newTestMaker(t).MakeUser("janedoe", testInvite(), "password", false, nil)
+	assert.True(t, errors.Is(err, ErrPasswordPolicy))
+	assert.Equal(t, 1, k.creates)
+}
+
+func TestAddUserGroupsMissingGroup(t *testing.T) {
+	k := setupKeycloakTestServer(t)
+	k.putGroup("/staff", nil)
+	m := newTestMaker(t)
+
+	userID, err := m.MakeUser("janedoe", testInvite(), "Correct-Horse-9", false, nil)
+	assert.NoError(t, err)
+
+	// Later groups are still tried
+	assert.Error(t, m.AddUserGroups(userID, []string{"nosuchgroup", "staff"}))
+	assert.Equal(t, []string{"/staff"}, k.memberOf(userID))
+}
+
+func TestIsPasswordPolicyError(t *testing.T) {
+	assert.True(t, isPasswordPolicyError(&apiError{Status: http.StatusBadRequest, Message: "invalidPasswordMinLengthMessage"}))
+	assert.False(t, isPasswordPolicyError(&apiError{Status: http.StatusBadRequest, Message: "Invalid email"}))
+	assert.False(t, isPasswordPolicyError(&apiError{Status: http.StatusForbidden, Message: "password"}))
+	assert.False(t, isPasswordPolicyError(nil))
+}
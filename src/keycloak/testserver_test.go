@@ -0,0 +1,265 @@
+package keycloak
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	testRealm        = "staff"
+	testClientID     = "netid-activate"
+	testClientSecret = "secret"
+	testToken        = "test-token"
+)
+
+// Keycloak stub with the client credentials grant and the Admin API
+// calls used here
+type testKeycloak struct {
+	mu     sync.Mutex
+	users  map[string]*user
+	groups map[string]*group
+	// Group IDs by user ID
+	members map[string][]string
+	policy  string
+	// Searches match substrings as on servers without exact
+	ignoreExact bool
+	// Status and message returned for user creates, if set
+	createStatus  int
+	createMessage string
+	creates       int
+	server        *httptest.Server
+}
+
+// Start stub Keycloak and set KEYCLOAK_* config to use it
+func setupKeycloakTestServer(t *testing.T) *testKeycloak {
+	t.Helper()
+
+	k := &testKeycloak{users: map[string]*user{}, groups: map[string]*group{}, members: map[string][]string{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /realms/staff/protocol/openid-connect/token", k.token)
+	mux.HandleFunc("GET /admin/realms/staff", k.admin(k.realm))
+	mux.HandleFunc("GET /admin/realms/staff/users", k.admin(k.findUsers))
+	mux.HandleFunc("POST /admin/realms/staff/users", k.admin(k.createUser))
+	mux.HandleFunc("PUT /admin/realms/staff/users/{id}", k.admin(k.updateUser))
+	mux.HandleFunc("GET /admin/realms/staff/users/{id}/groups", k.admin(k.userGroups))
+	mux.HandleFunc("PUT /admin/realms/staff/users/{id}/groups/{group}", k.admin(k.joinGroup))
+	mux.HandleFunc("GET /admin/realms/staff/group-by-path/{path...}", k.admin(k.groupByPath))
+	k.server = httptest.NewServer(mux)
+
+	settings := map[string]any{
+		"KEYCLOAK_URL":           k.server.URL,
+		"KEYCLOAK_REALM":         testRealm,
+		"KEYCLOAK_CLIENT_ID":     testClientID,
+		"KEYCLOAK_CLIENT_SECRET": testClientSecret,
+	}
+	for key, value := range settings {
+		viper.Set(key, value)
+	}
+	t.Cleanup(func() {
+		k.server.Close()
+		for key := range settings {
+			viper.Set(key, nil)
+		}
+		for _, key := range []string{"KEYCLOAK_ATTRIBUTES", "KEYCLOAK_REQUIRED_ACTIONS", "KEYCLOAK_ADD_GROUP", "OIDC_WELL_KNOWN", "IDM_GECOS"} {
+			viper.Set(key, nil)
+		}
+	})
+	return k
+}
+
+// Add user with ID, usernames and emails are stored lower case
+func (k *testKeycloak) putUser(id string, username string, email string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.users[id] = &user{ID: id, Username: strings.ToLower(username), Email: strings.ToLower(email), Enabled: true}
+}
+
+// Add group at path with attributes
+func (k *testKeycloak) putGroup(path string, attributes map[string][]string) *group {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	g := &group{ID: fmt.Sprintf("group-%d", len(k.groups)+1), Name: path[strings.LastIndex(path, "/")+1:], Path: path, Attributes: attributes}
+	k.groups[path] = g
+	return g
+}
+
+func (k *testKeycloak) addMember(userID string, groupID string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.members[userID] = append(k.members[userID], groupID)
+}
+
+// User with username, nil if none
+func (k *testKeycloak) user(username string) *user {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, u := range k.users {
+		if u.Username == username {
+			return u
+		}
+	}
+	return nil
+}
+
+// Paths of the groups of user ID
+func (k *testKeycloak) memberOf(userID string) []string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	var paths []string
+	for _, id := range k.members[userID] {
+		for _, g := range k.groups {
+			if g.ID == id {
+				paths = append(paths, g.Path)
+			}
+		}
+	}
+	return paths
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (k *testKeycloak) token(w http.ResponseWriter, r *http.Request) {
+	if r.PostFormValue("grant_type") != "client_credentials" || r.PostFormValue("client_id") != testClientID || r.PostFormValue("client_secret") != testClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized_client", "error_description": "Invalid client credentials"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"access_token": testToken, "token_type": "Bearer", "expires_in": 300})
+}
+
+// Require the service account token
+func (k *testKeycloak) admin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testToken {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "HTTP 401 Unauthorized"})
+			return
+		}
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		next(w, r)
+	}
+}
+
+func (k *testKeycloak) realm(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"realm": testRealm, "passwordPolicy": k.policy})
+}
+
+// Username and email searches are substring matches unless exact is set
+func (k *testKeycloak) findUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	exact := query.Get("exact") == "true" && !k.ignoreExact
+	matches := func(stored string, search string) bool {
+		search = strings.ToLower(search)
+		if exact {
+			return stored == search
+		}
+		return strings.Contains(stored, search)
+	}
+
+	found := []user{}
+	for _, u := range k.users {
+		if query.Has("username") && !matches(u.Username, query.Get("username")) {
+			continue
+		}
+		if query.Has("email") && !matches(u.Email, query.Get("email")) {
+			continue
+		}
+		found = append(found, user{ID: u.ID, Username: u.Username, Email: u.Email, Enabled: u.Enabled})
+	}
+	writeJSON(w, http.StatusOK, found)
+}
+
+func (k *testKeycloak) createUser(w http.ResponseWriter, r *http.Request) {
+	k.creates++
+
+	var u user
+	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"errorMessage": err.Error()})
+		return
+	}
+	if k.createStatus != 0 {
+		writeJSON(w, k.createStatus, map[string]string{"errorMessage": k.createMessage})
+		return
+	}
+	u.Username = strings.ToLower(u.Username)
+	u.Email = strings.ToLower(u.Email)
+	for _, existing := range k.users {
+		if existing.Username == u.Username {
+			writeJSON(w, http.StatusConflict, map[string]string{"errorMessage": "User exists with same username"})
+			return
+		}
+		if existing.Email == u.Email {
+			writeJSON(w, http.StatusConflict, map[string]string{"errorMessage": "User exists with same email"})
+			return
+		}
+	}
+
+	u.ID = fmt.Sprintf("user-%d", len(k.users)+1)
+	k.users[u.ID] = &u
+	w.Header().Set("Location", k.server.URL+"/admin/realms/staff/users/"+u.ID)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// Partial update, only enabled is applied
+func (k *testKeycloak) updateUser(w http.ResponseWriter, r *http.Request) {
+	u, ok := k.users[r.PathValue("id")]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "User not found"})
+		return
+	}
+	var update map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"errorMessage": err.Error()})
+		return
+	}
+	if enabled, ok := update["enabled"].(bool); ok {
+		u.Enabled = enabled
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (k *testKeycloak) userGroups(w http.ResponseWriter, r *http.Request) {
+	if _, ok := k.users[r.PathValue("id")]; !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "User not found"})
+		return
+	}
+	memberOf := []group{}
+	for _, id := range k.members[r.PathValue("id")] {
+		for _, g := range k.groups {
+			if g.ID == id {
+				memberOf = append(memberOf, group{ID: g.ID, Name: g.Name, Path: g.Path})
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, memberOf)
+}
+
+func (k *testKeycloak) joinGroup(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, ok := k.users[id]; !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "User not found"})
+		return
+	}
+	k.members[id] = append(k.members[id], r.PathValue("group"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (k *testKeycloak) groupByPath(w http.ResponseWriter, r *http.Request) {
+	g, ok := k.groups["/"+r.PathValue("path")]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Group path does not exist"})
+		return
+	}
+	writeJSON(w, http.StatusOK, g)
+}
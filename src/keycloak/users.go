@@ -0,0 +1,169 @@
+package keycloak
+
+import (
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+
+	idm "github.com/hadleyso/netid-activate/src/redhat-idm"
+)
+
+// Keycloak UserRepresentation fields used here
+type user struct {
+	ID              string              `json:"id,omitempty"`
+	Username        string              `json:"username"`
+	Email           string              `json:"email,omitempty"`
+	FirstName       string              `json:"firstName,omitempty"`
+	LastName        string              `json:"lastName,omitempty"`
+	Enabled         bool                `json:"enabled"`
+	EmailVerified   bool                `json:"emailVerified"`
+	Attributes      map[string][]string `json:"attributes,omitempty"`
+	RequiredActions []string            `json:"requiredActions,omitempty"`
+	Credentials     []credential        `json:"credentials,omitempty"`
+}
+
+type credential struct {
+	Type      string `json:"type"`
+	Value     string `json:"value"`
+	Temporary bool   `json:"temporary"`
+}
+
+// Checks if login names exist in the realm
+// Returns names that don't exist
+func CheckUsernamesExists(loginNames []string) ([]string, error) {
+	okUsername := []string{}
+
+	c, err := connect(SettingsFromConfig())
+	if err != nil {
+		log.Println("CheckUsernamesExists() unable to connect() " + err.Error())
+		return okUsername, err
+	}
+
+	for _, loginName := range loginNames {
+		found, err := c.findUser("username", loginName)
+		if err != nil {
+			log.Println("CheckUsernamesExists() unable to findUser() " + err.Error())
+			return okUsername, err
+		}
+		if found == nil {
+			okUsername = append(okUsername, loginName)
+		}
+	}
+
+	return okUsername, nil
+}
+
+// Checks if login name is unused
+func LoginNameAvailable(loginName string) (bool, error) {
+	free, err := CheckUsernamesExists([]string{loginName})
+	if err != nil {
+		return false, err
+	}
+	return len(free) == 1, nil
+}
+
+// Checks if a user with email exists in the realm
+func CheckEmailExists(email string) (bool, error) {
+	c, err := connect(SettingsFromConfig())
+	if err != nil {
+		log.Println("CheckEmailExists() unable to connect() " + err.Error())
+		return false, err
+	}
+
+	found, err := c.findUser("email", email)
+	if err != nil {
+		log.Println("CheckEmailExists() unable to findUser() " + err.Error())
+		return false, err
+	}
+	return found != nil, nil
+}
+
+// Disable the user with login name so it can no longer log in
+// A user already deleted is not an error
+func DisableUser(loginName string) error {
+	c, err := connect(SettingsFromConfig())
+	if err != nil {
+		log.Println("DisableUser() unable to connect() " + err.Error())
+		return err
+	}
+
+	found, err := c.findUser("username", loginName)
+	if err != nil {
+		log.Println("DisableUser() unable to findUser() " + err.Error())
+		return err
+	}
+	if found == nil {
+		log.Println("DisableUser() user " + loginName + " not found, nothing to disable")
+		return nil
+	}
+
+	// Partial representation, other fields are left as they are
+	_, err = c.do("PUT", "/users/"+url.PathEscape(found.ID), nil, map[string]any{"enabled": false}, nil)
+	return err
+}
+
+// Find user with username or email equal to value, nil if none
+// Older servers ignore exact and return partial matches, so results are
+// compared again ignoring case as Keycloak stores both lower case
+func (c *client) findUser(field string, value string) (*user, error) {
+	query := url.Values{
+		field:                 {value},
+		"exact":               {"true"},
+		"briefRepresentation": {"true"},
+	}
+	var users []user
+	if _, err := c.do("GET", "/users", query, nil, &users); err != nil {
+		return nil, err
+	}
+
+	for i, u := range users {
+		found := u.Username
+		if field == "email" {
+			found = u.Email
+		}
+		if strings.EqualFold(found, value) {
+			return &users[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// Password policy of the realm, needs the view-realm role
+func PasswordPolicy() (idm.PasswordPolicy, error) {
+	c, err := connect(SettingsFromConfig())
+	if err != nil {
+		log.Println("PasswordPolicy() unable to connect() " + err.Error())
+		return idm.PasswordPolicy{}, err
+	}
+
+	var realm struct {
+		PasswordPolicy string `json:"passwordPolicy"`
+	}
+	if _, err := c.do("GET", "", nil, nil, &realm); err != nil {
+		log.Println("PasswordPolicy() unable to get realm " + err.Error())
+		return idm.PasswordPolicy{}, err
+	}
+	return parsePasswordPolicy(realm.PasswordPolicy), nil
+}
+
+// Parse a realm policy such as "length(12) and digits(1) and notUsername(undefined)"
+// Each character class policy counts as one required class. Other
+// policies, including the username checks, are left to Keycloak.
+func parsePasswordPolicy(policy string) idm.PasswordPolicy {
+	var p idm.PasswordPolicy
+	for _, rule := range strings.Split(policy, " and ") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(rule), "(")
+		n, _ := strconv.Atoi(strings.TrimSuffix(arg, ")"))
+
+		switch name {
+		case "length":
+			p.MinLength = n
+		case "passwordHistory":
+			p.History = n
+		case "lowerCase", "upperCase", "digits", "specialChars":
+			p.MinClasses++
+		}
+	}
+	return p
+}
@@ -0,0 +1,85 @@
+package keycloak
+
+import (
+	"testing"
+
+	idm "github.com/hadleyso/netid-activate/src/redhat-idm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckUsernamesExists(t *testing.T) {
+	k := setupKeycloakTestServer(t)
+	k.putUser("1", "jdoe", "jdoe@example.com")
+	k.putUser("2", "jdoe2", "jdoe2@example.com")
+
+	// jd matches as a substring only
+	free, err := CheckUsernamesExists([]string{"JDoe", "jd", "johndoe"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"jd", "johndoe"}, free)
+
+	// Substring results are filtered
+	k.ignoreExact = true
+	free, err = CheckUsernamesExists([]string{"jdoe", "jd"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"jd"}, free)
+}
+
+func TestLoginNameAvailable(t *testing.T) {
+	k := setupKeycloakTestServer(t)
+	k.putUser("1", "jdoe", "jdoe@example.com")
+
+	available, err := LoginNameAvailable("jdoe")
+	assert.NoError(t, err)
+	assert.False(t, available)
+
+	available, err = LoginNameAvailable("janedoe")
+	assert.NoError(t, err)
+	assert.True(t, available)
+}
+
+func TestCheckEmailExists(t *testing.T) {
+	k := setupKeycloakTestServer(t)
+	k.putUser("1", "jdoe", "JDoe@Example.com")
+
+	exists, err := CheckEmailExists("jdoe@example.com")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	exists, err = CheckEmailExists("doe@example.com")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestCheckEmailExistsUnreachable(t *testing.T) {
+	k := setupKeycloakTestServer(t)
+	k.server.Close()
+
+	_, err := CheckEmailExists("jdoe@example.com")
+	assert.Error(t, err)
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	k := setupKeycloakTestServer(t)
+	k.policy = "length(12) and digits(1) and upperCase(1) and notUsername(undefined) and passwordHistory(3)"
+
+	policy, err := PasswordPolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, idm.PasswordPolicy{MinLength: 12, MinClasses: 2, History: 3}, policy)
+}
+
+func TestParsePasswordPolicy(t *testing.T) {
+	assert.Equal(t, idm.PasswordPolicy{}, parsePasswordPolicy(""))
+	assert.Equal(t, idm.PasswordPolicy{MinLength: 8, MinClasses: 4},
+		parsePasswordPolicy("lowerCase(1) and upperCase(1) and digits(2) and specialChars(1) and length(8) and maxLength(64)"))
+}
+
+func TestDisableUser(t *testing.T) {
+	k := setupKeycloakTestServer(t)
+	k.putUser("user-1", "jdoe", "jdoe@example.com")
+
+	assert.NoError(t, DisableUser("jdoe"))
+	assert.False(t, k.user("jdoe").Enabled)
+
+	// Deleted users are skipped
+	assert.NoError(t, DisableUser("janedoe"))
+}
@@ -0,0 +1,179 @@
+package ldapdir
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/hadleyso/netid-activate/src/config"
+	idm "github.com/hadleyso/netid-activate/src/redhat-idm"
+	"github.com/spf13/viper"
+)
+
+// Directory already has an entry with the login name
+var ErrLoginNameExists = errors.New("login name already exists in LDAP")
+
+// Directory rejected a password for policy reasons
+var ErrPasswordPolicy = errors.New("password rejected by LDAP password policy")
+
+// Attribute holding the login name when LDAP_LOGIN_ATTRIBUTE is not set
+const DefaultLoginAttribute = "uid"
+
+// Group membership attribute when LDAP_GROUP_MEMBER is not set
+const DefaultGroupMember = "member"
+
+// Object classes of new users when LDAP_OBJECT_CLASSES is not set
+var DefaultObjectClasses = []string{"top", "person", "organizationalPerson", "inetOrgPerson"}
+
+// Attributes for user fields when not set in LDAP_ATTRIBUTES
+// An empty attribute skips the field, gecos needs posixAccount and
+// sshpublickey needs ldapPublicKey
+var DefaultAttributes = map[string]string{
+	"cn":           "cn",
+	"gecos":        "",
+	"givenname":    "givenName",
+	"sn":           "sn",
+	"mail":         "mail",
+	"st":           "st",
+	"manager":      "manager",
+	"pager":        "pager",
+	"sshpublickey": "",
+}
+
+// LDAP directory settings
+type Settings struct {
+	URL            string
+	StartTLS       bool
+	BindDN         string
+	BindPassword   string
+	UserBaseDN     string
+	GroupBaseDN    string
+	LoginAttribute string
+	ObjectClasses  []string
+	Attributes     map[string]string
+	GroupMember    string
+	AddGroups      []string
+}
+
+// Settings from LDAP_* config with defaults
+func SettingsFromConfig() Settings {
+	settings := Settings{
+		URL:            viper.GetString("LDAP_URL"),
+		StartTLS:       viper.GetBool("LDAP_START_TLS"),
+		BindDN:         viper.GetString("LDAP_BIND_DN"),
+		BindPassword:   viper.GetString("LDAP_BIND_PASSWORD"),
+		UserBaseDN:     viper.GetString("LDAP_USER_BASE_DN"),
+		GroupBaseDN:    viper.GetString("LDAP_GROUP_BASE_DN"),
+		LoginAttribute: viper.GetString("LDAP_LOGIN_ATTRIBUTE"),
+		ObjectClasses:  viper.GetStringSlice("LDAP_OBJECT_CLASSES"),
+		Attributes:     map[string]string{},
+		GroupMember:    viper.GetString("LDAP_GROUP_MEMBER"),
+	}
+	if settings.LoginAttribute == "" {
+		settings.LoginAttribute = DefaultLoginAttribute
+	}
+	if len(settings.ObjectClasses) == 0 {
+		settings.ObjectClasses = DefaultObjectClasses
+	}
+	if settings.GroupMember == "" {
+		settings.GroupMember = DefaultGroupMember
+	}
+	for field, attribute := range DefaultAttributes {
+		settings.Attributes[field] = attribute
+	}
+	for field, attribute := range viper.GetStringMapString("LDAP_ATTRIBUTES") {
+		settings.Attributes[strings.ToLower(field)] = attribute
+	}
+	for _, group := range strings.Split(viper.GetString("LDAP_ADD_GROUP"), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			settings.AddGroups = append(settings.AddGroups, group)
+		}
+	}
+	return settings
+}
+
+// Check settings are usable, run at startup
+func (s Settings) Validate() error {
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+		return fmt.Errorf("LDAP_URL %q must be an ldap:// or ldaps:// URL", s.URL)
+	}
+	if s.StartTLS && u.Scheme == "ldaps" {
+		return errors.New("LDAP_START_TLS cannot be used with an ldaps:// URL")
+	}
+	for name, dn := range map[string]string{"LDAP_USER_BASE_DN": s.UserBaseDN, "LDAP_GROUP_BASE_DN": s.GroupBaseDN} {
+		if _, err := ldap.ParseDN(dn); err != nil || dn == "" {
+			return fmt.Errorf("%s %q is not a valid DN", name, dn)
+		}
+	}
+	for field := range s.Attributes {
+		isInviteField := slices.ContainsFunc(config.C.InviteFields, func(f config.InviteField) bool { return f.Name == field })
+		if _, ok := DefaultAttributes[field]; !ok && !isInviteField {
+			return fmt.Errorf("LDAP_ATTRIBUTES has unknown field %s", field)
+		}
+	}
+	if s.Attributes["mail"] == "" {
+		return errors.New("LDAP_ATTRIBUTES must map mail, it is used to find existing users")
+	}
+	switch s.GroupMember {
+	case "member", "memberUid":
+	default:
+		return fmt.Errorf("LDAP_GROUP_MEMBER %q must be member or memberUid", s.GroupMember)
+	}
+	return nil
+}
+
+// Check LDAP settings from config
+func CheckConfig() error {
+	return SettingsFromConfig().Validate()
+}
+
+// DN of user entry for login name
+func (s Settings) userDN(loginName string) string {
+	return s.LoginAttribute + "=" + ldap.EscapeDN(loginName) + "," + s.UserBaseDN
+}
+
+// DN of group entry for group name
+func (s Settings) groupDN(group string) string {
+	return "cn=" + ldap.EscapeDN(group) + "," + s.GroupBaseDN
+}
+
+func newTLSConfig(host string) (*tls.Config, error) {
+	rootCAs, err := idm.RootCAs()
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{ServerName: host, RootCAs: rootCAs}, nil
+}
+
+// Connect and bind as the service account
+func connect(s Settings) (*ldap.Conn, error) {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := newTLSConfig(u.Hostname())
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := ldap.DialURL(s.URL, ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	if s.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if err := conn.Bind(s.BindDN, s.BindPassword); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
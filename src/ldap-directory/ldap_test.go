@@ -0,0 +1,87 @@
+package ldapdir
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSettingsFromConfigDefaults(t *testing.T) {
+	setupLDAPTestServer(t)
+
+	settings := SettingsFromConfig()
+	assert.Equal(t, "uid", settings.LoginAttribute)
+	assert.Equal(t, "member", settings.GroupMember)
+	assert.Equal(t, DefaultObjectClasses, settings.ObjectClasses)
+	assert.Equal(t, "givenName", settings.Attributes["givenname"])
+	assert.Empty(t, settings.Attributes["gecos"])
+	assert.NoError(t, settings.Validate())
+	assert.Equal(t, "uid=jdoe,"+testUserBaseDN, settings.userDN("jdoe"))
+	assert.Equal(t, `cn=a\,b,`+testGroupBaseDN, settings.groupDN("a,b"))
+}
+
+func TestSettingsFromConfigOverrides(t *testing.T) {
+	setupLDAPTestServer(t)
+	viper.Set("LDAP_ATTRIBUTES", map[string]string{"gecos": "gecos", "pager": ""})
+	viper.Set("LDAP_GROUP_MEMBER", "memberUid")
+	viper.Set("LDAP_ADD_GROUP", "staff, ,vpn")
+
+	settings := SettingsFromConfig()
+	assert.Equal(t, "gecos", settings.Attributes["gecos"])
+	assert.Empty(t, settings.Attributes["pager"])
+	assert.Equal(t, "sn", settings.Attributes["sn"])
+	assert.Equal(t, []string{"staff", "vpn"}, settings.AddGroups)
+	assert.NoError(t, settings.Validate())
+}
+
+func TestSettingsValidate(t *testing.T) {
+	setupLDAPTestServer(t)
+
+	tests := []struct {
+		name   string
+		change func(s *Settings)
+	}{
+		{"http URL", func(s *Settings) { s.URL = "http://ldap.example.org" }},
+		{"StartTLS with ldaps", func(s *Settings) { s.URL = "ldaps://ldap.example.org"; s.StartTLS = true }},
+		{"missing user base", func(s *Settings) { s.UserBaseDN = "" }},
+		{"invalid group base", func(s *Settings) { s.GroupBaseDN = "not a dn" }},
+		{"unknown field", func(s *Settings) { s.Attributes["title"] = "title" }},
+		{"unmapped mail", func(s *Settings) { s.Attributes["mail"] = "" }},
+		{"unknown member attribute", func(s *Settings) { s.GroupMember = "uniqueMember" }},
+	}
+	for _, tt := range tests {
+		settings := SettingsFromConfig()
+		tt.change(&settings)
+		assert.Error(t, settings.Validate(), tt.name)
+	}
+}
+
+func TestConnectBadCredentials(t *testing.T) {
+	setupLDAPTestServer(t)
+	viper.Set("LDAP_BIND_PASSWORD", "wrong")
+
+	_, err := connect(SettingsFromConfig())
+	assert.Error(t, err)
+}
+
+func TestConnectStartTLS(t *testing.T) {
+	d := setupLDAPTestServer(t)
+	d.enableStartTLS(t)
+
+	conn, err := connect(SettingsFromConfig())
+	if assert.NoError(t, err) {
+		_, ok := conn.TLSConnectionState()
+		assert.True(t, ok)
+		conn.Close()
+	}
+}
+
+func TestConnectStartTLSUntrusted(t *testing.T) {
+	d := setupLDAPTestServer(t)
+	d.enableStartTLS(t)
+	viper.Set("CACERT_PATH", "")
+
+	_, err := connect(SettingsFromConfig())
+	assert.Error(t, err)
+}
@@ -0,0 +1,145 @@
+package ldapdir
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/hadleyso/netid-activate/src/countries"
+	"github.com/hadleyso/netid-activate/src/models"
+	"github.com/spf13/viper"
+)
+
+// Creates user entries for directory.HandleMakeUser, bound as the
+// service account until Close
+type Maker struct {
+	conn     *ldap.Conn
+	settings Settings
+}
+
+func NewMaker() (*Maker, error) {
+	settings := SettingsFromConfig()
+	conn, err := connect(settings)
+	if err != nil {
+		log.Println("NewMaker() unable to connect() " + err.Error())
+		return nil, err
+	}
+	return &Maker{conn: conn, settings: settings}, nil
+}
+
+// Groups from LDAP_ADD_GROUP every user joins
+func (m *Maker) DefaultGroups() []string {
+	return m.settings.AddGroups
+}
+
+// Add user entry, returns the login name groups refer to
+// LDAP has no portable temporary password flag, pwdReset is server policy
+func (m *Maker) MakeUser(loginName string, invite models.Invite, password string, temporary bool, sshKeys []string) (string, error) {
+	if err := makeUser(m.conn, m.settings, loginName, invite, password, sshKeys); err != nil {
+		return "", err
+	}
+	return loginName, nil
+}
+
+func (m *Maker) AddUserGroups(loginName string, groups []string) error {
+	return addUserGroups(m.conn, m.settings, loginName, groups)
+}
+
+func (m *Maker) Close() {
+	m.conn.Close()
+}
+
+// Add user entry with the mapped attributes
+func makeUser(conn *ldap.Conn, settings Settings, loginName string, invite models.Invite, password string, sshKeys []string) error {
+	countryName, err := countries.GetNameFromAlpha3(invite.Country)
+	if err != nil {
+		return err
+	}
+	alpha2, err := countries.GetAlpha2FromAlpha3(invite.Country)
+	if err != nil {
+		return err
+	}
+
+	cn := invite.FirstName + " " + invite.LastName
+	gecos := cn
+	if viper.GetString("IDM_GECOS") == "true" {
+		gecos = cn + " (" + invite.Country + " " + invite.Affiliation + ")"
+	}
+
+	values := map[string][]string{
+		"cn":           {cn},
+		"gecos":        {gecos},
+		"givenname":    {invite.FirstName},
+		"sn":           {invite.LastName},
+		"mail":         {invite.Email},
+		"st":           {invite.State + ", " + countryName},
+		"manager":      {settings.userDN(invite.Inviter)},
+		"pager":        {alpha2},
+		"sshpublickey": sshKeys,
+	}
+	if invite.Inviter == "" {
+		delete(values, "manager")
+	}
+	// INVITE_FIELDS are stored when mapped
+	for field, value := range invite.FieldValues() {
+		if value != "" {
+			values[field] = []string{value}
+		}
+	}
+	if len(sshKeys) > 0 && settings.Attributes["sshpublickey"] == "" {
+		log.Println("makeUser() SSH keys not stored, LDAP_ATTRIBUTES has no sshpublickey attribute")
+	}
+
+	request := ldap.NewAddRequest(settings.userDN(loginName), nil)
+	request.Attribute("objectClass", settings.ObjectClasses)
+	request.Attribute(settings.LoginAttribute, []string{loginName})
+	request.Attribute("userPassword", []string{password})
+	for field, value := range values {
+		if attribute := settings.Attributes[field]; attribute != "" && len(value) > 0 {
+			request.Attribute(attribute, value)
+		}
+	}
+
+	err = conn.Add(request)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultEntryAlreadyExists) {
+		return fmt.Errorf("%w: %v", ErrLoginNameExists, err)
+	}
+	if isPasswordPolicyError(err) {
+		return fmt.Errorf("%w: %v", ErrPasswordPolicy, err)
+	}
+	return err
+}
+
+// Add user to groups below LDAP_GROUP_BASE_DN
+// Groups are tried in turn, returns the last error
+func addUserGroups(conn *ldap.Conn, settings Settings, loginName string, groups []string) error {
+	member := settings.userDN(loginName)
+	if settings.GroupMember == "memberUid" {
+		member = loginName
+	}
+
+	var lastErr error
+	for _, group := range groups {
+		if group == "" {
+			continue
+		}
+		request := ldap.NewModifyRequest(settings.groupDN(group), nil)
+		request.Add(settings.GroupMember, []string{member})
+
+		err := conn.Modify(request)
+		if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultAttributeOrValueExists) {
+			log.Println("addUserGroups() unable to add to " + group + " " + err.Error())
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// Check add error for password policy rejection
+func isPasswordPolicyError(err error) bool {
+	if !ldap.IsErrorAnyOf(err, ldap.LDAPResultConstraintViolation, ldap.LDAPResultUnwillingToPerform) {
+		return false
+	}
+	return strings.Contains(strings.ToLower(err.Error()), "password")
+}
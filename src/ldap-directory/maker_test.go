@@ -0,0 +1,143 @@
+package ldapdir
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/hadleyso/netid-activate/src/config"
+	"github.com/hadleyso/netid-activate/src/models"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/datatypes"
+)
+
+func testInvite() models.Invite {
+	return models.Invite{
+		FirstName:   "Jane",
+		LastName:    "Doe",
+		Email:       "jane@example.com",
+		Country:     "USA",
+		Affiliation: "Staff",
+		State:       "GA",
+		Inviter:     "admin",
+	}
+}
+
+func newTestMaker(t *testing.T) *Maker {
+	m, err := NewMaker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(m.Close)
+	return m
+}
+
+func TestMakeUser(t *testing.T) {
+	d := setupLDAPTestServer(t)
+	d.put("cn=staff,"+testGroupBaseDN, map[string][]string{"cn": {"staff"}})
+	d.put("cn=vpn,"+testGroupBaseDN, map[string][]string{"cn": {"vpn"}, "member": {"uid=other," + testUserBaseDN}})
+	viper.Set("LDAP_ADD_GROUP", "vpn")
+	m := newTestMaker(t)
+	assert.Equal(t, []string{"vpn"}, m.DefaultGroups())
+
+	userID, err := m.MakeUser("janedoe", testInvite(), "Correct-Horse-9", false, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "janedoe", userID)
+	assert.NoError(t, m.AddUserGroups(userID, []string{"staff", "vpn"}))
+
+	entry := d.get("uid=janedoe," + testUserBaseDN)
+	if assert.NotNil(t, entry) {
+		assert.Equal(t, DefaultObjectClasses, entry["objectclass"])
+		assert.Equal(t, []string{"janedoe"}, entry["uid"])
+		assert.Equal(t, []string{"Jane Doe"}, entry["cn"])
+		assert.Equal(t, []string{"Jane"}, entry["givenname"])
+		assert.Equal(t, []string{"Doe"}, entry["sn"])
+		assert.Equal(t, []string{"jane@example.com"}, entry["mail"])
+		assert.Equal(t, []string{"GA, United States of America"}, entry["st"])
+		assert.Equal(t, []string{"uid=admin," + testUserBaseDN}, entry["manager"])
+		assert.Equal(t, []string{"US"}, entry["pager"])
+		assert.Equal(t, []string{"Correct-Horse-9"}, entry["userpassword"])
+		assert.NotContains(t, entry, "gecos")
+	}
+
+	assert.Equal(t, []string{"uid=janedoe," + testUserBaseDN}, d.get("cn=staff," + testGroupBaseDN)["member"])
+	assert.Contains(t, d.get("cn=vpn," + testGroupBaseDN)["member"], "uid=janedoe,"+testUserBaseDN)
+}
+
+func TestMakeUserAttributeMapping(t *testing.T) {
+	d := setupLDAPTestServer(t)
+	d.put("cn=staff,"+testGroupBaseDN, map[string][]string{"cn": {"staff"}})
+	viper.Set("LDAP_OBJECT_CLASSES", []string{"top", "inetOrgPerson", "posixAccount", "ldapPublicKey"})
+	viper.Set("LDAP_ATTRIBUTES", map[string]string{"gecos": "gecos", "pager": "", "sshpublickey": "sshPublicKey"})
+	viper.Set("LDAP_GROUP_MEMBER", "memberUid")
+	m := newTestMaker(t)
+
+	keys := []string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIEXAMPLE jane@laptop"}
+	userID, err := m.MakeUser("janedoe", testInvite(), "Correct-Horse-9", false, keys)
+	assert.NoError(t, err)
+	assert.NoError(t, m.AddUserGroups(userID, []string{"staff"}))
+
+	entry := d.get("uid=janedoe," + testUserBaseDN)
+	if assert.NotNil(t, entry) {
+		assert.Equal(t, []string{"Jane Doe"}, entry["gecos"])
+		assert.NotContains(t, entry, "pager")
+		assert.Equal(t, keys, entry["sshpublickey"])
+		assert.Contains(t, entry["objectclass"], "posixAccount")
+	}
+	assert.Equal(t, []string{"janedoe"}, d.get("cn=staff," + testGroupBaseDN)["memberuid"])
+}
+
+func TestMakeUserInviteFields(t *testing.T) {
+	d := setupLDAPTestServer(t)
+	config.C.InviteFields = []config.InviteField{{Name: "employee_id"}, {Name: "department"}}
+	t.Cleanup(func() { config.C.InviteFields = nil })
+	viper.Set("LDAP_ATTRIBUTES", map[string]string{"employee_id": "employeeNumber"})
+	assert.NoError(t, CheckConfig())
+
+	invite := testInvite()
+	invite.CustomFields = datatypes.JSON(`{"employee_id": "123456", "department": "Physics"}`)
+	_, err := newTestMaker(t).MakeUser("janedoe", invite, "Correct-Horse-9", false, nil)
+	assert.NoError(t, err)
+
+	entry := d.get("uid=janedoe," + testUserBaseDN)
+	if assert.NotNil(t, entry) {
+		assert.Equal(t, []string{"123456"}, entry["employeenumber"])
+		// Unmapped fields are not stored
+		assert.NotContains(t, entry, "department")
+	}
+}
+
+func TestMakeUserExists(t *testing.T) {
+	d := setupLDAPTestServer(t)
+	d.put("uid=janedoe,"+testUserBaseDN, map[string][]string{"uid": {"janedoe"}})
+
+	_, err := newTestMaker(t).MakeUser("janedoe", testInvite(), "Correct-Horse-9", false, nil)
+	assert.True(t, errors.Is(err, ErrLoginNameExists))
+}
+
+func TestMakeUserPasswordPolicy(t *testing.T) {
+	d := setupLDAPTestServer(t)
+	d.addError = ldap.LDAPResultConstraintViolation
+	d.addMessage = "Password fails quality checking policy"
+
+	_, err := newTestMaker(t).MakeUser("janedoe", testInvite(), "password", false, nil)
+	assert.True(t, errors.Is(err, ErrPasswordPolicy))
+	assert.Equal(t, 1, d.adds)
+}
+
+func TestAddUserGroupsMissingGroup(t *testing.T) {
+	d := setupLDAPTestServer(t)
+	d.put("cn=staff,"+testGroupBaseDN, map[string][]string{"cn": {"staff"}})
+
+	// Later groups are still tried
+	assert.Error(t, newTestMaker(t).AddUserGroups("janedoe", []string{"nosuchgroup", "staff"}))
+	assert.Equal(t, []string{"uid=janedoe," + testUserBaseDN}, d.get("cn=staff," + testGroupBaseDN)["member"])
+}
+
+func TestIsPasswordPolicyError(t *testing.T) {
+	assert.True(t, isPasswordPolicyError(ldap.NewError(ldap.LDAPResultConstraintViolation, errors.New("invalid password syntax"))))
+	assert.False(t, isPasswordPolicyError(ldap.NewError(ldap.LDAPResultConstraintViolation, errors.New("mail is not unique"))))
+	assert.False(t, isPasswordPolicyError(ldap.NewError(ldap.LDAPResultEntryAlreadyExists, errors.New("password"))))
+	assert.False(t, isPasswordPolicyError(nil))
+}
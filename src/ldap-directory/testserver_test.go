@@ -0,0 +1,353 @@
+package ldapdir
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/spf13/viper"
+)
+
+const (
+	testBindDN       = "cn=admin,dc=example,dc=org"
+	testBindPassword = "secret"
+	testUserBaseDN   = "ou=people,dc=example,dc=org"
+	testGroupBaseDN  = "ou=groups,dc=example,dc=org"
+)
+
+// In-process LDAP server with simple bind, StartTLS, search with
+// equality, presence, and, or and not filters, add and modify
+type testDirectory struct {
+	mu sync.Mutex
+	// Entries by lowercase DN, attribute names lowercase
+	entries map[string]map[string][]string
+	// Result code and message returned for adds, if set
+	addError   uint16
+	addMessage string
+	adds       int
+	tlsConfig  *tls.Config
+	listener   net.Listener
+}
+
+// Start test directory with base, people and groups entries and set
+// LDAP_* config to use it
+func setupLDAPTestServer(t *testing.T) *testDirectory {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	d := &testDirectory{entries: map[string]map[string][]string{}, listener: listener}
+	d.put("dc=example,dc=org", map[string][]string{"objectClass": {"top", "domain"}})
+	d.put(testUserBaseDN, map[string][]string{"objectClass": {"top", "organizationalUnit"}})
+	d.put(testGroupBaseDN, map[string][]string{"objectClass": {"top", "organizationalUnit"}})
+
+	go d.serve()
+
+	settings := map[string]any{
+		"LDAP_URL":           "ldap://" + listener.Addr().String(),
+		"LDAP_BIND_DN":       testBindDN,
+		"LDAP_BIND_PASSWORD": testBindPassword,
+		"LDAP_USER_BASE_DN":  testUserBaseDN,
+		"LDAP_GROUP_BASE_DN": testGroupBaseDN,
+	}
+	for key, value := range settings {
+		viper.Set(key, value)
+	}
+	t.Cleanup(func() {
+		listener.Close()
+		for key := range settings {
+			viper.Set(key, nil)
+		}
+		for _, key := range []string{"LDAP_START_TLS", "LDAP_ATTRIBUTES", "LDAP_GROUP_MEMBER", "LDAP_ADD_GROUP", "LDAP_OBJECT_CLASSES", "LDAP_LOGIN_ATTRIBUTE", "CACERT_PATH"} {
+			viper.Set(key, nil)
+		}
+	})
+
+	return d
+}
+
+// Serve StartTLS with a self signed certificate trusted through CACERT_PATH
+func (d *testDirectory) enableStartTLS(t *testing.T) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed: %v", err)
+	}
+
+	caPath := filepath.Join(t.TempDir(), "ca.crt")
+	os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	viper.Set("CACERT_PATH", caPath)
+	viper.Set("LDAP_START_TLS", true)
+
+	d.mu.Lock()
+	d.tlsConfig = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	d.mu.Unlock()
+}
+
+func (d *testDirectory) put(dn string, attributes map[string][]string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entry := map[string][]string{}
+	for name, values := range attributes {
+		entry[strings.ToLower(name)] = values
+	}
+	d.entries[strings.ToLower(dn)] = entry
+}
+
+func (d *testDirectory) get(dn string) map[string][]string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.entries[strings.ToLower(dn)]
+}
+
+func (d *testDirectory) serve() {
+	for {
+		conn, err := d.listener.Accept()
+		if err != nil {
+			return
+		}
+		go d.handle(conn)
+	}
+}
+
+func (d *testDirectory) handle(conn net.Conn) {
+	defer func() { conn.Close() }()
+
+	bound := false
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		messageID := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			name := op.Children[1].Data.String()
+			password := op.Children[2].Data.String()
+			bound = name == testBindDN && password == testBindPassword
+			code := uint16(ldap.LDAPResultSuccess)
+			if !bound {
+				code = ldap.LDAPResultInvalidCredentials
+			}
+			conn.Write(ldapResponse(messageID, ldap.ApplicationBindResponse, code, "").Bytes())
+
+		case ldap.ApplicationExtendedRequest:
+			d.mu.Lock()
+			tlsConfig := d.tlsConfig
+			d.mu.Unlock()
+			if tlsConfig == nil || op.Children[0].Data.String() != "1.3.6.1.4.1.1466.20037" {
+				conn.Write(ldapResponse(messageID, ldap.ApplicationExtendedResponse, ldap.LDAPResultProtocolError, "unsupported").Bytes())
+				continue
+			}
+			conn.Write(ldapResponse(messageID, ldap.ApplicationExtendedResponse, ldap.LDAPResultSuccess, "").Bytes())
+			tlsConn := tls.Server(conn, tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+
+		case ldap.ApplicationSearchRequest:
+			if !bound {
+				conn.Write(ldapResponse(messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultInsufficientAccessRights, "").Bytes())
+				continue
+			}
+			d.search(conn, messageID, op)
+
+		case ldap.ApplicationAddRequest:
+			code, message := uint16(ldap.LDAPResultInsufficientAccessRights), ""
+			if bound {
+				code, message = d.add(op)
+			}
+			conn.Write(ldapResponse(messageID, ldap.ApplicationAddResponse, code, message).Bytes())
+
+		case ldap.ApplicationModifyRequest:
+			code := uint16(ldap.LDAPResultInsufficientAccessRights)
+			if bound {
+				code = d.modify(op)
+			}
+			conn.Write(ldapResponse(messageID, ldap.ApplicationModifyResponse, code, "").Bytes())
+
+		case ldap.ApplicationUnbindRequest:
+			return
+
+		default:
+			conn.Write(ldapResponse(messageID, op.Tag+1, ldap.LDAPResultUnwillingToPerform, "").Bytes())
+		}
+	}
+}
+
+func (d *testDirectory) search(conn net.Conn, messageID int64, op *ber.Packet) {
+	base := strings.ToLower(op.Children[0].Data.String())
+	scope := op.Children[1].Value.(int64)
+	sizeLimit := op.Children[3].Value.(int64)
+	filter := op.Children[6]
+
+	d.mu.Lock()
+	var matched []string
+	for dn, entry := range d.entries {
+		inScope := dn == base
+		switch scope {
+		case ldap.ScopeSingleLevel:
+			inScope = strings.HasSuffix(dn, ","+base) && !strings.Contains(strings.TrimSuffix(dn, ","+base), ",")
+		case ldap.ScopeWholeSubtree:
+			inScope = dn == base || strings.HasSuffix(dn, ","+base)
+		}
+		if inScope && matchFilter(filter, entry) {
+			matched = append(matched, dn)
+		}
+	}
+	d.mu.Unlock()
+
+	for i, dn := range matched {
+		if sizeLimit > 0 && int64(i) >= sizeLimit {
+			conn.Write(ldapResponse(messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultSizeLimitExceeded, "").Bytes())
+			return
+		}
+		response := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+		response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "Message ID"))
+		entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+		entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, "Object Name"))
+		entry.AppendChild(ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes"))
+		response.AppendChild(entry)
+		conn.Write(response.Bytes())
+	}
+	conn.Write(ldapResponse(messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess, "").Bytes())
+}
+
+func (d *testDirectory) add(op *ber.Packet) (uint16, string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.adds++
+	if d.addError != 0 {
+		return d.addError, d.addMessage
+	}
+
+	dn := strings.ToLower(op.Children[0].Data.String())
+	if _, ok := d.entries[dn]; ok {
+		return ldap.LDAPResultEntryAlreadyExists, ""
+	}
+	entry := map[string][]string{}
+	for _, attribute := range op.Children[1].Children {
+		entry[strings.ToLower(attribute.Children[0].Data.String())] = packetValues(attribute.Children[1])
+	}
+	d.entries[dn] = entry
+	return ldap.LDAPResultSuccess, ""
+}
+
+func (d *testDirectory) modify(op *ber.Packet) uint16 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entry, ok := d.entries[strings.ToLower(op.Children[0].Data.String())]
+	if !ok {
+		return ldap.LDAPResultNoSuchObject
+	}
+	for _, change := range op.Children[1].Children {
+		operation := change.Children[0].Value.(int64)
+		name := strings.ToLower(change.Children[1].Children[0].Data.String())
+		values := packetValues(change.Children[1].Children[1])
+
+		switch operation {
+		case ldap.AddAttribute:
+			for _, value := range values {
+				if containsFold(entry[name], value) {
+					return ldap.LDAPResultAttributeOrValueExists
+				}
+				entry[name] = append(entry[name], value)
+			}
+		case ldap.ReplaceAttribute:
+			entry[name] = values
+		case ldap.DeleteAttribute:
+			delete(entry, name)
+		}
+	}
+	return ldap.LDAPResultSuccess
+}
+
+// Evaluate filter packet against entry
+func matchFilter(filter *ber.Packet, entry map[string][]string) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !matchFilter(child, entry) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range filter.Children {
+			if matchFilter(child, entry) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return !matchFilter(filter.Children[0], entry)
+	case ldap.FilterEqualityMatch:
+		name := strings.ToLower(filter.Children[0].Data.String())
+		return containsFold(entry[name], filter.Children[1].Data.String())
+	case ldap.FilterPresent:
+		return len(entry[strings.ToLower(filter.Data.String())]) > 0
+	}
+	return false
+}
+
+func packetValues(set *ber.Packet) []string {
+	var values []string
+	for _, value := range set.Children {
+		values = append(values, value.Data.String())
+	}
+	return values
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func ldapResponse(messageID int64, tag ber.Tag, code uint16, message string) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "Message ID"))
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, message, "Diagnostic Message"))
+	packet.AppendChild(result)
+	return packet
+}
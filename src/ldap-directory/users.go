@@ -0,0 +1,82 @@
+package ldapdir
+
+import (
+	"log"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Checks if login names exist under LDAP_USER_BASE_DN
+// Returns names that don't exist
+func CheckUsernamesExists(loginNames []string) ([]string, error) {
+	okUsername := []string{}
+	settings := SettingsFromConfig()
+
+	conn, err := connect(settings)
+	if err != nil {
+		log.Println("CheckUsernamesExists() unable to connect() " + err.Error())
+		return okUsername, err
+	}
+	defer conn.Close()
+
+	for _, loginName := range loginNames {
+		exists, err := entryExists(conn, settings.UserBaseDN, settings.LoginAttribute, loginName)
+		if err != nil {
+			log.Println("CheckUsernamesExists() unable to entryExists() " + err.Error())
+			return okUsername, err
+		}
+		if !exists {
+			okUsername = append(okUsername, loginName)
+		}
+	}
+
+	return okUsername, nil
+}
+
+// Checks if login name is unused
+func LoginNameAvailable(loginName string) (bool, error) {
+	free, err := CheckUsernamesExists([]string{loginName})
+	if err != nil {
+		return false, err
+	}
+	return len(free) == 1, nil
+}
+
+// Checks if a user with email exists under LDAP_USER_BASE_DN
+func CheckEmailExists(email string) (bool, error) {
+	settings := SettingsFromConfig()
+
+	conn, err := connect(settings)
+	if err != nil {
+		log.Println("CheckEmailExists() unable to connect() " + err.Error())
+		return false, err
+	}
+	defer conn.Close()
+
+	exists, err := entryExists(conn, settings.UserBaseDN, settings.Attributes["mail"], email)
+	if err != nil {
+		log.Println("CheckEmailExists() unable to entryExists() " + err.Error())
+		return false, err
+	}
+	return exists, nil
+}
+
+// Check for an entry below baseDN with attribute equal to value
+func entryExists(conn *ldap.Conn, baseDN string, attribute string, value string) (bool, error) {
+	request := ldap.NewSearchRequest(
+		baseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 1, 0, false,
+		"("+attribute+"="+ldap.EscapeFilter(value)+")",
+		[]string{"dn"},
+		nil,
+	)
+
+	result, err := conn.Search(request)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return len(result.Entries) > 0, nil
+}
@@ -0,0 +1,52 @@
+package ldapdir
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckUsernamesExists(t *testing.T) {
+	d := setupLDAPTestServer(t)
+	d.put("uid=jdoe,"+testUserBaseDN, map[string][]string{"uid": {"jdoe"}, "mail": {"jdoe@example.com"}})
+
+	free, err := CheckUsernamesExists([]string{"jdoe", "johndoe", "j*"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"johndoe", "j*"}, free)
+}
+
+func TestLoginNameAvailable(t *testing.T) {
+	d := setupLDAPTestServer(t)
+	d.put("uid=jdoe,"+testUserBaseDN, map[string][]string{"uid": {"jdoe"}})
+
+	available, err := LoginNameAvailable("JDoe")
+	assert.NoError(t, err)
+	assert.False(t, available)
+
+	available, err = LoginNameAvailable("janedoe")
+	assert.NoError(t, err)
+	assert.True(t, available)
+}
+
+func TestCheckEmailExists(t *testing.T) {
+	d := setupLDAPTestServer(t)
+	d.put("uid=jdoe,"+testUserBaseDN, map[string][]string{"uid": {"jdoe"}, "mail": {"jdoe@example.com"}})
+	// Outside the user base
+	d.put("uid=svc,dc=example,dc=org", map[string][]string{"uid": {"svc"}, "mail": {"svc@example.com"}})
+
+	exists, err := CheckEmailExists("jdoe@example.com")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	exists, err = CheckEmailExists("svc@example.com")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestCheckEmailExistsUnreachable(t *testing.T) {
+	d := setupLDAPTestServer(t)
+	d.listener.Close()
+
+	_, err := CheckEmailExists("jdoe@example.com")
+	assert.Error(t, err)
+}
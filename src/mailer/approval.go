@@ -0,0 +1,70 @@
+package mailer
+
+import (
+	"log"
+
+	"github.com/hadleyso/netid-activate/src/config"
+	"github.com/hadleyso/netid-activate/src/models"
+	"github.com/spf13/viper"
+)
+
+// Details of an invite shown to approvers and the inviter
+// Names and the reason are entered by users, the HTML templates are
+// parsed with html/template to escape them
+type approvalVars struct {
+	Tenant          string
+	SiteName        string
+	ServiceProvider string
+	PrivacyPolicy   string
+	ServerURL       string
+	Invite          models.Invite
+	Groups          []config.Group
+	Reason          string
+}
+
+// Email APPROVAL_EMAIL that an invite is awaiting approval for groups
+func HandleSendApprovalRequest(invite models.Invite, groups []config.Group) error {
+	vars := newApprovalVars(invite, groups, "")
+	subject := viper.GetString("TENANT_NAME") + " Invite Awaiting Approval"
+
+	if err := sendEmail(config.C.ApprovalEmail, subject, "approval", vars); err != nil {
+		log.Println("Error HandleSendApprovalRequest() " + err.Error())
+		return err
+	}
+	return nil
+}
+
+// Email the inviter that their invite was rejected with the reason
+func HandleSendRejection(invite models.Invite, groups []config.Group, reason string) error {
+	if invite.InviterEmail == "" {
+		log.Printf("HandleSendRejection() no email for inviter %s, not notified\n", invite.Inviter)
+		return nil
+	}
+
+	vars := newApprovalVars(invite, groups, reason)
+	subject := viper.GetString("TENANT_NAME") + " Invite Rejected"
+
+	if err := sendEmail([]string{invite.InviterEmail}, subject, "rejected", vars); err != nil {
+		log.Println("Error HandleSendRejection() " + err.Error())
+		return err
+	}
+	return nil
+}
+
+func newApprovalVars(invite models.Invite, groups []config.Group, reason string) approvalVars {
+	serverURL := viper.GetString("SERVER_HOSTNAME")
+	if viper.GetString("OIDC_SERVER_PORT") != "" {
+		serverURL = viper.GetString("SERVER_HOSTNAME") + ":" + viper.GetString("OIDC_SERVER_PORT")
+	}
+
+	return approvalVars{
+		Tenant:          viper.GetString("TENANT_NAME"),
+		SiteName:        viper.GetString("SITE_NAME"),
+		ServiceProvider: viper.GetString("LINK_SERVICE_PROVIDER"),
+		PrivacyPolicy:   viper.GetString("LINK_PRIVACY_POLICY"),
+		ServerURL:       serverURL,
+		Invite:          invite,
+		Groups:          groups,
+		Reason:          reason,
+	}
+}
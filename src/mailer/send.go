@@ -0,0 +1,78 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"log"
+	texttemplate "text/template"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2/types"
+	"github.com/hadleyso/netid-activate/src/emailTemplate"
+	"github.com/spf13/viper"
+)
+
+// Render templates/<name>.html and .txt and send to addresses
+// Names and other user entered values are escaped in the HTML template
+func sendEmail(to []string, subject string, name string, vars any) error {
+	htmlTpl := template.Must(template.ParseFS(emailTemplate.TemplateFS, "templates/"+name+".html"))
+	textTpl := texttemplate.Must(texttemplate.ParseFS(emailTemplate.TemplateFS, "templates/"+name+".txt"))
+
+	var htmlBody, textBody bytes.Buffer
+	if err := htmlTpl.Execute(&htmlBody, vars); err != nil {
+		return err
+	}
+	if err := textTpl.Execute(&textBody, vars); err != nil {
+		return err
+	}
+
+	if viper.GetString("DEV") == "true" {
+		log.Println(textBody.String())
+		return nil
+	}
+
+	ctx := context.Background()
+	cfg, err := config.LoadDefaultConfig(ctx,
+		config.WithRegion(viper.GetString("AWS_REGION")),
+		config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(
+				viper.GetString("AWS_ACCESS_KEY_ID"),
+				viper.GetString("AWS_SECRET_ACCESS_KEY"),
+				"",
+			),
+		),
+	)
+	if err != nil {
+		return err
+	}
+	client := sesv2.NewFromConfig(cfg)
+
+	from := viper.GetString("EMAIL_FROM")
+	input := &sesv2.SendEmailInput{
+		FromEmailAddress: &from,
+		Destination: &types.Destination{
+			ToAddresses: to,
+		},
+		Content: &types.EmailContent{
+			Simple: &types.Message{
+				Subject: &types.Content{Data: &subject},
+				Body: &types.Body{
+					Html: &types.Content{Data: aws.String(htmlBody.String())},
+					Text: &types.Content{Data: aws.String(textBody.String())},
+				},
+			},
+		},
+	}
+
+	resp, err := client.SendEmail(ctx, input)
+	if err != nil {
+		return err
+	}
+	log.Printf("Email sent! Message ID: %s\n", *resp.MessageId)
+
+	return nil
+}
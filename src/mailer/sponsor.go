@@ -0,0 +1,47 @@
+package mailer
+
+import (
+	"errors"
+	"log"
+
+	"github.com/hadleyso/netid-activate/src/models"
+	"github.com/spf13/viper"
+)
+
+// Sponsorship has no sponsor email to send to
+var ErrNoSponsorEmail = errors.New("sponsorship has no sponsor email")
+
+type sponsorVars struct {
+	Tenant          string
+	SiteName        string
+	ServiceProvider string
+	PrivacyPolicy   string
+	Sponsorship     models.Sponsorship
+	DaysLeft        int
+	Link            string
+}
+
+// Email the sponsor that the account expires in daysLeft days with a
+// link to renew or decline it
+func HandleSendSponsorReminder(sponsorship models.Sponsorship, daysLeft int, link string) error {
+	if sponsorship.SponsorEmail == "" {
+		return ErrNoSponsorEmail
+	}
+
+	vars := sponsorVars{
+		Tenant:          viper.GetString("TENANT_NAME"),
+		SiteName:        viper.GetString("SITE_NAME"),
+		ServiceProvider: viper.GetString("LINK_SERVICE_PROVIDER"),
+		PrivacyPolicy:   viper.GetString("LINK_PRIVACY_POLICY"),
+		Sponsorship:     sponsorship,
+		DaysLeft:        daysLeft,
+		Link:            link,
+	}
+	subject := viper.GetString("TENANT_NAME") + " Account Renewal for " + sponsorship.LoginName
+
+	if err := sendEmail([]string{sponsorship.SponsorEmail}, subject, "sponsor-reminder", vars); err != nil {
+		log.Println("Error HandleSendSponsorReminder() " + err.Error())
+		return err
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
@@ -29,8 +30,36 @@ type Invite struct {
 	Country        string
 	Affiliation    string
 	LoginNames     datatypes.JSON `json:"login_names" gorm:"type:json"`
+	CustomLogin    string
+	RequiredLogin  string
 	Inviter        string
 	OptionalGroups datatypes.JSON `json:"optional_groups" gorm:"type:json"`
+	CustomFields   datatypes.JSON `json:"custom_fields" gorm:"type:json"`
+	InviterEmail   string
+	ApprovalStatus string `gorm:"index"`
+	ApprovedBy     string
+}
+
+// ApprovalStatus of an invite, empty when no selected group requires
+// approval
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+)
+
+// Invite is held until an APPROVAL_GROUP member approves it, the
+// invitee is not emailed and cannot activate
+func (i Invite) AwaitingApproval() bool {
+	return i.ApprovalStatus == ApprovalPending
+}
+
+// Values of the INVITE_FIELDS entered by the inviter, by field name
+func (i Invite) FieldValues() map[string]string {
+	var values map[string]string
+	if err := json.Unmarshal(i.CustomFields, &values); err != nil || values == nil {
+		return map[string]string{}
+	}
+	return values
 }
 
 type OTP struct {
@@ -44,3 +73,56 @@ type EmailRate struct {
 	Email    string
 	LastSend time.Time
 }
+
+type Session struct {
+	Base
+	Token     string `gorm:"uniqueIndex"`
+	Name      string
+	Subject   string `gorm:"index"`
+	Data      []byte
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+type RateBucket struct {
+	Base
+	Key        string `gorm:"uniqueIndex"`
+	Tokens     float64
+	LastRefill time.Time `gorm:"index"`
+}
+
+// Status of a Sponsorship
+const (
+	SponsorActive   = "active"
+	SponsorDeclined = "declined"
+	SponsorExpired  = "expired"
+)
+
+// Term of an account created from an invite with a SPONSOR_AFFILIATIONS
+// affiliation, the sponsor is the inviter
+// Closed sponsorships are kept as a record of who ended the account
+type Sponsorship struct {
+	Base
+	LoginName     string `gorm:"index"`
+	Email         string
+	FirstName     string
+	LastName      string
+	Affiliation   string
+	Sponsor       string
+	SponsorEmail  string
+	ExpiresAt     time.Time `gorm:"index"`
+	Status        string    `gorm:"index"`
+	RemindersSent int
+	LastReminder  *time.Time
+	Renewals      int
+	ClosedAt      *time.Time
+}
+
+// Login name held for one invite, hard deleted on release
+// Names offered during activation expire, required names have
+// no expiry and are held until the invite is deleted
+type LoginReservation struct {
+	LoginName string     `gorm:"primaryKey"`
+	InviteID  string     `gorm:"index"`
+	ExpiresAt *time.Time `gorm:"index"`
+	CreatedAt time.Time
+}
@@ -1,12 +1,18 @@
 package models
 
-import "github.com/spf13/viper"
+import (
+	"net/http"
+
+	"github.com/gorilla/csrf"
+	"github.com/spf13/viper"
+)
 
 type PageBase struct {
 	PageTitle  string
 	FaviconURL string
 	LogoURL    string
 	UserInfo   *UserInfo
+	CSRFToken  string
 }
 
 // Constructor for PageBase with default title, favicon, and logo
@@ -22,3 +28,9 @@ func NewPageBase(PageTitle string) PageBase {
 		LogoURL:    viper.GetString("LOGO_URL"),
 	}
 }
+
+// Copy of PageBase with the CSRF token for forms set from request
+func (p PageBase) WithCSRF(r *http.Request) PageBase {
+	p.CSRFToken = csrf.Token(r)
+	return p
+}
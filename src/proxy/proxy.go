@@ -0,0 +1,203 @@
+package proxy
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/spf13/viper"
+)
+
+type contextKey string
+
+const infoKey contextKey = "proxyInfo"
+
+// Client details after applying trusted proxy headers
+type Info struct {
+	ClientIP string
+	Scheme   string
+}
+
+// One hop of a forwarding chain
+type hop struct {
+	addr  netip.Addr
+	proto string
+}
+
+// Parse TRUSTED_PROXIES, a list of CIDRs or IPs
+// Invalid entries are logged and skipped
+func TrustedProxies() []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, entry := range viper.GetStringSlice("TRUSTED_PROXIES") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		log.Println("TrustedProxies() invalid TRUSTED_PROXIES entry " + entry)
+	}
+	return prefixes
+}
+
+// Resolve client IP and scheme, honoring Forwarded, X-Forwarded-For and
+// X-Forwarded-Proto only when the connection comes from a trusted proxy
+func NewMiddleware() mux.MiddlewareFunc {
+	trusted := TrustedProxies()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			info := resolve(r, trusted)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), infoKey, info)))
+		})
+	}
+}
+
+// Client details for request, falls back to the connection
+// if the middleware did not run
+func FromRequest(r *http.Request) Info {
+	if info, ok := r.Context().Value(infoKey).(Info); ok {
+		return info
+	}
+	return resolve(r, nil)
+}
+
+// Client IP for request
+func ClientIP(r *http.Request) string {
+	return FromRequest(r).ClientIP
+}
+
+// Scheme the client used, http or https
+func Scheme(r *http.Request) string {
+	return FromRequest(r).Scheme
+}
+
+func resolve(r *http.Request, trusted []netip.Prefix) Info {
+	info := Info{ClientIP: remoteIP(r.RemoteAddr), Scheme: "http"}
+	if r.TLS != nil {
+		info.Scheme = "https"
+	}
+
+	remote, err := netip.ParseAddr(info.ClientIP)
+	if err != nil || !isTrusted(remote, trusted) {
+		return info
+	}
+
+	hops := forwardedHops(r.Header)
+	if len(hops) == 0 {
+		return info
+	}
+
+	// Walk from the nearest proxy back toward the client, stopping at
+	// the first address we do not trust
+	i := len(hops) - 1
+	for i > 0 && hops[i].addr.IsValid() && isTrusted(hops[i].addr, trusted) {
+		i--
+	}
+	if hops[i].addr.IsValid() {
+		info.ClientIP = hops[i].addr.String()
+	}
+
+	if proto := hops[i].proto; proto == "http" || proto == "https" {
+		info.Scheme = proto
+	}
+	return info
+}
+
+// Hops from Forwarded, or X-Forwarded-For and X-Forwarded-Proto
+// ordered client first
+func forwardedHops(header http.Header) []hop {
+	if values := header.Values("Forwarded"); len(values) > 0 {
+		return parseForwarded(strings.Join(values, ","))
+	}
+
+	var hops []hop
+	for _, value := range header.Values("X-Forwarded-For") {
+		for _, part := range strings.Split(value, ",") {
+			addr, _ := parseNode(part)
+			hops = append(hops, hop{addr: addr})
+		}
+	}
+
+	var protos []string
+	for _, value := range header.Values("X-Forwarded-Proto") {
+		for _, part := range strings.Split(value, ",") {
+			protos = append(protos, strings.ToLower(strings.TrimSpace(part)))
+		}
+	}
+
+	// Align protos with hops from the nearest proxy, a single value
+	// applies to every hop
+	if len(protos) == 1 {
+		for i := range hops {
+			hops[i].proto = protos[0]
+		}
+	} else {
+		for i, j := len(hops)-1, len(protos)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+			hops[i].proto = protos[j]
+		}
+	}
+	return hops
+}
+
+// Parse RFC 7239 Forwarded header value
+func parseForwarded(value string) []hop {
+	var hops []hop
+	for _, element := range strings.Split(value, ",") {
+		var h hop
+		for _, pair := range strings.Split(element, ";") {
+			key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok {
+				continue
+			}
+			val = strings.Trim(val, `"`)
+			switch strings.ToLower(key) {
+			case "for":
+				h.addr, _ = parseNode(val)
+			case "proto":
+				h.proto = strings.ToLower(val)
+			}
+		}
+		hops = append(hops, h)
+	}
+	return hops
+}
+
+// Parse address with optional port and IPv6 brackets
+func parseNode(node string) (netip.Addr, error) {
+	node = strings.TrimSpace(node)
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		node = host
+	}
+	node = strings.TrimSuffix(strings.TrimPrefix(node, "["), "]")
+	addr, err := netip.ParseAddr(node)
+	return addr.Unmap(), err
+}
+
+func remoteIP(remoteAddr string) string {
+	addr, err := parseNode(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return addr.String()
+}
+
+func isTrusted(addr netip.Addr, trusted []netip.Prefix) bool {
+	addr = addr.Unmap()
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
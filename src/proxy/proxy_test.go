@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func resolveWith(t *testing.T, trusted []string, req *http.Request) Info {
+	t.Helper()
+	viper.Set("TRUSTED_PROXIES", trusted)
+	t.Cleanup(func() { viper.Set("TRUSTED_PROXIES", nil) })
+
+	var info Info
+	handler := NewMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info = FromRequest(r)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	return info
+}
+
+func TestTrustedProxies(t *testing.T) {
+	viper.Set("TRUSTED_PROXIES", []string{"10.0.0.0/8", "192.168.1.5", "not-a-cidr", " ", "fd00::/8"})
+	defer viper.Set("TRUSTED_PROXIES", nil)
+
+	prefixes := TrustedProxies()
+	assert.Len(t, prefixes, 3)
+	assert.Equal(t, "192.168.1.5/32", prefixes[1].String())
+}
+
+func TestResolveUntrustedIgnoresHeaders(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "203.0.113.9:5555"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("Forwarded", "for=1.2.3.4;proto=https")
+
+	info := resolveWith(t, []string{"10.0.0.0/8"}, req)
+	assert.Equal(t, "203.0.113.9", info.ClientIP)
+	assert.Equal(t, "http", info.Scheme)
+}
+
+func TestResolveNoTrustedProxies(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:5555"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4")
+
+	info := resolveWith(t, nil, req)
+	assert.Equal(t, "10.0.0.1", info.ClientIP)
+}
+
+func TestResolveXForwardedFor(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:5555"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4, 10.0.0.2")
+	req.Header.Set("X-Forwarded-Proto", "https")
+
+	info := resolveWith(t, []string{"10.0.0.0/8"}, req)
+	assert.Equal(t, "1.2.3.4", info.ClientIP)
+	assert.Equal(t, "https", info.Scheme)
+}
+
+func TestResolveSpoofedXForwardedFor(t *testing.T) {
+	// Client prepends a fake address, the proxy appends the real one
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:5555"
+	req.Header.Set("X-Forwarded-For", "6.6.6.6, 198.51.100.7")
+
+	info := resolveWith(t, []string{"10.0.0.0/8"}, req)
+	assert.Equal(t, "198.51.100.7", info.ClientIP)
+}
+
+func TestResolveAllTrusted(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:5555"
+	req.Header.Set("X-Forwarded-For", "10.0.0.3, 10.0.0.2")
+
+	info := resolveWith(t, []string{"10.0.0.0/8"}, req)
+	assert.Equal(t, "10.0.0.3", info.ClientIP)
+}
+
+func TestResolveForwarded(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "[fd00::1]:5555"
+	req.Header.Set("Forwarded", `for="[2001:db8::1]:4711";proto=https, for=fd00::2;proto=http`)
+	req.Header.Set("X-Forwarded-For", "6.6.6.6")
+
+	info := resolveWith(t, []string{"fd00::/8"}, req)
+	assert.Equal(t, "2001:db8::1", info.ClientIP)
+	assert.Equal(t, "https", info.Scheme)
+}
+
+func TestResolveInvalidProto(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:5555"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4")
+	req.Header.Set("X-Forwarded-Proto", "javascript")
+
+	info := resolveWith(t, []string{"10.0.0.0/8"}, req)
+	assert.Equal(t, "1.2.3.4", info.ClientIP)
+	assert.Equal(t, "http", info.Scheme)
+}
+
+func TestFromRequestWithoutMiddleware(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "203.0.113.9:5555"
+	req.TLS = &tls.ConnectionState{}
+
+	assert.Equal(t, "203.0.113.9", ClientIP(req))
+	assert.Equal(t, "https", Scheme(req))
+}
@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hadleyso/netid-activate/src/db"
+	"github.com/hadleyso/netid-activate/src/models"
+)
+
+// How long an untouched bucket is kept, longer than any sensible period
+const staleAfter = 7 * 24 * time.Hour
+
+// Buckets stored in the rate_buckets table
+type dbBackend struct{}
+
+func (dbBackend) Take(key string, limit Limit, now time.Time) (bool, time.Duration, error) {
+	if err := db.DeleteStaleRateBuckets(now.Add(-staleAfter)); err != nil {
+		return false, 0, err
+	}
+
+	var ok bool
+	var wait time.Duration
+	err := db.UpdateRateBucket(key, func(bucket *models.RateBucket) {
+		bucket.Tokens, ok, wait = take(bucket.Tokens, bucket.LastRefill, limit, now)
+		bucket.LastRefill = now
+	})
+	if err != nil {
+		return false, 0, err
+	}
+
+	return ok, wait, nil
+}
+
+type memoryBucket struct {
+	tokens     float64
+	lastRefill time.Time
+}
+
+// Buckets stored in process memory
+type memoryBackend struct {
+	mu      sync.Mutex
+	buckets map[string]memoryBucket
+}
+
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{buckets: map[string]memoryBucket{}}
+}
+
+func (m *memoryBackend) Take(key string, limit Limit, now time.Time) (bool, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Drop stale buckets
+	for k, v := range m.buckets {
+		if now.Sub(v.lastRefill) > staleAfter {
+			delete(m.buckets, k)
+		}
+	}
+
+	bucket := m.buckets[key]
+	tokens, ok, wait := take(bucket.tokens, bucket.lastRefill, limit, now)
+	m.buckets[key] = memoryBucket{tokens: tokens, lastRefill: now}
+
+	return ok, wait, nil
+}
@@ -0,0 +1,149 @@
+package ratelimit
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Default per client IP limit for each endpoint
+const DefaultIPLimit = "30/1h"
+
+// Default per target email limit for each endpoint
+const DefaultEmailLimit = "10/1h"
+
+// Default per client IP and per email limit for the live login name
+// check, which runs as the invitee types
+const DefaultNameCheckLimit = "120/1h"
+
+// Token bucket, Burst requests at once refilled at Burst per Period
+type Limit struct {
+	Burst  int
+	Period time.Duration
+}
+
+// Zero limits do not throttle
+func (l Limit) Enabled() bool {
+	return l.Burst > 0 && l.Period > 0
+}
+
+// Parse limit as count/duration eg 10/1h
+// Empty, 0 or off disables the limit
+func ParseLimit(value string) (Limit, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || value == "0" || strings.EqualFold(value, "off") {
+		return Limit{}, nil
+	}
+
+	count, period, ok := strings.Cut(value, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("rate limit %q must be count/duration", value)
+	}
+	burst, err := strconv.Atoi(strings.TrimSpace(count))
+	if err != nil || burst < 0 {
+		return Limit{}, fmt.Errorf("rate limit %q has invalid count", value)
+	}
+	duration, err := time.ParseDuration(strings.TrimSpace(period))
+	if err != nil || duration <= 0 {
+		return Limit{}, fmt.Errorf("rate limit %q has invalid duration", value)
+	}
+
+	return Limit{Burst: burst, Period: duration}, nil
+}
+
+func configLimit(key string, fallback string) Limit {
+	value := fallback
+	if viper.IsSet(key) {
+		value = viper.GetString(key)
+	}
+	limit, err := ParseLimit(value)
+	if err != nil {
+		log.Println("Error in configLimit() " + key + ": " + err.Error())
+		limit, _ = ParseLimit(fallback)
+	}
+	return limit
+}
+
+// Per client IP limit, RATE_LIMIT_IP
+func IPLimit() Limit {
+	return configLimit("RATE_LIMIT_IP", DefaultIPLimit)
+}
+
+// Per target email limit, RATE_LIMIT_EMAIL
+func EmailLimit() Limit {
+	return configLimit("RATE_LIMIT_EMAIL", DefaultEmailLimit)
+}
+
+// Per client IP and per email limit for the login name check,
+// RATE_LIMIT_NAMECHECK
+func NameCheckLimit() Limit {
+	return configLimit("RATE_LIMIT_NAMECHECK", DefaultNameCheckLimit)
+}
+
+// Storage for buckets
+type Backend interface {
+	// Take one token from key, returns false and time until
+	// the next token if the bucket is empty
+	Take(key string, limit Limit, now time.Time) (bool, time.Duration, error)
+}
+
+type Limiter struct {
+	backend Backend
+}
+
+func NewLimiter(backend Backend) *Limiter {
+	return &Limiter{backend: backend}
+}
+
+// Limiter backed by the rate_buckets table, shared across replicas
+// using the same database
+func NewDBLimiter() *Limiter {
+	return NewLimiter(dbBackend{})
+}
+
+// Limiter in process memory, each replica counts separately
+func NewMemoryLimiter() *Limiter {
+	return NewLimiter(newMemoryBackend())
+}
+
+// Limiter chosen by RATE_LIMIT_STORE, db (default) or memory
+func New() *Limiter {
+	if viper.GetString("RATE_LIMIT_STORE") == "memory" {
+		return NewMemoryLimiter()
+	}
+	return NewDBLimiter()
+}
+
+// Take a token for key
+// Disabled limits always allow
+func (l *Limiter) Allow(key string, limit Limit) (bool, time.Duration, error) {
+	if !limit.Enabled() {
+		return true, 0, nil
+	}
+	return l.backend.Take(key, limit, time.Now())
+}
+
+// Refill bucket since last and take a token
+// A bucket never seen has a zero last and starts full
+func take(tokens float64, last time.Time, limit Limit, now time.Time) (float64, bool, time.Duration) {
+	burst := float64(limit.Burst)
+	rate := burst / limit.Period.Seconds()
+
+	if last.IsZero() {
+		tokens = burst
+	} else if elapsed := now.Sub(last).Seconds(); elapsed > 0 {
+		tokens = math.Min(burst, tokens+elapsed*rate)
+	}
+
+	if tokens >= 1 {
+		return tokens - 1, true, 0
+	}
+
+	wait := time.Duration((1 - tokens) / rate * float64(time.Second))
+	return tokens, false, wait
+}
@@ -0,0 +1,145 @@
+package ratelimit
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hadleyso/netid-activate/src/db"
+	"github.com/hadleyso/netid-activate/src/models"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLimit(t *testing.T) {
+	limit, err := ParseLimit("10/1h")
+	assert.NoError(t, err)
+	assert.Equal(t, Limit{Burst: 10, Period: time.Hour}, limit)
+	assert.True(t, limit.Enabled())
+
+	for _, value := range []string{"", "0", "off", "OFF"} {
+		limit, err := ParseLimit(value)
+		assert.NoError(t, err)
+		assert.False(t, limit.Enabled(), value)
+	}
+
+	for _, value := range []string{"10", "x/1h", "10/x", "-1/1h", "10/0s", "10/-1m"} {
+		_, err := ParseLimit(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestConfigLimits(t *testing.T) {
+	defer viper.Set("RATE_LIMIT_IP", nil)
+	defer viper.Set("RATE_LIMIT_EMAIL", nil)
+
+	viper.Set("RATE_LIMIT_IP", nil)
+	viper.Set("RATE_LIMIT_EMAIL", nil)
+	assert.Equal(t, Limit{Burst: 30, Period: time.Hour}, IPLimit())
+	assert.Equal(t, Limit{Burst: 10, Period: time.Hour}, EmailLimit())
+
+	viper.Set("RATE_LIMIT_IP", "5/10m")
+	viper.Set("RATE_LIMIT_EMAIL", "off")
+	assert.Equal(t, Limit{Burst: 5, Period: 10 * time.Minute}, IPLimit())
+	assert.False(t, EmailLimit().Enabled())
+
+	// Invalid values fall back to the default
+	viper.Set("RATE_LIMIT_IP", "lots")
+	assert.Equal(t, Limit{Burst: 30, Period: time.Hour}, IPLimit())
+}
+
+func TestNameCheckLimit(t *testing.T) {
+	defer viper.Set("RATE_LIMIT_NAMECHECK", nil)
+
+	viper.Set("RATE_LIMIT_NAMECHECK", nil)
+	assert.Equal(t, Limit{Burst: 120, Period: time.Hour}, NameCheckLimit())
+
+	viper.Set("RATE_LIMIT_NAMECHECK", "20/1m")
+	assert.Equal(t, Limit{Burst: 20, Period: time.Minute}, NameCheckLimit())
+}
+
+func TestTake(t *testing.T) {
+	limit := Limit{Burst: 2, Period: time.Minute}
+	now := time.Now()
+
+	// New bucket starts full
+	tokens, ok, _ := take(0, time.Time{}, limit, now)
+	assert.True(t, ok)
+	assert.Equal(t, float64(1), tokens)
+
+	tokens, ok, _ = take(tokens, now, limit, now)
+	assert.True(t, ok)
+	assert.Equal(t, float64(0), tokens)
+
+	// Empty, next token in 30s
+	tokens, ok, wait := take(tokens, now, limit, now)
+	assert.False(t, ok)
+	assert.Equal(t, 30*time.Second, wait)
+
+	// Refilled after 30s
+	_, ok, _ = take(tokens, now, limit, now.Add(30*time.Second))
+	assert.True(t, ok)
+
+	// Refill is capped at burst
+	tokens, ok, _ = take(0, now, limit, now.Add(time.Hour))
+	assert.True(t, ok)
+	assert.Equal(t, float64(1), tokens)
+}
+
+func testLimiter(t *testing.T, limiter *Limiter) {
+	limit := Limit{Burst: 3, Period: time.Hour}
+
+	for i := 0; i < 3; i++ {
+		ok, _, err := limiter.Allow("ip:otp:192.0.2.1", limit)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	}
+
+	ok, wait, err := limiter.Allow("ip:otp:192.0.2.1", limit)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Greater(t, wait, time.Duration(0))
+
+	// Other keys are separate
+	ok, _, err = limiter.Allow("ip:otp:192.0.2.2", limit)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// Disabled limits always allow
+	ok, _, err = limiter.Allow("ip:otp:192.0.2.1", Limit{})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestMemoryLimiter(t *testing.T) {
+	testLimiter(t, NewMemoryLimiter())
+}
+
+func TestDBLimiter(t *testing.T) {
+	dbPath := "test_ratelimit_limiter.db"
+	viper.Set("DB_PATH", dbPath)
+	database := db.DbConnect()
+	assert.NoError(t, database.AutoMigrate(&models.RateBucket{}))
+	t.Cleanup(func() {
+		dbInstance, _ := database.DB()
+		dbInstance.Close()
+		os.Remove(dbPath)
+	})
+
+	testLimiter(t, NewDBLimiter())
+
+	// Shared between limiters using the same database
+	ok, _, err := NewDBLimiter().Allow("ip:otp:192.0.2.1", Limit{Burst: 3, Period: time.Hour})
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestNew(t *testing.T) {
+	defer viper.Set("RATE_LIMIT_STORE", nil)
+
+	viper.Set("RATE_LIMIT_STORE", "memory")
+	assert.IsType(t, &memoryBackend{}, New().backend)
+
+	viper.Set("RATE_LIMIT_STORE", nil)
+	assert.IsType(t, dbBackend{}, New().backend)
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
 
 	"github.com/spf13/viper"
 )
@@ -67,3 +68,54 @@ func CheckUsernamesExists(loginNames []string) ([]string, error) {
 
 	return okUsername, nil
 }
+
+// Checks if login name is unused by active and staged users
+func LoginNameAvailable(loginName string) (bool, error) {
+	client, errClient := newHTTPClient(false)
+	if errClient != nil {
+		log.Println("LoginNameAvailable() unable to newHTTPClient() " + errClient.Error())
+		return false, errClient
+	}
+
+	errLogin := login(client, viper.GetString("IDM_USERNAME"), viper.GetString("IDM_PASSWORD"))
+	if errLogin != nil {
+		log.Println("LoginNameAvailable() unable to login() with HTTPClient " + errLogin.Error())
+		return false, errLogin
+	}
+
+	for _, find := range []func(*http.Client, string) (any, error){findUserByLogin, findStageUserByLogin} {
+		rpcResponse, errRPC := find(client, loginName)
+		if errRPC != nil {
+			return false, errRPC
+		}
+
+		count, err := resultCount(rpcResponse)
+		if err != nil {
+			return false, err
+		}
+		if count > 0 {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// Count from a find response
+func resultCount(rpcResponse any) (int, error) {
+	data, ok := rpcResponse.(map[string]interface{})
+	if !ok {
+		return 0, fmt.Errorf("unexpected response type: %T", rpcResponse)
+	}
+
+	num, ok := data["count"].(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("count is not JSON.Number: %T", data["count"])
+	}
+
+	count, err := num.Int64()
+	if err != nil {
+		return 0, fmt.Errorf("invalid count value %q: %w", num, err)
+	}
+	return int(count), nil
+}
@@ -1,7 +1,9 @@
 package idm
 
 import (
+	"encoding/json"
 	"net/http"
+	"strconv"
 	"strings"
 	"testing"
 )
@@ -21,3 +23,46 @@ func TestCheckUsernamesExists_LoginFailure(t *testing.T) {
 		t.Errorf("expected error to contain 'RedHat IdM login failed', but got: %s", err.Error())
 	}
 }
+
+func TestLoginNameAvailable(t *testing.T) {
+	activeUsers := map[string]bool{"active1": true}
+	stagedUsers := map[string]bool{"staged1": true}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ipa/session/login_password", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/ipa/session/json", func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+		var options map[string]any
+		json.Unmarshal(payload.Params[1], &options)
+		uid, _ := options["uid"].(string)
+
+		users := activeUsers
+		if payload.Method == "stageuser_find" {
+			users = stagedUsers
+		}
+		count := 0
+		if users[uid] {
+			count = 1
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result": {"count": ` + strconv.Itoa(count) + `}}`))
+	})
+	_ = setupIDMTestServerReplicate(t, mux)
+
+	tests := map[string]bool{"active1": false, "staged1": false, "free1": true}
+	for name, expected := range tests {
+		available, err := LoginNameAvailable(name)
+		if err != nil {
+			t.Fatalf("LoginNameAvailable(%q) error: %v", name, err)
+		}
+		if available != expected {
+			t.Errorf("LoginNameAvailable(%q) = %v, expected %v", name, available, expected)
+		}
+	}
+}
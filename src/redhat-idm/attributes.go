@@ -0,0 +1,191 @@
+package idm
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/hadleyso/netid-activate/src/config"
+	"github.com/hadleyso/netid-activate/src/countries"
+	"github.com/spf13/viper"
+)
+
+// user_add options IDM_ATTRIBUTES may set, true if multi-valued
+// uid, mail, userpassword and ipasshpubkey are always set from the invite
+var AllowedAttributes = map[string]bool{
+	"givenname":                false,
+	"sn":                       false,
+	"cn":                       false,
+	"displayname":              false,
+	"initials":                 false,
+	"gecos":                    false,
+	"title":                    false,
+	"manager":                  false,
+	"st":                       false,
+	"l":                        false,
+	"street":                   false,
+	"postalcode":               false,
+	"ou":                       false,
+	"telephonenumber":          true,
+	"mobile":                   true,
+	"pager":                    true,
+	"facsimiletelephonenumber": true,
+	"carlicense":               true,
+	"employeenumber":           false,
+	"employeetype":             false,
+	"departmentnumber":         true,
+	"preferredlanguage":        false,
+	"userclass":                true,
+	"loginshell":               false,
+}
+
+// Templates for user_add options when not set in IDM_ATTRIBUTES
+var DefaultAttributes = map[string]string{
+	"givenname":   "{{.FirstName}}",
+	"sn":          "{{.LastName}}",
+	"cn":          "{{.FirstName}} {{.LastName}}",
+	"displayname": "{{.FirstName}} {{.LastName}}",
+	"initials":    "{{.Initials}}",
+	"gecos":       "{{.FirstName}} {{.LastName}}",
+	"st":          "{{.State}}, {{.CountryName}}",
+	"manager":     "{{.Inviter}}",
+	"pager":       "{{.CountryAlpha2}}",
+}
+
+// GECOS template when IDM_GECOS is true
+const gecosWithAffiliation = "{{.FirstName}} {{.LastName}} ({{.Country}} {{.Affiliation}})"
+
+// Invite fields available to IDM_ATTRIBUTES templates
+type AttributeData struct {
+	LoginName     string
+	FirstName     string
+	LastName      string
+	Initials      string
+	Email         string
+	State         string
+	Country       string // alpha-3
+	CountryAlpha2 string
+	CountryName   string
+	Affiliation   string
+	Inviter       string
+	Fields        map[string]string // INVITE_FIELDS by name
+}
+
+var attributeFuncs = template.FuncMap{
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// Template data for an invitee, the country must be a known alpha-3 code
+// Every INVITE_FIELDS name is set, invites from before a field was
+// added have it empty
+func newAttributeData(loginName string, email string, firstName string, lastName string, country string, affiliation string, st string, inviter string, fields map[string]string) (AttributeData, error) {
+	alpha2, err := countries.GetAlpha2FromAlpha3(country)
+	if err != nil {
+		return AttributeData{}, err
+	}
+	countryName, err := countries.GetNameFromAlpha3(country)
+	if err != nil {
+		return AttributeData{}, err
+	}
+
+	return AttributeData{
+		LoginName:     loginName,
+		FirstName:     firstName,
+		LastName:      lastName,
+		Initials:      strings.ToUpper(firstName[:1] + lastName[:1]),
+		Email:         email,
+		State:         st,
+		Country:       country,
+		CountryAlpha2: alpha2,
+		CountryName:   countryName,
+		Affiliation:   affiliation,
+		Inviter:       inviter,
+		Fields:        inviteFields(fields),
+	}, nil
+}
+
+// Values for every INVITE_FIELDS name
+func inviteFields(values map[string]string) map[string]string {
+	fields := map[string]string{}
+	for _, field := range config.C.InviteFields {
+		fields[field.Name] = values[field.Name]
+	}
+	return fields
+}
+
+// Parsed templates from DefaultAttributes and IDM_ATTRIBUTES by option
+// An empty template in IDM_ATTRIBUTES drops a default
+func attributeTemplates() (map[string]*template.Template, error) {
+	sources := map[string]string{}
+	for option, source := range DefaultAttributes {
+		sources[option] = source
+	}
+	if viper.GetString("IDM_GECOS") == "true" {
+		sources["gecos"] = gecosWithAffiliation
+	}
+	for option, source := range viper.GetStringMapString("IDM_ATTRIBUTES") {
+		sources[strings.ToLower(option)] = source
+	}
+
+	templates := map[string]*template.Template{}
+	for option, source := range sources {
+		if _, ok := AllowedAttributes[option]; !ok {
+			return nil, fmt.Errorf("IDM_ATTRIBUTES %s is not an allowed user_add option, use one of %s", option, strings.Join(allowedAttributeNames(), ", "))
+		}
+		if source == "" {
+			continue
+		}
+		tmpl, err := template.New(option).Funcs(attributeFuncs).Option("missingkey=error").Parse(source)
+		if err != nil {
+			return nil, fmt.Errorf("IDM_ATTRIBUTES %s: %w", option, err)
+		}
+		templates[option] = tmpl
+	}
+	return templates, nil
+}
+
+// Render user_add options for data, empty results are left out
+func renderAttributes(data AttributeData) (map[string]any, error) {
+	templates, err := attributeTemplates()
+	if err != nil {
+		return nil, err
+	}
+
+	options := map[string]any{}
+	for option, tmpl := range templates {
+		var b strings.Builder
+		if err := tmpl.Execute(&b, data); err != nil {
+			return nil, fmt.Errorf("IDM_ATTRIBUTES %s: %w", option, err)
+		}
+		value := strings.TrimSpace(b.String())
+		if value == "" {
+			continue
+		}
+		if AllowedAttributes[option] {
+			options[option] = []string{value}
+		} else {
+			options[option] = value
+		}
+	}
+	return options, nil
+}
+
+// Check IDM_ATTRIBUTES options are allowed and templates only use
+// known fields, run at startup
+func CheckAttributes() error {
+	data := AttributeData{FirstName: "A", LastName: "B", Fields: inviteFields(nil)}
+	_, err := renderAttributes(data)
+	return err
+}
+
+// Allowed user_add options in name order
+func allowedAttributeNames() []string {
+	var names []string
+	for option := range AllowedAttributes {
+		names = append(names, option)
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,178 @@
+package idm
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/hadleyso/netid-activate/src/config"
+	"github.com/spf13/viper"
+)
+
+// Reset IDM_ATTRIBUTES and IDM_GECOS after test
+func resetAttributes(t *testing.T) {
+	t.Cleanup(func() {
+		viper.Set("IDM_ATTRIBUTES", nil)
+		viper.Set("IDM_GECOS", nil)
+	})
+}
+
+func testAttributeData(t *testing.T) AttributeData {
+	data, err := newAttributeData("jdoe", "jdoe@example.com", "john", "Doe", "USA", "staff", "CA", "mgr001", nil)
+	if err != nil {
+		t.Fatalf("newAttributeData failed: %v", err)
+	}
+	return data
+}
+
+func TestRenderAttributesDefaults(t *testing.T) {
+	resetAttributes(t)
+
+	options, err := renderAttributes(testAttributeData(t))
+	if err != nil {
+		t.Fatalf("renderAttributes failed: %v", err)
+	}
+	expected := map[string]any{
+		"givenname":   "john",
+		"sn":          "Doe",
+		"cn":          "john Doe",
+		"displayname": "john Doe",
+		"initials":    "JD",
+		"gecos":       "john Doe",
+		"st":          "CA, United States of America",
+		"manager":     "mgr001",
+		"pager":       []string{"US"},
+	}
+	if !reflect.DeepEqual(options, expected) {
+		t.Errorf("expected %v, got %v", expected, options)
+	}
+
+	viper.Set("IDM_GECOS", "true")
+	options, _ = renderAttributes(testAttributeData(t))
+	if options["gecos"] != "john Doe (USA staff)" {
+		t.Errorf("expected GECOS with country and affiliation, got %v", options["gecos"])
+	}
+}
+
+func TestRenderAttributesConfig(t *testing.T) {
+	resetAttributes(t)
+	viper.Set("IDM_ATTRIBUTES", map[string]string{
+		"gecos":        "{{.FirstName}} {{.LastName}} ({{.Country}})",
+		"EmployeeType": "{{upper .Affiliation}}",
+		"mobile":       "{{.State}}",
+		"pager":        "",
+		"manager":      "{{if eq .Affiliation \"staff\"}}{{else}}{{.Inviter}}{{end}}",
+	})
+
+	options, err := renderAttributes(testAttributeData(t))
+	if err != nil {
+		t.Fatalf("renderAttributes failed: %v", err)
+	}
+	if options["gecos"] != "john Doe (USA)" {
+		t.Errorf("expected mapped GECOS, got %v", options["gecos"])
+	}
+	if options["employeetype"] != "STAFF" {
+		t.Errorf("expected employeetype STAFF, got %v", options["employeetype"])
+	}
+	if !reflect.DeepEqual(options["mobile"], []string{"CA"}) {
+		t.Errorf("expected multi-valued mobile, got %v", options["mobile"])
+	}
+	// Empty template drops the default, empty result leaves the option out
+	if _, ok := options["pager"]; ok {
+		t.Errorf("expected no pager, got %v", options["pager"])
+	}
+	if _, ok := options["manager"]; ok {
+		t.Errorf("expected no manager, got %v", options["manager"])
+	}
+	if options["cn"] != "john Doe" {
+		t.Errorf("expected default cn, got %v", options["cn"])
+	}
+}
+
+func TestCheckAttributes(t *testing.T) {
+	resetAttributes(t)
+
+	if err := CheckAttributes(); err != nil {
+		t.Errorf("expected defaults to pass, got %v", err)
+	}
+
+	tests := map[string]map[string]string{
+		"not allowed":   {"uid": "{{.LoginName}}"},
+		"fixed option":  {"userpassword": "x"},
+		"unknown field": {"title": "{{.Phone}}"},
+		"parse error":   {"title": "{{.FirstName"},
+	}
+	for name, attributes := range tests {
+		viper.Set("IDM_ATTRIBUTES", attributes)
+		if err := CheckAttributes(); err == nil {
+			t.Errorf("%s: expected CheckAttributes to fail", name)
+		}
+	}
+}
+
+func TestRenderAttributesInviteFields(t *testing.T) {
+	resetAttributes(t)
+	config.C.InviteFields = []config.InviteField{{Name: "employee_id"}, {Name: "end_date", Type: "date"}}
+	t.Cleanup(func() { config.C.InviteFields = nil })
+	viper.Set("IDM_ATTRIBUTES", map[string]string{
+		"employeenumber": "{{.Fields.employee_id}}",
+		"title":          "{{.Fields.end_date}}",
+	})
+
+	if err := CheckAttributes(); err != nil {
+		t.Errorf("expected invite fields to be known, got %v", err)
+	}
+
+	// Invite from before end_date was added
+	data, err := newAttributeData("jdoe", "jdoe@example.com", "John", "Doe", "USA", "staff", "CA", "mgr001", map[string]string{"employee_id": "123456"})
+	if err != nil {
+		t.Fatalf("newAttributeData failed: %v", err)
+	}
+	options, err := renderAttributes(data)
+	if err != nil {
+		t.Fatalf("renderAttributes failed: %v", err)
+	}
+	if options["employeenumber"] != "123456" {
+		t.Errorf("expected employeenumber from invite field, got %v", options["employeenumber"])
+	}
+	if _, ok := options["title"]; ok {
+		t.Errorf("expected no title, got %v", options["title"])
+	}
+
+	viper.Set("IDM_ATTRIBUTES", map[string]string{"title": "{{.Fields.project}}"})
+	if err := CheckAttributes(); err == nil {
+		t.Error("expected unknown invite field to fail")
+	}
+}
+
+func TestMakeUser_Attributes(t *testing.T) {
+	resetAttributes(t)
+	var options map[string]any
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ipa/session/json", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Params []json.RawMessage `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		json.Unmarshal(req.Params[1], &options)
+		writeJSONRPCResponse(w, map[string]any{"result": map[string]any{"uid": "jdoe"}}, nil)
+	})
+	ts := setupIDMTestServer(t, mux)
+	viper.Set("IDM_HOST", ts.URL)
+	viper.Set("IDM_ATTRIBUTES", map[string]string{"title": "{{.Affiliation}} invited by {{.Inviter}}"})
+
+	client, _ := newHTTPClient(false)
+	if _, err := makeUser(client, "jdoe", "jdoe@example.com", "John", "Doe", "USA", "staff", "secret", "CA", "mgr001", nil, nil); err != nil {
+		t.Fatalf("makeUser failed: %v", err)
+	}
+	if options["title"] != "staff invited by mgr001" {
+		t.Errorf("expected mapped title, got %v", options["title"])
+	}
+	if options["userpassword"] != "secret" || options["all"] != true {
+		t.Errorf("expected fixed options, got %v", options)
+	}
+	if mail, _ := options["mail"].([]any); len(mail) != 1 || mail[0] != "jdoe@example.com" {
+		t.Errorf("expected mail from invite, got %v", options["mail"])
+	}
+}
@@ -0,0 +1,185 @@
+package idm
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	krbclient "github.com/jcmturner/gokrb5/v8/client"
+	krbconfig "github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/spf13/viper"
+)
+
+// IdM login methods for IDM_AUTH
+const (
+	AuthPassword = "password"
+	AuthKerberos = "kerberos"
+	AuthX509     = "x509"
+)
+
+// Kerberos config when IDM_KRB5_CONF is not set
+const DefaultKrb5Conf = "/etc/krb5.conf"
+
+// Login method from IDM_AUTH, password when not set
+func authMode() string {
+	if mode := viper.GetString("IDM_AUTH"); mode != "" {
+		return mode
+	}
+	return AuthPassword
+}
+
+// Check IDM_AUTH and the credentials it needs, run at startup
+func CheckAuth() error {
+	switch mode := authMode(); mode {
+	case AuthPassword:
+		return nil
+	case AuthKerberos:
+		krb, err := newKerberosClient()
+		if err != nil {
+			return err
+		}
+		krb.Destroy()
+		return nil
+	case AuthX509:
+		_, err := clientCertificate()
+		return err
+	default:
+		return fmt.Errorf("IDM_AUTH %q must be password, kerberos or x509", mode)
+	}
+}
+
+// Check IdM hosts, login and attribute settings, run at startup
+func CheckConfig() error {
+	if err := CheckHosts(); err != nil {
+		return err
+	}
+	if err := CheckAuth(); err != nil {
+		return err
+	}
+	return CheckAttributes()
+}
+
+// Kerberos client for IDM_PRINCIPAL with keys from IDM_KEYTAB
+// The principal realm defaults to default_realm of IDM_KRB5_CONF
+func newKerberosClient() (*krbclient.Client, error) {
+	confPath := viper.GetString("IDM_KRB5_CONF")
+	if confPath == "" {
+		confPath = DefaultKrb5Conf
+	}
+	cfg, err := krbconfig.Load(confPath)
+	if err != nil {
+		return nil, fmt.Errorf("IDM_KRB5_CONF %s: %w", confPath, err)
+	}
+
+	kt, err := keytab.Load(viper.GetString("IDM_KEYTAB"))
+	if err != nil {
+		return nil, fmt.Errorf("IDM_KEYTAB %s: %w", viper.GetString("IDM_KEYTAB"), err)
+	}
+
+	principal := viper.GetString("IDM_PRINCIPAL")
+	username, realm, found := strings.Cut(principal, "@")
+	if !found {
+		realm = cfg.LibDefaults.DefaultRealm
+	}
+	if username == "" || realm == "" {
+		return nil, fmt.Errorf("IDM_PRINCIPAL %q must be user@REALM or krb5.conf must set default_realm", principal)
+	}
+
+	return krbclient.NewWithKeytab(username, realm, kt, cfg, krbclient.DisablePAFXFAST(true)), nil
+}
+
+// IdM issued certificate and key from IDM_CERT_PATH and IDM_KEY_PATH
+func clientCertificate() (tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(viper.GetString("IDM_CERT_PATH"), viper.GetString("IDM_KEY_PATH"))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("IDM_CERT_PATH and IDM_KEY_PATH: %w", err)
+	}
+	return cert, nil
+}
+
+// Present the client certificate for x509 login
+// IdM asks for the certificate on /ipa/session/login_x509 only, which
+// needs TLS 1.2 renegotiation as Go has no TLS 1.3 post-handshake auth
+func setClientCertificate(tlsConfig *tls.Config) error {
+	if authMode() != AuthX509 {
+		return nil
+	}
+	cert, err := clientCertificate()
+	if err != nil {
+		return err
+	}
+	tlsConfig.Certificates = []tls.Certificate{cert}
+	tlsConfig.Renegotiation = tls.RenegotiateOnceAsClient
+	tlsConfig.MaxVersion = tls.VersionTLS12
+	return nil
+}
+
+// Log in to host with the IDM_AUTH method
+// Returns if another host should be tried when login fails
+func loginHost(client *http.Client, host string, user string, password string) (bool, error) {
+	var req *http.Request
+	var err error
+	switch authMode() {
+	case AuthKerberos:
+		req, err = kerberosLoginRequest(host)
+	case AuthX509:
+		req, err = http.NewRequest("POST", host+"/ipa/session/login_x509", nil)
+	default:
+		form := url.Values{
+			"user":     {user},
+			"password": {password},
+		}
+		req, err = http.NewRequest("POST", host+"/ipa/session/login_password", strings.NewReader(form.Encode()))
+	}
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Referer", host+"/ipa")
+	req.Header.Set("Accept", "text/plain")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Println("RedHat IdM login failed: " + resp.Status)
+		return resp.StatusCode >= http.StatusInternalServerError, errors.New("RedHat IdM login failed")
+	}
+	return false, nil
+}
+
+// login_kerberos request with a SPNEGO token for HTTP/<host>
+func kerberosLoginRequest(host string) (*http.Request, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, err
+	}
+
+	krb, err := newKerberosClient()
+	if err != nil {
+		return nil, err
+	}
+	defer krb.Destroy()
+	if err := krb.Login(); err != nil {
+		return nil, fmt.Errorf("Kerberos login as %s: %w", krb.Credentials.CName().PrincipalNameString(), err)
+	}
+
+	req, err := http.NewRequest("POST", host+"/ipa/session/login_kerberos", nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := spnego.SetSPNEGOHeader(krb, req, "HTTP/"+u.Hostname()); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
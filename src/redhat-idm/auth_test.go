@@ -0,0 +1,282 @@
+package idm
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/spf13/viper"
+)
+
+// Reset IDM_AUTH and its settings after test
+func resetAuth(t *testing.T) {
+	t.Cleanup(func() {
+		for _, key := range []string{"IDM_AUTH", "IDM_KEYTAB", "IDM_PRINCIPAL", "IDM_KRB5_CONF", "IDM_CERT_PATH", "IDM_KEY_PATH", "CACERT_PATH"} {
+			viper.Set(key, nil)
+		}
+	})
+}
+
+// Self-signed certificate and key for commonName, written as PEM to dir
+func writeCertificate(t *testing.T, dir string, commonName string, usage x509.ExtKeyUsage) (*x509.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{usage},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPath := filepath.Join(dir, commonName+".crt")
+	keyPath := filepath.Join(dir, commonName+".key")
+	os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return cert, certPath, keyPath
+}
+
+// IdM replica over TLS accepting x509 login with clientCert
+// Sets CACERT_PATH to trust the replica
+func newX509Replica(t *testing.T, clientCert *x509.Certificate, loginStatus int, requests *atomic.Int32) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ipa/session/login_x509", func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Referer() == "" {
+			t.Error("expected Referer on login_x509")
+		}
+		if len(r.TLS.PeerCertificates) == 0 || r.TLS.PeerCertificates[0].Subject.CommonName != "netid-activate" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "ipa_session", Value: "x509", Path: "/ipa"})
+		w.WriteHeader(loginStatus)
+	})
+	mux.HandleFunc("/ipa/session/json", func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if cookie, err := r.Cookie("ipa_session"); err != nil || cookie.Value != "x509" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		writeJSONRPCResponse(w, map[string]any{"count": 0, "result": []any{}}, nil)
+	})
+
+	server := httptest.NewUnstartedServer(mux)
+	pool := x509.NewCertPool()
+	pool.AddCert(clientCert)
+	server.TLS = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: pool}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	caPath := filepath.Join(t.TempDir(), "ca.crt")
+	os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+	viper.Set("CACERT_PATH", caPath)
+	return server
+}
+
+func TestCheckAuth(t *testing.T) {
+	resetAuth(t)
+
+	if err := CheckAuth(); err != nil {
+		t.Errorf("expected password mode by default, got %v", err)
+	}
+
+	viper.Set("IDM_AUTH", "token")
+	if err := CheckAuth(); err == nil {
+		t.Error("expected unknown IDM_AUTH to fail")
+	}
+
+	viper.Set("IDM_AUTH", AuthX509)
+	viper.Set("IDM_CERT_PATH", filepath.Join(t.TempDir(), "missing.crt"))
+	if err := CheckAuth(); err == nil {
+		t.Error("expected missing certificate to fail")
+	}
+
+	dir := t.TempDir()
+	_, certPath, keyPath := writeCertificate(t, dir, "netid-activate", x509.ExtKeyUsageClientAuth)
+	viper.Set("IDM_CERT_PATH", certPath)
+	viper.Set("IDM_KEY_PATH", keyPath)
+	if err := CheckAuth(); err != nil {
+		t.Errorf("expected certificate to load, got %v", err)
+	}
+
+	viper.Set("IDM_AUTH", AuthKerberos)
+	viper.Set("IDM_KRB5_CONF", filepath.Join(dir, "missing.conf"))
+	if err := CheckAuth(); err == nil {
+		t.Error("expected missing krb5.conf to fail")
+	}
+}
+
+func TestLoginX509(t *testing.T) {
+	resetHosts(t)
+	resetAuth(t)
+	clientCert, certPath, keyPath := writeCertificate(t, t.TempDir(), "netid-activate", x509.ExtKeyUsageClientAuth)
+	var requests atomic.Int32
+	server := newX509Replica(t, clientCert, http.StatusOK, &requests)
+	viper.Set("IDM_HOST", server.URL)
+	viper.Set("IDM_AUTH", AuthX509)
+	viper.Set("IDM_CERT_PATH", certPath)
+	viper.Set("IDM_KEY_PATH", keyPath)
+
+	client, err := newHTTPClient(false)
+	if err != nil {
+		t.Fatalf("newHTTPClient failed: %v", err)
+	}
+	if err := login(client, "", ""); err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	if _, err := findUserByLogin(client, "jdoe"); err != nil {
+		t.Errorf("findUserByLogin failed: %v", err)
+	}
+	if requests.Load() != 2 {
+		t.Errorf("expected 2 requests, got %d", requests.Load())
+	}
+}
+
+func TestLoginX509NoCertificate(t *testing.T) {
+	resetHosts(t)
+	resetAuth(t)
+	clientCert, _, _ := writeCertificate(t, t.TempDir(), "netid-activate", x509.ExtKeyUsageClientAuth)
+	var requests atomic.Int32
+	server := newX509Replica(t, clientCert, http.StatusOK, &requests)
+	viper.Set("IDM_HOST", server.URL)
+	viper.Set("IDM_AUTH", AuthX509)
+
+	// Certificate is required before any request is sent
+	if _, err := newHTTPClient(false); err == nil {
+		t.Error("expected newHTTPClient to fail without a certificate")
+	}
+
+	// A certificate IdM does not map to a user is rejected on every replica
+	_, certPath, keyPath := writeCertificate(t, t.TempDir(), "someone-else", x509.ExtKeyUsageClientAuth)
+	viper.Set("IDM_CERT_PATH", certPath)
+	viper.Set("IDM_KEY_PATH", keyPath)
+	client, err := newHTTPClient(false)
+	if err != nil {
+		t.Fatalf("newHTTPClient failed: %v", err)
+	}
+	if err := login(client, "", ""); err == nil {
+		t.Error("expected login to fail")
+	}
+	if hosts := orderedHosts(); hosts[0] != server.URL {
+		t.Errorf("expected %s not marked down, got %v", server.URL, hosts)
+	}
+}
+
+// Keytab for svc-activate@EXAMPLE.COM and a krb5.conf whose KDC refuses
+// connections
+func writeKerberosConfig(t *testing.T) (string, string) {
+	dir := t.TempDir()
+
+	kt := keytab.New()
+	if err := kt.AddEntry("svc-activate", "EXAMPLE.COM", "Secret123", time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96); err != nil {
+		t.Fatal(err)
+	}
+	b, err := kt.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keytabPath := filepath.Join(dir, "activate.keytab")
+	os.WriteFile(keytabPath, b, 0600)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kdc := listener.Addr().String()
+	listener.Close()
+
+	conf := fmt.Sprintf(`[libdefaults]
+ default_realm = EXAMPLE.COM
+ dns_lookup_kdc = false
+ udp_preference_limit = 1
+
+[realms]
+ EXAMPLE.COM = {
+  kdc = %s
+ }
+`, kdc)
+	confPath := filepath.Join(dir, "krb5.conf")
+	os.WriteFile(confPath, []byte(conf), 0600)
+	return keytabPath, confPath
+}
+
+func TestCheckAuthKerberos(t *testing.T) {
+	resetAuth(t)
+	keytabPath, confPath := writeKerberosConfig(t)
+	viper.Set("IDM_AUTH", AuthKerberos)
+	viper.Set("IDM_KRB5_CONF", confPath)
+	viper.Set("IDM_KEYTAB", keytabPath)
+
+	// Realm from default_realm
+	viper.Set("IDM_PRINCIPAL", "svc-activate")
+	if err := CheckAuth(); err != nil {
+		t.Errorf("expected Kerberos config to load, got %v", err)
+	}
+
+	viper.Set("IDM_PRINCIPAL", "@EXAMPLE.COM")
+	if err := CheckAuth(); err == nil {
+		t.Error("expected principal without a name to fail")
+	}
+
+	viper.Set("IDM_PRINCIPAL", "svc-activate@EXAMPLE.COM")
+	viper.Set("IDM_KEYTAB", filepath.Join(t.TempDir(), "missing.keytab"))
+	if err := CheckAuth(); err == nil {
+		t.Error("expected missing keytab to fail")
+	}
+}
+
+func TestLoginKerberosKDCUnavailable(t *testing.T) {
+	resetHosts(t)
+	resetAuth(t)
+	var requests atomic.Int32
+	replica := newReplica(t, http.StatusOK, &requests)
+	keytabPath, confPath := writeKerberosConfig(t)
+	viper.Set("IDM_HOST", replica.URL)
+	viper.Set("IDM_AUTH", AuthKerberos)
+	viper.Set("IDM_KRB5_CONF", confPath)
+	viper.Set("IDM_KEYTAB", keytabPath)
+	viper.Set("IDM_PRINCIPAL", "svc-activate@EXAMPLE.COM")
+
+	client, _ := newHTTPClient(false)
+	if err := login(client, "", ""); err == nil {
+		t.Error("expected login to fail without a KDC")
+	}
+	// No ticket, nothing sent to IdM and the replica is not blamed
+	if requests.Load() != 0 {
+		t.Errorf("expected no requests to IdM, got %d", requests.Load())
+	}
+	if hosts := orderedHosts(); hosts[0] != replica.URL {
+		t.Errorf("expected %s not marked down, got %v", replica.URL, hosts)
+	}
+}
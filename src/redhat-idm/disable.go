@@ -0,0 +1,63 @@
+package idm
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/spf13/viper"
+)
+
+// IdM error codes user_disable returns for users already handled
+const (
+	notFoundCode        = 4001
+	alreadyInactiveCode = 4010
+)
+
+// Disable the user with login name so it can no longer log in
+// A user already disabled or deleted is not an error
+func DisableUser(loginName string) error {
+	client, errClient := newHTTPClient(false)
+	if errClient != nil {
+		log.Println("DisableUser() unable to newHTTPClient() " + errClient.Error())
+		return errClient
+	}
+
+	errLogin := login(client, viper.GetString("IDM_USERNAME"), viper.GetString("IDM_PASSWORD"))
+	if errLogin != nil {
+		log.Println("DisableUser() unable to login() with HTTPClient " + errLogin.Error())
+		return errLogin
+	}
+
+	return disableUser(client, loginName)
+}
+
+// Client must be authenticated
+func disableUser(client *http.Client, loginName string) error {
+	rpcClient := newRPCClient(client)
+
+	params := []any{
+		[]string{loginName},
+		map[string]any{},
+	}
+
+	resp, err := rpcClient.Call(context.Background(), "user_disable", params...)
+	if err != nil {
+		log.Println("disableUser() call error " + err.Error())
+		return err
+	}
+	if resp.Error != nil {
+		switch resp.Error.Code {
+		case alreadyInactiveCode:
+			return nil
+		case notFoundCode:
+			log.Println("disableUser() user " + loginName + " not found, nothing to disable")
+			return nil
+		}
+		log.Println("disableUser() response error " + resp.Error.Message)
+		return fmt.Errorf("RPC error: %v", resp.Error.Message)
+	}
+
+	return nil
+}
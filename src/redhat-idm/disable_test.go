@@ -0,0 +1,56 @@
+package idm
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestDisableUser(t *testing.T) {
+	resetHosts(t)
+	var method string
+	var uid []string
+	errorCode := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ipa/session/login_password", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/ipa/session/json", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		method = req.Method
+		json.Unmarshal(req.Params[0], &uid)
+		if errorCode != 0 {
+			writeJSONRPCResponse(w, nil, map[string]any{"code": errorCode, "message": "error"})
+			return
+		}
+		writeJSONRPCResponse(w, map[string]any{"result": true, "value": "jdoe"}, nil)
+	})
+	ts := setupIDMTestServer(t, mux)
+	viper.Set("IDM_HOST", ts.URL)
+
+	if err := DisableUser("jdoe"); err != nil {
+		t.Fatalf("DisableUser failed: %v", err)
+	}
+	if method != "user_disable" || len(uid) != 1 || uid[0] != "jdoe" {
+		t.Errorf("expected user_disable of jdoe, got %s %v", method, uid)
+	}
+
+	// Already disabled or deleted
+	for _, code := range []int{alreadyInactiveCode, notFoundCode} {
+		errorCode = code
+		if err := DisableUser("jdoe"); err != nil {
+			t.Errorf("expected error %d to be ignored, got %v", code, err)
+		}
+	}
+
+	errorCode = 2100
+	if err := DisableUser("jdoe"); err == nil {
+		t.Error("expected ACI error to fail")
+	}
+}
@@ -7,11 +7,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"net/http/cookiejar"
-	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	"errors"
 
@@ -20,81 +21,105 @@ import (
 	"github.com/ybbus/jsonrpc/v3"
 )
 
+// Time to connect to a host before trying the next one
+const dialTimeout = 5 * time.Second
+
+// Time for a whole request, replaced in tests
+var requestTimeout = 30 * time.Second
+
+// JSON-RPC client for the host client is logged in to
+func newRPCClient(client *http.Client) jsonrpc.RPCClient {
+	return jsonrpc.NewClientWithOpts(idmHost(client)+"/ipa/session/json",
+		&jsonrpc.RPCClientOpts{
+			AllowUnknownFields: true, // IdM returns principal
+			CustomHeaders: map[string]string{
+				"Referer":      idmHost(client) + "/ipa",
+				"Content-Type": "application/json",
+				"Accept":       "application/json",
+			},
+			HTTPClient: client,
+		})
+}
+
+// Certificates from CACERT_PATH, nil for the system roots when not set
+func RootCAs() (*x509.CertPool, error) {
+	caPath := viper.GetString("CACERT_PATH")
+	if caPath == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(caPath)
+	if err != nil {
+		log.Println("RootCAs() could not open cert file")
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if ok := pool.AppendCertsFromPEM(b); !ok {
+		log.Printf("RootCAs: no certs appended from %s", caPath)
+	}
+	return pool, nil
+}
+
 func newHTTPClient(insecureSkipVerify bool) (*http.Client, error) {
 	jar, err := cookiejar.New(nil)
 	if err != nil {
 		return nil, err
 	}
 
+	rootCAs, err := RootCAs()
+	if err != nil {
+		return nil, err
+	}
 	tlsConfig := &tls.Config{
 		InsecureSkipVerify: insecureSkipVerify,
+		RootCAs:            rootCAs,
 	}
-	if caPath := viper.GetString("CACERT_PATH"); caPath != "" {
-		b, err := os.ReadFile(caPath)
-		if err != nil {
-			log.Println("newHTTPClient() could not open cert file")
-			return nil, err
-		}
-		pool := x509.NewCertPool()
-		if ok := pool.AppendCertsFromPEM(b); !ok {
-			log.Printf("newHTTPClient: no certs appended from %s", caPath)
-		}
-		tlsConfig.RootCAs = pool
+	if err := setClientCertificate(tlsConfig); err != nil {
+		return nil, err
 	}
 
-	transport := &http.Transport{TLSClientConfig: tlsConfig}
+	transport := &http.Transport{
+		TLSClientConfig: tlsConfig,
+		DialContext:     (&net.Dialer{Timeout: dialTimeout}).DialContext,
+	}
 	return &http.Client{
 		Jar:       jar,
-		Transport: transport,
+		Transport: &idmTransport{base: transport},
+		Timeout:   requestTimeout,
 	}, nil
 }
 
+// Log in to the first configured host that answers, the client then
+// stays on that host so the session cookie is used
+// User and password are only used when IDM_AUTH is password
 func login(client *http.Client, user string, password string) error {
-	IDM_HOST := viper.GetString("IDM_HOST")
-	loginURL := IDM_HOST + "/ipa/session/login_password"
-	form := url.Values{
-		"user":     {user},
-		"password": {password},
+	hosts := orderedHosts()
+	if len(hosts) == 0 {
+		return errors.New("IDM_HOST or IDM_SRV_DOMAIN must be set")
 	}
 
-	body := strings.NewReader(form.Encode())
-
-	req, err := http.NewRequest("POST", loginURL, body)
-	if err != nil {
-		return err
-	}
-
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("Referer", IDM_HOST+"/ipa")
-	req.Header.Set("Accept", "text/plain")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		log.Println("RedHat IdM login failed: " + resp.Status)
-		return errors.New("RedHat IdM login failed")
+	var lastErr error
+	for _, host := range hosts {
+		failover, err := loginHost(client, host, user, password)
+		if err == nil {
+			markHostUp(host)
+			pinHost(client, host)
+			return nil
+		}
+		// Credentials are the same on every replica
+		if !failover {
+			return err
+		}
+		log.Println("login() IdM host " + host + " unavailable " + err.Error())
+		markHostDown(host)
+		lastErr = err
 	}
-	return nil
+	return lastErr
 }
 
 // Find user by email
 // client must be authenticated
 func findUserByEmail(client *http.Client, email string) (any, error) {
-	rpcURL := viper.GetString("IDM_HOST") + "/ipa/session/json"
-	rpcClient := jsonrpc.NewClientWithOpts(rpcURL,
-		&jsonrpc.RPCClientOpts{
-			AllowUnknownFields: true, // IdM returns principal
-			CustomHeaders: map[string]string{
-				"Referer":      viper.GetString("IDM_HOST") + "/ipa",
-				"Content-Type": "application/json",
-				"Accept":       "application/json",
-			},
-			HTTPClient: client,
-		})
+	rpcClient := newRPCClient(client)
 
 	// Params: 1st = query filters, 2nd = options
 	params := []any{
@@ -117,17 +142,7 @@ func findUserByEmail(client *http.Client, email string) (any, error) {
 // Find user by login name
 // client must be authenticated
 func findUserByLogin(client *http.Client, loginName string) (any, error) {
-	rpcURL := viper.GetString("IDM_HOST") + "/ipa/session/json"
-	rpcClient := jsonrpc.NewClientWithOpts(rpcURL,
-		&jsonrpc.RPCClientOpts{
-			AllowUnknownFields: true, // IdM returns principal
-			CustomHeaders: map[string]string{
-				"Referer":      viper.GetString("IDM_HOST") + "/ipa",
-				"Content-Type": "application/json",
-				"Accept":       "application/json",
-			},
-			HTTPClient: client,
-		})
+	rpcClient := newRPCClient(client)
 
 	// Params: 1st = query filters, 2nd = options
 	params := []any{
@@ -147,19 +162,29 @@ func findUserByLogin(client *http.Client, loginName string) (any, error) {
 	return resp.Result, nil
 }
 
+func findStageUserByLogin(client *http.Client, loginName string) (any, error) {
+	rpcClient := newRPCClient(client)
+
+	// Params: 1st = query filters, 2nd = options
+	params := []any{
+		[]string{},
+		map[string]any{"all": true, "sizelimit": 1, "pkey_only": true, "uid": loginName},
+	}
+
+	resp, err := rpcClient.Call(context.Background(), "stageuser_find", params...)
+	if err != nil {
+		log.Println("findStageUserByLogin() call error")
+		return nil, err
+	}
+	if resp.Error != nil {
+		log.Println("findStageUserByLogin() response error")
+		return nil, fmt.Errorf("RPC error: %v", resp.Error.Message)
+	}
+	return resp.Result, nil
+}
+
 func getDN(client *http.Client) (string, error) {
-	// Set connection
-	rpcURL := viper.GetString("IDM_HOST") + "/ipa/session/json"
-	rpcClient := jsonrpc.NewClientWithOpts(rpcURL,
-		&jsonrpc.RPCClientOpts{
-			AllowUnknownFields: true, // IdM returns principal
-			CustomHeaders: map[string]string{
-				"Referer":      viper.GetString("IDM_HOST") + "/ipa",
-				"Content-Type": "application/json",
-				"Accept":       "application/json",
-			},
-			HTTPClient: client,
-		})
+	rpcClient := newRPCClient(client)
 
 	// Params
 	params := []any{
@@ -231,17 +256,7 @@ func getGroupBatch(client *http.Client, cns []string) (error, models.BatchRespon
 		return nil, models.BatchResponse{}
 	}
 
-	rpcURL := viper.GetString("IDM_HOST") + "/ipa/session/json"
-	rpcClient := jsonrpc.NewClientWithOpts(rpcURL,
-		&jsonrpc.RPCClientOpts{
-			AllowUnknownFields: true, // IdM returns principal
-			CustomHeaders: map[string]string{
-				"Referer":      viper.GetString("IDM_HOST") + "/ipa",
-				"Content-Type": "application/json",
-				"Accept":       "application/json",
-			},
-			HTTPClient: client,
-		})
+	rpcClient := newRPCClient(client)
 
 	resp, err := rpcClient.Call(context.Background(), "batch", batchParams, map[string]any{})
 	if err != nil {
@@ -0,0 +1,185 @@
+package idm
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// How long a host that failed is only tried after the healthy ones
+const hostRetryAfter = 30 * time.Second
+
+// How long IDM_SRV_DOMAIN lookups are reused
+const srvCacheTTL = 5 * time.Minute
+
+// Replaced in tests
+var lookupSRV = net.LookupSRV
+
+var hostsMu sync.Mutex
+
+// Hosts that failed, by host URL
+var hostDownUntil = map[string]time.Time{}
+
+var srvCache struct {
+	domain  string
+	hosts   []string
+	expires time.Time
+}
+
+// IdM server URLs from the _ldap._tcp SRV records of IDM_SRV_DOMAIN,
+// then the comma separated IDM_HOST list
+func configuredHosts() []string {
+	var hosts []string
+	if domain := viper.GetString("IDM_SRV_DOMAIN"); domain != "" {
+		srvHosts, err := lookupSRVHosts(domain)
+		if err != nil {
+			log.Println("configuredHosts() unable to lookupSRVHosts() " + err.Error())
+		}
+		hosts = append(hosts, srvHosts...)
+	}
+	for _, host := range strings.Split(viper.GetString("IDM_HOST"), ",") {
+		host = strings.TrimRight(strings.TrimSpace(host), "/")
+		if host != "" && !slices.Contains(hosts, host) {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// https:// URLs of the IdM servers in the SRV records of domain, in
+// priority and weight order
+// The records are the LDAP ones IPA clients use, the JSON-RPC API is
+// served over HTTPS on the same servers
+func lookupSRVHosts(domain string) ([]string, error) {
+	hostsMu.Lock()
+	if srvCache.domain == domain && time.Now().Before(srvCache.expires) {
+		hosts := srvCache.hosts
+		hostsMu.Unlock()
+		return hosts, nil
+	}
+	hostsMu.Unlock()
+
+	_, records, err := lookupSRV("ldap", "tcp", domain)
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []string
+	for _, record := range records {
+		target := strings.TrimSuffix(record.Target, ".")
+		if target == "" {
+			continue
+		}
+		hosts = append(hosts, "https://"+target)
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no _ldap._tcp.%s SRV records", domain)
+	}
+
+	hostsMu.Lock()
+	srvCache.domain = domain
+	srvCache.hosts = hosts
+	srvCache.expires = time.Now().Add(srvCacheTTL)
+	hostsMu.Unlock()
+	return hosts, nil
+}
+
+// Configured hosts, the healthy ones first
+func orderedHosts() []string {
+	hosts := configuredHosts()
+
+	hostsMu.Lock()
+	defer hostsMu.Unlock()
+
+	now := time.Now()
+	var healthy, down []string
+	for _, host := range hosts {
+		if now.Before(hostDownUntil[host]) {
+			down = append(down, host)
+		} else {
+			healthy = append(healthy, host)
+		}
+	}
+	return append(healthy, down...)
+}
+
+func markHostDown(host string) {
+	hostsMu.Lock()
+	defer hostsMu.Unlock()
+	hostDownUntil[host] = time.Now().Add(hostRetryAfter)
+}
+
+func markHostUp(host string) {
+	hostsMu.Lock()
+	defer hostsMu.Unlock()
+	delete(hostDownUntil, host)
+}
+
+// Check IDM_HOST entries are URLs, run at startup
+func CheckHosts() error {
+	for _, host := range strings.Split(viper.GetString("IDM_HOST"), ",") {
+		host = strings.TrimSpace(host)
+		if host == "" {
+			continue
+		}
+		u, err := url.Parse(host)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("IDM_HOST entry %q must be an https:// URL", host)
+		}
+	}
+	return nil
+}
+
+// Sends requests for a client logged in to one host and marks the host
+// down when it stops answering
+type idmTransport struct {
+	base http.RoundTripper
+	host string
+}
+
+func (t *idmTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if t.host != "" && isHostFailure(resp, err) {
+		log.Println("idmTransport IdM host " + t.host + " failed, marking down")
+		markHostDown(t.host)
+	}
+	return resp, err
+}
+
+// Errors that mean the host, not the request, is the problem
+func isHostFailure(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Host client is logged in to, the first configured host before login
+func idmHost(client *http.Client) string {
+	if t, ok := client.Transport.(*idmTransport); ok && t.host != "" {
+		return t.host
+	}
+	if hosts := configuredHosts(); len(hosts) > 0 {
+		return hosts[0]
+	}
+	return ""
+}
+
+// Send the rest of the client's requests to host
+func pinHost(client *http.Client, host string) {
+	if t, ok := client.Transport.(*idmTransport); ok {
+		t.host = host
+	}
+}
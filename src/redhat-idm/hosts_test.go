@@ -0,0 +1,213 @@
+package idm
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Reset host health, SRV cache and IDM_HOST after test
+func resetHosts(t *testing.T) {
+	t.Cleanup(func() {
+		hostsMu.Lock()
+		hostDownUntil = map[string]time.Time{}
+		srvCache.domain = ""
+		srvCache.hosts = nil
+		hostsMu.Unlock()
+		lookupSRV = net.LookupSRV
+		viper.Set("IDM_HOST", nil)
+		viper.Set("IDM_SRV_DOMAIN", nil)
+	})
+}
+
+// IdM replica answering login with status and counting requests
+func newReplica(t *testing.T, loginStatus int, requests *atomic.Int32) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ipa/session/login_password", func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(loginStatus)
+	})
+	mux.HandleFunc("/ipa/session/json", func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		writeJSONRPCResponse(w, map[string]any{"count": 0, "result": []any{}}, nil)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestConfiguredHosts(t *testing.T) {
+	resetHosts(t)
+	viper.Set("IDM_HOST", " https://ipa1.example.com/ ,https://ipa2.example.com,,https://ipa1.example.com")
+
+	hosts := configuredHosts()
+	expected := []string{"https://ipa1.example.com", "https://ipa2.example.com"}
+	if !reflect.DeepEqual(hosts, expected) {
+		t.Errorf("expected %v, got %v", expected, hosts)
+	}
+}
+
+func TestConfiguredHostsSRV(t *testing.T) {
+	resetHosts(t)
+	lookups := 0
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		lookups++
+		if service != "ldap" || proto != "tcp" || name != "example.com" {
+			t.Errorf("unexpected lookup _%s._%s.%s", service, proto, name)
+		}
+		return "", []*net.SRV{{Target: "ipa2.example.com.", Port: 389}, {Target: "ipa3.example.com.", Port: 389}}, nil
+	}
+	viper.Set("IDM_SRV_DOMAIN", "example.com")
+	viper.Set("IDM_HOST", "https://ipa1.example.com,https://ipa2.example.com")
+
+	// SRV records first, IDM_HOST as fallback
+	expected := []string{"https://ipa2.example.com", "https://ipa3.example.com", "https://ipa1.example.com"}
+	if hosts := configuredHosts(); !reflect.DeepEqual(hosts, expected) {
+		t.Errorf("expected %v, got %v", expected, hosts)
+	}
+
+	// Cached
+	configuredHosts()
+	if lookups != 1 {
+		t.Errorf("expected 1 lookup, got %d", lookups)
+	}
+}
+
+func TestConfiguredHostsSRVFailure(t *testing.T) {
+	resetHosts(t)
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		return "", nil, errors.New("no such host")
+	}
+	viper.Set("IDM_SRV_DOMAIN", "example.com")
+	viper.Set("IDM_HOST", "https://ipa1.example.com")
+
+	expected := []string{"https://ipa1.example.com"}
+	if hosts := configuredHosts(); !reflect.DeepEqual(hosts, expected) {
+		t.Errorf("expected %v, got %v", expected, hosts)
+	}
+}
+
+func TestLoginFailover(t *testing.T) {
+	resetHosts(t)
+	var downRequests, upRequests atomic.Int32
+	down := newReplica(t, http.StatusServiceUnavailable, &downRequests)
+	up := newReplica(t, http.StatusOK, &upRequests)
+	viper.Set("IDM_HOST", down.URL+","+up.URL)
+
+	client, _ := newHTTPClient(false)
+	if err := login(client, "admin", "Secret123"); err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	if host := idmHost(client); host != up.URL {
+		t.Errorf("expected client on %s, got %s", up.URL, host)
+	}
+
+	// RPC calls stay on the host logged in to
+	if _, err := findUserByLogin(client, "jdoe"); err != nil {
+		t.Errorf("findUserByLogin failed: %v", err)
+	}
+	if downRequests.Load() != 1 || upRequests.Load() != 2 {
+		t.Errorf("expected 1 and 2 requests, got %d and %d", downRequests.Load(), upRequests.Load())
+	}
+
+	// The failed host is tried last
+	expected := []string{up.URL, down.URL}
+	if hosts := orderedHosts(); !reflect.DeepEqual(hosts, expected) {
+		t.Errorf("expected %v, got %v", expected, hosts)
+	}
+}
+
+func TestLoginFailoverUnreachable(t *testing.T) {
+	resetHosts(t)
+	var requests atomic.Int32
+	gone := httptest.NewServer(http.NotFoundHandler())
+	gone.Close()
+	up := newReplica(t, http.StatusOK, &requests)
+	viper.Set("IDM_HOST", gone.URL+","+up.URL)
+
+	client, _ := newHTTPClient(false)
+	if err := login(client, "admin", "Secret123"); err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	if host := idmHost(client); host != up.URL {
+		t.Errorf("expected client on %s, got %s", up.URL, host)
+	}
+}
+
+func TestLoginRejectedNoFailover(t *testing.T) {
+	resetHosts(t)
+	var firstRequests, secondRequests atomic.Int32
+	first := newReplica(t, http.StatusUnauthorized, &firstRequests)
+	second := newReplica(t, http.StatusOK, &secondRequests)
+	viper.Set("IDM_HOST", first.URL+","+second.URL)
+
+	client, _ := newHTTPClient(false)
+	if err := login(client, "admin", "wrong"); err == nil {
+		t.Error("expected login to fail")
+	}
+	if secondRequests.Load() != 0 {
+		t.Errorf("expected no requests to second host, got %d", secondRequests.Load())
+	}
+	// Rejected credentials do not mark the host down
+	if hosts := orderedHosts(); hosts[0] != first.URL {
+		t.Errorf("expected %s first, got %v", first.URL, hosts)
+	}
+}
+
+func TestLoginNoHosts(t *testing.T) {
+	resetHosts(t)
+	viper.Set("IDM_HOST", "")
+
+	client, _ := newHTTPClient(false)
+	if err := login(client, "admin", "Secret123"); err == nil {
+		t.Error("expected login to fail without hosts")
+	}
+}
+
+func TestRPCFailureMarksHostDown(t *testing.T) {
+	resetHosts(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ipa/session/login_password", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/ipa/session/json", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	failing := httptest.NewServer(mux)
+	t.Cleanup(failing.Close)
+	viper.Set("IDM_HOST", failing.URL+",https://ipa2.example.com")
+
+	client, _ := newHTTPClient(false)
+	if err := login(client, "admin", "Secret123"); err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	if _, err := findUserByEmail(client, "jdoe@example.com"); err == nil {
+		t.Error("expected findUserByEmail to fail")
+	}
+
+	expected := []string{"https://ipa2.example.com", failing.URL}
+	if hosts := orderedHosts(); !reflect.DeepEqual(hosts, expected) {
+		t.Errorf("expected %v, got %v", expected, hosts)
+	}
+}
+
+func TestCheckHosts(t *testing.T) {
+	resetHosts(t)
+
+	viper.Set("IDM_HOST", "https://ipa1.example.com, https://ipa2.example.com")
+	if err := CheckHosts(); err != nil {
+		t.Errorf("expected valid hosts, got %v", err)
+	}
+
+	viper.Set("IDM_HOST", "https://ipa1.example.com,ipa2.example.com")
+	if err := CheckHosts(); err == nil {
+		t.Error("expected error for host without scheme")
+	}
+}
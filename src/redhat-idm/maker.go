@@ -3,21 +3,38 @@ package idm
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"math/rand"
 	"net/http"
 	"strings"
 	"time"
 
-	"github.com/hadleyso/netid-activate/src/countries"
 	"github.com/hadleyso/netid-activate/src/models"
 	"github.com/spf13/viper"
-	"github.com/ybbus/jsonrpc/v3"
 )
 
-func HandleMakeUser(invite models.Invite, loginName string) (string, error) {
+// IdM already has a user with the login name
+var ErrLoginNameExists = errors.New("login name already exists in IdM")
 
+// user_add got no answer, it may have been committed
+var errWriteUnconfirmed = errors.New("IdM did not confirm user_add")
+
+// IdM DuplicateEntry error code
+const duplicateEntryCode = 4002
+
+// LDAP GeneralizedTime in UTC, as IdM takes dates
+const generalizedTime = "20060102150405Z"
+
+// How many times and how often the host that got an unanswered user_add
+// is asked if it committed the user, replaced in tests
+var userAddedAttempts = 3
+var userAddedDelay = 2 * time.Second
+
+// Create user in IdM and add to groups
+// Empty password generates a temporary password which is returned
+// SSH public keys are optional and must already be validated
+func HandleMakeUser(invite models.Invite, loginName string, password string, sshKeys []string) (string, error) {
 	// Create client
 	client, errClient := newHTTPClient(false)
 	if errClient != nil {
@@ -26,98 +43,230 @@ func HandleMakeUser(invite models.Invite, loginName string) (string, error) {
 	}
 
 	// Auth
-	username := viper.GetString("IDM_USERNAME")
-	password := viper.GetString("IDM_PASSWORD")
-	errLogin := login(client, username, password)
+	errLogin := login(client, viper.GetString("IDM_USERNAME"), viper.GetString("IDM_PASSWORD"))
 	if errLogin != nil {
 		log.Println("MakeUser() unable to login() with HTTPClient " + errLogin.Error())
 		return "", errLogin
 	}
 
-	// Generate password
-	pin := randPIN()
+	// Groups to add to
+	groups, err := inviteGroups(invite)
+	if err != nil {
+		log.Println("MakeUser() unable to inviteGroups() " + err.Error())
+		return "", err
+	}
 
 	// Create user
-	_, err := makeUser(client, loginName, invite.Email, invite.FirstName, invite.LastName, invite.Country, invite.Affiliation, pin, invite.State, invite.Inviter)
+	var tempPassword string
+	if password != "" {
+		err = addUser(client, loginName, invite, password, sshKeys)
+	} else {
+		tempPassword, err = makeUserTempPassword(client, groups, loginName, invite, sshKeys)
+	}
 	if err != nil {
 		log.Println("MakeUser() unable to makeUser() " + err.Error())
 		return "", err
 	}
 
-	// Add to groups
+	addUserGroups(client, loginName, groups) // TODO: handle errors
+
+	// IdM expires passwords set by the app account at once, the invitee
+	// chose this one and should not have to change it at first login
+	if password != "" {
+		if err := keepChosenPassword(client, loginName); err != nil {
+			log.Println("MakeUser() unable to keepChosenPassword() " + err.Error())
+		}
+	}
+	return tempPassword, nil
+}
+
+// Expire the password of user as if the user had set it, after the
+// policy of its groups, instead of at once
+// Client must be authenticated
+func keepChosenPassword(client *http.Client, uid string) error {
+	policy, err := userPasswordPolicy(client, uid)
+	if err != nil {
+		return err
+	}
+
+	// Empty removes the expiration, policies without a max lifetime
+	// never expire passwords
+	expiration := ""
+	if policy.MaxLife > 0 {
+		expiration = time.Now().UTC().AddDate(0, 0, policy.MaxLife).Format(generalizedTime)
+	}
+
+	rpcClient := newRPCClient(client)
+
+	params := []any{
+		[]string{uid},
+		map[string]any{"krbpasswordexpiration": expiration},
+	}
+
+	resp, err := rpcClient.Call(context.Background(), "user_mod", params...)
+	if err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return fmt.Errorf("RPC error: %v", resp.Error.Message)
+	}
+	return nil
+}
+
+// Optional groups of invite and IDM_ADD_GROUP, the groups the user joins
+func inviteGroups(invite models.Invite) ([]string, error) {
 	var groups []string
 	if err := json.Unmarshal(invite.OptionalGroups, &groups); err != nil {
-		log.Println("MakeUser() unable to json.Unmarshal() " + err.Error())
-		return "", err
+		return nil, err
 	}
-	groups = append(groups, strings.Split(viper.GetString("IDM_ADD_GROUP"), ",")...)
+	return append(groups, strings.Split(viper.GetString("IDM_ADD_GROUP"), ",")...), nil
+}
 
-	addUserGroups(client, loginName, groups) // TODO: handle errors
-	return pin, nil
+// Create user with a generated temporary password meeting the policy of
+// the groups the user will join, retrying if IdM rejects it
+// Client must be authenticated
+func makeUserTempPassword(client *http.Client, groups []string, loginName string, invite models.Invite, sshKeys []string) (string, error) {
+	policy := strictestPasswordPolicy(client, groups)
+
+	var err error
+	for attempt := 1; attempt <= tempPasswordAttempts; attempt++ {
+		var password string
+		password, err = newTempPassword(policy, loginName, invite.FirstName, invite.LastName)
+		if err != nil {
+			return "", err
+		}
+
+		err = addUser(client, loginName, invite, password, sshKeys)
+		if err == nil {
+			return password, nil
+		}
+		if !errors.Is(err, ErrPasswordPolicy) {
+			return "", err
+		}
+		log.Printf("makeUserTempPassword() password rejected, attempt %d of %d\n", attempt, tempPasswordAttempts)
+	}
+
+	return "", err
 }
 
+// Run user_add once. A user_add without an answer is never sent again,
+// a replica is asked if the user was committed instead
 // Client must be authenticated
-func makeUser(client *http.Client, uid string, email string, firstName string, lastName string, country string, affiliation string, password string, st string, managerUIN string) (any, error) {
-	// Combine variables
-	cn := firstName + " " + lastName
-	initials := strings.ToUpper(firstName[:1] + lastName[:1])
-	gecos := cn
-	if viper.GetString("IDM_GECOS") == "true" {
-		gecos = cn + " (" + country + " " + affiliation + ")"
-	}
-
-	// Get value
-	alpha2, errCountry := countries.GetAlpha2FromAlpha3(country)
-	if errCountry != nil {
-		return nil, errCountry
-	}
-	countryName, errCountry := countries.GetNameFromAlpha3(country)
-	if errCountry != nil {
-		return nil, errCountry
-	}
-
-	// Set st
-	st = st + ", " + countryName
-
-	// Set connection
-	rpcURL := viper.GetString("IDM_HOST") + "/ipa/session/json"
-	rpcClient := jsonrpc.NewClientWithOpts(rpcURL,
-		&jsonrpc.RPCClientOpts{
-			AllowUnknownFields: true, // IdM returns principal
-			CustomHeaders: map[string]string{
-				"Referer":      viper.GetString("IDM_HOST") + "/ipa",
-				"Content-Type": "application/json",
-				"Accept":       "application/json",
-			},
-			HTTPClient: client,
-		})
+func addUser(client *http.Client, loginName string, invite models.Invite, password string, sshKeys []string) error {
+	_, err := makeUser(client, loginName, invite.Email, invite.FirstName, invite.LastName, invite.Country, invite.Affiliation, password, invite.State, invite.Inviter, sshKeys, invite.FieldValues())
+	if !errors.Is(err, errWriteUnconfirmed) {
+		return err
+	}
+
+	added, errCheck := userAdded(client, loginName, invite.Email)
+	if errCheck != nil {
+		log.Println("addUser() unable to userAdded() " + errCheck.Error())
+		return err
+	}
+	if !added {
+		return err
+	}
+	log.Println("addUser() user_add for " + loginName + " was committed without an answer")
+	return nil
+}
+
+// Check if an unanswered user_add committed the user
+// The host that got the user_add is asked first, a few times as it may
+// still be finishing it and replicas may not have it yet. Other replicas
+// are only asked when that host cannot be reached
+// Client must be authenticated
+func userAdded(client *http.Client, loginName string, email string) (bool, error) {
+	host := idmHost(client)
+
+	var err error
+	for attempt := 1; attempt <= userAddedAttempts; attempt++ {
+		var added bool
+		added, err = findAddedUser(client, loginName, email)
+		if err == nil && added {
+			return true, nil
+		}
+		if attempt < userAddedAttempts {
+			time.Sleep(userAddedDelay)
+		}
+	}
+	if err == nil {
+		return false, nil
+	}
+	log.Println("userAdded() IdM host " + host + " unavailable, asking replicas " + err.Error())
+
+	replica, err := newHTTPClient(false)
+	if err != nil {
+		return false, err
+	}
+	if err := login(replica, viper.GetString("IDM_USERNAME"), viper.GetString("IDM_PASSWORD")); err != nil {
+		return false, err
+	}
+	return findAddedUser(replica, loginName, email)
+}
+
+// Check for a user with login name and email
+// Client must be authenticated
+func findAddedUser(client *http.Client, loginName string, email string) (bool, error) {
+	rpcClient := newRPCClient(client)
 
-	// Params
+	params := []any{
+		[]string{},
+		map[string]any{"sizelimit": 1, "pkey_only": true, "uid": loginName, "mail": []string{email}},
+	}
+
+	resp, err := rpcClient.Call(context.Background(), "user_find", params...)
+	if err != nil {
+		return false, err
+	}
+	if resp.Error != nil {
+		return false, fmt.Errorf("RPC error: %v", resp.Error.Message)
+	}
+
+	count, err := resultCount(resp.Result)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// Client must be authenticated
+func makeUser(client *http.Client, uid string, email string, firstName string, lastName string, country string, affiliation string, password string, st string, managerUIN string, sshKeys []string, fields map[string]string) (any, error) {
+	data, err := newAttributeData(uid, email, firstName, lastName, country, affiliation, st, managerUIN, fields)
+	if err != nil {
+		return nil, err
+	}
+	options, err := renderAttributes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	rpcClient := newRPCClient(client)
+
+	// Params, the mapped attributes and the fixed ones
+	options["all"] = true
+	options["mail"] = []string{email}
+	options["userpassword"] = password
+	if len(sshKeys) > 0 {
+		options["ipasshpubkey"] = sshKeys
+	}
 	params := []any{
 		[]string{uid},
-		map[string]any{
-			"all":          true,
-			"cn":           cn,
-			"displayname":  cn,
-			"gecos":        gecos,
-			"givenname":    firstName,
-			"sn":           lastName,
-			"initials":     initials,
-			"mail":         []string{email},
-			"st":           st,
-			"userpassword": password,
-			"manager":      managerUIN,
-			"pager":        []string{alpha2},
-		},
+		options,
 	}
 
 	resp, err := rpcClient.Call(context.Background(), "user_add", params...)
 	if err != nil {
 		log.Println("makeUser() call error " + err.Error())
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", errWriteUnconfirmed, err)
 	}
 	if resp.Error != nil {
 		log.Println("makeUser() response error " + resp.Error.Message)
+		if isPasswordPolicyError(resp.Error.Message) {
+			return nil, fmt.Errorf("%w: %v", ErrPasswordPolicy, resp.Error.Message)
+		}
+		if isDuplicateEntryError(resp.Error.Code, resp.Error.Message) {
+			return nil, fmt.Errorf("%w: %v", ErrLoginNameExists, resp.Error.Message)
+		}
 		return nil, fmt.Errorf("RPC error: %v", resp.Error.Message)
 	}
 
@@ -127,18 +276,7 @@ func makeUser(client *http.Client, uid string, email string, firstName string, l
 
 func addUserGroups(client *http.Client, uid string, groups []string) error {
 
-	// Set connection
-	rpcURL := viper.GetString("IDM_HOST") + "/ipa/session/json"
-	rpcClient := jsonrpc.NewClientWithOpts(rpcURL,
-		&jsonrpc.RPCClientOpts{
-			AllowUnknownFields: true, // IdM returns principal
-			CustomHeaders: map[string]string{
-				"Referer":      viper.GetString("IDM_HOST") + "/ipa",
-				"Content-Type": "application/json",
-				"Accept":       "application/json",
-			},
-			HTTPClient: client,
-		})
+	rpcClient := newRPCClient(client)
 
 	var subRequests []any
 	for _, grp := range groups {
@@ -176,20 +314,7 @@ func addUserGroups(client *http.Client, uid string, groups []string) error {
 
 }
 
-func randPIN() string {
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-
-	const letters = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
-	parts := make([]string, 3)
-
-	for i := range parts {
-		var sb strings.Builder
-		for j := 0; j < 3; j++ {
-			idx := r.Intn(len(letters))
-			sb.WriteByte(letters[idx])
-		}
-		parts[i] = sb.String()
-	}
-
-	return strings.Join(parts, "-")
+// Check user_add error for an existing user with the login name
+func isDuplicateEntryError(code int, message string) bool {
+	return code == duplicateEntryCode || strings.Contains(strings.ToLower(message), "already exists")
 }
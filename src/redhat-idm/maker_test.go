@@ -2,11 +2,13 @@ package idm
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
-	"regexp"
-	"strings"
+	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/hadleyso/netid-activate/src/models"
@@ -14,14 +16,6 @@ import (
 	"gorm.io/datatypes"
 )
 
-func TestRandPIN(t *testing.T) {
-	pin := randPIN()
-	match, _ := regexp.MatchString(`^[A-Z]{3}-[A-Z]{3}-[A-Z]{3}$`, pin)
-	if !match {
-		t.Errorf("PIN format is incorrect: got %s", pin)
-	}
-}
-
 var testUser string = "testuser-" + uuid.New().String()[:8]
 
 func TestHandleMakeUser(t *testing.T) {
@@ -60,16 +54,13 @@ func TestHandleMakeUser(t *testing.T) {
 		OptionalGroups: datatypes.JSON(jsonGroups),
 	}
 
-	pin, err := HandleMakeUser(invite, testUser)
+	pin, err := HandleMakeUser(invite, testUser, "", nil)
 	if err != nil {
 		t.Fatalf("HandleMakeUser failed: %v", err)
 	}
 
-	if pin == "" {
-		t.Error("expected a PIN, but got empty string")
-	}
-	if !strings.Contains(pin, "-") {
-		t.Errorf("expected PIN to contain '-', got %s", pin)
+	if len(pin) < DefaultTempPasswordLength {
+		t.Errorf("expected a temporary password of at least %d characters, got %q", DefaultTempPasswordLength, pin)
 	}
 }
 
@@ -140,7 +131,7 @@ func TestMakeUser_Success(t *testing.T) {
 
 	client, _ := newHTTPClient(false)
 
-	result, err := makeUser(client, "jdoe", "jdoe@example.com", "John", "Doe", "USA", "staff", "secret", "CA", "mgr001")
+	result, err := makeUser(client, "jdoe", "jdoe@example.com", "John", "Doe", "USA", "staff", "secret", "CA", "mgr001", nil, nil)
 	if err != nil {
 		t.Fatalf("makeUser failed: %v", err)
 	}
@@ -149,6 +140,40 @@ func TestMakeUser_Success(t *testing.T) {
 	}
 }
 
+func TestMakeUser_SSHKeys(t *testing.T) {
+	var options map[string]any
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ipa/session/json", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Params []json.RawMessage `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		json.Unmarshal(req.Params[1], &options)
+		writeJSONRPCResponse(w, map[string]any{"result": map[string]any{"uid": "jdoe"}}, nil)
+	})
+	ts := setupIDMTestServer(t, mux)
+	viper.Set("IDM_HOST", ts.URL)
+
+	client, _ := newHTTPClient(false)
+
+	// No keys, attribute omitted
+	if _, err := makeUser(client, "jdoe", "jdoe@example.com", "John", "Doe", "USA", "staff", "secret", "CA", "mgr001", nil, nil); err != nil {
+		t.Fatalf("makeUser failed: %v", err)
+	}
+	if _, ok := options["ipasshpubkey"]; ok {
+		t.Error("expected no ipasshpubkey without keys")
+	}
+
+	keys := []string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGZ2 jdoe@laptop"}
+	if _, err := makeUser(client, "jdoe", "jdoe@example.com", "John", "Doe", "USA", "staff", "secret", "CA", "mgr001", keys, nil); err != nil {
+		t.Fatalf("makeUser failed: %v", err)
+	}
+	got, _ := options["ipasshpubkey"].([]any)
+	if len(got) != 1 || got[0] != keys[0] {
+		t.Errorf("expected ipasshpubkey %v, got %v", keys, options["ipasshpubkey"])
+	}
+}
+
 func TestMakeUser_RPCError(t *testing.T) {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/ipa/session/json", func(w http.ResponseWriter, r *http.Request) {
@@ -160,12 +185,31 @@ func TestMakeUser_RPCError(t *testing.T) {
 
 	client, _ := newHTTPClient(false)
 
-	_, err := makeUser(client, "jdoe", "jdoe@example.com", "John", "Doe", "USA", "staff", "secret", "CA", "mgr001")
+	_, err := makeUser(client, "jdoe", "jdoe@example.com", "John", "Doe", "USA", "staff", "secret", "CA", "mgr001", nil, nil)
 	if err == nil {
 		t.Error("expected error but got nil")
 	}
 }
 
+func TestMakeUser_DuplicateEntry(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ipa/session/json", func(w http.ResponseWriter, r *http.Request) {
+		writeJSONRPCResponse(w, nil, map[string]any{"code": 4002, "message": `user with name "jdoe" already exists`, "name": "DuplicateEntry"})
+	})
+	ts := setupIDMTestServer(t, mux)
+	viper.Set("IDM_HOST", ts.URL)
+
+	client, _ := newHTTPClient(false)
+
+	_, err := makeUser(client, "jdoe", "jdoe@example.com", "John", "Doe", "USA", "staff", "secret", "CA", "mgr001", nil, nil)
+	if !errors.Is(err, ErrLoginNameExists) {
+		t.Errorf("expected ErrLoginNameExists, got %v", err)
+	}
+	if errors.Is(err, ErrPasswordPolicy) {
+		t.Error("expected duplicate user not to be a password policy error")
+	}
+}
+
 func TestMakeUser_HTTPError(t *testing.T) {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/ipa/session/json", func(w http.ResponseWriter, r *http.Request) {
@@ -177,7 +221,7 @@ func TestMakeUser_HTTPError(t *testing.T) {
 
 	client, _ := newHTTPClient(false)
 
-	_, err := makeUser(client, "jdoe", "jdoe@example.com", "John", "Doe", "USA", "staff", "secret", "CA", "mgr001")
+	_, err := makeUser(client, "jdoe", "jdoe@example.com", "John", "Doe", "USA", "staff", "secret", "CA", "mgr001", nil, nil)
 	if err == nil {
 		t.Error("expected error due to HTTP 500 but got nil")
 	}
@@ -187,8 +231,224 @@ func TestMakeUser_InvalidCountry(t *testing.T) {
 	// Pass an invalid alpha-3 code to trigger country lookup error
 	client, _ := newHTTPClient(false)
 
-	_, err := makeUser(client, "jdoe", "jdoe@example.com", "John", "Doe", "XXX", "staff", "secret", "CA", "mgr001")
+	_, err := makeUser(client, "jdoe", "jdoe@example.com", "John", "Doe", "XXX", "staff", "secret", "CA", "mgr001", nil, nil)
 	if err == nil {
 		t.Error("expected error due to invalid country code but got nil")
 	}
 }
+
+// Replica whose user_add answers after the client timed out and whose
+// user_find reports if the user was committed, or findStatus if not 200
+func newSlowReplica(t *testing.T, committed bool, findStatus int, userAdds *atomic.Int32) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ipa/session/login_password", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/ipa/session/json", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var payload map[string]any
+		json.Unmarshal(body, &payload)
+
+		switch payload["method"] {
+		case "user_add":
+			userAdds.Add(1)
+			time.Sleep(4 * requestTimeout)
+			writeJSONRPCResponse(w, map[string]any{"result": map[string]any{}}, nil)
+		case "user_find":
+			if findStatus != http.StatusOK {
+				w.WriteHeader(findStatus)
+				return
+			}
+			count := 0
+			if committed {
+				count = 1
+			}
+			writeJSONRPCResponse(w, map[string]any{"count": count, "result": []any{}}, nil)
+		default:
+			writeJSONRPCResponse(w, map[string]any{"count": 1, "results": []any{map[string]any{}}}, nil)
+		}
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func testTimeoutInvite() models.Invite {
+	return models.Invite{
+		FirstName:      "Test",
+		LastName:       "User",
+		Email:          "test@example.com",
+		Country:        "USA",
+		Affiliation:    "staff",
+		State:          "CA",
+		Inviter:        "admin",
+		OptionalGroups: datatypes.JSON(`[]`),
+	}
+}
+
+// Short request timeout and user_add checks
+func shortTimeouts(t *testing.T) {
+	timeout, delay := requestTimeout, userAddedDelay
+	requestTimeout = 100 * time.Millisecond
+	userAddedDelay = 10 * time.Millisecond
+	t.Cleanup(func() {
+		requestTimeout = timeout
+		userAddedDelay = delay
+	})
+}
+
+func TestHandleMakeUser_TimeoutCommitted(t *testing.T) {
+	resetHosts(t)
+	shortTimeouts(t)
+
+	var userAdds atomic.Int32
+	first := newSlowReplica(t, true, http.StatusOK, &userAdds)
+	second := newSlowReplica(t, true, http.StatusOK, &userAdds)
+	viper.Set("IDM_HOST", first.URL+","+second.URL)
+
+	_, err := HandleMakeUser(testTimeoutInvite(), testUser, "Correct-Horse-9", nil)
+	if err != nil {
+		t.Errorf("expected committed user_add to succeed, got %v", err)
+	}
+	if userAdds.Load() != 1 {
+		t.Errorf("expected user_add to be sent once, got %d", userAdds.Load())
+	}
+}
+
+func TestHandleMakeUser_TimeoutNotCommitted(t *testing.T) {
+	resetHosts(t)
+	shortTimeouts(t)
+
+	var userAdds atomic.Int32
+	first := newSlowReplica(t, false, http.StatusOK, &userAdds)
+	second := newSlowReplica(t, false, http.StatusOK, &userAdds)
+	viper.Set("IDM_HOST", first.URL+","+second.URL)
+
+	_, err := HandleMakeUser(testTimeoutInvite(), testUser, "", nil)
+	if !errors.Is(err, errWriteUnconfirmed) {
+		t.Errorf("expected errWriteUnconfirmed, got %v", err)
+	}
+	// Temporary passwords are not drawn again after a timeout
+	if userAdds.Load() != 1 {
+		t.Errorf("expected user_add to be sent once, got %d", userAdds.Load())
+	}
+}
+
+func TestHandleMakeUser_TimeoutReplicaLag(t *testing.T) {
+	resetHosts(t)
+	shortTimeouts(t)
+
+	// Only the host that got user_add has the user so far
+	var userAdds atomic.Int32
+	first := newSlowReplica(t, true, http.StatusOK, &userAdds)
+	second := newSlowReplica(t, false, http.StatusOK, &userAdds)
+	viper.Set("IDM_HOST", first.URL+","+second.URL)
+
+	_, err := HandleMakeUser(testTimeoutInvite(), testUser, "Correct-Horse-9", nil)
+	if err != nil {
+		t.Errorf("expected user_add committed on the pinned host to succeed, got %v", err)
+	}
+	if userAdds.Load() != 1 {
+		t.Errorf("expected user_add to be sent once, got %d", userAdds.Load())
+	}
+}
+
+func TestHandleMakeUser_TimeoutHostDown(t *testing.T) {
+	resetHosts(t)
+	shortTimeouts(t)
+
+	// Host that got user_add stops answering, the replica has the user
+	var userAdds atomic.Int32
+	first := newSlowReplica(t, true, http.StatusServiceUnavailable, &userAdds)
+	second := newSlowReplica(t, true, http.StatusOK, &userAdds)
+	viper.Set("IDM_HOST", first.URL+","+second.URL)
+
+	_, err := HandleMakeUser(testTimeoutInvite(), testUser, "Correct-Horse-9", nil)
+	if err != nil {
+		t.Errorf("expected replica to confirm user_add, got %v", err)
+	}
+	if userAdds.Load() != 1 {
+		t.Errorf("expected user_add to be sent once, got %d", userAdds.Load())
+	}
+}
+
+// Replica recording user_mod options, whose policy has maxlife days
+func newPolicyReplica(t *testing.T, maxLife string, userMods *[]map[string]any) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ipa/session/login_password", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/ipa/session/json", func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Method string `json:"method"`
+			Params []any  `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+
+		switch payload.Method {
+		case "pwpolicy_show":
+			options, _ := payload.Params[1].(map[string]any)
+			if options["user"] != nil && options["user"] != testUser {
+				t.Errorf("expected policy of %s, got %v", testUser, options["user"])
+			}
+			writeJSONRPCResponse(w, map[string]any{"result": map[string]any{"krbmaxpwdlife": []any{maxLife}}}, nil)
+		case "user_mod":
+			if uids, _ := payload.Params[0].([]any); len(uids) != 1 || uids[0] != testUser {
+				t.Errorf("expected user_mod of %s, got %v", testUser, payload.Params[0])
+			}
+			options, _ := payload.Params[1].(map[string]any)
+			*userMods = append(*userMods, options)
+			writeJSONRPCResponse(w, map[string]any{"result": map[string]any{}}, nil)
+		default:
+			writeJSONRPCResponse(w, map[string]any{"count": 1, "results": []any{map[string]any{}}, "result": map[string]any{}}, nil)
+		}
+	})
+	return setupIDMTestServerReplicate(t, mux)
+}
+
+func TestHandleMakeUser_ChosenPasswordNotExpired(t *testing.T) {
+	resetHosts(t)
+	var userMods []map[string]any
+	newPolicyReplica(t, "90", &userMods)
+
+	if _, err := HandleMakeUser(testTimeoutInvite(), testUser, "Correct-Horse-9", nil); err != nil {
+		t.Fatalf("HandleMakeUser failed: %v", err)
+	}
+	if len(userMods) != 1 {
+		t.Fatalf("expected one user_mod, got %d", len(userMods))
+	}
+	expiration, err := time.Parse(generalizedTime, userMods[0]["krbpasswordexpiration"].(string))
+	if err != nil {
+		t.Fatalf("unexpected krbpasswordexpiration %v", userMods[0]["krbpasswordexpiration"])
+	}
+	if until := time.Until(expiration); until < 89*24*time.Hour || until > 90*24*time.Hour {
+		t.Errorf("expected expiration in 90 days, got %v", expiration)
+	}
+}
+
+func TestHandleMakeUser_ChosenPasswordNoMaxLife(t *testing.T) {
+	resetHosts(t)
+	var userMods []map[string]any
+	newPolicyReplica(t, "0", &userMods)
+
+	if _, err := HandleMakeUser(testTimeoutInvite(), testUser, "Correct-Horse-9", nil); err != nil {
+		t.Fatalf("HandleMakeUser failed: %v", err)
+	}
+	if len(userMods) != 1 || userMods[0]["krbpasswordexpiration"] != "" {
+		t.Errorf("expected expiration to be removed, got %v", userMods)
+	}
+}
+
+func TestHandleMakeUser_TemporaryPasswordExpired(t *testing.T) {
+	resetHosts(t)
+	var userMods []map[string]any
+	newPolicyReplica(t, "90", &userMods)
+
+	if _, err := HandleMakeUser(testTimeoutInvite(), testUser, "", nil); err != nil {
+		t.Fatalf("HandleMakeUser failed: %v", err)
+	}
+	// Temporary passwords are changed at first login
+	if len(userMods) != 0 {
+		t.Errorf("expected no user_mod for a temporary password, got %v", userMods)
+	}
+}
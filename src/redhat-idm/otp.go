@@ -0,0 +1,89 @@
+package idm
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/spf13/viper"
+)
+
+// Add a TOTP token for user and require OTP with password login
+//
+// The secret has already been verified by the user
+func EnrollTOTP(loginName string, secret []byte, description string) error {
+	client, errClient := newHTTPClient(false)
+	if errClient != nil {
+		log.Println("EnrollTOTP() unable to newHTTPClient() " + errClient.Error())
+		return errClient
+	}
+
+	errLogin := login(client, viper.GetString("IDM_USERNAME"), viper.GetString("IDM_PASSWORD"))
+	if errLogin != nil {
+		log.Println("EnrollTOTP() unable to login() with HTTPClient " + errLogin.Error())
+		return errLogin
+	}
+
+	if err := addTOTPToken(client, loginName, secret, description); err != nil {
+		return err
+	}
+	return setUserAuthType(client, loginName, []string{"otp", "password"})
+}
+
+// Client must be authenticated
+func addTOTPToken(client *http.Client, loginName string, secret []byte, description string) error {
+	rpcClient := newRPCClient(client)
+
+	// Token ID is generated by IdM
+	params := []any{
+		[]string{},
+		map[string]any{
+			"type":                 "totp",
+			"ipatokenowner":        loginName,
+			"description":          description,
+			"ipatokenotpkey":       map[string]string{"__base64__": base64.StdEncoding.EncodeToString(secret)},
+			"ipatokenotpalgorithm": "sha1",
+			"ipatokenotpdigits":    6,
+			"ipatokentotptimestep": 30,
+			"no_qrcode":            true,
+		},
+	}
+
+	resp, err := rpcClient.Call(context.Background(), "otptoken_add", params...)
+	if err != nil {
+		log.Println("addTOTPToken() call error " + err.Error())
+		return err
+	}
+	if resp.Error != nil {
+		log.Println("addTOTPToken() response error " + resp.Error.Message)
+		return fmt.Errorf("RPC error: %v", resp.Error.Message)
+	}
+
+	return nil
+}
+
+// Client must be authenticated
+func setUserAuthType(client *http.Client, loginName string, authTypes []string) error {
+	rpcClient := newRPCClient(client)
+
+	params := []any{
+		[]string{loginName},
+		map[string]any{
+			"ipauserauthtype": authTypes,
+		},
+	}
+
+	resp, err := rpcClient.Call(context.Background(), "user_mod", params...)
+	if err != nil {
+		log.Println("setUserAuthType() call error " + err.Error())
+		return err
+	}
+	if resp.Error != nil {
+		log.Println("setUserAuthType() response error " + resp.Error.Message)
+		return fmt.Errorf("RPC error: %v", resp.Error.Message)
+	}
+
+	return nil
+}
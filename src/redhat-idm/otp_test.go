@@ -0,0 +1,87 @@
+package idm
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestEnrollTOTP(t *testing.T) {
+	var methods []string
+	var tokenOptions, modOptions map[string]any
+	var modArgs []any
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ipa/session/login_password", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/ipa/session/json", func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Method string `json:"method"`
+			Params []any  `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+		methods = append(methods, payload.Method)
+
+		switch payload.Method {
+		case "otptoken_add":
+			tokenOptions, _ = payload.Params[1].(map[string]any)
+		case "user_mod":
+			modArgs, _ = payload.Params[0].([]any)
+			modOptions, _ = payload.Params[1].(map[string]any)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result": {"result": {}}}`))
+	})
+	setupIDMTestServerReplicate(t, mux)
+
+	err := EnrollTOTP("jdoe", []byte("12345678901234567890"), "Activation")
+	if err != nil {
+		t.Fatalf("EnrollTOTP() error: %v", err)
+	}
+
+	if !reflect.DeepEqual(methods, []string{"otptoken_add", "user_mod"}) {
+		t.Fatalf("unexpected calls %v", methods)
+	}
+	if tokenOptions["type"] != "totp" || tokenOptions["ipatokenowner"] != "jdoe" {
+		t.Errorf("unexpected token options %v", tokenOptions)
+	}
+	key, _ := tokenOptions["ipatokenotpkey"].(map[string]any)
+	if key["__base64__"] != "MTIzNDU2Nzg5MDEyMzQ1Njc4OTA=" {
+		t.Errorf("unexpected token key %v", tokenOptions["ipatokenotpkey"])
+	}
+	if !reflect.DeepEqual(modArgs, []any{"jdoe"}) {
+		t.Errorf("unexpected user_mod args %v", modArgs)
+	}
+	if !reflect.DeepEqual(modOptions["ipauserauthtype"], []any{"otp", "password"}) {
+		t.Errorf("unexpected auth types %v", modOptions["ipauserauthtype"])
+	}
+}
+
+func TestEnrollTOTPTokenError(t *testing.T) {
+	var methods []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ipa/session/login_password", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/ipa/session/json", func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Method string `json:"method"`
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+		methods = append(methods, payload.Method)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"error": {"code": 2100, "message": "Insufficient access", "name": "ACIError"}}`))
+	})
+	setupIDMTestServerReplicate(t, mux)
+
+	if err := EnrollTOTP("jdoe", []byte("12345678901234567890"), "Activation"); err == nil {
+		t.Fatal("expected error")
+	}
+	// Auth type is not changed without a token
+	if !reflect.DeepEqual(methods, []string{"otptoken_add"}) {
+		t.Errorf("unexpected calls %v", methods)
+	}
+}
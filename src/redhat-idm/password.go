@@ -0,0 +1,153 @@
+package idm
+
+import (
+	"crypto/rand"
+	"errors"
+	"log"
+	"math/big"
+	"net/http"
+
+	"github.com/spf13/viper"
+)
+
+// Default temporary password length when TEMP_PASSWORD_LENGTH is not set
+const DefaultTempPasswordLength = 12
+
+// Default character classes when TEMP_PASSWORD_CLASSES is not set
+const DefaultTempPasswordClasses = 3
+
+// Times user_add is tried with a new password after a policy rejection
+const tempPasswordAttempts = 3
+
+// Character classes without look alike characters, in the order they
+// are required
+var passwordCharsets = []string{
+	"abcdefghijkmnopqrstuvwxyz",
+	"ABCDEFGHJKLMNPQRSTUVWXYZ",
+	"23456789",
+	"!@#$%^&*-_=+?",
+}
+
+// Random password of length using the first classes character sets
+// with at least one character from each
+func GeneratePassword(length int, classes int) (string, error) {
+	if classes < 1 {
+		classes = 1
+	}
+	if classes > len(passwordCharsets) {
+		classes = len(passwordCharsets)
+	}
+	if length < classes {
+		length = classes
+	}
+
+	var all string
+	password := make([]byte, 0, length)
+	for _, charset := range passwordCharsets[:classes] {
+		all += charset
+		c, err := randomChar(charset)
+		if err != nil {
+			return "", err
+		}
+		password = append(password, c)
+	}
+	for len(password) < length {
+		c, err := randomChar(all)
+		if err != nil {
+			return "", err
+		}
+		password = append(password, c)
+	}
+
+	// Shuffle so required classes are not always first
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j.Int64()] = password[j.Int64()], password[i]
+	}
+
+	return string(password), nil
+}
+
+func randomChar(charset string) (byte, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(len(charset))))
+	if err != nil {
+		return 0, err
+	}
+	return charset[i.Int64()], nil
+}
+
+// Temporary password meeting policy and TEMP_PASSWORD_LENGTH and
+// TEMP_PASSWORD_CLASSES, whichever is stricter
+func newTempPassword(policy PasswordPolicy, names ...string) (string, error) {
+	length := DefaultTempPasswordLength
+	if viper.IsSet("TEMP_PASSWORD_LENGTH") {
+		length = viper.GetInt("TEMP_PASSWORD_LENGTH")
+	}
+	classes := DefaultTempPasswordClasses
+	if viper.IsSet("TEMP_PASSWORD_CLASSES") {
+		classes = viper.GetInt("TEMP_PASSWORD_CLASSES")
+	}
+	length = max(length, policy.MinLength)
+	classes = max(classes, policy.MinClasses)
+
+	// Repeat and sequence limits are rare to hit, draw again
+	for i := 0; i < 20; i++ {
+		password, err := GeneratePassword(length, classes)
+		if err != nil {
+			return "", err
+		}
+		if len(policy.Validate(password, names...)) == 0 {
+			return password, nil
+		}
+	}
+	return "", errors.New("unable to generate password meeting policy")
+}
+
+// Temporary password from TEMP_PASSWORD_LENGTH and TEMP_PASSWORD_CLASSES
+// for directories without an IdM password policy
+func TempPassword() (string, error) {
+	return newTempPassword(PasswordPolicy{})
+}
+
+// Strictest of the global policy and the policies of groups
+// Groups without their own policy are skipped
+// Client must be authenticated
+func strictestPasswordPolicy(client *http.Client, groups []string) PasswordPolicy {
+	policy, err := getPasswordPolicy(client, "")
+	if err != nil {
+		log.Println("strictestPasswordPolicy() unable to get global policy " + err.Error())
+	}
+
+	for _, group := range groups {
+		if group == "" {
+			continue
+		}
+		groupPolicy, err := getPasswordPolicy(client, group)
+		if err != nil {
+			continue
+		}
+		policy.MinLength = max(policy.MinLength, groupPolicy.MinLength)
+		policy.MinClasses = max(policy.MinClasses, groupPolicy.MinClasses)
+		policy.History = max(policy.History, groupPolicy.History)
+		policy.MaxRepeat = stricterLimit(policy.MaxRepeat, groupPolicy.MaxRepeat)
+		policy.MaxSequence = stricterLimit(policy.MaxSequence, groupPolicy.MaxSequence)
+		policy.UserCheck = policy.UserCheck || groupPolicy.UserCheck
+		policy.DictCheck = policy.DictCheck || groupPolicy.DictCheck
+	}
+
+	return policy
+}
+
+// Smaller non zero limit, zero is unlimited
+func stricterLimit(a int, b int) int {
+	if a == 0 {
+		return b
+	}
+	if b == 0 {
+		return a
+	}
+	return min(a, b)
+}
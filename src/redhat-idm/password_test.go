@@ -0,0 +1,218 @@
+package idm
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hadleyso/netid-activate/src/models"
+	"github.com/spf13/viper"
+	"gorm.io/datatypes"
+)
+
+func TestGeneratePassword(t *testing.T) {
+	for classes := 1; classes <= len(passwordCharsets); classes++ {
+		password, err := GeneratePassword(16, classes)
+		if err != nil {
+			t.Fatalf("GeneratePassword() error: %v", err)
+		}
+		if len(password) != 16 {
+			t.Errorf("expected length 16, got %d", len(password))
+		}
+
+		allowed := strings.Join(passwordCharsets[:classes], "")
+		for _, c := range password {
+			if !strings.ContainsRune(allowed, c) {
+				t.Errorf("unexpected character %q for %d classes", c, classes)
+			}
+		}
+		for _, charset := range passwordCharsets[:classes] {
+			if !strings.ContainsAny(password, charset) {
+				t.Errorf("expected a character from %q in %q", charset, password)
+			}
+		}
+	}
+
+	// Length raised to fit classes, classes capped
+	password, _ := GeneratePassword(1, 10)
+	if len(password) != len(passwordCharsets) {
+		t.Errorf("expected length %d, got %q", len(passwordCharsets), password)
+	}
+
+	a, _ := GeneratePassword(16, 4)
+	b, _ := GeneratePassword(16, 4)
+	if a == b {
+		t.Error("expected different passwords")
+	}
+}
+
+func TestNewTempPassword(t *testing.T) {
+	defer viper.Set("TEMP_PASSWORD_LENGTH", nil)
+	defer viper.Set("TEMP_PASSWORD_CLASSES", nil)
+
+	password, err := newTempPassword(PasswordPolicy{})
+	if err != nil {
+		t.Fatalf("newTempPassword() error: %v", err)
+	}
+	if len(password) != DefaultTempPasswordLength || passwordClasses(password) < DefaultTempPasswordClasses {
+		t.Errorf("expected default length and classes, got %q", password)
+	}
+
+	// Policy stricter than config
+	viper.Set("TEMP_PASSWORD_LENGTH", 10)
+	viper.Set("TEMP_PASSWORD_CLASSES", 2)
+	password, _ = newTempPassword(PasswordPolicy{MinLength: 20, MinClasses: 4})
+	if len(password) != 20 || passwordClasses(password) != 4 {
+		t.Errorf("expected policy length and classes, got %q", password)
+	}
+
+	// Config stricter than policy
+	viper.Set("TEMP_PASSWORD_LENGTH", 24)
+	password, _ = newTempPassword(PasswordPolicy{MinLength: 8})
+	if len(password) != 24 {
+		t.Errorf("expected config length, got %q", password)
+	}
+}
+
+// IdM stub with global and students policies, user_add rejects the first
+// rejections passwords
+func newPolicyMux(rejections int, passwords *[]string) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ipa/session/login_password", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/ipa/session/json", func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Method string `json:"method"`
+			Params []any  `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+
+		w.Header().Set("Content-Type", "application/json")
+		switch payload.Method {
+		case "pwpolicy_show":
+			args, _ := payload.Params[0].([]any)
+			switch {
+			case len(args) == 0:
+				w.Write([]byte(`{"result": {"result": {"krbpwdminlength": ["8"], "krbpwdmindiffchars": ["2"], "ipapwdmaxrepeat": ["3"]}}}`))
+			case args[0] == "students":
+				w.Write([]byte(`{"result": {"result": {"krbpwdminlength": ["14"], "krbpwdmindiffchars": ["4"], "ipapwdmaxrepeat": ["2"]}}}`))
+			default:
+				w.Write([]byte(`{"error": {"code": 4001, "message": "password policy not found", "name": "NotFound"}}`))
+			}
+		case "user_add":
+			options, _ := payload.Params[1].(map[string]any)
+			password, _ := options["userpassword"].(string)
+			*passwords = append(*passwords, password)
+			if len(*passwords) <= rejections {
+				w.Write([]byte(`{"error": {"code": 4203, "message": "Constraint violation: Password is too simple", "name": "ValidationError"}}`))
+				return
+			}
+			w.Write([]byte(`{"result": {"result": {}}}`))
+		default:
+			w.Write([]byte(`{"result": {"count": 0}}`))
+		}
+	})
+	return mux
+}
+
+func TestStrictestPasswordPolicy(t *testing.T) {
+	var passwords []string
+	setupIDMTestServerReplicate(t, newPolicyMux(0, &passwords))
+
+	client, _ := newHTTPClient(false)
+	policy := strictestPasswordPolicy(client, []string{"students", "nopolicy", ""})
+
+	expected := PasswordPolicy{MinLength: 14, MinClasses: 4, MaxRepeat: 2}
+	if policy != expected {
+		t.Errorf("expected %+v, got %+v", expected, policy)
+	}
+}
+
+func TestStricterLimit(t *testing.T) {
+	if stricterLimit(0, 3) != 3 || stricterLimit(3, 0) != 3 || stricterLimit(2, 3) != 2 || stricterLimit(0, 0) != 0 {
+		t.Error("stricterLimit() returned unexpected value")
+	}
+}
+
+func TestHandleMakeUserTempPasswordRetry(t *testing.T) {
+	var passwords []string
+	setupIDMTestServerReplicate(t, newPolicyMux(1, &passwords))
+	viper.Set("IDM_ADD_GROUP", "")
+
+	invite := models.Invite{
+		FirstName:      "Test",
+		LastName:       "User",
+		Email:          "test@example.com",
+		Country:        "USA",
+		Affiliation:    "staff",
+		State:          "CA",
+		Inviter:        "admin",
+		OptionalGroups: datatypes.JSON(`["students"]`),
+	}
+
+	password, err := HandleMakeUser(invite, "tuser", "", nil)
+	if err != nil {
+		t.Fatalf("HandleMakeUser() error: %v", err)
+	}
+	if len(passwords) != 2 {
+		t.Fatalf("expected 2 user_add calls, got %d", len(passwords))
+	}
+	if password != passwords[1] || password == passwords[0] {
+		t.Errorf("expected the accepted password to be returned")
+	}
+	if len(password) < 14 || passwordClasses(password) < 4 {
+		t.Errorf("expected password to meet students policy, got %q", password)
+	}
+}
+
+func TestHandleMakeUserTempPasswordGivesUp(t *testing.T) {
+	var passwords []string
+	setupIDMTestServerReplicate(t, newPolicyMux(tempPasswordAttempts, &passwords))
+
+	invite := models.Invite{
+		FirstName:      "Test",
+		LastName:       "User",
+		Email:          "test@example.com",
+		Country:        "USA",
+		Affiliation:    "staff",
+		State:          "CA",
+		Inviter:        "admin",
+		OptionalGroups: datatypes.JSON(`[]`),
+	}
+
+	if _, err := HandleMakeUser(invite, "tuser", "", nil); err == nil {
+		t.Error("expected error after all attempts rejected")
+	}
+	if len(passwords) != tempPasswordAttempts {
+		t.Errorf("expected %d user_add calls, got %d", tempPasswordAttempts, len(passwords))
+	}
+}
+
+func TestHandleMakeUserChosenPasswordNotReturned(t *testing.T) {
+	var passwords []string
+	setupIDMTestServerReplicate(t, newPolicyMux(0, &passwords))
+
+	invite := models.Invite{
+		FirstName:      "Test",
+		LastName:       "User",
+		Email:          "test@example.com",
+		Country:        "USA",
+		Affiliation:    "staff",
+		State:          "CA",
+		Inviter:        "admin",
+		OptionalGroups: datatypes.JSON(`[]`),
+	}
+
+	password, err := HandleMakeUser(invite, "tuser", "Chosen-Passw0rd", nil)
+	if err != nil {
+		t.Fatalf("HandleMakeUser() error: %v", err)
+	}
+	if password != "" {
+		t.Errorf("expected no temporary password, got %q", password)
+	}
+	if len(passwords) != 1 || passwords[0] != "Chosen-Passw0rd" {
+		t.Errorf("expected chosen password sent to user_add, got %v", passwords)
+	}
+}
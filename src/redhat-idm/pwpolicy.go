@@ -0,0 +1,257 @@
+package idm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/hadleyso/netid-activate/src/models"
+	"github.com/spf13/viper"
+)
+
+// IdM rejected a password for policy reasons
+var ErrPasswordPolicy = errors.New("password rejected by IdM password policy")
+
+// IdM password policy from pwpolicy_show
+type PasswordPolicy struct {
+	MinLength   int
+	MinClasses  int
+	History     int
+	MaxRepeat   int
+	MaxSequence int
+	UserCheck   bool
+	DictCheck   bool
+	// Days until a password the user set expires, zero never
+	MaxLife int
+}
+
+// Fetch password policy for group, empty group is the global policy
+func GetPasswordPolicy(group string) (PasswordPolicy, error) {
+	client, errClient := newHTTPClient(false)
+	if errClient != nil {
+		log.Println("GetPasswordPolicy() unable to newHTTPClient() " + errClient.Error())
+		return PasswordPolicy{}, errClient
+	}
+
+	username := viper.GetString("IDM_USERNAME")
+	password := viper.GetString("IDM_PASSWORD")
+	errLogin := login(client, username, password)
+	if errLogin != nil {
+		log.Println("GetPasswordPolicy() unable to login() with HTTPClient " + errLogin.Error())
+		return PasswordPolicy{}, errLogin
+	}
+
+	return getPasswordPolicy(client, group)
+}
+
+// Strictest policy of the global policy and the groups the user of
+// invite will join, the policy IdM checks the password against
+func GetInvitePasswordPolicy(invite models.Invite) (PasswordPolicy, error) {
+	groups, err := inviteGroups(invite)
+	if err != nil {
+		return PasswordPolicy{}, err
+	}
+
+	client, errClient := newHTTPClient(false)
+	if errClient != nil {
+		log.Println("GetInvitePasswordPolicy() unable to newHTTPClient() " + errClient.Error())
+		return PasswordPolicy{}, errClient
+	}
+
+	errLogin := login(client, viper.GetString("IDM_USERNAME"), viper.GetString("IDM_PASSWORD"))
+	if errLogin != nil {
+		log.Println("GetInvitePasswordPolicy() unable to login() with HTTPClient " + errLogin.Error())
+		return PasswordPolicy{}, errLogin
+	}
+
+	return strictestPasswordPolicy(client, groups), nil
+}
+
+// Client must be authenticated
+func getPasswordPolicy(client *http.Client, group string) (PasswordPolicy, error) {
+	args := []string{}
+	if group != "" {
+		args = []string{group}
+	}
+	return showPasswordPolicy(client, args, map[string]any{})
+}
+
+// Policy that applies to user, from its groups or the global policy
+// Client must be authenticated
+func userPasswordPolicy(client *http.Client, uid string) (PasswordPolicy, error) {
+	return showPasswordPolicy(client, []string{}, map[string]any{"user": uid})
+}
+
+// Client must be authenticated
+func showPasswordPolicy(client *http.Client, args []string, options map[string]any) (PasswordPolicy, error) {
+	rpcClient := newRPCClient(client)
+
+	params := []any{
+		args,
+		options,
+	}
+
+	resp, err := rpcClient.Call(context.Background(), "pwpolicy_show", params...)
+	if err != nil {
+		log.Println("getPasswordPolicy() call error " + err.Error())
+		return PasswordPolicy{}, err
+	}
+	if resp.Error != nil {
+		log.Println("getPasswordPolicy() response error " + resp.Error.Message)
+		return PasswordPolicy{}, fmt.Errorf("RPC error: %v", resp.Error.Message)
+	}
+
+	data, ok := resp.Result.(map[string]any)
+	if !ok {
+		return PasswordPolicy{}, fmt.Errorf("getPasswordPolicy() unexpected response type: %T", resp.Result)
+	}
+	result, _ := data["result"].(map[string]any)
+
+	return PasswordPolicy{
+		MinLength:   policyInt(result["krbpwdminlength"]),
+		MinClasses:  policyInt(result["krbpwdmindiffchars"]),
+		History:     policyInt(result["krbpwdhistorylength"]),
+		MaxRepeat:   policyInt(result["ipapwdmaxrepeat"]),
+		MaxSequence: policyInt(result["ipapwdmaxsequence"]),
+		UserCheck:   policyBool(result["ipapwdusercheck"]),
+		DictCheck:   policyBool(result["ipapwddictcheck"]),
+		MaxLife:     policyInt(result["krbmaxpwdlife"]),
+	}, nil
+}
+
+// IdM attributes come back as single value lists of strings or numbers
+func policyValue(v any) string {
+	if list, ok := v.([]any); ok {
+		if len(list) == 0 {
+			return ""
+		}
+		v = list[0]
+	}
+	switch value := v.(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	case bool:
+		return strconv.FormatBool(value)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+func policyInt(v any) int {
+	n, _ := strconv.Atoi(policyValue(v))
+	return n
+}
+
+func policyBool(v any) bool {
+	return strings.EqualFold(policyValue(v), "true")
+}
+
+// Character classes counted by IdM
+func passwordClasses(password string) int {
+	var lower, upper, digit, special, other bool
+	for _, c := range password {
+		switch {
+		case c > unicode.MaxASCII:
+			other = true
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsDigit(c):
+			digit = true
+		default:
+			special = true
+		}
+	}
+
+	count := 0
+	for _, class := range []bool{lower, upper, digit, special, other} {
+		if class {
+			count++
+		}
+	}
+	return count
+}
+
+// Problems with password under policy, empty if acceptable
+//
+// Names are checked when the policy enables the user check. The dictionary
+// check can only be done by IdM.
+func (p PasswordPolicy) Validate(password string, names ...string) []string {
+	var problems []string
+	runes := []rune(password)
+
+	if len(runes) == 0 {
+		return []string{"Password is required"}
+	}
+	if len(runes) < p.MinLength {
+		problems = append(problems, fmt.Sprintf("Must be at least %d characters", p.MinLength))
+	}
+	if passwordClasses(password) < p.MinClasses {
+		problems = append(problems, fmt.Sprintf("Must use at least %d of lowercase, uppercase, digits, symbols and non-ASCII characters", p.MinClasses))
+	}
+
+	if p.MaxRepeat > 0 {
+		run := 1
+		for i := 1; i < len(runes); i++ {
+			if runes[i] == runes[i-1] {
+				run++
+			} else {
+				run = 1
+			}
+			if run > p.MaxRepeat {
+				problems = append(problems, fmt.Sprintf("Must not repeat a character more than %d times in a row", p.MaxRepeat))
+				break
+			}
+		}
+	}
+
+	if p.MaxSequence > 0 {
+		run, step := 1, rune(0)
+		for i := 1; i < len(runes); i++ {
+			diff := runes[i] - runes[i-1]
+			if diff == 1 || diff == -1 {
+				if run > 1 && diff != step {
+					run = 1
+				}
+				run++
+				step = diff
+			} else {
+				run = 1
+			}
+			if run > p.MaxSequence {
+				problems = append(problems, fmt.Sprintf("Must not contain a sequence longer than %d characters", p.MaxSequence))
+				break
+			}
+		}
+	}
+
+	if p.UserCheck {
+		lower := strings.ToLower(password)
+		for _, name := range names {
+			if len(name) >= 3 && strings.Contains(lower, strings.ToLower(name)) {
+				problems = append(problems, "Must not contain your name or login name")
+				break
+			}
+		}
+	}
+
+	return problems
+}
+
+// Check user_add error for password policy rejection
+func isPasswordPolicyError(message string) bool {
+	lower := strings.ToLower(message)
+	return strings.Contains(lower, "password") &&
+		(strings.Contains(lower, "constraint violation") || strings.Contains(lower, "policy"))
+}
@@ -0,0 +1,153 @@
+package idm
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/hadleyso/netid-activate/src/models"
+	"gorm.io/datatypes"
+)
+
+func TestGetPasswordPolicy(t *testing.T) {
+	var gotParams []any
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ipa/session/login_password", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/ipa/session/json", func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Method string `json:"method"`
+			Params []any  `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+		gotParams = payload.Params
+
+		w.Header().Set("Content-Type", "application/json")
+		if payload.Method != "pwpolicy_show" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"result": {"result": {"cn": ["global_policy"], "krbpwdminlength": ["12"], "krbpwdmindiffchars": [3], "krbpwdhistorylength": ["5"], "ipapwdmaxrepeat": ["2"], "ipapwdmaxsequence": ["3"], "ipapwdusercheck": [true], "ipapwddictcheck": ["FALSE"]}, "value": "global_policy"}}`))
+	})
+	setupIDMTestServerReplicate(t, mux)
+
+	policy, err := GetPasswordPolicy("")
+	if err != nil {
+		t.Fatalf("GetPasswordPolicy() error: %v", err)
+	}
+	expected := PasswordPolicy{MinLength: 12, MinClasses: 3, History: 5, MaxRepeat: 2, MaxSequence: 3, UserCheck: true}
+	if policy != expected {
+		t.Errorf("expected %+v, got %+v", expected, policy)
+	}
+	if !reflect.DeepEqual(gotParams[0], []any{}) {
+		t.Errorf("expected global policy lookup, got %v", gotParams[0])
+	}
+
+	if _, err := GetPasswordPolicy("students"); err != nil {
+		t.Fatalf("GetPasswordPolicy() error: %v", err)
+	}
+	if !reflect.DeepEqual(gotParams[0], []any{"students"}) {
+		t.Errorf("expected group policy lookup, got %v", gotParams[0])
+	}
+}
+
+func TestGetPasswordPolicyError(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ipa/session/login_password", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/ipa/session/json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"error": {"code": 4001, "message": "students: password policy not found", "name": "NotFound"}}`))
+	})
+	setupIDMTestServerReplicate(t, mux)
+
+	if _, err := GetPasswordPolicy("students"); err == nil {
+		t.Error("expected error for missing policy")
+	}
+}
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	policy := PasswordPolicy{MinLength: 8, MinClasses: 3, MaxRepeat: 2, MaxSequence: 3, UserCheck: true}
+
+	accepted := []string{"Tr0ub4dor&3", "Pw-abc-9xQ"}
+	for _, password := range accepted {
+		if problems := policy.Validate(password, "jdoe", "Jane", "Doe"); len(problems) != 0 {
+			t.Errorf("expected %q accepted, got %v", password, problems)
+		}
+	}
+
+	tests := []struct {
+		password string
+		problem  string
+	}{
+		{"", "Password is required"},
+		{"Ab1!", "Must be at least 8 characters"},
+		{"abcdefghxyz", "Must use at least 3 of lowercase, uppercase, digits, symbols and non-ASCII characters"},
+		{"Paaassword1", "Must not repeat a character more than 2 times in a row"},
+		{"Pw-abcd-9x", "Must not contain a sequence longer than 3 characters"},
+		{"Pw-dcba-9x", "Must not contain a sequence longer than 3 characters"},
+		{"JDoe-secret-1", "Must not contain your name or login name"},
+	}
+	for _, tt := range tests {
+		problems := policy.Validate(tt.password, "jdoe", "Jane", "Doe")
+		if !slices.Contains(problems, tt.problem) {
+			t.Errorf("expected %q to fail with %q, got %v", tt.password, tt.problem, problems)
+		}
+	}
+
+	// Non-ASCII counts as its own class
+	if problems := (PasswordPolicy{MinClasses: 3}).Validate("pässwörd1"); len(problems) != 0 {
+		t.Errorf("expected non-ASCII class accepted, got %v", problems)
+	}
+
+	// Empty policy accepts anything
+	if problems := (PasswordPolicy{}).Validate("a"); len(problems) != 0 {
+		t.Errorf("expected empty policy to accept, got %v", problems)
+	}
+}
+
+func TestIsPasswordPolicyError(t *testing.T) {
+	if !isPasswordPolicyError("Constraint violation: Password is too short") {
+		t.Error("expected constraint violation to be a policy error")
+	}
+	if !isPasswordPolicyError("Password fails to meet minimum strength criteria (policy)") {
+		t.Error("expected strength message to be a policy error")
+	}
+	if isPasswordPolicyError(`user with name "jdoe" already exists`) {
+		t.Error("expected duplicate user not to be a policy error")
+	}
+}
+
+func TestMakeUserPasswordPolicyError(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ipa/session/login_password", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/ipa/session/json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"error": {"code": 4203, "message": "Constraint violation: Password is too short", "name": "ValidationError"}}`))
+	})
+	setupIDMTestServerReplicate(t, mux)
+
+	invite := models.Invite{
+		FirstName:      "Test",
+		LastName:       "User",
+		Email:          "test@example.com",
+		Country:        "USA",
+		Affiliation:    "staff",
+		State:          "CA",
+		Inviter:        "admin",
+		OptionalGroups: datatypes.JSON(`[]`),
+	}
+
+	_, err := HandleMakeUser(invite, "testuser-"+uuid.New().String()[:8], "short", nil)
+	if !errors.Is(err, ErrPasswordPolicy) {
+		t.Errorf("expected ErrPasswordPolicy, got %v", err)
+	}
+}
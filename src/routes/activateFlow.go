@@ -9,5 +9,8 @@ func activate() {
 	Router.HandleFunc("/activate", handlers.ActivateEmailGet).Methods("GET")
 	Router.HandleFunc("/otp", handlers.ActivateOTPPost).Methods("POST")
 	Router.HandleFunc("/login-name-select", handlers.CreateUser).Methods("POST")
+	Router.HandleFunc("/login-name-check", handlers.LoginNameCheck).Methods("POST")
+	Router.HandleFunc("/otp-enroll/{inviteID}", handlers.OTPEnrollGet).Methods("GET")
+	Router.HandleFunc("/otp-enroll/{inviteID}", handlers.OTPEnrollPost).Methods("POST")
 	Router.HandleFunc("/success/{inviteID}", handlers.CreateSuccess).Methods("GET")
 }
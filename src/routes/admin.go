@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"github.com/hadleyso/netid-activate/src/auth"
+	"github.com/hadleyso/netid-activate/src/handlers"
+)
+
+func admin() {
+	adminRouter := Router.PathPrefix("/admin").Subrouter()
+	adminRouter.Use(auth.MiddleValidateSession)
+	adminRouter.Use(auth.MiddleAdmin)
+
+	adminRouter.HandleFunc("/sessions", handlers.AdminSessions).Methods("GET")
+	adminRouter.HandleFunc("/sessions", handlers.AdminRevokeSessions).Methods("POST")
+}
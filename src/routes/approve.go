@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"github.com/hadleyso/netid-activate/src/auth"
+	"github.com/hadleyso/netid-activate/src/handlers"
+)
+
+func approve() {
+	approveRouter := Router.PathPrefix("/approve").Subrouter()
+	approveRouter.Use(auth.MiddleValidateSession)
+	approveRouter.Use(auth.MiddleApprover)
+
+	approveRouter.HandleFunc("/", handlers.ApprovalList).Methods("GET")
+	approveRouter.HandleFunc("/approve", handlers.ApprovalApprove).Methods("POST")
+	approveRouter.HandleFunc("/reject", handlers.ApprovalReject).Methods("POST")
+}
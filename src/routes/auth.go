@@ -40,7 +40,12 @@ func authRoutes() {
 		redirectURI = fmt.Sprintf("%s%v", SERVER_HOSTNAME, auth.CallbackPath)
 	}
 
-	cookieHandler := httphelper.NewCookieHandler([]byte(viper.GetString("SESSION_KEY")), []byte(viper.GetString("SESSION_KEY")), httphelper.WithUnsecure())
+	// OIDC state cookie, same attributes as auth.SessionOptions
+	cookieOptions := []httphelper.CookieHandlerOpt{httphelper.WithSameSite(http.SameSiteLaxMode)}
+	if !auth.SecureCookies() {
+		cookieOptions = append(cookieOptions, httphelper.WithUnsecure())
+	}
+	cookieHandler := httphelper.NewCookieHandler([]byte(viper.GetString("SESSION_KEY")), []byte(viper.GetString("SESSION_KEY")), cookieOptions...)
 
 	// Set Relying Party settings
 	options := []rp.Option{
@@ -77,15 +82,10 @@ func authRoutes() {
 }
 
 func handleLogout(w http.ResponseWriter, r *http.Request) {
+	auth.ClearSession(w, r)
+
 	for _, cookie := range r.Cookies() {
-		http.SetCookie(w, &http.Cookie{
-			Name:     cookie.Name,
-			Value:    "",
-			Path:     "/",
-			Expires:  time.Unix(0, 0),
-			MaxAge:   -1,
-			HttpOnly: true,
-		})
+		http.SetCookie(w, auth.NewCookie(cookie.Name, "", -1))
 	}
 
 	http.Redirect(w, r, hqRelyingParty.GetEndSessionEndpoint(), http.StatusSeeOther)
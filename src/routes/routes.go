@@ -4,12 +4,18 @@ import (
 	"log"
 
 	"github.com/gorilla/mux"
+	"github.com/hadleyso/netid-activate/src/auth"
+	"github.com/hadleyso/netid-activate/src/proxy"
 )
 
 // Routing
 var Router = mux.NewRouter()
 
 func Main() {
+	Router.Use(proxy.NewMiddleware())
+	Router.Use(auth.MiddleSecurityHeaders)
+	Router.Use(auth.NewCSRFMiddleware())
+
 	static()
 	errorRoutes()
 	status()
@@ -17,5 +23,8 @@ func Main() {
 	landing()
 	activate()
 	invite()
+	admin()
+	approve()
+	sponsor()
 	log.Println("Routes registered [src/routes/routes]")
 }
@@ -0,0 +1,11 @@
+package routes
+
+import (
+	"github.com/hadleyso/netid-activate/src/handlers"
+)
+
+// Links emailed to sponsors, authorized by their signature
+func sponsor() {
+	Router.HandleFunc("/sponsor/{id}/{signature}", handlers.SponsorGet).Methods("GET")
+	Router.HandleFunc("/sponsor/{id}/{signature}", handlers.SponsorPost).Methods("POST")
+}
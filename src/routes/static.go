@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"text/template"
 
+	"github.com/hadleyso/netid-activate/src/auth"
 	"github.com/hadleyso/netid-activate/src/models"
 	"github.com/hadleyso/netid-activate/src/scenes"
 )
@@ -44,7 +45,7 @@ func errorRoutes() {
 }
 
 func status() {
-	Router.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+	Router.Handle("/status", auth.MiddleClientCert(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		status := Status{
 			Version:   Version,
 			GitCommit: GitCommit,
@@ -52,5 +53,5 @@ func status() {
 		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(status)
-	})
+	})))
 }
@@ -0,0 +1,180 @@
+package scim
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/hadleyso/netid-activate/src/countries"
+	"github.com/hadleyso/netid-activate/src/models"
+)
+
+// SCIM User resource attributes set here, RFC 7643 section 4.1
+type user struct {
+	Schemas     []string  `json:"schemas"`
+	ID          string    `json:"id,omitempty"`
+	UserName    string    `json:"userName"`
+	Name        name      `json:"name"`
+	DisplayName string    `json:"displayName,omitempty"`
+	UserType    string    `json:"userType,omitempty"`
+	Active      bool      `json:"active"`
+	Password    string    `json:"password,omitempty"`
+	Emails      []email   `json:"emails,omitempty"`
+	Addresses   []address `json:"addresses,omitempty"`
+}
+
+type name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type address struct {
+	Region  string `json:"region,omitempty"`
+	Country string `json:"country,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// Creates users in the service provider for directory.HandleMakeUser
+type Maker struct {
+	c *client
+}
+
+func NewMaker() (*Maker, error) {
+	c, err := newClient(SettingsFromConfig())
+	if err != nil {
+		log.Println("NewMaker() unable to newClient() " + err.Error())
+		return nil, err
+	}
+	return &Maker{c: c}, nil
+}
+
+// Groups from SCIM_ADD_GROUP every user joins
+func (m *Maker) DefaultGroups() []string {
+	return m.c.settings.AddGroups
+}
+
+// Create user, returns the id assigned by the service provider
+// SCIM has no temporary password flag or SSH key attribute, both are dropped
+func (m *Maker) MakeUser(loginName string, invite models.Invite, password string, temporary bool, sshKeys []string) (string, error) {
+	if len(sshKeys) > 0 {
+		log.Println("MakeUser() SSH keys not stored, SCIM has no SSH key attribute")
+	}
+	return m.c.makeUser(loginName, invite, password)
+}
+
+func (m *Maker) AddUserGroups(userID string, groups []string) error {
+	return m.c.addUserGroups(userID, groups)
+}
+
+// Nothing to release, requests are not kept open
+func (m *Maker) Close() {}
+
+// POST /Users, returns the id assigned by the service provider
+func (c *client) makeUser(loginName string, invite models.Invite, password string) (string, error) {
+	alpha2, err := countries.GetAlpha2FromAlpha3(invite.Country)
+	if err != nil {
+		return "", err
+	}
+
+	displayName := invite.FirstName + " " + invite.LastName
+	newUser := user{
+		Schemas:  []string{schemaUser},
+		UserName: loginName,
+		Name: name{
+			Formatted:  displayName,
+			GivenName:  invite.FirstName,
+			FamilyName: invite.LastName,
+		},
+		DisplayName: displayName,
+		UserType:    invite.Affiliation,
+		Active:      true,
+		Password:    password,
+		Emails:      []email{{Value: invite.Email, Type: "work", Primary: true}},
+		Addresses:   []address{{Region: invite.State, Country: alpha2, Type: "work", Primary: true}},
+	}
+
+	var created user
+	err = c.do("POST", "/Users", nil, newUser, &created)
+	if isStatus(err, http.StatusConflict) {
+		// uniqueness does not say which attribute, an email conflict
+		// is not solved by another login name
+		if count, errCount := c.countUsers(eqFilter("userName", loginName)); errCount == nil && count > 0 {
+			return "", fmt.Errorf("%w: %v", ErrLoginNameExists, err)
+		}
+		return "", err
+	}
+	if isPasswordPolicyError(err) {
+		return "", fmt.Errorf("%w: %v", ErrPasswordPolicy, err)
+	}
+	if err != nil {
+		return "", err
+	}
+	if created.ID == "" {
+		return "", errors.New("SCIM user created without an id")
+	}
+	return created.ID, nil
+}
+
+// Add user to groups by displayName
+// Groups are tried in turn, returns the last error
+func (c *client) addUserGroups(userID string, groups []string) error {
+	var lastErr error
+	for _, group := range groups {
+		if group == "" {
+			continue
+		}
+		err := c.addUserGroup(userID, group)
+		if err != nil {
+			log.Println("addUserGroups() unable to add to " + group + " " + err.Error())
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// PATCH the group found by displayName with the user as a new member
+func (c *client) addUserGroup(userID string, group string) error {
+	query := url.Values{
+		"filter":     {eqFilter("displayName", group)},
+		"attributes": {"id,displayName"},
+	}
+	var list listResponse[struct {
+		ID string `json:"id"`
+	}]
+	if err := c.do("GET", "/Groups", query, nil, &list); err != nil {
+		return err
+	}
+	if len(list.Resources) == 0 {
+		return fmt.Errorf("group %s not found", group)
+	}
+
+	patch := map[string]any{
+		"schemas": []string{schemaPatch},
+		"Operations": []map[string]any{{
+			"op":    "add",
+			"path":  "members",
+			"value": []map[string]string{{"value": userID}},
+		}},
+	}
+	return c.do("PATCH", "/Groups/"+url.PathEscape(list.Resources[0].ID), nil, patch, nil)
+}
+
+// Check create error for password policy rejection
+func isPasswordPolicyError(err error) bool {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusBadRequest {
+		return false
+	}
+	return strings.Contains(strings.ToLower(apiErr.Detail), "password")
+}
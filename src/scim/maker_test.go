@@ -0,0 +1,106 @@
+package scim
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/hadleyso/netid-activate/src/models"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func testInvite() models.Invite {
+	return models.Invite{
+		FirstName:   "Jane",
+		LastName:    "Doe",
+		Email:       "jane@example.com",
+		Country:     "USA",
+		Affiliation: "Staff",
+		State:       "GA",
+		Inviter:     "admin",
+	}
+}
+
+func newTestMaker(t *testing.T) *Maker {
+	m, err := NewMaker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(m.Close)
+	return m
+}
+
+func TestMakeUser(t *testing.T) {
+	stub := newSCIMStub()
+	stub.putGroup("g1", "staff")
+	stub.putGroup("g2", "vpn")
+	setupSCIMTestServer(t, stub)
+	viper.Set("SCIM_ADD_GROUP", "vpn")
+	m := newTestMaker(t)
+	assert.Equal(t, []string{"vpn"}, m.DefaultGroups())
+
+	userID, err := m.MakeUser("janedoe", testInvite(), "Correct-Horse-9", false, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, m.AddUserGroups(userID, []string{"staff", "vpn"}))
+
+	u, ok := stub.user("janedoe")
+	if assert.True(t, ok) {
+		assert.Equal(t, u.ID, userID)
+		assert.Equal(t, []string{schemaUser}, u.Schemas)
+		assert.Equal(t, name{Formatted: "Jane Doe", GivenName: "Jane", FamilyName: "Doe"}, u.Name)
+		assert.Equal(t, "Jane Doe", u.DisplayName)
+		assert.Equal(t, "Staff", u.UserType)
+		assert.True(t, u.Active)
+		assert.Equal(t, "Correct-Horse-9", u.Password)
+		assert.Equal(t, []email{{Value: "jane@example.com", Type: "work", Primary: true}}, u.Emails)
+		assert.Equal(t, []address{{Region: "GA", Country: "US", Type: "work", Primary: true}}, u.Addresses)
+		assert.Equal(t, []string{u.ID}, stub.members("g1"))
+		assert.Equal(t, []string{u.ID}, stub.members("g2"))
+	}
+}
+
+func TestMakeUserExists(t *testing.T) {
+	stub := newSCIMStub()
+	stub.putUser("1", "janedoe", "other@example.com")
+	setupSCIMTestServer(t, stub)
+	m := newTestMaker(t)
+
+	_, err := m.MakeUser("janedoe", testInvite(), "Correct-Horse-9", false, nil)
+	assert.True(t, errors.Is(err, ErrLoginNameExists))
+
+	// Email uniqueness conflicts are not a taken login name
+	stub.putUser("2", "other", "jane@example.com")
+	_, err = m.MakeUser("jane.doe", testInvite(), "Correct-Horse-9", false, nil)
+	assert.True(t, isStatus(err, http.StatusConflict))
+	assert.False(t, errors.Is(err, ErrLoginNameExists))
+}
+
+func TestMakeUserPasswordPolicy(t *testing.T) {
+	stub := newSCIMStub()
+	stub.createStatus = http.StatusBadRequest
+	stub.createType = "invalidValue"
+	stub.createDetail = "Password does not meet complexity requirements"
+	setupSCIMTestServer(t, stub)
+
+	_, err := newTestMaker(t).MakeUser("janedoe", testInvite(), "password", false, nil)
+	assert.True(t, errors.Is(err, ErrPasswordPolicy))
+	assert.Equal(t, 1, stub.creates)
+}
+
+func TestAddUserGroupsMissingGroup(t *testing.T) {
+	stub := newSCIMStub()
+	stub.putGroup("g1", "staff")
+	setupSCIMTestServer(t, stub)
+
+	// Later groups are still tried
+	assert.Error(t, newTestMaker(t).AddUserGroups("1", []string{"nosuchgroup", "staff"}))
+	assert.Equal(t, []string{"1"}, stub.members("g1"))
+}
+
+func TestIsPasswordPolicyError(t *testing.T) {
+	assert.True(t, isPasswordPolicyError(&apiError{Status: http.StatusBadRequest, SCIMType: "invalidValue", Detail: "password too short"}))
+	assert.False(t, isPasswordPolicyError(&apiError{Status: http.StatusBadRequest, SCIMType: "invalidValue", Detail: "Invalid email"}))
+	assert.False(t, isPasswordPolicyError(&apiError{Status: http.StatusForbidden, Detail: "password"}))
+	assert.False(t, isPasswordPolicyError(nil))
+}
@@ -0,0 +1,180 @@
+package scim
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	idm "github.com/hadleyso/netid-activate/src/redhat-idm"
+	"github.com/spf13/viper"
+)
+
+// Service provider already has a user with the login name
+var ErrLoginNameExists = errors.New("login name already exists in the SCIM service provider")
+
+// Service provider rejected a password for policy reasons
+var ErrPasswordPolicy = errors.New("password rejected by the SCIM service provider")
+
+const (
+	schemaUser      = "urn:ietf:params:scim:schemas:core:2.0:User"
+	schemaPatch     = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	contentTypeSCIM = "application/scim+json"
+)
+
+// SCIM service provider settings
+type Settings struct {
+	URL       string
+	Token     string
+	AddGroups []string
+}
+
+// Settings from SCIM_* config
+func SettingsFromConfig() Settings {
+	settings := Settings{
+		URL:   strings.TrimRight(viper.GetString("SCIM_URL"), "/"),
+		Token: viper.GetString("SCIM_TOKEN"),
+	}
+	for _, group := range strings.Split(viper.GetString("SCIM_ADD_GROUP"), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			settings.AddGroups = append(settings.AddGroups, group)
+		}
+	}
+	return settings
+}
+
+// Check settings are usable, run at startup
+func (s Settings) Validate() error {
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("SCIM_URL %q must be an http:// or https:// URL", s.URL)
+	}
+	if s.Token == "" {
+		return errors.New("SCIM_TOKEN must be set")
+	}
+	return nil
+}
+
+// Check SCIM settings from config
+func CheckConfig() error {
+	return SettingsFromConfig().Validate()
+}
+
+// SCIM error response, RFC 7644 section 3.12
+type apiError struct {
+	Status   int
+	SCIMType string
+	Detail   string
+}
+
+func (e *apiError) Error() string {
+	if e.SCIMType != "" {
+		return fmt.Sprintf("SCIM returned %d %s: %s", e.Status, e.SCIMType, e.Detail)
+	}
+	return fmt.Sprintf("SCIM returned %d: %s", e.Status, e.Detail)
+}
+
+// Check for a SCIM error with status
+func isStatus(err error, status int) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && apiErr.Status == status
+}
+
+// Client for the service provider with the bearer token
+type client struct {
+	settings Settings
+	http     *http.Client
+}
+
+func newClient(s Settings) (*client, error) {
+	rootCAs, err := idm.RootCAs()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{RootCAs: rootCAs}
+
+	return &client{
+		settings: s,
+		http: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+			Timeout:   30 * time.Second,
+		},
+	}, nil
+}
+
+// Call endpoint path below SCIM_URL, decoding the JSON response into out
+// when set. Errors for responses other than 2xx are *apiError.
+func (c *client) do(method string, path string, query url.Values, body any, out any) error {
+	u := c.settings.URL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, u, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.settings.Token)
+	req.Header.Set("Accept", contentTypeSCIM+", application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", contentTypeSCIM)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return responseError(resp)
+	}
+	if out != nil && resp.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func responseError(resp *http.Response) error {
+	var body struct {
+		SCIMType string `json:"scimType"`
+		Detail   string `json:"detail"`
+	}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	_ = json.Unmarshal(b, &body)
+
+	detail := body.Detail
+	if detail == "" {
+		detail = http.StatusText(resp.StatusCode)
+	}
+	return &apiError{Status: resp.StatusCode, SCIMType: body.SCIMType, Detail: detail}
+}
+
+// Filter comparing attribute to value, the value quoted as a JSON string
+func eqFilter(attribute string, value string) string {
+	quoted, _ := json.Marshal(value)
+	return attribute + " eq " + string(quoted)
+}
+
+// SCIM ListResponse with resources decoded into T
+type listResponse[T any] struct {
+	TotalResults int `json:"totalResults"`
+	Resources    []T `json:"Resources"`
+}
@@ -0,0 +1,299 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+const testToken = "scim-token"
+
+// setupSCIMTestServer creates a test HTTP server and configures viper to use it.
+func setupSCIMTestServer(t *testing.T, handler http.Handler) *httptest.Server {
+	server := httptest.NewServer(handler)
+	viper.Set("SCIM_URL", server.URL+"/scim/v2")
+	viper.Set("SCIM_TOKEN", testToken)
+	t.Cleanup(func() {
+		server.Close()
+		viper.Set("SCIM_URL", nil)
+		viper.Set("SCIM_TOKEN", nil)
+		viper.Set("SCIM_ADD_GROUP", nil)
+	})
+	return server
+}
+
+// In-process SCIM service provider with userName, emails.value and
+// displayName eq filters, user create and group member patches
+type scimStub struct {
+	mu     sync.Mutex
+	users  map[string]user
+	groups map[string]*stubGroup
+	// Status, scimType and detail returned for user creates, if set
+	createStatus int
+	createType   string
+	createDetail string
+	creates      int
+	// Filters received for /Users
+	filters []string
+}
+
+type stubGroup struct {
+	ID          string
+	DisplayName string
+	Members     []string
+}
+
+func newSCIMStub() *scimStub {
+	return &scimStub{users: map[string]user{}, groups: map[string]*stubGroup{}}
+}
+
+func (s *scimStub) putUser(id string, userName string, emailAddress string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[id] = user{ID: id, UserName: userName, Active: true, Emails: []email{{Value: emailAddress}}}
+}
+
+func (s *scimStub) putGroup(id string, displayName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.groups[id] = &stubGroup{ID: id, DisplayName: displayName}
+}
+
+// User with userName, ok false if none
+func (s *scimStub) user(userName string) (user, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if u.UserName == userName {
+			return u, true
+		}
+	}
+	return user{}, false
+}
+
+func (s *scimStub) members(id string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.groups[id].Members
+}
+
+func writeSCIM(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", contentTypeSCIM)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeSCIMError(w http.ResponseWriter, status int, scimType string, detail string) {
+	writeSCIM(w, status, map[string]string{
+		"schemas":  "urn:ietf:params:scim:api:messages:2.0:Error",
+		"status":   fmt.Sprint(status),
+		"scimType": scimType,
+		"detail":   detail,
+	})
+}
+
+// Parse `attribute eq "value"`, userName and emails are case insensitive
+func parseEqFilter(filter string) (string, string, bool) {
+	attribute, quoted, found := strings.Cut(filter, " eq ")
+	if !found {
+		return "", "", false
+	}
+	var value string
+	if err := json.Unmarshal([]byte(quoted), &value); err != nil {
+		return "", "", false
+	}
+	return attribute, value, true
+}
+
+func (s *scimStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+testToken {
+		writeSCIMError(w, http.StatusUnauthorized, "", "Invalid bearer token")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/scim/v2")
+	switch {
+	case r.Method == "GET" && path == "/Users":
+		s.listUsers(w, r)
+	case r.Method == "POST" && path == "/Users":
+		s.createUser(w, r)
+	case r.Method == "PATCH" && strings.HasPrefix(path, "/Users/"):
+		s.patchUser(w, r, strings.TrimPrefix(path, "/Users/"))
+	case r.Method == "GET" && path == "/Groups":
+		s.listGroups(w, r)
+	case r.Method == "PATCH" && strings.HasPrefix(path, "/Groups/"):
+		s.patchGroup(w, r, strings.TrimPrefix(path, "/Groups/"))
+	default:
+		writeSCIMError(w, http.StatusNotFound, "", "Resource not found")
+	}
+}
+
+func (s *scimStub) listUsers(w http.ResponseWriter, r *http.Request) {
+	filter := r.URL.Query().Get("filter")
+	s.filters = append(s.filters, filter)
+
+	attribute, value, ok := parseEqFilter(filter)
+	if !ok || (attribute != "userName" && attribute != "emails.value") {
+		writeSCIMError(w, http.StatusBadRequest, "invalidFilter", "Unsupported filter "+filter)
+		return
+	}
+
+	found := []map[string]string{}
+	for _, u := range s.users {
+		match := strings.EqualFold(u.UserName, value)
+		if attribute == "emails.value" {
+			match = len(u.Emails) > 0 && strings.EqualFold(u.Emails[0].Value, value)
+		}
+		if match {
+			found = append(found, map[string]string{"id": u.ID})
+		}
+	}
+	writeSCIM(w, http.StatusOK, map[string]any{
+		"schemas":      []string{"urn:ietf:params:scim:api:messages:2.0:ListResponse"},
+		"totalResults": len(found),
+		"Resources":    found,
+	})
+}
+
+func (s *scimStub) createUser(w http.ResponseWriter, r *http.Request) {
+	s.creates++
+
+	var u user
+	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+		writeSCIMError(w, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+	if s.createStatus != 0 {
+		writeSCIMError(w, s.createStatus, s.createType, s.createDetail)
+		return
+	}
+	for _, existing := range s.users {
+		if strings.EqualFold(existing.UserName, u.UserName) || strings.EqualFold(existing.Emails[0].Value, u.Emails[0].Value) {
+			writeSCIMError(w, http.StatusConflict, "uniqueness", "User already exists")
+			return
+		}
+	}
+
+	u.ID = fmt.Sprintf("user-%d", len(s.users)+1)
+	s.users[u.ID] = u
+
+	// The password is never returned
+	u.Password = ""
+	writeSCIM(w, http.StatusCreated, u)
+}
+
+// Only replace of active is supported
+func (s *scimStub) patchUser(w http.ResponseWriter, r *http.Request, id string) {
+	u, ok := s.users[id]
+	if !ok {
+		writeSCIMError(w, http.StatusNotFound, "", "User not found")
+		return
+	}
+
+	var patch struct {
+		Operations []struct {
+			Op    string `json:"op"`
+			Path  string `json:"path"`
+			Value bool   `json:"value"`
+		} `json:"Operations"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil || len(patch.Operations) != 1 || patch.Operations[0].Op != "replace" || patch.Operations[0].Path != "active" {
+		writeSCIMError(w, http.StatusBadRequest, "invalidSyntax", "Unsupported patch")
+		return
+	}
+	u.Active = patch.Operations[0].Value
+	s.users[id] = u
+	writeSCIM(w, http.StatusOK, u)
+}
+
+func (s *scimStub) listGroups(w http.ResponseWriter, r *http.Request) {
+	attribute, value, ok := parseEqFilter(r.URL.Query().Get("filter"))
+	if !ok || attribute != "displayName" {
+		writeSCIMError(w, http.StatusBadRequest, "invalidFilter", "Unsupported filter")
+		return
+	}
+
+	found := []map[string]string{}
+	for _, g := range s.groups {
+		if g.DisplayName == value {
+			found = append(found, map[string]string{"id": g.ID, "displayName": g.DisplayName})
+		}
+	}
+	writeSCIM(w, http.StatusOK, map[string]any{"totalResults": len(found), "Resources": found})
+}
+
+func (s *scimStub) patchGroup(w http.ResponseWriter, r *http.Request, id string) {
+	g, ok := s.groups[id]
+	if !ok {
+		writeSCIMError(w, http.StatusNotFound, "", "Group not found")
+		return
+	}
+
+	var patch struct {
+		Schemas    []string `json:"schemas"`
+		Operations []struct {
+			Op    string `json:"op"`
+			Path  string `json:"path"`
+			Value []struct {
+				Value string `json:"value"`
+			} `json:"value"`
+		} `json:"Operations"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil || len(patch.Schemas) != 1 || patch.Schemas[0] != schemaPatch {
+		writeSCIMError(w, http.StatusBadRequest, "invalidSyntax", "Invalid PatchOp")
+		return
+	}
+	for _, op := range patch.Operations {
+		if op.Op != "add" || op.Path != "members" {
+			writeSCIMError(w, http.StatusBadRequest, "invalidPath", "Unsupported operation")
+			return
+		}
+		for _, member := range op.Value {
+			g.Members = append(g.Members, member.Value)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestSettingsFromConfig(t *testing.T) {
+	server := setupSCIMTestServer(t, newSCIMStub())
+	viper.Set("SCIM_URL", server.URL+"/scim/v2/")
+	viper.Set("SCIM_ADD_GROUP", "staff, ,vpn")
+
+	settings := SettingsFromConfig()
+	assert.Equal(t, server.URL+"/scim/v2", settings.URL)
+	assert.Equal(t, testToken, settings.Token)
+	assert.Equal(t, []string{"staff", "vpn"}, settings.AddGroups)
+	assert.NoError(t, settings.Validate())
+}
+
+func TestSettingsValidate(t *testing.T) {
+	assert.Error(t, Settings{URL: "ftp://scim.example.org", Token: testToken}.Validate())
+	assert.Error(t, Settings{URL: "https://", Token: testToken}.Validate())
+	assert.Error(t, Settings{URL: "https://scim.example.org/v2"}.Validate())
+	assert.NoError(t, Settings{URL: "https://scim.example.org/v2", Token: testToken}.Validate())
+}
+
+func TestBadToken(t *testing.T) {
+	setupSCIMTestServer(t, newSCIMStub())
+	viper.Set("SCIM_TOKEN", "wrong")
+
+	_, err := CheckEmailExists("jdoe@example.com")
+	assert.True(t, isStatus(err, http.StatusUnauthorized))
+	assert.ErrorContains(t, err, "Invalid bearer token")
+}
+
+func TestEqFilter(t *testing.T) {
+	assert.Equal(t, `userName eq "jdoe"`, eqFilter("userName", "jdoe"))
+	assert.Equal(t, `displayName eq "a \"b\" \\ c"`, eqFilter("displayName", `a "b" \ c`))
+}
@@ -0,0 +1,106 @@
+package scim
+
+import (
+	"log"
+	"net/url"
+)
+
+// Checks if userNames exist in the service provider
+// Returns names that don't exist
+func CheckUsernamesExists(loginNames []string) ([]string, error) {
+	okUsername := []string{}
+
+	c, err := newClient(SettingsFromConfig())
+	if err != nil {
+		log.Println("CheckUsernamesExists() unable to newClient() " + err.Error())
+		return okUsername, err
+	}
+
+	for _, loginName := range loginNames {
+		count, err := c.countUsers(eqFilter("userName", loginName))
+		if err != nil {
+			log.Println("CheckUsernamesExists() unable to countUsers() " + err.Error())
+			return okUsername, err
+		}
+		if count == 0 {
+			okUsername = append(okUsername, loginName)
+		}
+	}
+
+	return okUsername, nil
+}
+
+// Checks if login name is unused
+func LoginNameAvailable(loginName string) (bool, error) {
+	free, err := CheckUsernamesExists([]string{loginName})
+	if err != nil {
+		return false, err
+	}
+	return len(free) == 1, nil
+}
+
+// Checks if a user with email exists in the service provider
+func CheckEmailExists(email string) (bool, error) {
+	c, err := newClient(SettingsFromConfig())
+	if err != nil {
+		log.Println("CheckEmailExists() unable to newClient() " + err.Error())
+		return false, err
+	}
+
+	count, err := c.countUsers(eqFilter("emails.value", email))
+	if err != nil {
+		log.Println("CheckEmailExists() unable to countUsers() " + err.Error())
+		return false, err
+	}
+	return count != 0, nil
+}
+
+// Set the user with login name inactive so it can no longer log in
+// A user already deleted is not an error
+func DisableUser(loginName string) error {
+	c, err := newClient(SettingsFromConfig())
+	if err != nil {
+		log.Println("DisableUser() unable to newClient() " + err.Error())
+		return err
+	}
+
+	query := url.Values{
+		"filter":     {eqFilter("userName", loginName)},
+		"attributes": {"id"},
+	}
+	var list listResponse[struct {
+		ID string `json:"id"`
+	}]
+	if err := c.do("GET", "/Users", query, nil, &list); err != nil {
+		log.Println("DisableUser() unable to find user " + err.Error())
+		return err
+	}
+	if len(list.Resources) == 0 {
+		log.Println("DisableUser() user " + loginName + " not found, nothing to disable")
+		return nil
+	}
+
+	patch := map[string]any{
+		"schemas": []string{schemaPatch},
+		"Operations": []map[string]any{{
+			"op":    "replace",
+			"path":  "active",
+			"value": false,
+		}},
+	}
+	return c.do("PATCH", "/Users/"+url.PathEscape(list.Resources[0].ID), nil, patch, nil)
+}
+
+// Number of users matching filter
+func (c *client) countUsers(filter string) (int, error) {
+	query := url.Values{
+		"filter":     {filter},
+		"attributes": {"id"},
+		"count":      {"1"},
+	}
+	var list listResponse[struct{}]
+	if err := c.do("GET", "/Users", query, nil, &list); err != nil {
+		return 0, err
+	}
+	return max(list.TotalResults, len(list.Resources)), nil
+}
@@ -0,0 +1,68 @@
+package scim
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckUsernamesExists(t *testing.T) {
+	stub := newSCIMStub()
+	stub.putUser("1", "jdoe", "jdoe@example.com")
+	setupSCIMTestServer(t, stub)
+
+	free, err := CheckUsernamesExists([]string{"JDoe", "johndoe"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"johndoe"}, free)
+	assert.Equal(t, []string{`userName eq "JDoe"`, `userName eq "johndoe"`}, stub.filters)
+}
+
+func TestLoginNameAvailable(t *testing.T) {
+	stub := newSCIMStub()
+	stub.putUser("1", "jdoe", "jdoe@example.com")
+	setupSCIMTestServer(t, stub)
+
+	available, err := LoginNameAvailable("jdoe")
+	assert.NoError(t, err)
+	assert.False(t, available)
+
+	available, err = LoginNameAvailable("janedoe")
+	assert.NoError(t, err)
+	assert.True(t, available)
+}
+
+func TestCheckEmailExists(t *testing.T) {
+	stub := newSCIMStub()
+	stub.putUser("1", "jdoe", "jdoe@example.com")
+	setupSCIMTestServer(t, stub)
+
+	exists, err := CheckEmailExists("JDoe@example.com")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, []string{`emails.value eq "JDoe@example.com"`}, stub.filters)
+
+	exists, err = CheckEmailExists("jane@example.com")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestCheckEmailExistsUnreachable(t *testing.T) {
+	server := setupSCIMTestServer(t, newSCIMStub())
+	server.Close()
+
+	_, err := CheckEmailExists("jdoe@example.com")
+	assert.Error(t, err)
+}
+
+func TestDisableUser(t *testing.T) {
+	stub := newSCIMStub()
+	stub.putUser("1", "jdoe", "jdoe@example.com")
+	setupSCIMTestServer(t, stub)
+
+	assert.NoError(t, DisableUser("jdoe"))
+	u, _ := stub.user("jdoe")
+	assert.False(t, u.Active)
+
+	// Deleted users are skipped
+	assert.NoError(t, DisableUser("janedoe"))
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"crypto/tls"
+	"log"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Serves a TLS certificate and key pair from disk,
+// reloading when either file changes
+type certReloader struct {
+	certPath string
+	keyPath  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+
+	watcher *fsnotify.Watcher
+}
+
+// Load certificate and key and start watching for changes
+func newCertReloader(certPath string, keyPath string) (*certReloader, error) {
+	c := &certReloader{certPath: certPath, keyPath: keyPath}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	// Watch directories, certificate renewals usually replace the file
+	dirs := map[string]bool{filepath.Dir(certPath): true, filepath.Dir(keyPath): true}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+	}
+	c.watcher = watcher
+
+	go c.watch()
+	return c, nil
+}
+
+func (c *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certPath, c.keyPath)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.cert = &cert
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *certReloader) watch() {
+	certPath := filepath.Clean(c.certPath)
+	keyPath := filepath.Clean(c.keyPath)
+
+	for {
+		select {
+		case event, ok := <-c.watcher.Events:
+			if !ok {
+				return
+			}
+			name := filepath.Clean(event.Name)
+			if name != certPath && name != keyPath {
+				continue
+			}
+			if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) && !event.Has(fsnotify.Rename) {
+				continue
+			}
+
+			// Keep serving the old pair if the new one is incomplete
+			if err := c.reload(); err != nil {
+				log.Println("certReloader unable to reload TLS certificate " + err.Error())
+				continue
+			}
+			log.Println("certReloader reloaded TLS certificate " + c.certPath)
+
+		case err, ok := <-c.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Println("certReloader watch error " + err.Error())
+		}
+	}
+}
+
+// tls.Config.GetCertificate callback
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// Stop watching for changes
+func (c *certReloader) Close() error {
+	return c.watcher.Close()
+}
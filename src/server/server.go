@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/hadleyso/netid-activate/src/directory"
+	"github.com/spf13/viper"
+)
+
+// Address to listen on
+// LISTEN_ADDR, otherwise localhost:SERVER_PORT
+func ListenAddr() string {
+	if addr := viper.GetString("LISTEN_ADDR"); addr != "" {
+		return addr
+	}
+	return fmt.Sprintf("localhost:%v", viper.GetString("SERVER_PORT"))
+}
+
+// Time allowed for requests and account creations to finish on shutdown
+func shutdownTimeout() time.Duration {
+	if viper.IsSet("SHUTDOWN_TIMEOUT") {
+		return viper.GetDuration("SHUTDOWN_TIMEOUT")
+	}
+	return 30 * time.Second
+}
+
+// Build TLS config from TLS_CERT_PATH, TLS_KEY_PATH and TLS_CLIENT_CA_PATH
+// Returns nil if TLS is not configured
+func newTLSConfig() (*tls.Config, *certReloader, error) {
+	certPath := viper.GetString("TLS_CERT_PATH")
+	keyPath := viper.GetString("TLS_KEY_PATH")
+	if certPath == "" && keyPath == "" {
+		return nil, nil, nil
+	}
+	if certPath == "" || keyPath == "" {
+		return nil, nil, errors.New("TLS_CERT_PATH and TLS_KEY_PATH must both be set")
+	}
+
+	reloader, err := newCertReloader(certPath, keyPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	// Client certificates are verified if sent, routes using
+	// auth.MiddleClientCert require one
+	if caPath := viper.GetString("TLS_CLIENT_CA_PATH"); caPath != "" {
+		b, err := os.ReadFile(caPath)
+		if err != nil {
+			reloader.Close()
+			return nil, nil, err
+		}
+		pool := x509.NewCertPool()
+		if ok := pool.AppendCertsFromPEM(b); !ok {
+			reloader.Close()
+			return nil, nil, fmt.Errorf("no certs appended from %s", caPath)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return tlsConfig, reloader, nil
+}
+
+// Serve handler until SIGINT or SIGTERM, then drain in flight
+// requests and account creations
+func Run(handler http.Handler) error {
+	tlsConfig, reloader, err := newTLSConfig()
+	if err != nil {
+		return err
+	}
+	if reloader != nil {
+		defer reloader.Close()
+	}
+
+	srv := &http.Server{
+		Addr:              ListenAddr(),
+		Handler:           handler,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if tlsConfig != nil {
+			log.Println("Listening with TLS to " + srv.Addr)
+			serveErr <- srv.ListenAndServeTLS("", "")
+		} else {
+			log.Println("Listening to " + srv.Addr)
+			serveErr <- srv.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Println("Shutting down, draining requests")
+	return shutdown(srv, shutdownTimeout())
+}
+
+// Stop accepting requests and wait for handlers and account creations
+func shutdown(srv *http.Server, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	errShutdown := srv.Shutdown(ctx)
+	if errShutdown != nil {
+		log.Println("shutdown() requests did not finish " + errShutdown.Error())
+	}
+
+	// Handlers may have timed out or lost their client mid creation,
+	// a half created account is worse than a slow exit
+	ctxDrain, cancelDrain := context.WithTimeout(context.Background(), timeout)
+	defer cancelDrain()
+	if err := directory.WaitInFlight(ctxDrain); err != nil {
+		log.Println("shutdown() account creations did not finish " + err.Error())
+		return err
+	}
+
+	return errShutdown
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// Write a self signed certificate and key for commonName to dir
+func writeTestCert(t *testing.T, dir string, commonName string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{commonName},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed: %v", err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	certPath := filepath.Join(dir, "tls.crt")
+	keyPath := filepath.Join(dir, "tls.key")
+	os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certPath, keyPath
+}
+
+func certCommonName(t *testing.T, c *certReloader) string {
+	t.Helper()
+	cert, err := c.GetCertificate(nil)
+	assert.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	assert.NoError(t, err)
+	return leaf.Subject.CommonName
+}
+
+func TestListenAddr(t *testing.T) {
+	defer viper.Set("LISTEN_ADDR", "")
+
+	viper.Set("SERVER_PORT", 8080)
+	viper.Set("LISTEN_ADDR", "")
+	assert.Equal(t, "localhost:8080", ListenAddr())
+
+	viper.Set("LISTEN_ADDR", ":8443")
+	assert.Equal(t, ":8443", ListenAddr())
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := writeTestCert(t, dir, "first.example.com")
+
+	reloader, err := newCertReloader(certPath, keyPath)
+	if err != nil {
+		t.Fatalf("newCertReloader failed: %v", err)
+	}
+	defer reloader.Close()
+	assert.Equal(t, "first.example.com", certCommonName(t, reloader))
+
+	// Replace files on disk
+	writeTestCert(t, dir, "second.example.com")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if certCommonName(t, reloader) == "second.example.com" {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("certificate was not reloaded, still %s", certCommonName(t, reloader))
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	defer func() {
+		viper.Set("TLS_CERT_PATH", "")
+		viper.Set("TLS_KEY_PATH", "")
+		viper.Set("TLS_CLIENT_CA_PATH", "")
+	}()
+
+	// Not configured
+	viper.Set("TLS_CERT_PATH", "")
+	viper.Set("TLS_KEY_PATH", "")
+	tlsConfig, _, err := newTLSConfig()
+	assert.NoError(t, err)
+	assert.Nil(t, tlsConfig)
+
+	// Half configured
+	viper.Set("TLS_CERT_PATH", "/tmp/only-cert.pem")
+	_, _, err = newTLSConfig()
+	assert.Error(t, err)
+
+	// Certificate with client CA
+	dir := t.TempDir()
+	certPath, keyPath := writeTestCert(t, dir, "idclaim.example.com")
+	viper.Set("TLS_CERT_PATH", certPath)
+	viper.Set("TLS_KEY_PATH", keyPath)
+	viper.Set("TLS_CLIENT_CA_PATH", certPath)
+
+	tlsConfig, reloader, err := newTLSConfig()
+	assert.NoError(t, err)
+	defer reloader.Close()
+	assert.Equal(t, tls.VerifyClientCertIfGiven, tlsConfig.ClientAuth)
+	assert.NotNil(t, tlsConfig.ClientCAs)
+}
+
+func TestShutdown_DrainsRequests(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+
+	started := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("done"))
+	})}
+	go srv.Serve(listener)
+
+	result := make(chan int, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err != nil {
+			result <- 0
+			return
+		}
+		resp.Body.Close()
+		result <- resp.StatusCode
+	}()
+
+	<-started
+	err = shutdown(srv, 5*time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, <-result)
+}
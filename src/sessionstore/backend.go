@@ -0,0 +1,106 @@
+package sessionstore
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/hadleyso/netid-activate/src/db"
+	"gorm.io/gorm"
+)
+
+// Sessions stored in the sessions table
+type dbBackend struct{}
+
+func (dbBackend) Load(token string) ([]byte, error) {
+	session, err := db.GetSession(token)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return session.Data, nil
+}
+
+func (dbBackend) Save(token string, name string, subject string, data []byte, expiresAt time.Time) error {
+	if err := db.DeleteExpiredSessions(); err != nil {
+		return err
+	}
+	return db.SaveSession(token, name, subject, data, expiresAt)
+}
+
+func (dbBackend) Delete(token string) error {
+	return db.DeleteSession(token)
+}
+
+func (dbBackend) RevokeSubject(subject string) (int64, error) {
+	return db.RevokeSubjectSessions(subject)
+}
+
+type memoryEntry struct {
+	subject   string
+	data      []byte
+	expiresAt time.Time
+}
+
+// Sessions stored in process memory
+type memoryBackend struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+}
+
+var sharedMemory = &memoryBackend{entries: map[string]memoryEntry{}}
+
+func (m *memoryBackend) Load(token string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.entries[token]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(m.entries, token)
+		return nil, ErrNotFound
+	}
+	return entry.data, nil
+}
+
+func (m *memoryBackend) Save(token string, name string, subject string, data []byte, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Drop expired entries
+	now := time.Now()
+	for k, v := range m.entries {
+		if now.After(v.expiresAt) {
+			delete(m.entries, k)
+		}
+	}
+
+	m.entries[token] = memoryEntry{subject: subject, data: data, expiresAt: expiresAt}
+	return nil
+}
+
+func (m *memoryBackend) Delete(token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entries, token)
+	return nil
+}
+
+func (m *memoryBackend) RevokeSubject(subject string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var count int64
+	for k, v := range m.entries {
+		if v.subject == subject {
+			delete(m.entries, k)
+			count++
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,150 @@
+package sessionstore
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// Session value key holding the user the session belongs to,
+// used to revoke every session of a user
+const SubjectKey = "SUBJECT"
+
+// ErrNotFound is returned by a Backend when a token has no stored session
+var ErrNotFound = errors.New("session not found")
+
+// Backend persists encoded session values by opaque token
+type Backend interface {
+	Load(token string) ([]byte, error)
+	Save(token string, name string, subject string, data []byte, expiresAt time.Time) error
+	Delete(token string) error
+	RevokeSubject(subject string) (int64, error)
+}
+
+// Store is a gorilla sessions.Store that keeps session values server side.
+// The cookie only carries a signed opaque session ID.
+type Store struct {
+	Codecs  []securecookie.Codec
+	Options *sessions.Options
+	backend Backend
+}
+
+// Returns a Store using backend and signing keys
+func NewStore(backend Backend, keyPairs ...[]byte) *Store {
+	return &Store{
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{
+			Path:     "/",
+			MaxAge:   86400 * 30,
+			HttpOnly: true,
+		},
+		backend: backend,
+	}
+}
+
+// Returns a Store backed by the database
+func NewDBStore(keyPairs ...[]byte) *Store {
+	return NewStore(dbBackend{}, keyPairs...)
+}
+
+// Returns a Store backed by process memory, shared by all memory stores
+// Only suitable for single instance deployments
+func NewMemoryStore(keyPairs ...[]byte) *Store {
+	return NewStore(sharedMemory, keyPairs...)
+}
+
+// Get returns a cached session for the request or loads it
+func (s *Store) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New loads the session referenced by the request cookie,
+// or returns a new session when there is none
+func (s *Store) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+
+	cookie, errCookie := r.Cookie(name)
+	if errCookie != nil {
+		return session, nil
+	}
+
+	var token string
+	if err := securecookie.DecodeMulti(name, cookie.Value, &token, s.Codecs...); err != nil {
+		return session, err
+	}
+
+	data, err := s.backend.Load(token)
+	if errors.Is(err, ErrNotFound) {
+		// Expired or revoked, start over
+		return session, nil
+	}
+	if err != nil {
+		return session, err
+	}
+
+	if err := (securecookie.GobEncoder{}).Deserialize(data, &session.Values); err != nil {
+		return session, err
+	}
+
+	session.ID = token
+	session.IsNew = false
+	return session, nil
+}
+
+// Save writes session values to the backend and the session ID to the cookie
+// MaxAge <= 0 deletes the session
+func (s *Store) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge <= 0 {
+		if session.ID != "" {
+			if err := s.backend.Delete(session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		session.ID = newToken()
+	}
+
+	data, err := (securecookie.GobEncoder{}).Serialize(session.Values)
+	if err != nil {
+		return err
+	}
+
+	subject, _ := session.Values[SubjectKey].(string)
+	expiresAt := time.Now().Add(time.Duration(session.Options.MaxAge) * time.Second)
+	if err := s.backend.Save(session.ID, session.Name(), subject, data, expiresAt); err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// Delete every session belonging to subject, forcing a new login
+// Returns number of sessions removed
+func (s *Store) RevokeSubject(subject string) (int64, error) {
+	return s.backend.RevokeSubject(subject)
+}
+
+// Random session ID
+func newToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return strings.TrimRight(base32.StdEncoding.EncodeToString(b), "=")
+}
@@ -0,0 +1,128 @@
+package sessionstore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hadleyso/netid-activate/src/db"
+	"github.com/hadleyso/netid-activate/src/models"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupTestDBForStore(t *testing.T) {
+	dbPath := "test_sessionstore.db"
+	viper.Set("DB_PATH", dbPath)
+	database := db.DbConnect()
+
+	err := database.AutoMigrate(&models.Session{})
+	if err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	t.Cleanup(func() {
+		dbInstance, _ := database.DB()
+		dbInstance.Close()
+		os.Remove(dbPath)
+	})
+}
+
+// Save values with store and return a request carrying the cookie
+func saveSession(t *testing.T, store *Store, name string, values map[any]any) *http.Request {
+	t.Helper()
+
+	req := httptest.NewRequest("GET", "/", nil)
+	session, err := store.New(req, name)
+	assert.NoError(t, err)
+	for k, v := range values {
+		session.Values[k] = v
+	}
+
+	rr := httptest.NewRecorder()
+	assert.NoError(t, store.Save(req, rr, session))
+
+	req2 := httptest.NewRequest("GET", "/", nil)
+	for _, cookie := range rr.Result().Cookies() {
+		req2.AddCookie(cookie)
+	}
+	return req2
+}
+
+func testStoreRoundTrip(t *testing.T, store *Store) {
+	req := saveSession(t, store, "IDCLAIM_AUTH", map[any]any{
+		"AUTHENTICATED": true,
+		SubjectKey:      "jdoe",
+	})
+
+	// Cookie only holds the session ID
+	cookie, err := req.Cookie("IDCLAIM_AUTH")
+	assert.NoError(t, err)
+	assert.Less(t, len(cookie.Value), 200)
+
+	session, err := store.New(req, "IDCLAIM_AUTH")
+	assert.NoError(t, err)
+	assert.False(t, session.IsNew)
+	assert.Equal(t, true, session.Values["AUTHENTICATED"])
+
+	// Revoke
+	count, err := store.RevokeSubject("jdoe")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	session, err = store.New(req, "IDCLAIM_AUTH")
+	assert.NoError(t, err)
+	assert.True(t, session.IsNew)
+	assert.Nil(t, session.Values["AUTHENTICATED"])
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStoreRoundTrip(t, NewMemoryStore([]byte("test-session-key")))
+}
+
+func TestDBStore(t *testing.T) {
+	setupTestDBForStore(t)
+	testStoreRoundTrip(t, NewDBStore([]byte("test-session-key")))
+}
+
+func TestStore_LargeValues(t *testing.T) {
+	store := NewMemoryStore([]byte("test-session-key"))
+
+	groups := make([]string, 500)
+	for i := range groups {
+		groups[i] = "group-with-a-fairly-long-name-" + strings.Repeat("x", 10)
+	}
+
+	req := saveSession(t, store, "IDCLAIM_IDENTITY", map[any]any{"GROUPS": groups})
+
+	session, err := store.New(req, "IDCLAIM_IDENTITY")
+	assert.NoError(t, err)
+	assert.Len(t, session.Values["GROUPS"], 500)
+}
+
+func TestStore_Delete(t *testing.T) {
+	store := NewMemoryStore([]byte("test-session-key"))
+	req := saveSession(t, store, "IDCLAIM_AUTH", map[any]any{"AUTHENTICATED": true})
+
+	session, _ := store.New(req, "IDCLAIM_AUTH")
+	session.Options.MaxAge = -1
+	rr := httptest.NewRecorder()
+	assert.NoError(t, store.Save(req, rr, session))
+
+	session, err := store.New(req, "IDCLAIM_AUTH")
+	assert.NoError(t, err)
+	assert.True(t, session.IsNew)
+}
+
+func TestStore_TamperedCookie(t *testing.T) {
+	store := NewMemoryStore([]byte("test-session-key"))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "IDCLAIM_AUTH", Value: "forged"})
+
+	session, err := store.New(req, "IDCLAIM_AUTH")
+	assert.Error(t, err)
+	assert.True(t, session.IsNew)
+}
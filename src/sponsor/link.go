@@ -0,0 +1,36 @@
+package sponsor
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+
+	"github.com/hadleyso/netid-activate/src/auth"
+	"github.com/hadleyso/netid-activate/src/models"
+	"github.com/spf13/viper"
+)
+
+// Key for sponsor links, derived from SESSION_KEY
+func signingKey() []byte {
+	key := sha256.Sum256([]byte("sponsor:" + viper.GetString("SESSION_KEY")))
+	return key[:]
+}
+
+// Signature for the current term of the sponsorship
+// Links stop working once the sponsorship is renewed
+func Sign(sponsorship models.Sponsorship) string {
+	mac := hmac.New(sha256.New, signingKey())
+	mac.Write([]byte(sponsorship.ID.String() + ":" + strconv.Itoa(sponsorship.Renewals)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Check signature is for the current term of the sponsorship
+func Valid(sponsorship models.Sponsorship, signature string) bool {
+	return hmac.Equal([]byte(Sign(sponsorship)), []byte(signature))
+}
+
+// Link to renew or decline the sponsorship
+func Link(sponsorship models.Sponsorship) string {
+	return auth.PublicURL() + "/sponsor/" + sponsorship.ID.String() + "/" + Sign(sponsorship)
+}
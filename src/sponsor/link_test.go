@@ -0,0 +1,37 @@
+package sponsor
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/hadleyso/netid-activate/src/models"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSign(t *testing.T) {
+	viper.Set("SESSION_KEY", "a-very-secret-key-for-sponsor")
+	viper.Set("SERVER_HOSTNAME", "https://id.example.org")
+	defer viper.Set("SERVER_HOSTNAME", nil)
+
+	sponsorship := models.Sponsorship{Base: models.Base{ID: uuid.New()}}
+	signature := Sign(sponsorship)
+
+	assert.True(t, Valid(sponsorship, signature))
+	assert.False(t, Valid(sponsorship, ""))
+	assert.False(t, Valid(sponsorship, signature[1:]))
+	assert.Equal(t, "https://id.example.org/sponsor/"+sponsorship.ID.String()+"/"+signature, Link(sponsorship))
+
+	// Another sponsorship
+	other := models.Sponsorship{Base: models.Base{ID: uuid.New()}}
+	assert.False(t, Valid(other, signature))
+
+	// Renewing ends links for the old term
+	sponsorship.Renewals++
+	assert.False(t, Valid(sponsorship, signature))
+
+	// Keyed by SESSION_KEY
+	sponsorship.Renewals--
+	viper.Set("SESSION_KEY", "another-secret-key")
+	assert.False(t, Valid(sponsorship, signature))
+}
@@ -0,0 +1,126 @@
+package sponsor
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"time"
+
+	"github.com/hadleyso/netid-activate/src/db"
+	"github.com/hadleyso/netid-activate/src/mailer"
+	"github.com/hadleyso/netid-activate/src/models"
+)
+
+// Check sponsorships every SPONSOR_CHECK_INTERVAL until ctx is done
+// Several instances may run, each change is claimed in the DB first
+func Start(ctx context.Context) {
+	if !Enabled() {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(checkInterval())
+		defer ticker.Stop()
+
+		log.Println("Sponsor scheduler started, checking every " + checkInterval().String())
+		for {
+			RunOnce(time.Now())
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Disable expired accounts and send due reminders
+func RunOnce(now time.Time) {
+	var window time.Duration
+	if days := reminderDays(); len(days) > 0 {
+		window = time.Duration(days[0]) * day
+	}
+
+	sponsorships, err := db.ActiveSponsorships(now.Add(window + time.Nanosecond))
+	if err != nil {
+		log.Println("RunOnce() unable to ActiveSponsorships() " + err.Error())
+		return
+	}
+
+	for _, sponsorship := range sponsorships {
+		if !now.Before(sponsorship.ExpiresAt) {
+			expire(sponsorship, now)
+			continue
+		}
+		remind(sponsorship, now)
+	}
+}
+
+// Email the sponsor a reminder, replaced in tests
+var sendReminder = mailer.HandleSendSponsorReminder
+
+// Disable the account of a sponsorship that was not renewed
+// A sponsor never reminded, such as one whose emails failed, is sent
+// the last reminder instead and the account is disabled at a later check
+func expire(sponsorship models.Sponsorship, now time.Time) {
+	if sponsorship.RemindersSent == 0 && len(reminderDays()) > 0 {
+		log.Println("expire() no reminder sent for " + sponsorship.LoginName + ", reminding before disabling")
+		remind(sponsorship, now)
+		return
+	}
+
+	err := Close(sponsorship, models.SponsorExpired, now)
+	if err != nil && !errors.Is(err, db.ErrSponsorshipChanged) {
+		log.Println("expire() unable to expire " + sponsorship.LoginName + " " + err.Error())
+	}
+}
+
+// Send the sponsor the next reminder if one is due
+func remind(sponsorship models.Sponsorship, now time.Time) {
+	due, daysLeft := remindersDue(sponsorship.ExpiresAt, now)
+	if due <= sponsorship.RemindersSent {
+		return
+	}
+
+	// Claim first so only one instance sends it
+	if err := db.ClaimReminder(sponsorship, due, now); err != nil {
+		if !errors.Is(err, db.ErrSponsorshipChanged) {
+			log.Println("remind() unable to ClaimReminder() " + sponsorship.LoginName + " " + err.Error())
+		}
+		return
+	}
+
+	reminder := sponsorship
+	reminder.SponsorEmail = reminderEmail(sponsorship.SponsorEmail)
+	err := sendReminder(reminder, max(daysLeft, 0), Link(sponsorship))
+	if err == nil {
+		return
+	}
+	if errors.Is(err, mailer.ErrNoSponsorEmail) {
+		log.Println("remind() no email for sponsor " + sponsorship.Sponsor + " of " + sponsorship.LoginName + " and no ADMIN_EMAIL")
+	} else {
+		log.Println("remind() unable to HandleSendSponsorReminder() " + sponsorship.LoginName + " " + err.Error())
+	}
+
+	// Sent again at the next check
+	if err := db.UnclaimReminder(sponsorship, due); err != nil {
+		log.Println("remind() unable to UnclaimReminder() " + sponsorship.LoginName + " " + err.Error())
+	}
+}
+
+// Number of reminders due for an expiry at now, one for each reminder
+// day passed, and the whole days left rounded up
+// Missed reminders are not sent again, only the latest one is
+func remindersDue(expiresAt time.Time, now time.Time) (int, int) {
+	daysLeft := int(math.Ceil(float64(expiresAt.Sub(now)) / float64(day)))
+
+	due := 0
+	for _, days := range reminderDays() {
+		if daysLeft <= days {
+			due++
+		}
+	}
+	return due, daysLeft
+}
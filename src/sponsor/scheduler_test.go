@@ -0,0 +1,161 @@
+package sponsor
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hadleyso/netid-activate/src/db"
+	"github.com/hadleyso/netid-activate/src/models"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemindersDue(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		expiresIn time.Duration
+		due       int
+		daysLeft  int
+	}{
+		{expiresIn: 60 * day, due: 0, daysLeft: 60},
+		{expiresIn: 30*day + time.Hour, due: 0, daysLeft: 31},
+		{expiresIn: 30 * day, due: 1, daysLeft: 30},
+		{expiresIn: 8 * day, due: 1, daysLeft: 8},
+		{expiresIn: 6*day + time.Hour, due: 2, daysLeft: 7},
+		{expiresIn: time.Hour, due: 3, daysLeft: 1},
+	}
+	for _, tt := range tests {
+		due, daysLeft := remindersDue(now.Add(tt.expiresIn), now)
+		assert.Equal(t, tt.due, due, tt.expiresIn.String())
+		assert.Equal(t, tt.daysLeft, daysLeft, tt.expiresIn.String())
+	}
+}
+
+func TestRunOnce(t *testing.T) {
+	setupTestDBForSponsor(t)
+	setupSponsorConfig(t)
+	stub := newSCIMStub(t)
+	now := time.Now()
+
+	later := models.Sponsorship{LoginName: "later", SponsorEmail: "sponsor@example.com", ExpiresAt: now.Add(60 * day)}
+	soon := models.Sponsorship{LoginName: "soon", SponsorEmail: "sponsor@example.com", ExpiresAt: now.Add(6 * day)}
+	noEmail := models.Sponsorship{LoginName: "noemail", ExpiresAt: now.Add(6 * day)}
+	expired := models.Sponsorship{LoginName: "expired", SponsorEmail: "sponsor@example.com", ExpiresAt: now.Add(-time.Hour), RemindersSent: 3}
+	unreminded := models.Sponsorship{LoginName: "unreminded", SponsorEmail: "sponsor@example.com", ExpiresAt: now.Add(-time.Hour)}
+	for _, s := range []*models.Sponsorship{&later, &soon, &noEmail, &expired, &unreminded} {
+		assert.NoError(t, db.CreateSponsorship(s))
+	}
+
+	RunOnce(now)
+
+	stored, _ := db.GetSponsorship(later.ID.String())
+	assert.Equal(t, 0, stored.RemindersSent)
+
+	// Missed reminders are counted as sent
+	stored, _ = db.GetSponsorship(soon.ID.String())
+	assert.Equal(t, 2, stored.RemindersSent)
+	assert.NotNil(t, stored.LastReminder)
+
+	// Nobody to remind, tried again at the next check
+	stored, _ = db.GetSponsorship(noEmail.ID.String())
+	assert.Equal(t, 0, stored.RemindersSent)
+	assert.Nil(t, stored.LastReminder)
+
+	stored, _ = db.GetSponsorship(expired.ID.String())
+	assert.Equal(t, models.SponsorExpired, stored.Status)
+	assert.Equal(t, []string{"expired"}, stub.disabled)
+
+	// Reminded before it is disabled
+	stored, _ = db.GetSponsorship(unreminded.ID.String())
+	assert.Equal(t, models.SponsorActive, stored.Status)
+	assert.Equal(t, 3, stored.RemindersSent)
+
+	// Nothing new is due
+	RunOnce(now.Add(time.Hour))
+	stored, _ = db.GetSponsorship(soon.ID.String())
+	assert.Equal(t, 2, stored.RemindersSent)
+	assert.ElementsMatch(t, []string{"expired", "unreminded"}, stub.disabled)
+
+	// Last reminder, then expiry
+	RunOnce(now.Add(5*day + time.Hour))
+	stored, _ = db.GetSponsorship(soon.ID.String())
+	assert.Equal(t, 3, stored.RemindersSent)
+
+	RunOnce(now.Add(6 * day))
+	stored, _ = db.GetSponsorship(soon.ID.String())
+	assert.Equal(t, models.SponsorExpired, stored.Status)
+	assert.ElementsMatch(t, []string{"expired", "unreminded", "soon"}, stub.disabled)
+
+	// Never reminded, so never disabled
+	stored, _ = db.GetSponsorship(noEmail.ID.String())
+	assert.Equal(t, models.SponsorActive, stored.Status)
+}
+
+func TestRunOnceAdminEmail(t *testing.T) {
+	setupTestDBForSponsor(t)
+	setupSponsorConfig(t)
+	viper.Set("ADMIN_EMAIL", "idm-admins@example.com")
+	t.Cleanup(func() { viper.Set("ADMIN_EMAIL", nil) })
+	var sentTo []string
+	replaceSendReminder(t, func(sponsorship models.Sponsorship, daysLeft int, link string) error {
+		sentTo = append(sentTo, sponsorship.SponsorEmail)
+		return nil
+	})
+	now := time.Now()
+
+	noEmail := models.Sponsorship{LoginName: "noemail", ExpiresAt: now.Add(6 * day)}
+	assert.NoError(t, db.CreateSponsorship(&noEmail))
+
+	RunOnce(now)
+	assert.Equal(t, []string{"idm-admins@example.com"}, sentTo)
+	stored, _ := db.GetSponsorship(noEmail.ID.String())
+	assert.Equal(t, 2, stored.RemindersSent)
+}
+
+func TestRunOnceMailerFails(t *testing.T) {
+	setupTestDBForSponsor(t)
+	setupSponsorConfig(t)
+	stub := newSCIMStub(t)
+	sendErr := errors.New("SES unavailable")
+	sends := 0
+	replaceSendReminder(t, func(sponsorship models.Sponsorship, daysLeft int, link string) error {
+		sends++
+		return sendErr
+	})
+	now := time.Now()
+
+	soon := models.Sponsorship{LoginName: "soon", SponsorEmail: "sponsor@example.com", ExpiresAt: now.Add(6 * day), RemindersSent: 1}
+	unreminded := models.Sponsorship{LoginName: "unreminded", SponsorEmail: "sponsor@example.com", ExpiresAt: now.Add(-time.Hour)}
+	for _, s := range []*models.Sponsorship{&soon, &unreminded} {
+		assert.NoError(t, db.CreateSponsorship(s))
+	}
+
+	// The claims are undone
+	RunOnce(now)
+	assert.Equal(t, 2, sends)
+	stored, _ := db.GetSponsorship(soon.ID.String())
+	assert.Equal(t, 1, stored.RemindersSent)
+	assert.Nil(t, stored.LastReminder)
+	stored, _ = db.GetSponsorship(unreminded.ID.String())
+	assert.Equal(t, 0, stored.RemindersSent)
+	assert.Equal(t, models.SponsorActive, stored.Status)
+
+	// Sent again at the next check, the account is kept until then
+	sendErr = nil
+	RunOnce(now.Add(time.Hour))
+	assert.Equal(t, 4, sends)
+	stored, _ = db.GetSponsorship(soon.ID.String())
+	assert.Equal(t, 2, stored.RemindersSent)
+	assert.Empty(t, stub.disabled)
+
+	RunOnce(now.Add(2 * time.Hour))
+	assert.Equal(t, []string{"unreminded"}, stub.disabled)
+}
+
+func replaceSendReminder(t *testing.T, send func(models.Sponsorship, int, string) error) {
+	original := sendReminder
+	sendReminder = send
+	t.Cleanup(func() { sendReminder = original })
+}
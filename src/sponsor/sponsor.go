@@ -0,0 +1,163 @@
+package sponsor
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hadleyso/netid-activate/src/db"
+	"github.com/hadleyso/netid-activate/src/directory"
+	"github.com/hadleyso/netid-activate/src/models"
+	"github.com/spf13/viper"
+)
+
+// Days before expiry sponsors are reminded when SPONSOR_REMINDER_DAYS
+// is not set
+var DefaultReminderDays = []int{30, 7, 1}
+
+// How often sponsorships are checked when SPONSOR_CHECK_INTERVAL is not set
+const defaultCheckInterval = time.Hour
+
+const day = 24 * time.Hour
+
+// Check if accounts get a sponsored term, SPONSOR_TERM_DAYS is set
+func Enabled() bool {
+	return viper.GetInt("SPONSOR_TERM_DAYS") > 0
+}
+
+// Length of a term
+func term() time.Duration {
+	return time.Duration(viper.GetInt("SPONSOR_TERM_DAYS")) * day
+}
+
+// Check if accounts for invitees with affiliation are sponsored
+// SPONSOR_AFFILIATIONS lists affiliation keys, * for all
+func EnabledFor(affiliation string) bool {
+	if !Enabled() {
+		return false
+	}
+	for _, allowed := range viper.GetStringSlice("SPONSOR_AFFILIATIONS") {
+		if allowed == "*" || strings.EqualFold(allowed, affiliation) {
+			return true
+		}
+	}
+	return false
+}
+
+// Days before expiry to remind the sponsor, largest first
+func reminderDays() []int {
+	days := DefaultReminderDays
+	if viper.IsSet("SPONSOR_REMINDER_DAYS") {
+		days = viper.GetIntSlice("SPONSOR_REMINDER_DAYS")
+	}
+	days = slices.Clone(days)
+	slices.Sort(days)
+	slices.Reverse(days)
+	return days
+}
+
+func checkInterval() time.Duration {
+	if viper.IsSet("SPONSOR_CHECK_INTERVAL") {
+		return viper.GetDuration("SPONSOR_CHECK_INTERVAL")
+	}
+	return defaultCheckInterval
+}
+
+// Check SPONSOR_* settings, run at startup
+func CheckConfig() error {
+	if viper.GetInt("SPONSOR_TERM_DAYS") < 0 {
+		return errors.New("SPONSOR_TERM_DAYS must not be negative")
+	}
+	if !Enabled() {
+		return nil
+	}
+	if len(viper.GetStringSlice("SPONSOR_AFFILIATIONS")) == 0 {
+		return errors.New("SPONSOR_TERM_DAYS is set, set SPONSOR_AFFILIATIONS")
+	}
+	for _, days := range reminderDays() {
+		if days <= 0 || days >= viper.GetInt("SPONSOR_TERM_DAYS") {
+			return fmt.Errorf("SPONSOR_REMINDER_DAYS %d must be between 1 and SPONSOR_TERM_DAYS - 1", days)
+		}
+	}
+	if checkInterval() <= 0 {
+		return errors.New("SPONSOR_CHECK_INTERVAL must be a positive duration such as 1h")
+	}
+	if viper.GetString("DIRECTORY_BACKEND") == "ldap" {
+		return fmt.Errorf("SPONSOR_TERM_DAYS is set: %w", directory.ErrDisableNotSupported)
+	}
+	return nil
+}
+
+// Address reminders for a sponsor with email are sent to, ADMIN_EMAIL
+// when the sponsor has none, such as invites sent before inviter emails
+// were kept or from an IdP without an email claim
+func reminderEmail(email string) string {
+	if email != "" {
+		return email
+	}
+	return viper.GetString("ADMIN_EMAIL")
+}
+
+// Record the term of an account created from invite, if its
+// affiliation is sponsored
+// Accounts nobody can be reminded about are not sponsored, they would
+// be disabled without anyone asked to renew them
+// The account already exists, errors are logged and not returned
+func Record(invite models.Invite, loginName string, now time.Time) {
+	if !EnabledFor(invite.Affiliation) {
+		return
+	}
+
+	sponsorEmail := reminderEmail(invite.InviterEmail)
+	if sponsorEmail == "" {
+		log.Println("Record() no email for sponsor " + invite.Inviter + " and no ADMIN_EMAIL, " + loginName + " is not sponsored and will not expire")
+		return
+	}
+
+	sponsorship := models.Sponsorship{
+		LoginName:    loginName,
+		Email:        invite.Email,
+		FirstName:    invite.FirstName,
+		LastName:     invite.LastName,
+		Affiliation:  invite.Affiliation,
+		Sponsor:      invite.Inviter,
+		SponsorEmail: sponsorEmail,
+		ExpiresAt:    now.Add(term()),
+	}
+	if err := db.CreateSponsorship(&sponsorship); err != nil {
+		log.Println("Record() unable to CreateSponsorship() for " + loginName + " " + err.Error())
+	}
+}
+
+// Extend the sponsorship for another term from its current expiry
+func Renew(sponsorship models.Sponsorship) (time.Time, error) {
+	expiresAt := sponsorship.ExpiresAt.Add(term())
+	if err := db.RenewSponsorship(sponsorship, expiresAt); err != nil {
+		return time.Time{}, err
+	}
+	log.Printf("Renew() %s renewed by %s until %s\n", sponsorship.LoginName, sponsorship.Sponsor, expiresAt.Format(time.DateOnly))
+	return expiresAt, nil
+}
+
+// Close the sponsorship with status and disable the account
+// The sponsorship is reopened if the directory cannot disable the
+// account, so it is tried again
+func Close(sponsorship models.Sponsorship, status string, now time.Time) error {
+	if err := db.CloseSponsorship(sponsorship, status, now); err != nil {
+		return err
+	}
+
+	if err := directory.DisableUser(sponsorship.LoginName); err != nil {
+		log.Println("Close() unable to DisableUser() " + sponsorship.LoginName + " " + err.Error())
+		if errReopen := db.ReopenSponsorship(sponsorship, status); errReopen != nil {
+			log.Println("Close() unable to ReopenSponsorship() " + sponsorship.LoginName + " " + errReopen.Error())
+		}
+		return err
+	}
+
+	log.Printf("Close() %s disabled, sponsorship %s\n", sponsorship.LoginName, status)
+	return nil
+}
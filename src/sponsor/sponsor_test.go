@@ -0,0 +1,240 @@
+package sponsor
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hadleyso/netid-activate/src/db"
+	"github.com/hadleyso/netid-activate/src/models"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupSponsorConfig(t *testing.T) {
+	viper.Set("SPONSOR_TERM_DAYS", 90)
+	viper.Set("SPONSOR_AFFILIATIONS", []string{"guest"})
+	viper.Set("DIRECTORY_BACKEND", "idm")
+
+	t.Cleanup(func() {
+		viper.Set("SPONSOR_TERM_DAYS", nil)
+		viper.Set("SPONSOR_AFFILIATIONS", nil)
+		viper.Set("SPONSOR_REMINDER_DAYS", nil)
+		viper.Set("SPONSOR_CHECK_INTERVAL", nil)
+		viper.Set("DIRECTORY_BACKEND", nil)
+	})
+}
+
+func setupTestDBForSponsor(t *testing.T) {
+	dbPath := "test_sponsor.db"
+	viper.Set("DB_PATH", dbPath)
+	viper.Set("DEV", "true")
+
+	database := db.DbConnect()
+	if err := database.AutoMigrate(&models.Sponsorship{}); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	t.Cleanup(func() {
+		dbInstance, _ := database.DB()
+		dbInstance.Close()
+		os.Remove(dbPath)
+		viper.Set("DEV", nil)
+	})
+}
+
+// SCIM server recording the users disabled
+type scimStub struct {
+	mu       sync.Mutex
+	disabled []string
+	fail     bool
+}
+
+func newSCIMStub(t *testing.T) *scimStub {
+	stub := &scimStub{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/scim+json")
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/Users":
+			// filter is userName eq "name"
+			name := strings.Trim(strings.TrimPrefix(r.URL.Query().Get("filter"), "userName eq "), `"`)
+			json.NewEncoder(w).Encode(map[string]any{
+				"totalResults": 1,
+				"Resources":    []map[string]string{{"id": name}},
+			})
+		case r.Method == "PATCH" && strings.HasPrefix(r.URL.Path, "/Users/"):
+			stub.mu.Lock()
+			defer stub.mu.Unlock()
+			if stub.fail {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			stub.disabled = append(stub.disabled, strings.TrimPrefix(r.URL.Path, "/Users/"))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	viper.Set("DIRECTORY_BACKEND", "scim")
+	viper.Set("SCIM_URL", server.URL)
+	viper.Set("SCIM_TOKEN", "token")
+	t.Cleanup(func() {
+		viper.Set("SCIM_URL", nil)
+		viper.Set("SCIM_TOKEN", nil)
+	})
+	return stub
+}
+
+func TestEnabledFor(t *testing.T) {
+	assert.False(t, EnabledFor("guest"))
+
+	setupSponsorConfig(t)
+	assert.True(t, EnabledFor("guest"))
+	assert.True(t, EnabledFor("Guest"))
+	assert.False(t, EnabledFor("staff"))
+
+	viper.Set("SPONSOR_AFFILIATIONS", []string{"*"})
+	assert.True(t, EnabledFor("staff"))
+}
+
+func TestCheckConfig(t *testing.T) {
+	assert.NoError(t, CheckConfig())
+
+	setupSponsorConfig(t)
+	assert.NoError(t, CheckConfig())
+	assert.Equal(t, []int{30, 7, 1}, reminderDays())
+	assert.Equal(t, time.Hour, checkInterval())
+
+	viper.Set("SPONSOR_REMINDER_DAYS", []int{1, 14})
+	assert.Equal(t, []int{14, 1}, reminderDays())
+	assert.NoError(t, CheckConfig())
+
+	// Reminders must fall inside the term
+	viper.Set("SPONSOR_REMINDER_DAYS", []int{90})
+	assert.Error(t, CheckConfig())
+	viper.Set("SPONSOR_REMINDER_DAYS", []int{0})
+	assert.Error(t, CheckConfig())
+	viper.Set("SPONSOR_REMINDER_DAYS", nil)
+
+	viper.Set("SPONSOR_CHECK_INTERVAL", "0s")
+	assert.Error(t, CheckConfig())
+	viper.Set("SPONSOR_CHECK_INTERVAL", "15m")
+	assert.NoError(t, CheckConfig())
+
+	viper.Set("SPONSOR_AFFILIATIONS", nil)
+	assert.Error(t, CheckConfig())
+	viper.Set("SPONSOR_AFFILIATIONS", []string{"guest"})
+
+	// LDAP cannot disable accounts
+	viper.Set("DIRECTORY_BACKEND", "ldap")
+	assert.Error(t, CheckConfig())
+
+	viper.Set("SPONSOR_TERM_DAYS", -1)
+	assert.Error(t, CheckConfig())
+}
+
+func TestRecord(t *testing.T) {
+	setupTestDBForSponsor(t)
+	setupSponsorConfig(t)
+	now := time.Now()
+
+	Record(models.Invite{Email: "staff@example.com", Affiliation: "staff"}, "staff1", now)
+	Record(models.Invite{
+		Email:        "guest@example.com",
+		FirstName:    "Guest",
+		Affiliation:  "guest",
+		Inviter:      "sponsor1",
+		InviterEmail: "sponsor1@example.com",
+	}, "guest1", now)
+
+	sponsorships, err := db.ActiveSponsorships(now.Add(365 * day))
+	assert.NoError(t, err)
+	if assert.Len(t, sponsorships, 1) {
+		assert.Equal(t, "guest1", sponsorships[0].LoginName)
+		assert.Equal(t, "sponsor1", sponsorships[0].Sponsor)
+		assert.Equal(t, "sponsor1@example.com", sponsorships[0].SponsorEmail)
+		assert.WithinDuration(t, now.Add(90*day), sponsorships[0].ExpiresAt, time.Second)
+	}
+}
+
+func TestRecordNoSponsorEmail(t *testing.T) {
+	setupTestDBForSponsor(t)
+	setupSponsorConfig(t)
+	t.Cleanup(func() { viper.Set("ADMIN_EMAIL", nil) })
+	now := time.Now()
+	invite := models.Invite{Email: "guest@example.com", Affiliation: "guest", Inviter: "sponsor1"}
+
+	// Nobody would be asked to renew it
+	Record(invite, "guest1", now)
+	sponsorships, err := db.ActiveSponsorships(now.Add(365 * day))
+	assert.NoError(t, err)
+	assert.Empty(t, sponsorships)
+
+	// Administrators are reminded instead
+	viper.Set("ADMIN_EMAIL", "idm-admins@example.com")
+	Record(invite, "guest2", now)
+	sponsorships, err = db.ActiveSponsorships(now.Add(365 * day))
+	assert.NoError(t, err)
+	if assert.Len(t, sponsorships, 1) {
+		assert.Equal(t, "guest2", sponsorships[0].LoginName)
+		assert.Equal(t, "sponsor1", sponsorships[0].Sponsor)
+		assert.Equal(t, "idm-admins@example.com", sponsorships[0].SponsorEmail)
+	}
+}
+
+func TestRenew(t *testing.T) {
+	setupTestDBForSponsor(t)
+	setupSponsorConfig(t)
+	expiresAt := time.Now().Add(day)
+
+	sponsorship := models.Sponsorship{LoginName: "guest1", ExpiresAt: expiresAt, RemindersSent: 3}
+	assert.NoError(t, db.CreateSponsorship(&sponsorship))
+
+	renewed, err := Renew(sponsorship)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, expiresAt.Add(90*day), renewed, time.Second)
+
+	stored, err := db.GetSponsorship(sponsorship.ID.String())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, stored.Renewals)
+	assert.Equal(t, 0, stored.RemindersSent)
+
+	// The old copy is stale
+	_, err = Renew(sponsorship)
+	assert.ErrorIs(t, err, db.ErrSponsorshipChanged)
+}
+
+func TestClose(t *testing.T) {
+	setupTestDBForSponsor(t)
+	setupSponsorConfig(t)
+	stub := newSCIMStub(t)
+	now := time.Now()
+
+	sponsorship := models.Sponsorship{LoginName: "guest1", ExpiresAt: now.Add(day)}
+	assert.NoError(t, db.CreateSponsorship(&sponsorship))
+
+	// Reopened when the directory fails
+	stub.fail = true
+	assert.Error(t, Close(sponsorship, models.SponsorDeclined, now))
+	stored, _ := db.GetSponsorship(sponsorship.ID.String())
+	assert.Equal(t, models.SponsorActive, stored.Status)
+	assert.Nil(t, stored.ClosedAt)
+
+	stub.fail = false
+	assert.NoError(t, Close(sponsorship, models.SponsorDeclined, now))
+	stored, _ = db.GetSponsorship(sponsorship.ID.String())
+	assert.Equal(t, models.SponsorDeclined, stored.Status)
+	assert.NotNil(t, stored.ClosedAt)
+	assert.Equal(t, []string{"guest1"}, stub.disabled)
+
+	// Already closed
+	assert.ErrorIs(t, Close(sponsorship, models.SponsorDeclined, now), db.ErrSponsorshipChanged)
+	assert.Len(t, stub.disabled, 1)
+}
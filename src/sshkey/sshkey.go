@@ -0,0 +1,125 @@
+package sshkey
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
+)
+
+// Default minimum RSA modulus size
+const DefaultMinRSABits = 3072
+
+// Most keys accepted in one submission
+const MaxKeys = 10
+
+// Key types accepted when SSH_KEY_TYPES is not set, DSA is excluded
+var DefaultTypes = []string{
+	ssh.KeyAlgoED25519,
+	ssh.KeyAlgoECDSA256,
+	ssh.KeyAlgoECDSA384,
+	ssh.KeyAlgoECDSA521,
+	ssh.KeyAlgoSKED25519,
+	ssh.KeyAlgoSKECDSA256,
+	ssh.KeyAlgoRSA,
+}
+
+// Accepted key types, SSH_KEY_TYPES
+func AllowedTypes() []string {
+	if types := viper.GetStringSlice("SSH_KEY_TYPES"); len(types) > 0 {
+		return types
+	}
+	return DefaultTypes
+}
+
+// Minimum RSA size, SSH_KEY_MIN_RSA_BITS
+func MinRSABits() int {
+	if viper.IsSet("SSH_KEY_MIN_RSA_BITS") {
+		return viper.GetInt("SSH_KEY_MIN_RSA_BITS")
+	}
+	return DefaultMinRSABits
+}
+
+// Check if invitees with affiliation are offered the SSH key step
+// SSH_KEY_AFFILIATIONS lists affiliation keys, * for all
+func EnabledFor(affiliation string) bool {
+	for _, allowed := range viper.GetStringSlice("SSH_KEY_AFFILIATIONS") {
+		if allowed == "*" || strings.EqualFold(allowed, affiliation) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse pasted authorized_keys lines
+//
+// Returns normalized keys and a problem for each rejected line. Blank and
+// comment lines are skipped, duplicates dropped, options are not allowed.
+func Parse(input string) ([]string, []string) {
+	var keys []string
+	var problems []string
+	seen := map[string]bool{}
+
+	allowed := AllowedTypes()
+	minBits := MinRSABits()
+
+	lineNumber := 0
+	for _, line := range strings.Split(input, "\n") {
+		lineNumber++
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		pub, comment, options, rest, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("Line %d is not a valid SSH public key", lineNumber))
+			continue
+		}
+		if len(options) > 0 || len(strings.TrimSpace(string(rest))) > 0 {
+			problems = append(problems, fmt.Sprintf("Line %d must be a single key without options", lineNumber))
+			continue
+		}
+		if !slices.Contains(allowed, pub.Type()) {
+			problems = append(problems, fmt.Sprintf("Line %d key type %s is not allowed, use one of %s", lineNumber, pub.Type(), strings.Join(allowed, ", ")))
+			continue
+		}
+		if bits := rsaBits(pub); bits > 0 && bits < minBits {
+			problems = append(problems, fmt.Sprintf("Line %d RSA key is %d bits, at least %d are required", lineNumber, bits, minBits))
+			continue
+		}
+
+		key := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub)))
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if comment = strings.TrimSpace(comment); comment != "" {
+			key += " " + comment
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) > MaxKeys {
+		problems = append(problems, fmt.Sprintf("At most %d keys can be added", MaxKeys))
+	}
+
+	return keys, problems
+}
+
+// RSA modulus size, 0 for other key types
+func rsaBits(pub ssh.PublicKey) int {
+	cryptoKey, ok := pub.(ssh.CryptoPublicKey)
+	if !ok {
+		return 0
+	}
+	rsaKey, ok := cryptoKey.CryptoPublicKey().(*rsa.PublicKey)
+	if !ok {
+		return 0
+	}
+	return rsaKey.N.BitLen()
+}
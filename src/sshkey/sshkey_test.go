@@ -0,0 +1,112 @@
+package sshkey
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func authorizedKey(t *testing.T, key any) string {
+	t.Helper()
+	pub, err := ssh.NewPublicKey(key)
+	if err != nil {
+		t.Fatalf("NewPublicKey: %v", err)
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub)))
+}
+
+func ed25519Key(t *testing.T) string {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	return authorizedKey(t, pub)
+}
+
+func rsaKey(t *testing.T, bits int) string {
+	t.Helper()
+	priv, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	return authorizedKey(t, &priv.PublicKey)
+}
+
+func TestParseValidKeys(t *testing.T) {
+	first := ed25519Key(t)
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	second := authorizedKey(t, &priv.PublicKey)
+
+	input := "# laptop\n\n  " + first + " alice@laptop  \r\n" + second + "\n" + first + "\n"
+	keys, problems := Parse(input)
+
+	assert.Empty(t, problems)
+	assert.Equal(t, []string{first + " alice@laptop", second}, keys)
+}
+
+func TestParseRejectsInvalid(t *testing.T) {
+	keys, problems := Parse("ssh-ed25519 not-base64\n" + `from="10.0.0.1" ` + ed25519Key(t))
+
+	assert.Empty(t, keys)
+	assert.Len(t, problems, 2)
+	assert.Contains(t, problems[0], "Line 1")
+	assert.Contains(t, problems[1], "without options")
+}
+
+func TestParseRSASize(t *testing.T) {
+	small := rsaKey(t, 2048)
+
+	keys, problems := Parse(small)
+	assert.Empty(t, keys)
+	assert.Len(t, problems, 1)
+	assert.Contains(t, problems[0], "2048 bits")
+
+	viper.Set("SSH_KEY_MIN_RSA_BITS", 2048)
+	defer viper.Set("SSH_KEY_MIN_RSA_BITS", nil)
+
+	keys, problems = Parse(small)
+	assert.Empty(t, problems)
+	assert.Len(t, keys, 1)
+}
+
+func TestParseTypeAllowlist(t *testing.T) {
+	viper.Set("SSH_KEY_TYPES", []string{ssh.KeyAlgoRSA})
+	defer viper.Set("SSH_KEY_TYPES", nil)
+
+	keys, problems := Parse(ed25519Key(t))
+	assert.Empty(t, keys)
+	assert.Len(t, problems, 1)
+	assert.Contains(t, problems[0], "ssh-ed25519 is not allowed")
+}
+
+func TestParseMaxKeys(t *testing.T) {
+	var lines []string
+	for range MaxKeys + 1 {
+		lines = append(lines, ed25519Key(t))
+	}
+
+	keys, problems := Parse(strings.Join(lines, "\n"))
+	assert.Len(t, keys, MaxKeys+1)
+	assert.Len(t, problems, 1)
+}
+
+func TestEnabledFor(t *testing.T) {
+	assert.False(t, EnabledFor("GUEST"))
+
+	viper.Set("SSH_KEY_AFFILIATIONS", []string{"ctr"})
+	assert.True(t, EnabledFor("CTR"))
+	assert.False(t, EnabledFor("GUEST"))
+
+	viper.Set("SSH_KEY_AFFILIATIONS", []string{"*"})
+	assert.True(t, EnabledFor("GUEST"))
+	viper.Set("SSH_KEY_AFFILIATIONS", nil)
+}
@@ -0,0 +1,102 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// RFC 6238 parameters, the defaults every authenticator app supports
+const (
+	Digits   = 6
+	Period   = 30 * time.Second
+	Skew     = 1
+	keyBytes = 20
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// New random secret
+func NewSecret() ([]byte, error) {
+	secret := make([]byte, keyBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// Secret as unpadded base32 for manual entry and otpauth URIs
+func EncodeSecret(secret []byte) string {
+	return encoding.EncodeToString(secret)
+}
+
+// Parse unpadded base32 secret, spaces and case are ignored
+func DecodeSecret(encoded string) ([]byte, error) {
+	encoded = strings.ToUpper(strings.ReplaceAll(encoded, " ", ""))
+	return encoding.DecodeString(strings.TrimRight(encoded, "="))
+}
+
+// Code for secret at time t
+func Code(secret []byte, t time.Time) string {
+	return code(secret, uint64(t.Unix()/int64(Period.Seconds())))
+}
+
+func code(secret []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, value%mod)
+}
+
+// Check code for secret at time t, allowing Skew periods of clock drift
+func Validate(secret []byte, candidate string, t time.Time) bool {
+	candidate = strings.ReplaceAll(candidate, " ", "")
+	if len(candidate) != Digits {
+		return false
+	}
+
+	counter := t.Unix() / int64(Period.Seconds())
+	for i := int64(-Skew); i <= Skew; i++ {
+		if subtle.ConstantTimeCompare([]byte(code(secret, uint64(counter+i))), []byte(candidate)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// otpauth URI for authenticator apps
+func URI(secret []byte, issuer string, account string) string {
+	label := url.PathEscape(account)
+	if issuer != "" {
+		label = url.PathEscape(issuer) + ":" + label
+	}
+
+	query := url.Values{}
+	query.Set("secret", EncodeSecret(secret))
+	if issuer != "" {
+		query.Set("issuer", issuer)
+	}
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(Digits))
+	query.Set("period", fmt.Sprint(int(Period.Seconds())))
+
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
@@ -0,0 +1,70 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// RFC 6238 appendix B SHA1 secret
+var rfcSecret = []byte("12345678901234567890")
+
+func TestCodeRFC6238(t *testing.T) {
+	// Last 6 digits of the RFC 8 digit vectors
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, expected := range vectors {
+		assert.Equal(t, expected, Code(rfcSecret, time.Unix(unix, 0)), unix)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Code(rfcSecret, now)
+
+	assert.True(t, Validate(rfcSecret, current, now))
+	assert.True(t, Validate(rfcSecret, current[:3]+" "+current[3:], now))
+
+	// One period of drift allowed
+	assert.True(t, Validate(rfcSecret, Code(rfcSecret, now.Add(-Period)), now))
+	assert.True(t, Validate(rfcSecret, Code(rfcSecret, now.Add(Period)), now))
+	assert.False(t, Validate(rfcSecret, Code(rfcSecret, now.Add(-3*Period)), now))
+
+	assert.False(t, Validate(rfcSecret, "", now))
+	assert.False(t, Validate(rfcSecret, "12345", now))
+}
+
+func TestSecret(t *testing.T) {
+	secret, err := NewSecret()
+	assert.NoError(t, err)
+	assert.Len(t, secret, 20)
+
+	other, _ := NewSecret()
+	assert.NotEqual(t, secret, other)
+
+	encoded := EncodeSecret(secret)
+	assert.NotContains(t, encoded, "=")
+
+	decoded, err := DecodeSecret(strings.ToLower(encoded[:8]) + " " + encoded[8:])
+	assert.NoError(t, err)
+	assert.Equal(t, secret, decoded)
+}
+
+func TestURI(t *testing.T) {
+	uri := URI(rfcSecret, "My Corp", "jdoe")
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/My%20Corp:jdoe?"))
+	assert.Contains(t, uri, "secret="+EncodeSecret(rfcSecret))
+	assert.Contains(t, uri, "issuer=My+Corp")
+	assert.Contains(t, uri, "digits=6")
+	assert.Contains(t, uri, "period=30")
+
+	assert.True(t, strings.HasPrefix(URI(rfcSecret, "", "jdoe"), "otpauth://totp/jdoe?"))
+}